@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/pingcap/tidb/ddl"
 	"github.com/pingcap/tidb/session"
 	"github.com/spf13/cobra"
@@ -15,6 +17,14 @@ func runBackupCommand(command *cobra.Command, cmdName string) error {
 	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
 		return err
 	}
+	if cfg.DryRun {
+		plan, err := task.PlanBackup(GetDefaultContext(), &cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(command.OutOrStdout(), plan)
+		return nil
+	}
 	return task.RunBackup(GetDefaultContext(), cmdName, &cfg)
 }
 
@@ -24,7 +34,7 @@ func NewBackupCommand() *cobra.Command {
 		Use:   "backup",
 		Short: "backup a TiDB cluster",
 		PersistentPreRunE: func(c *cobra.Command, args []string) error {
-			if err := Init(c); err != nil {
+			if err := InitDryRunnable(c); err != nil {
 				return err
 			}
 			utils.LogBRInfo()
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/ddl"
+	"github.com/pingcap/tidb/session"
+	"github.com/spf13/cobra"
+
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+func printCheckReport(command *cobra.Command, report *task.CheckReport) error {
+	fmt.Fprintln(command.OutOrStdout(), report)
+	if report.Failed() {
+		return errors.New("pre-flight check failed")
+	}
+	return nil
+}
+
+func runBackupCheckCommand(command *cobra.Command) error {
+	cfg := task.BackupConfig{Config: task.Config{LogProgress: HasLogFile()}}
+	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+		return err
+	}
+	report, err := task.RunBackupCheck(GetDefaultContext(), &cfg)
+	if err != nil {
+		return err
+	}
+	return printCheckReport(command, report)
+}
+
+func runRestoreCheckCommand(command *cobra.Command) error {
+	cfg := task.RestoreConfig{Config: task.Config{LogProgress: HasLogFile()}}
+	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+		return err
+	}
+	report, err := task.RunRestoreCheck(GetDefaultContext(), &cfg)
+	if err != nil {
+		return err
+	}
+	return printCheckReport(command, report)
+}
+
+// NewCheckCommand returns a check subcommand, which checks whether a backup
+// or restore task can run with the same flags.
+func NewCheckCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "check <subcommand>",
+		Short: "check whether a backup or restore can run",
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := InitReport(c); err != nil {
+				return err
+			}
+			utils.LogBRInfo()
+			utils.LogArguments(c)
+
+			// Do not run ddl worker in BR.
+			ddl.RunWorker = false
+			// Do not run stat worker in BR.
+			session.DisableStats4Test()
+			return nil
+		},
+	}
+	command.AddCommand(
+		newBackupCheckCommand(),
+		newRestoreCheckCommand(),
+	)
+	return command
+}
+
+func newBackupCheckCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "backup",
+		Short: "check whether a backup can run",
+	}
+	command.AddCommand(
+		newCheckSubcommand("full", "check a backup of all databases", runBackupCheckCommand),
+		newCheckSubcommand("db", "check a backup of a database", runBackupCheckCommand),
+		newCheckSubcommand("table", "check a backup of a table", runBackupCheckCommand),
+	)
+	task.DefineBackupFlags(command.PersistentFlags())
+	return command
+}
+
+func newRestoreCheckCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "restore",
+		Short: "check whether a restore can run",
+	}
+	command.AddCommand(
+		newCheckSubcommand("full", "check a restore of all tables", runRestoreCheckCommand),
+		newCheckSubcommand("db", "check a restore of the tables in a database", runRestoreCheckCommand),
+		newCheckSubcommand("table", "check a restore of a table", runRestoreCheckCommand),
+	)
+	task.DefineRestoreFlags(command.PersistentFlags())
+	return command
+}
+
+// newCheckSubcommand returns a full, db or table subcommand, which takes the
+// same flags as the backup or restore subcommand of the same name.
+func newCheckSubcommand(use, short string, run func(*cobra.Command) error) *cobra.Command {
+	command := &cobra.Command{
+		Use:   use,
+		Short: short,
+		RunE: func(command *cobra.Command, _ []string) error {
+			return run(command)
+		},
+	}
+	switch use {
+	case "db":
+		task.DefineDatabaseFlags(command)
+	case "table":
+		task.DefineTableFlags(command)
+	}
+	return command
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
 	"github.com/pingcap/br/pkg/task"
 	"github.com/pingcap/br/pkg/utils"
@@ -46,7 +47,8 @@ func AddFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().StringP(FlagLogLevel, "L", "info",
 		"Set the log level")
 	cmd.PersistentFlags().String(FlagLogFile, "",
-		"Set the log file path. If not set, logs will output to stdout")
+		"Set the log file path. If not set, logs will output to stdout, "+
+			"or to stderr for the commands reporting to stdout")
 	cmd.PersistentFlags().String(FlagStatusAddr, "",
 		"Set the HTTP listening address for the status report service. Set to empty string to disable")
 	task.DefineCommonFlags(cmd.PersistentFlags())
@@ -57,7 +59,30 @@ func AddFlags(cmd *cobra.Command) {
 }
 
 // Init ...
-func Init(cmd *cobra.Command) (err error) {
+func Init(cmd *cobra.Command) error {
+	return initialize(cmd, "stdout")
+}
+
+// InitReport initializes a command which reports to stdout. Without a log
+// file, its logs are written to stderr so they do not mix into the report.
+func InitReport(cmd *cobra.Command) error {
+	return initialize(cmd, "stderr")
+}
+
+// InitDryRunnable initializes a backup or restore command, which reports its
+// plan to stdout like InitReport with the dry run flag.
+func InitDryRunnable(cmd *cobra.Command) error {
+	dryRun, err := cmd.Flags().GetBool(task.FlagDryRun)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return InitReport(cmd)
+	}
+	return Init(cmd)
+}
+
+func initialize(cmd *cobra.Command, logOutput string) (err error) {
 	initOnce.Do(func() {
 		// Initialize the logger.
 		conf := new(log.Config)
@@ -69,10 +94,21 @@ func Init(cmd *cobra.Command) (err error) {
 		if err != nil {
 			return
 		}
+		var (
+			lg *zap.Logger
+			p  *log.ZapProperties
+			e  error
+		)
 		if len(conf.File.Filename) != 0 {
 			atomic.StoreUint64(&hasLogFile, 1)
+			lg, p, e = log.InitLogger(conf)
+		} else {
+			var output zapcore.WriteSyncer
+			output, _, e = zap.Open(logOutput)
+			if e == nil {
+				lg, p, e = log.InitLoggerWithWriteSyncer(conf, output)
+			}
 		}
-		lg, p, e := log.InitLogger(conf)
 		if e != nil {
 			err = e
 			return
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// NewDebugCommand returns a debug subcommand, which inspects the internals
+// of the backups.
+func NewDebugCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "debug <subcommand>",
+		Short: "inspect the internals of the backups",
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := InitReport(c); err != nil {
+				return err
+			}
+			utils.LogBRInfo()
+			utils.LogArguments(c)
+			return nil
+		},
+	}
+	command.AddCommand(newDebugSSTCommand())
+	return command
+}
+
+func newDebugSSTCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "sst",
+		Short: "decode a backup file and check its checksum",
+		RunE: func(command *cobra.Command, _ []string) error {
+			var cfg task.DebugSSTConfig
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				return err
+			}
+			return task.RunDebugSST(GetDefaultContext(), &cfg, command.OutOrStdout())
+		},
+	}
+	task.DefineDebugSSTFlags(command.Flags())
+	return command
+}
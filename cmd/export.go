@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// NewExportCommand returns an export subcommand, which exports the rows of
+// the tables of a backup to CSV or SQL files.
+func NewExportCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "export",
+		Short: "export the tables of a backup to CSV or SQL files",
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return err
+			}
+			utils.LogBRInfo()
+			utils.LogArguments(c)
+			return nil
+		},
+		RunE: func(command *cobra.Command, _ []string) error {
+			var cfg task.ExportConfig
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				return err
+			}
+			return task.RunExport(GetDefaultContext(), &cfg, command.OutOrStdout())
+		},
+	}
+	task.DefineExportFlags(command.Flags())
+	return command
+}
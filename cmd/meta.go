@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// NewMetaCommand returns a meta subcommand, which inspects the backups.
+func NewMetaCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "meta <subcommand>",
+		Short: "inspect the backups",
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := InitReport(c); err != nil {
+				return err
+			}
+			utils.LogBRInfo()
+			utils.LogArguments(c)
+			return nil
+		},
+	}
+	command.AddCommand(
+		newMetaShowCommand(),
+		newMetaDiffCommand(),
+	)
+	return command
+}
+
+func newMetaShowCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "show",
+		Short: "show the summary of a backup",
+		RunE: func(command *cobra.Command, _ []string) error {
+			var cfg task.MetaShowConfig
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				return err
+			}
+			return task.RunMetaShow(GetDefaultContext(), &cfg, command.OutOrStdout())
+		},
+	}
+	task.DefineMetaShowFlags(command.Flags())
+	return command
+}
+
+func newMetaDiffCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "diff",
+		Short: "compare a backup with an old backup",
+		Long: "Compare a backup with an old backup.\n\n" +
+			"The ddl jobs between the backups are listed only if the new backup is an incremental backup " +
+			"since the old backup, because a full backup doesn't record the ddl jobs.",
+		RunE: func(command *cobra.Command, _ []string) error {
+			var cfg task.MetaDiffConfig
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				return err
+			}
+			return task.RunMetaDiff(GetDefaultContext(), &cfg, command.OutOrStdout())
+		},
+	}
+	task.DefineMetaDiffFlags(command.Flags())
+	return command
+}
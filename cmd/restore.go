@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/pingcap/tidb/session"
 	"github.com/spf13/cobra"
 
@@ -14,6 +16,14 @@ func runRestoreCommand(command *cobra.Command, cmdName string) error {
 	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
 		return err
 	}
+	if cfg.DryRun {
+		plan, err := task.PlanRestore(GetDefaultContext(), &cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(command.OutOrStdout(), plan)
+		return nil
+	}
 	return task.RunRestore(GetDefaultContext(), cmdName, &cfg)
 }
 
@@ -23,7 +33,7 @@ func NewRestoreCommand() *cobra.Command {
 		Use:   "restore",
 		Short: "restore a TiKV cluster from a backup",
 		PersistentPreRunE: func(c *cobra.Command, args []string) error {
-			if err := Init(c); err != nil {
+			if err := InitDryRunnable(c); err != nil {
 				return err
 			}
 			utils.LogBRInfo()
@@ -77,6 +87,6 @@ func newTableRestoreCommand() *cobra.Command {
 			return runRestoreCommand(cmd, "Table restore")
 		},
 	}
-	task.DefineTableFlags(command)
+	task.DefineRestoreTableFlags(command)
 	return command
 }
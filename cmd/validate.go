@@ -15,6 +15,7 @@ import (
 	"github.com/pingcap/log"
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/pd/pkg/mock/mockid"
+	"github.com/pingcap/tidb/tablecodec"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 
@@ -59,68 +60,85 @@ func newCheckSumCommand() *cobra.Command {
 				return err
 			}
 
-			_, s, backupMeta, err := task.ReadBackupMeta(ctx, &cfg)
+			_, s, reader, err := task.NewBackupMetaReader(ctx, &cfg)
 			if err != nil {
 				return err
 			}
 
-			dbs, err := utils.LoadBackupTables(backupMeta)
+			// Load the schemas first, then the files are checked shard by
+			// shard.
+			type tableChecksum struct {
+				schema     *backup.Schema
+				info       *model.TableInfo
+				calCRC64   uint64
+				totalKVs   uint64
+				totalBytes uint64
+			}
+			tables := make([]*tableChecksum, 0)
+			tableIDs := make(map[int64]*tableChecksum)
+			err = reader.WalkSchemas(ctx, func(schema *backup.Schema) error {
+				tblInfo := &model.TableInfo{}
+				if err := json.Unmarshal(schema.Table, tblInfo); err != nil {
+					return errors.Trace(err)
+				}
+				tbl := &tableChecksum{schema: schema, info: tblInfo}
+				tables = append(tables, tbl)
+				tableIDs[tblInfo.ID] = tbl
+				if tblInfo.Partition != nil {
+					for _, def := range tblInfo.Partition.Definitions {
+						tableIDs[def.ID] = tbl
+					}
+				}
+				return nil
+			})
 			if err != nil {
-				return errors.Trace(err)
+				return err
 			}
 
-			for _, schema := range backupMeta.Schemas {
-				dbInfo := &model.DBInfo{}
-				err = json.Unmarshal(schema.Db, dbInfo)
-				if err != nil {
-					return errors.Trace(err)
+			err = reader.WalkFiles(ctx, func(file *backup.File) error {
+				tbl, ok := tableIDs[tablecodec.DecodeTableID(file.GetStartKey())]
+				if !ok {
+					return nil
 				}
-				tblInfo := &model.TableInfo{}
-				err = json.Unmarshal(schema.Table, tblInfo)
+				tbl.calCRC64 ^= file.Crc64Xor
+				tbl.totalKVs += file.GetTotalKvs()
+				tbl.totalBytes += file.GetTotalBytes()
+				log.Info("file info", zap.Stringer("table", tbl.info.Name),
+					zap.String("file", file.GetName()),
+					zap.Uint64("crc64xor", file.GetCrc64Xor()),
+					zap.Uint64("totalKvs", file.GetTotalKvs()),
+					zap.Uint64("totalBytes", file.GetTotalBytes()),
+					zap.Uint64("startVersion", file.GetStartVersion()),
+					zap.Uint64("endVersion", file.GetEndVersion()),
+					zap.Binary("startKey", file.GetStartKey()),
+					zap.Binary("endKey", file.GetEndKey()),
+				)
+
+				data, err := s.Read(ctx, file.Name)
 				if err != nil {
 					return errors.Trace(err)
 				}
-				tbl := dbs[dbInfo.Name.String()].GetTable(tblInfo.Name.String())
-
-				var calCRC64 uint64
-				var totalKVs uint64
-				var totalBytes uint64
-				for _, file := range tbl.Files {
-					calCRC64 ^= file.Crc64Xor
-					totalKVs += file.GetTotalKvs()
-					totalBytes += file.GetTotalBytes()
-					log.Info("file info", zap.Stringer("table", tblInfo.Name),
-						zap.String("file", file.GetName()),
-						zap.Uint64("crc64xor", file.GetCrc64Xor()),
-						zap.Uint64("totalKvs", file.GetTotalKvs()),
-						zap.Uint64("totalBytes", file.GetTotalBytes()),
-						zap.Uint64("startVersion", file.GetStartVersion()),
-						zap.Uint64("endVersion", file.GetEndVersion()),
-						zap.Binary("startKey", file.GetStartKey()),
-						zap.Binary("endKey", file.GetEndKey()),
-					)
-
-					var data []byte
-					data, err = s.Read(ctx, file.Name)
-					if err != nil {
-						return errors.Trace(err)
-					}
-					s := sha256.Sum256(data)
-					if !bytes.Equal(s[:], file.Sha256) {
-						return errors.Errorf(`
+				s := sha256.Sum256(data)
+				if !bytes.Equal(s[:], file.Sha256) {
+					return errors.Errorf(`
 backup data checksum failed: %s may be changed
 calculated sha256 is %s,
 origin sha256 is %s`,
-							file.Name, hex.EncodeToString(s[:]), hex.EncodeToString(file.Sha256))
-					}
+						file.Name, hex.EncodeToString(s[:]), hex.EncodeToString(file.Sha256))
 				}
-				log.Info("table info", zap.Stringer("table", tblInfo.Name),
-					zap.Uint64("CRC64", calCRC64),
-					zap.Uint64("totalKvs", totalKVs),
-					zap.Uint64("totalBytes", totalBytes),
-					zap.Uint64("schemaTotalKvs", schema.TotalKvs),
-					zap.Uint64("schemaTotalBytes", schema.TotalBytes),
-					zap.Uint64("schemaCRC64", schema.Crc64Xor))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, tbl := range tables {
+				log.Info("table info", zap.Stringer("table", tbl.info.Name),
+					zap.Uint64("CRC64", tbl.calCRC64),
+					zap.Uint64("totalKvs", tbl.totalKVs),
+					zap.Uint64("totalBytes", tbl.totalBytes),
+					zap.Uint64("schemaTotalKvs", tbl.schema.TotalKvs),
+					zap.Uint64("schemaTotalBytes", tbl.schema.TotalBytes),
+					zap.Uint64("schemaCRC64", tbl.schema.Crc64Xor))
 			}
 			cmd.Println("backup data checksum succeed!")
 			return nil
@@ -147,38 +165,11 @@ func newBackupMetaCommand() *cobra.Command {
 			if err = cfg.ParseFromFlags(cmd.Flags()); err != nil {
 				return err
 			}
-			_, _, backupMeta, err := task.ReadBackupMeta(ctx, &cfg)
+			_, _, reader, err := task.NewBackupMetaReader(ctx, &cfg)
 			if err != nil {
 				log.Error("read backupmeta failed", zap.Error(err))
 				return err
 			}
-			dbs, err := utils.LoadBackupTables(backupMeta)
-			if err != nil {
-				log.Error("load tables failed", zap.Error(err))
-				return err
-			}
-			files := make([]*backup.File, 0)
-			tables := make([]*utils.Table, 0)
-			for _, db := range dbs {
-				for _, table := range db.Tables {
-					files = append(files, table.Files...)
-				}
-				tables = append(tables, db.Tables...)
-			}
-			// Check if the ranges of files overlapped
-			rangeTree := restore.NewRangeTree()
-			for _, file := range files {
-				if out := rangeTree.InsertRange(restore.Range{
-					StartKey: file.GetStartKey(),
-					EndKey:   file.GetEndKey(),
-				}); out != nil {
-					log.Error(
-						"file ranges overlapped",
-						zap.Stringer("out", out.(*restore.Range)),
-						zap.Stringer("file", file),
-					)
-				}
-			}
 
 			tableIDAllocator := mockid.NewIDAllocator()
 			// Advance table ID allocator to the offset.
@@ -190,32 +181,53 @@ func newBackupMetaCommand() *cobra.Command {
 				Data:  make([]*import_sstpb.RewriteRule, 0),
 			}
 			tableIDMap := make(map[int64]int64)
+			// The IDs of the tables and the partitions whose files are checked.
+			tableIDs := make(map[int64]bool)
 			// Simulate to create table
-			for _, table := range tables {
-				indexIDAllocator := mockid.NewIDAllocator()
-				newTable := new(model.TableInfo)
-				tableID, _ := tableIDAllocator.Alloc()
-				newTable.ID = int64(tableID)
-				newTable.Name = table.Info.Name
-				newTable.Indices = make([]*model.IndexInfo, len(table.Info.Indices))
-				for i, indexInfo := range table.Info.Indices {
-					indexID, _ := indexIDAllocator.Alloc()
-					newTable.Indices[i] = &model.IndexInfo{
-						ID:   int64(indexID),
-						Name: indexInfo.Name,
-					}
+			err = reader.WalkSchemas(ctx, func(schema *backup.Schema) error {
+				tableInfo, _, err := utils.UnmarshalTableInfo(schema.Table)
+				if err != nil {
+					log.Error("load tables failed", zap.Error(err))
+					return err
 				}
-				rules := restore.GetRewriteRules(newTable, table.Info, 0)
+				newTable := restore.MockCreatedTable(tableInfo, tableIDAllocator)
+				rules := restore.GetRewriteRules(newTable, tableInfo, 0)
 				rewriteRules.Table = append(rewriteRules.Table, rules.Table...)
 				rewriteRules.Data = append(rewriteRules.Data, rules.Data...)
-				tableIDMap[table.Info.ID] = int64(tableID)
+				tableIDMap[tableInfo.ID] = newTable.ID
+				tableIDs[tableInfo.ID] = true
+				if tableInfo.Partition != nil {
+					for _, def := range tableInfo.Partition.Definitions {
+						tableIDs[def.ID] = true
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
 			}
-			// Validate rewrite rules
-			for _, file := range files {
-				err = restore.ValidateFileRewriteRule(file, rewriteRules)
-				if err != nil {
-					return err
+
+			// Check if the ranges of files overlapped, and validate rewrite
+			// rules.
+			rangeTree := restore.NewRangeTree()
+			err = reader.WalkFiles(ctx, func(file *backup.File) error {
+				if !tableIDs[tablecodec.DecodeTableID(file.GetStartKey())] {
+					return nil
 				}
+				if out := rangeTree.InsertRange(restore.Range{
+					StartKey: file.GetStartKey(),
+					EndKey:   file.GetEndKey(),
+				}); out != nil {
+					log.Error(
+						"file ranges overlapped",
+						zap.Stringer("out", out.(*restore.Range)),
+						zap.Stringer("file", file),
+					)
+				}
+				return restore.ValidateFileRewriteRule(file, rewriteRules)
+			})
+			if err != nil {
+				return err
 			}
 			cmd.Println("Check backupmeta done")
 			return nil
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// NewVerifyCommand returns a verify subcommand, which verifies the files of
+// a backup against its backup meta.
+func NewVerifyCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "verify",
+		Short: "verify the integrity of the backup files",
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := InitReport(c); err != nil {
+				return err
+			}
+			utils.LogBRInfo()
+			utils.LogArguments(c)
+			return nil
+		},
+		RunE: func(command *cobra.Command, _ []string) error {
+			var cfg task.VerifyConfig
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				return err
+			}
+			report, err := task.RunVerify(GetDefaultContext(), &cfg, command.OutOrStdout())
+			if err != nil {
+				return err
+			}
+			if report.Failed() {
+				return errors.New("backup verification failed")
+			}
+			return nil
+		},
+	}
+	task.DefineVerifyFlags(command.Flags())
+	return command
+}
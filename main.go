@@ -50,6 +50,11 @@ func main() {
 		cmd.NewValidateCommand(),
 		cmd.NewBackupCommand(),
 		cmd.NewRestoreCommand(),
+		cmd.NewCheckCommand(),
+		cmd.NewMetaCommand(),
+		cmd.NewVerifyCommand(),
+		cmd.NewDebugCommand(),
+		cmd.NewExportCommand(),
 	)
 	rootCmd.SetArgs(os.Args[1:])
 	if err := rootCmd.Execute(); err != nil {
@@ -1,14 +1,15 @@
 package backup
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
 	"encoding/json"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/gogo/protobuf/proto"
 	"github.com/google/btree"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/backup"
@@ -24,6 +25,7 @@ import (
 	"github.com/pingcap/tidb/meta/autoid"
 	"github.com/pingcap/tidb/store/tikv"
 	"github.com/pingcap/tidb/store/tikv/oracle"
+	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/util"
 	"github.com/pingcap/tidb/util/codec"
 	"github.com/pingcap/tidb/util/ranger"
@@ -48,14 +50,46 @@ const (
 	backupFineGrainedMaxBackoff = 80000
 )
 
+const (
+	// DefaultRangeConcurrency is the default maximum number of the ranges
+	// which are backed up concurrently.
+	DefaultRangeConcurrency = 4
+	// DefaultFineGrainedConcurrency is the default number of the regions
+	// which are backed up concurrently in fine grained backup.
+	DefaultFineGrainedConcurrency = 4
+
+	scanRegionPaginationLimit = 128
+)
+
 // Client is a client instructs TiKV how to do a backup.
 type Client struct {
 	mgr       ClientMgr
 	clusterID uint64
 
-	backupMeta backup.BackupMeta
-	storage    storage.ExternalStorage
-	backend    *backup.StorageBackend
+	backupMeta  backup.BackupMeta
+	storage     storage.ExternalStorage
+	backend     *backup.StorageBackend
+	storeFilter StoreFilter
+
+	fineGrainedConcurrency uint
+	rangeConcurrency       uint
+	// metLocks is the number of the locks met in fine grained backup.
+	metLocks int64
+
+	metaVersion   int
+	metaShardSize int
+	metaWriter    *utils.MetaWriter
+	// fileStats are the checksums of the backed up files of each table or
+	// partition, the files themselves are not kept after written to the
+	// backup meta.
+	fileStats map[int64]*fileStats
+}
+
+// fileStats is the checksum of the files of a table or a partition.
+type fileStats struct {
+	crc64Xor   uint64
+	totalKvs   uint64
+	totalBytes uint64
 }
 
 // NewBackupClient returns a new backup client
@@ -64,11 +98,48 @@ func NewBackupClient(ctx context.Context, mgr ClientMgr) (*Client, error) {
 	pdClient := mgr.GetPDClient()
 	clusterID := pdClient.GetClusterID(ctx)
 	return &Client{
-		clusterID: clusterID,
-		mgr:       mgr,
+		clusterID:   clusterID,
+		mgr:         mgr,
+		metaVersion: utils.MetaV1,
+
+		rangeConcurrency: DefaultRangeConcurrency,
+		fileStats:        make(map[int64]*fileStats),
 	}, nil
 }
 
+// SetMetaVersion sets the version of the backup meta, and the number of the
+// files or the schemas in a shard of the backup meta in MetaV2. It must be
+// called before SetStorage.
+func (bc *Client) SetMetaVersion(version, shardSize int) {
+	bc.metaVersion = version
+	bc.metaShardSize = shardSize
+}
+
+// SetBackupInfo sets the environment of the backup, which is saved along with
+// the backup meta. It must be called after SetStorage.
+func (bc *Client) SetBackupInfo(info *utils.BackupInfo) {
+	bc.backupMeta.ClusterId = info.ClusterID
+	bc.backupMeta.ClusterVersion = info.ClusterVersion
+	bc.metaWriter.SetInfo(info)
+}
+
+// SetStoreFilter sets the filter which selects the stores to backup.
+func (bc *Client) SetStoreFilter(filter StoreFilter) {
+	bc.storeFilter = filter
+}
+
+// SetFineGrainedConcurrency sets the number of the regions which are backed
+// up concurrently in fine grained backup.
+func (bc *Client) SetFineGrainedConcurrency(concurrency uint) {
+	bc.fineGrainedConcurrency = concurrency
+}
+
+// SetRangeConcurrency sets the maximum number of the ranges which are backed
+// up concurrently. The rate limit and the concurrency are divided among them.
+func (bc *Client) SetRangeConcurrency(concurrency uint) {
+	bc.rangeConcurrency = concurrency
+}
+
 // GetTS returns the latest timestamp.
 func (bc *Client) GetTS(ctx context.Context, duration time.Duration) (uint64, error) {
 	p, l, err := bc.mgr.GetPDClient().GetTS(ctx)
@@ -108,14 +179,15 @@ func (bc *Client) SetStorage(ctx context.Context, backend *backup.StorageBackend
 		return err
 	}
 	// backupmeta already exists
-	exist, err := bc.storage.FileExists(ctx, utils.MetaFile)
+	exist, err := utils.MetaExists(ctx, bc.storage)
 	if err != nil {
-		return errors.Annotatef(err, "error occurred when checking %s file", utils.MetaFile)
+		return err
 	}
 	if exist {
 		return errors.New("backup meta exists, may be some backup files in the path already")
 	}
 	bc.backend = backend
+	bc.metaWriter = utils.NewMetaWriter(bc.storage, bc.metaVersion, bc.metaShardSize)
 	return nil
 }
 
@@ -126,15 +198,29 @@ func (bc *Client) SaveBackupMeta(ctx context.Context, ddlJobs []*model.Job) erro
 		return errors.Trace(err)
 	}
 	bc.backupMeta.Ddls = ddlJobsData
-	backupMetaData, err := proto.Marshal(&bc.backupMeta)
-	if err != nil {
-		return errors.Trace(err)
-	}
 	log.Debug("backup meta",
 		zap.Reflect("meta", bc.backupMeta))
 	backendURL := storage.FormatBackendURL(bc.backend)
-	log.Info("save backup meta", zap.Stringer("path", &backendURL), zap.Int("jobs", len(ddlJobs)))
-	return bc.storage.Write(ctx, utils.MetaFile, backupMetaData)
+	log.Info("save backup meta", zap.Stringer("path", &backendURL),
+		zap.Int("jobs", len(ddlJobs)), zap.Int("version", bc.metaVersion))
+	return bc.metaWriter.Finish(ctx, &bc.backupMeta)
+}
+
+// appendFiles writes the backed up files to the backup meta, and records
+// their checksums for the fast checksum.
+func (bc *Client) appendFiles(ctx context.Context, files []*backup.File) error {
+	for _, file := range files {
+		tableID := tablecodec.DecodeTableID(file.GetStartKey())
+		stats, ok := bc.fileStats[tableID]
+		if !ok {
+			stats = &fileStats{}
+			bc.fileStats[tableID] = stats
+		}
+		stats.crc64Xor ^= file.GetCrc64Xor()
+		stats.totalKvs += file.GetTotalKvs()
+		stats.totalBytes += file.GetTotalBytes()
+	}
+	return bc.metaWriter.AppendFiles(ctx, files)
 }
 
 func buildTableRanges(tbl *model.TableInfo) ([]kv.KeyRange, error) {
@@ -174,10 +260,13 @@ func appendRanges(tbl *model.TableInfo, tblID int64) ([]kv.KeyRange, error) {
 }
 
 // BuildBackupRangeAndSchema gets the range and schema of tables.
+// If partitions are given, only these partitions of the matched tables are
+// backed up.
 func BuildBackupRangeAndSchema(
 	dom *domain.Domain,
 	storage kv.Storage,
 	tableFilter *filter.Filter,
+	partitions []string,
 	backupTS uint64,
 ) ([]Range, *Schemas, error) {
 	info, err := dom.GetSnapshotInfoSchema(backupTS)
@@ -185,6 +274,13 @@ func BuildBackupRangeAndSchema(
 		return nil, nil, errors.Trace(err)
 	}
 
+	// Tables with in-flight DDL jobs have non-public indices and columns,
+	// which are excluded from the backup.
+	runningJobs, err := getRunningDDLJobs(dom, backupTS)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+
 	ranges := make([]Range, 0)
 	backupSchemas := newBackupSchemas()
 	for _, dbInfo := range info.AllSchemas() {
@@ -194,22 +290,33 @@ func BuildBackupRangeAndSchema(
 		}
 
 		var dbData []byte
-		idAlloc := autoid.NewAllocator(storage, dbInfo.ID, false, autoid.RowIDAllocType)
-
 		for _, tableInfo := range dbInfo.Tables {
 			if !tableFilter.Match(&filter.Table{Schema: dbInfo.Name.L, Name: tableInfo.Name.L}) {
 				// Skip tables other than the given table.
 				continue
 			}
+			// The allocators of a table renamed from another database by old
+			// TiDB versions are still saved in the old database.
+			dbID := tableInfo.GetDBID(dbInfo.ID)
+			idAlloc := autoid.NewAllocator(storage, dbID, false, autoid.RowIDAllocType)
 			globalAutoID, err := idAlloc.NextGlobalAutoID(tableInfo.ID)
 			if err != nil {
 				return nil, nil, errors.Trace(err)
 			}
 			tableInfo.AutoIncID = globalAutoID
+			var autoRandomID int64
+			if tableInfo.ContainsAutoRandomBits() {
+				randAlloc := autoid.NewAllocator(storage, dbID, false, autoid.AutoRandomType)
+				autoRandomID, err = randAlloc.NextGlobalAutoID(tableInfo.ID)
+				if err != nil {
+					return nil, nil, errors.Trace(err)
+				}
+			}
 			log.Info("change table AutoIncID",
 				zap.Stringer("db", dbInfo.Name),
 				zap.Stringer("table", tableInfo.Name),
-				zap.Int64("AutoIncID", globalAutoID))
+				zap.Int64("AutoIncID", globalAutoID),
+				zap.Int64("AutoRandomID", autoRandomID))
 
 			if dbData == nil {
 				dbData, err = json.Marshal(dbInfo)
@@ -217,7 +324,15 @@ func BuildBackupRangeAndSchema(
 					return nil, nil, errors.Trace(err)
 				}
 			}
-			tableData, err := json.Marshal(tableInfo)
+			tableInfo, extra := excludeNonPublicSchema(tableInfo, runningJobs[tableInfo.ID])
+			if len(partitions) > 0 {
+				tableInfo, err = utils.FilterPartitions(tableInfo, partitions)
+				if err != nil {
+					return nil, nil, errors.Trace(err)
+				}
+			}
+			extra.AutoRandomID = autoRandomID
+			tableData, err := utils.MarshalTableInfo(tableInfo, extra)
 			if err != nil {
 				return nil, nil, errors.Trace(err)
 			}
@@ -292,6 +407,33 @@ func GetBackupDDLJobs(dom *domain.Domain, lastBackupTS, backupTS uint64) ([]*mod
 	return completedJobs, nil
 }
 
+// divideRangeLimits returns the number of the ranges backed up concurrently,
+// and the rate limit and the concurrency of the request of each range. TiKV
+// enforces the limits per request, so they are divided among the concurrent
+// ranges to keep the total within the limits on each store.
+func divideRangeLimits(
+	ranges int, rangeConcurrency uint, rateLimit uint64, concurrency uint32,
+) (uint, uint64, uint32) {
+	n := rangeConcurrency
+	if n > uint(ranges) {
+		n = uint(ranges)
+	}
+	// Each range needs at least one thread.
+	if concurrency > 0 && n > uint(concurrency) {
+		n = uint(concurrency)
+	}
+	if n == 0 {
+		n = 1
+	}
+	if rateLimit > 0 {
+		rateLimit /= uint64(n)
+		if rateLimit == 0 {
+			rateLimit = 1
+		}
+	}
+	return n, rateLimit, concurrency / uint32(n)
+}
+
 // BackupRanges make a backup of the given key ranges.
 func (bc *Client) BackupRanges(
 	ctx context.Context,
@@ -308,16 +450,54 @@ func (bc *Client) BackupRanges(
 		log.Info("Backup Ranges", zap.Duration("take", elapsed))
 	}()
 
-	errCh := make(chan error)
+	ranges = coalesceRanges(ranges)
+	rangeConcurrency, rateLimit, concurrency := divideRangeLimits(
+		len(ranges), bc.rangeConcurrency, rateLimit, concurrency)
+	log.Info("backup coalesced ranges",
+		zap.Int("ranges", len(ranges)),
+		zap.Uint("concurrency", rangeConcurrency),
+		zap.Uint64("rate limit of each range", rateLimit),
+		zap.Uint32("concurrency of each range", concurrency))
+
+	errCh := make(chan error, 1)
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+
+	var mu sync.Mutex
+	results := newRangeTree()
 	go func() {
+		pool := utils.NewWorkerPool(rangeConcurrency, "backup range")
+		wg := new(sync.WaitGroup)
 		for _, r := range ranges {
-			err := bc.backupRange(
-				ctx, r.StartKey, r.EndKey, lastBackupTS, backupTS, rateLimit, concurrency, updateCh)
-			if err != nil {
-				errCh <- err
-				return
+			if ctx.Err() != nil {
+				break
+			}
+			rg := r
+			wg.Add(1)
+			pool.Apply(func() {
+				defer wg.Done()
+				tree, err := bc.backupRange(
+					ctx, rg.StartKey, rg.EndKey, lastBackupTS, backupTS, rateLimit, concurrency, updateCh)
+				if err != nil {
+					select {
+					case errCh <- err:
+					default:
+					}
+					// Stop the other ranges on the first error.
+					cancel()
+					return
+				}
+				mu.Lock()
+				results.merge(tree)
+				mu.Unlock()
+			})
+		}
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			// Some ranges are skipped if the context is canceled.
+			select {
+			case errCh <- err:
+			default:
 			}
 		}
 		close(errCh)
@@ -327,16 +507,25 @@ func (bc *Client) BackupRanges(
 	t := time.NewTicker(time.Second * 30)
 	defer t.Stop()
 
+	// stop cancels the ranges being backed up and waits for them to exit,
+	// so that nothing is sent to updateCh after returning.
+	stop := func() {
+		cancel()
+		for range errCh {
+		}
+	}
 	finished := false
 	for {
 		err := CheckGCSafepoint(ctx, bc.mgr.GetPDClient(), backupTS)
 		if err != nil {
 			log.Error("check GC safepoint failed", zap.Error(err))
+			if !finished {
+				stop()
+			}
 			return err
 		}
 		if finished {
-			// Return error (if there is any) before finishing backup.
-			return err
+			break
 		}
 		select {
 		case err, ok := <-errCh:
@@ -346,14 +535,34 @@ func (bc *Client) BackupRanges(
 				finished = true
 			}
 			if err != nil {
+				stop()
 				return err
 			}
 		case <-t.C:
 		}
 	}
+
+	bc.backupMeta.StartVersion = lastBackupTS
+	bc.backupMeta.EndVersion = backupTS
+	log.Info("backup time range",
+		zap.Reflect("StartVersion", lastBackupTS),
+		zap.Reflect("EndVersion", backupTS))
+
+	files := make([]*backup.File, 0, results.tree.Len())
+	results.tree.Ascend(func(i btree.Item) bool {
+		r := i.(*Range)
+		files = append(files, r.Files...)
+		return true
+	})
+
+	// Check if there are duplicated files.
+	results.checkDupFiles()
+
+	return bc.appendFiles(ctx, files)
 }
 
-// backupRange make a backup of the given key range.
+// backupRange make a backup of the given key range, and returns the backed up
+// files of the range.
 func (bc *Client) backupRange(
 	ctx context.Context,
 	startKey, endKey []byte,
@@ -362,7 +571,7 @@ func (bc *Client) backupRange(
 	rateLimit uint64,
 	concurrency uint32,
 	updateCh chan<- struct{},
-) (err error) {
+) (results RangeTree, err error) {
 	start := time.Now()
 	defer func() {
 		elapsed := time.Since(start)
@@ -383,9 +592,13 @@ func (bc *Client) backupRange(
 	defer cancel()
 
 	var allStores []*metapb.Store
-	allStores, err = bc.mgr.GetPDClient().GetAllStores(ctx, pd.WithExcludeTombstone())
+	allStores, err = bc.getEligibleStores(ctx)
 	if err != nil {
-		return errors.Trace(err)
+		return results, err
+	}
+	eligibleStores := make(map[uint64]bool, len(allStores))
+	for _, store := range allStores {
+		eligibleStores[store.GetId()] = true
 	}
 
 	req := backup.BackupRequest{
@@ -400,10 +613,9 @@ func (bc *Client) backupRange(
 	}
 	push := newPushDown(ctx, bc.mgr, len(allStores))
 
-	var results RangeTree
 	results, err = push.pushBackup(req, allStores, updateCh)
 	if err != nil {
-		return err
+		return results, err
 	}
 	log.Info("finish backup push down", zap.Int("Ok", results.len()))
 
@@ -411,52 +623,56 @@ func (bc *Client) backupRange(
 	// TODO: test fine grained backup.
 	err = bc.fineGrainedBackup(
 		ctx, startKey, endKey, lastBackupTS,
-		backupTS, rateLimit, concurrency, results, updateCh)
+		backupTS, rateLimit, concurrency, eligibleStores, results, updateCh)
 	if err != nil {
-		return err
+		return results, err
 	}
-
-	bc.backupMeta.StartVersion = lastBackupTS
-	bc.backupMeta.EndVersion = backupTS
-	log.Info("backup time range",
-		zap.Reflect("StartVersion", lastBackupTS),
-		zap.Reflect("EndVersion", backupTS))
-
-	results.tree.Ascend(func(i btree.Item) bool {
-		r := i.(*Range)
-		bc.backupMeta.Files = append(bc.backupMeta.Files, r.Files...)
-		return true
-	})
-
-	// Check if there are duplicated files.
-	results.checkDupFiles()
-
-	return nil
+	return results, nil
 }
 
+// findRegionLeader finds the leader of the region containing the key, which
+// must be on one of the eligible stores.
 func (bc *Client) findRegionLeader(
 	ctx context.Context,
-	key []byte) (*metapb.Peer, error) {
+	key []byte,
+	eligibleStores map[uint64]bool,
+) (*metapb.Peer, error) {
 	// Keys are saved in encoded format in TiKV, so the key must be encoded
 	// in order to find the correct region.
 	key = codec.EncodeBytes([]byte{}, key)
+	var ineligible *metapb.Peer
+	var region *metapb.Region
 	for i := 0; i < 5; i++ {
 		// better backoff.
-		_, leader, err := bc.mgr.GetPDClient().GetRegion(ctx, key)
+		var leader *metapb.Peer
+		var err error
+		region, leader, err = bc.mgr.GetPDClient().GetRegion(ctx, key)
 		if err != nil {
 			log.Error("find leader failed", zap.Error(err))
 			time.Sleep(time.Millisecond * time.Duration(100*i))
 			continue
 		}
 		if leader != nil {
-			log.Info("find leader",
+			if eligibleStores[leader.GetStoreId()] {
+				log.Info("find leader",
+					zap.Reflect("Leader", leader), zap.Binary("Key", key))
+				return leader, nil
+			}
+			// The leader may be transferred to an eligible store later.
+			log.Warn("leader is not on an eligible store",
 				zap.Reflect("Leader", leader), zap.Binary("Key", key))
-			return leader, nil
+			ineligible = leader
+		} else {
+			log.Warn("no region found", zap.Binary("Key", key))
 		}
-		log.Warn("no region found", zap.Binary("Key", key))
 		time.Sleep(time.Millisecond * time.Duration(100*i))
 		continue
 	}
+	if ineligible != nil {
+		return nil, errors.Errorf(
+			"the leader of region %d is on store %d, which is not eligible for backup",
+			region.GetId(), ineligible.GetStoreId())
+	}
 	return nil, errors.Errorf("can not find leader for key %v", key)
 }
 
@@ -467,75 +683,84 @@ func (bc *Client) fineGrainedBackup(
 	backupTS uint64,
 	rateLimit uint64,
 	concurrency uint32,
+	eligibleStores map[uint64]bool,
 	rangeTree RangeTree,
 	updateCh chan<- struct{},
 ) error {
+	workers := bc.fineGrainedConcurrency
+	if workers == 0 {
+		workers = DefaultFineGrainedConcurrency
+	}
 	bo := tikv.NewBackoffer(ctx, backupFineGrainedMaxBackoff)
 	for {
-		// Step1, check whether there is any incomplete range
+		// Step1, check whether there is any incomplete range, and split them
+		// at the region boundaries.
 		incomplete := rangeTree.getIncompleteRange(startKey, endKey)
 		if len(incomplete) == 0 {
 			return nil
 		}
-		log.Info("start fine grained backup", zap.Int("incomplete", len(incomplete)))
-		// Step2, retry backup on incomplete range
-		respCh := make(chan *backup.BackupResponse, 4)
-		errCh := make(chan error, 4)
-		retry := make(chan Range, 4)
+		pieces := make([]regionRange, 0, len(incomplete))
+		for _, rg := range incomplete {
+			rgs, err := bc.splitRangeByRegions(ctx, rg)
+			if err != nil {
+				return err
+			}
+			pieces = append(pieces, rgs...)
+		}
+		log.Info("start fine grained backup",
+			zap.Int("incomplete", len(incomplete)), zap.Int("regions", len(pieces)))
 
-		max := &struct {
-			ms int
-			mu sync.Mutex
-		}{}
+		// Step2, retry backup on the regions of the incomplete ranges.
+		roundCtx, cancel := context.WithCancel(ctx)
+		respCh := make(chan *backup.BackupResponse, workers)
+		errCh := make(chan error, workers)
+		retry := make(chan regionRange, workers)
+		backoff := newFineGrainedBackoff()
 		wg := new(sync.WaitGroup)
-		for i := 0; i < 4; i++ {
+		for i := uint(0); i < workers; i++ {
 			wg.Add(1)
 			fork, _ := bo.Fork()
 			go func(boFork *tikv.Backoffer) {
 				defer wg.Done()
 				for rg := range retry {
-					backoffMs, err :=
-						bc.handleFineGrained(ctx, boFork, rg, lastBackupTS, backupTS, rateLimit, concurrency, respCh)
+					err := bc.handleFineGrained(roundCtx, boFork, rg, lastBackupTS, backupTS,
+						rateLimit, concurrency, eligibleStores, backoff, respCh)
 					if err != nil {
 						errCh <- err
 						return
 					}
-					if backoffMs != 0 {
-						max.mu.Lock()
-						if max.ms < backoffMs {
-							max.ms = backoffMs
-						}
-						max.mu.Unlock()
-					}
 				}
 			}(fork)
 		}
 
 		// Dispatch rangs and wait
 		go func() {
-			for _, rg := range incomplete {
-				retry <- rg
+			defer close(respCh)
+			defer wg.Wait()
+			defer close(retry)
+			for _, rg := range pieces {
+				select {
+				case retry <- rg:
+				case <-roundCtx.Done():
+					return
+				}
 			}
-			close(retry)
-			wg.Wait()
-			close(respCh)
 		}()
 
 	selectLoop:
 		for {
 			select {
 			case err := <-errCh:
-				// TODO: should we handle err here?
+				// Stop the other workers and wait for them to exit.
+				cancel()
+				for range respCh {
+				}
 				return err
 			case resp, ok := <-respCh:
 				if !ok {
 					// Finished.
 					break selectLoop
 				}
-				if resp.Error != nil {
-					log.Fatal("unexpected backup error",
-						zap.Reflect("error", resp.Error))
-				}
 				log.Info("put fine grained range",
 					zap.Binary("StartKey", resp.StartKey),
 					zap.Binary("EndKey", resp.EndKey),
@@ -546,33 +771,156 @@ func (bc *Client) fineGrainedBackup(
 				updateCh <- struct{}{}
 			}
 		}
+		cancel()
 
 		// Step3. Backoff if needed, then repeat.
-		max.mu.Lock()
-		ms := max.ms
-		max.mu.Unlock()
-		if ms != 0 {
-			log.Info("handle fine grained", zap.Int("backoffMs", ms))
-			err := bo.BackoffWithMaxSleep(2, /* magic boTxnLockFast */
-				ms, errors.New("TODO: attach error"))
+		if err := backoff.apply(bo); err != nil {
+			return errors.Trace(err)
+		}
+	}
+}
+
+// regionRange is a part of an incomplete range in a region.
+type regionRange struct {
+	Range
+	regionID uint64
+	leader   *metapb.Peer
+}
+
+// splitRangeByRegions splits the range at the boundaries of the regions it
+// crosses, so that each part can be sent to the leader of its region.
+func (bc *Client) splitRangeByRegions(ctx context.Context, rg Range) ([]regionRange, error) {
+	// Keys are saved in encoded format in TiKV and PD.
+	scanStart := codec.EncodeBytes([]byte{}, rg.StartKey)
+	var scanEnd []byte
+	if len(rg.EndKey) != 0 {
+		scanEnd = codec.EncodeBytes([]byte{}, rg.EndKey)
+	}
+	pieces := make([]regionRange, 0, 1)
+	for {
+		regions, leaders, err := bc.mgr.GetPDClient().ScanRegions(ctx, scanStart, scanEnd, scanRegionPaginationLimit)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(regions) == 0 {
+			return nil, errors.Errorf("no region found in range [%s, %s)",
+				hex.EncodeToString(rg.StartKey), hex.EncodeToString(rg.EndKey))
+		}
+		for i, region := range regions {
+			piece := regionRange{Range: rg, regionID: region.GetId()}
+			if i < len(leaders) {
+				piece.leader = leaders[i]
+			}
+			regionStart, regionEnd, err := decodeRegionRange(region)
 			if err != nil {
-				return errors.Trace(err)
+				return nil, err
+			}
+			if bytes.Compare(regionStart, piece.StartKey) > 0 {
+				piece.StartKey = regionStart
 			}
+			if len(regionEnd) != 0 && (len(piece.EndKey) == 0 || bytes.Compare(regionEnd, piece.EndKey) < 0) {
+				piece.EndKey = regionEnd
+			}
+			pieces = append(pieces, piece)
+		}
+		last := regions[len(regions)-1]
+		if len(last.GetEndKey()) == 0 || (len(scanEnd) != 0 && bytes.Compare(last.GetEndKey(), scanEnd) >= 0) {
+			return pieces, nil
+		}
+		scanStart = last.GetEndKey()
+	}
+}
+
+// decodeRegionRange returns the raw keys of the region boundaries.
+func decodeRegionRange(region *metapb.Region) (startKey, endKey []byte, err error) {
+	if len(region.GetStartKey()) != 0 {
+		if _, startKey, err = codec.DecodeBytes(region.GetStartKey(), nil); err != nil {
+			return nil, nil, errors.Annotatef(err, "decode start key of region %d", region.GetId())
 		}
 	}
+	if len(region.GetEndKey()) != 0 {
+		if _, endKey, err = codec.DecodeBytes(region.GetEndKey(), nil); err != nil {
+			return nil, nil, errors.Annotatef(err, "decode end key of region %d", region.GetId())
+		}
+	}
+	return startKey, endKey, nil
+}
+
+// backoffKind is the kind of the backoff needed by a backup error.
+type backoffKind int
+
+const (
+	backoffNone backoffKind = iota
+	backoffTxnLock
+	backoffUpdateLeader
+	backoffRegionMiss
+	backoffServerBusy
+)
+
+// fineGrainedBackoff collects the errors of a round of fine grained backup,
+// and backs off once for each kind of them before the next round.
+type fineGrainedBackoff struct {
+	mu sync.Mutex
+	// errs is the last error of each kind.
+	errs map[backoffKind]error
+	// lockMs is the longest time before the met locks expire.
+	lockMs int
+}
+
+func newFineGrainedBackoff() *fineGrainedBackoff {
+	return &fineGrainedBackoff{errs: make(map[backoffKind]error)}
+}
+
+func (b *fineGrainedBackoff) record(kind backoffKind, ms int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.errs[kind] = err
+	if kind == backoffTxnLock && b.lockMs < ms {
+		b.lockMs = ms
+	}
 }
 
+// apply sleeps by the backoffer, and returns an error if the backoffer
+// exceeds its maximum sleep time.
+func (b *fineGrainedBackoff) apply(bo *tikv.Backoffer) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for kind, err := range b.errs {
+		log.Info("fine grained backup backoff", zap.Int("kind", int(kind)), zap.Error(err))
+		var boErr error
+		switch kind {
+		case backoffTxnLock:
+			if b.lockMs == 0 {
+				continue
+			}
+			boErr = bo.BackoffWithMaxSleep(2 /* magic boTxnLockFast */, b.lockMs, err)
+		case backoffUpdateLeader:
+			boErr = bo.Backoff(tikv.BoUpdateLeader, err)
+		case backoffRegionMiss:
+			boErr = bo.Backoff(tikv.BoRegionMiss, err)
+		case backoffServerBusy:
+			boErr = bo.Backoff(6 /* magic boServerBusy */, err)
+		}
+		if boErr != nil {
+			return boErr
+		}
+	}
+	return nil
+}
+
+// onBackupResponse handles the error in the response, it returns the
+// response if it has no error, or the kind of backoff needed before retrying
+// the range if the error is retryable.
 func onBackupResponse(
 	bo *tikv.Backoffer,
 	backupTS uint64,
 	lockResolver *tikv.LockResolver,
 	resp *backup.BackupResponse,
-) (*backup.BackupResponse, int, error) {
+) (*backup.BackupResponse, backoffKind, int, error) {
 	log.Debug("onBackupResponse", zap.Reflect("resp", resp))
 	if resp.Error == nil {
-		return resp, 0, nil
+		return resp, backoffNone, 0, nil
 	}
-	backoffMs := 0
 	switch v := resp.Error.Detail.(type) {
 	case *backup.Error_KvError:
 		if lockErr := v.KvError.Locked; lockErr != nil {
@@ -581,68 +929,71 @@ func onBackupResponse(
 			msBeforeExpired, _, err1 := lockResolver.ResolveLocks(
 				bo, backupTS, []*tikv.Lock{tikv.NewLock(lockErr)})
 			if err1 != nil {
-				return nil, 0, errors.Trace(err1)
+				return nil, backoffNone, 0, errors.Trace(err1)
 			}
-			if msBeforeExpired > 0 {
-				backoffMs = int(msBeforeExpired)
-			}
-			return nil, backoffMs, nil
+			return nil, backoffTxnLock, int(msBeforeExpired), nil
 		}
 		// Backup should not meet error other than KeyLocked.
 		log.Error("unexpect kv error", zap.Reflect("KvError", v.KvError))
-		return nil, backoffMs, errors.Errorf("onBackupResponse error %v", v)
+		return nil, backoffNone, 0, errors.Errorf("onBackupResponse error %v", v)
 
 	case *backup.Error_RegionError:
 		regionErr := v.RegionError
-		// Ignore following errors.
-		if !(regionErr.EpochNotMatch != nil ||
-			regionErr.NotLeader != nil ||
-			regionErr.RegionNotFound != nil ||
-			regionErr.ServerIsBusy != nil ||
-			regionErr.StaleCommand != nil ||
-			regionErr.StoreNotMatch != nil) {
-			log.Error("unexpect region error",
-				zap.Reflect("RegionError", regionErr))
-			return nil, backoffMs, errors.Errorf("onBackupResponse error %v", v)
-		}
 		log.Warn("backup occur region error",
 			zap.Reflect("RegionError", regionErr))
-		// TODO: a better backoff.
-		backoffMs = 1000 /* 1s */
-		return nil, backoffMs, nil
+		switch {
+		case regionErr.NotLeader != nil:
+			return nil, backoffUpdateLeader, 0, nil
+		case regionErr.ServerIsBusy != nil:
+			return nil, backoffServerBusy, 0, nil
+		case regionErr.EpochNotMatch != nil,
+			regionErr.RegionNotFound != nil,
+			regionErr.StaleCommand != nil,
+			regionErr.StoreNotMatch != nil:
+			// The regions are scanned again before the next round.
+			return nil, backoffRegionMiss, 0, nil
+		}
+		log.Error("unexpect region error",
+			zap.Reflect("RegionError", regionErr))
+		return nil, backoffNone, 0, errors.Errorf("onBackupResponse error %v", v)
 	case *backup.Error_ClusterIdError:
 		log.Error("backup occur cluster ID error",
 			zap.Reflect("error", v))
-		err := errors.Errorf("%v", resp.Error)
-		return nil, 0, err
+		return nil, backoffNone, 0, errors.Errorf("%v", resp.Error)
 	default:
 		log.Error("backup occur unknown error",
 			zap.String("error", resp.Error.GetMsg()))
-		err := errors.Errorf("%v", resp.Error)
-		return nil, 0, err
+		return nil, backoffNone, 0, errors.Errorf("%v", resp.Error)
 	}
 }
 
+// handleFineGrained backs up a part of an incomplete range in a region by
+// the leader of the region.
 func (bc *Client) handleFineGrained(
 	ctx context.Context,
 	bo *tikv.Backoffer,
-	rg Range,
+	rg regionRange,
 	lastBackupTS uint64,
 	backupTS uint64,
 	rateLimit uint64,
 	concurrency uint32,
+	eligibleStores map[uint64]bool,
+	backoff *fineGrainedBackoff,
 	respCh chan<- *backup.BackupResponse,
-) (int, error) {
-	leader, pderr := bc.findRegionLeader(ctx, rg.StartKey)
-	if pderr != nil {
-		return 0, pderr
+) error {
+	leader := rg.leader
+	if leader == nil || !eligibleStores[leader.GetStoreId()] {
+		var pderr error
+		leader, pderr = bc.findRegionLeader(ctx, rg.StartKey, eligibleStores)
+		if pderr != nil {
+			return pderr
+		}
 	}
 	storeID := leader.GetStoreId()
-	max := 0
 
 	req := backup.BackupRequest{
 		ClusterId:      bc.clusterID,
-		StartKey:       rg.StartKey, // TODO: the range may cross region.
+		StartKey:       rg.StartKey,
 		EndKey:         rg.EndKey,
 		StartVersion:   lastBackupTS,
 		EndVersion:     backupTS,
@@ -654,29 +1005,32 @@ func (bc *Client) handleFineGrained(
 	client, err := bc.mgr.GetBackupClient(ctx, storeID)
 	if err != nil {
 		log.Error("fail to connect store", zap.Uint64("StoreID", storeID))
-		return 0, errors.Trace(err)
+		return errors.Trace(err)
 	}
-	err = SendBackup(
+	return SendBackup(
 		ctx, storeID, client, req,
 		// Handle responses with the same backoffer.
 		func(resp *backup.BackupResponse) error {
-			response, backoffMs, err1 :=
+			response, kind, backoffMs, err1 :=
 				onBackupResponse(bo, backupTS, lockResolver, resp)
 			if err1 != nil {
-				return err1
+				return errors.Annotatef(err1, "backup region %d on store %d", rg.regionID, storeID)
+			}
+			if kind == backoffTxnLock {
+				atomic.AddInt64(&bc.metLocks, 1)
 			}
-			if max < backoffMs {
-				max = backoffMs
+			if kind != backoffNone {
+				backoff.record(kind, backoffMs, errors.Errorf("%v", resp.Error))
 			}
 			if response != nil {
-				respCh <- response
+				select {
+				case respCh <- response:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
 			}
 			return nil
 		})
-	if err != nil {
-		return 0, err
-	}
-	return max, nil
 }
 
 // SendBackup send backup request to the given store.
@@ -718,70 +1072,79 @@ func SendBackup(
 	return nil
 }
 
-// FastChecksum check data integrity by xor all(sst_checksum) per table
-func (bc *Client) FastChecksum() (bool, error) {
-	start := time.Now()
-	defer func() {
-		elapsed := time.Since(start)
-		summary.CollectDuration("backup fast checksum", elapsed)
-	}()
-
-	dbs, err := utils.LoadBackupTables(&bc.backupMeta)
+// FastChecksum check data integrity by xor all(sst_checksum) of a table
+func (bc *Client) FastChecksum(schema *backup.Schema) (bool, error) {
+	dbInfo := &model.DBInfo{}
+	err := json.Unmarshal(schema.Db, dbInfo)
 	if err != nil {
 		return false, err
 	}
-
-	for _, schema := range bc.backupMeta.Schemas {
-		dbInfo := &model.DBInfo{}
-		err = json.Unmarshal(schema.Db, dbInfo)
-		if err != nil {
-			return false, err
-		}
-		tblInfo := &model.TableInfo{}
-		err = json.Unmarshal(schema.Table, tblInfo)
-		if err != nil {
-			return false, err
+	tblInfo := &model.TableInfo{}
+	err = json.Unmarshal(schema.Table, tblInfo)
+	if err != nil {
+		return false, err
+	}
+	tableIDs := []int64{tblInfo.ID}
+	if tblInfo.Partition != nil {
+		for _, def := range tblInfo.Partition.Definitions {
+			tableIDs = append(tableIDs, def.ID)
 		}
-		tbl := dbs[dbInfo.Name.String()].GetTable(tblInfo.Name.String())
+	}
 
-		checksum := uint64(0)
-		totalKvs := uint64(0)
-		totalBytes := uint64(0)
-		for _, file := range tbl.Files {
-			checksum ^= file.Crc64Xor
-			totalKvs += file.TotalKvs
-			totalBytes += file.TotalBytes
+	checksum := uint64(0)
+	totalKvs := uint64(0)
+	totalBytes := uint64(0)
+	for _, id := range tableIDs {
+		if stats, ok := bc.fileStats[id]; ok {
+			checksum ^= stats.crc64Xor
+			totalKvs += stats.totalKvs
+			totalBytes += stats.totalBytes
 		}
+	}
 
-		summary.CollectSuccessUnit(summary.TotalKV, totalKvs)
-		summary.CollectSuccessUnit(summary.TotalBytes, totalBytes)
+	summary.CollectSuccessUnit(summary.TotalKV, totalKvs)
+	summary.CollectSuccessUnit(summary.TotalBytes, totalBytes)
 
-		if schema.Crc64Xor == checksum && schema.TotalKvs == totalKvs && schema.TotalBytes == totalBytes {
-			log.Info("fast checksum success", zap.Stringer("db", dbInfo.Name), zap.Stringer("table", tblInfo.Name))
-		} else {
-			log.Error("failed in fast checksum",
-				zap.String("database", dbInfo.Name.String()),
-				zap.String("table", tblInfo.Name.String()),
-				zap.Uint64("origin tidb crc64", schema.Crc64Xor),
-				zap.Uint64("calculated crc64", checksum),
-				zap.Uint64("origin tidb total kvs", schema.TotalKvs),
-				zap.Uint64("calculated total kvs", totalKvs),
-				zap.Uint64("origin tidb total bytes", schema.TotalBytes),
-				zap.Uint64("calculated total bytes", totalBytes),
-			)
-			return false, nil
-		}
+	if schema.Crc64Xor == checksum && schema.TotalKvs == totalKvs && schema.TotalBytes == totalBytes {
+		log.Info("fast checksum success", zap.Stringer("db", dbInfo.Name), zap.Stringer("table", tblInfo.Name))
+		return true, nil
 	}
-
-	return true, nil
+	log.Error("failed in fast checksum",
+		zap.String("database", dbInfo.Name.String()),
+		zap.String("table", tblInfo.Name.String()),
+		zap.Uint64("origin tidb crc64", schema.Crc64Xor),
+		zap.Uint64("calculated crc64", checksum),
+		zap.Uint64("origin tidb total kvs", schema.TotalKvs),
+		zap.Uint64("calculated total kvs", totalKvs),
+		zap.Uint64("origin tidb total bytes", schema.TotalBytes),
+		zap.Uint64("calculated total bytes", totalBytes),
+	)
+	return false, nil
 }
 
-// CompleteMeta wait response of admin checksum from TiDB to complete backup meta
-func (bc *Client) CompleteMeta(backupSchemas *Schemas) error {
-	schemas, err := backupSchemas.finishTableChecksum()
-	if err != nil {
-		return err
-	}
-	bc.backupMeta.Schemas = schemas
-	return nil
+// CompleteMeta wait response of admin checksum from TiDB to complete backup
+// meta. Each schema is checked by the fast checksum if fastChecksum is set,
+// and then appended to the backup meta once its checksum finishes.
+func (bc *Client) CompleteMeta(ctx context.Context, backupSchemas *Schemas, fastChecksum bool) error {
+	var elapsed time.Duration
+	defer func() {
+		if fastChecksum {
+			summary.CollectDuration("backup fast checksum", elapsed)
+		}
+	}()
+	return backupSchemas.finishTableChecksum(func(schema *backup.Schema) error {
+		if fastChecksum {
+			start := time.Now()
+			valid, err := bc.FastChecksum(schema)
+			elapsed += time.Since(start)
+			if err != nil {
+				return err
+			}
+			if !valid {
+				log.Error("backup FastChecksum mismatch!")
+				return errors.Errorf("mismatched checksum")
+			}
+		}
+		return bc.metaWriter.AppendSchemas(ctx, []*backup.Schema{schema})
+	})
 }
@@ -0,0 +1,112 @@
+package backup
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/domain"
+	"github.com/pingcap/tidb/meta"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// getRunningDDLJobs returns the DDL jobs running at the backupTS, grouped by
+// table ID.
+func getRunningDDLJobs(dom *domain.Domain, backupTS uint64) (map[int64][]*model.Job, error) {
+	snapMeta, err := dom.GetSnapshotMeta(backupTS)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	runningJobs := make(map[int64][]*model.Job)
+	for _, key := range []meta.JobListKeyType{meta.DefaultJobListKey, meta.AddIndexJobListKey} {
+		jobs, err := snapMeta.GetAllDDLJobsInQueue(key)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, job := range jobs {
+			runningJobs[job.TableID] = append(runningJobs[job.TableID], job)
+		}
+	}
+	return runningJobs, nil
+}
+
+// addingIndexNames returns the lower case names of the indices being added by
+// the jobs.
+func addingIndexNames(jobs []*model.Job) map[string]bool {
+	names := make(map[string]bool)
+	for _, job := range jobs {
+		if job.Type != model.ActionAddIndex && job.Type != model.ActionAddPrimaryKey {
+			continue
+		}
+		var unique bool
+		var indexName model.CIStr
+		if err := job.DecodeArgs(&unique, &indexName); err != nil {
+			log.Warn("decode add index job failed", zap.Stringer("job", job), zap.Error(err))
+			continue
+		}
+		names[indexName.L] = true
+	}
+	return names
+}
+
+// excludeNonPublicSchema removes the non-public indices and columns, which
+// belong to the in-flight DDL jobs, from the table schema. It returns the
+// public schema and the removed schema objects.
+func excludeNonPublicSchema(
+	tableInfo *model.TableInfo, runningJobs []*model.Job,
+) (*model.TableInfo, utils.TableExtra) {
+	var extra utils.TableExtra
+	hasNonPublic := false
+	for _, col := range tableInfo.Columns {
+		hasNonPublic = hasNonPublic || col.State != model.StatePublic
+	}
+	for _, idx := range tableInfo.Indices {
+		hasNonPublic = hasNonPublic || idx.State != model.StatePublic
+	}
+	if !hasNonPublic {
+		return tableInfo, extra
+	}
+
+	info := tableInfo.Clone()
+	// The offsets of columns change after removing the non-public columns.
+	offsets := make(map[int]int, len(info.Columns))
+	columns := make([]*model.ColumnInfo, 0, len(info.Columns))
+	for _, col := range info.Columns {
+		if col.State != model.StatePublic {
+			extra.ExcludedColumns = append(extra.ExcludedColumns, col)
+			continue
+		}
+		offsets[col.Offset] = len(columns)
+		col.Offset = len(columns)
+		columns = append(columns, col)
+	}
+	info.Columns = columns
+
+	addingIndices := addingIndexNames(runningJobs)
+	indices := make([]*model.IndexInfo, 0, len(info.Indices))
+	for _, idx := range info.Indices {
+		for _, col := range idx.Columns {
+			if offset, ok := offsets[col.Offset]; ok {
+				col.Offset = offset
+			}
+		}
+		if idx.State != model.StatePublic {
+			if addingIndices[idx.Name.L] {
+				extra.PendingIndices = append(extra.PendingIndices, idx)
+			} else {
+				extra.ExcludedIndices = append(extra.ExcludedIndices, idx)
+			}
+			continue
+		}
+		indices = append(indices, idx)
+	}
+	info.Indices = indices
+
+	log.Info("exclude non-public schema objects",
+		zap.Stringer("table", tableInfo.Name),
+		zap.Int("pendingIndices", len(extra.PendingIndices)),
+		zap.Int("excludedIndices", len(extra.ExcludedIndices)),
+		zap.Int("excludedColumns", len(extra.ExcludedColumns)))
+	return info, extra
+}
@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"encoding/json"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+)
+
+var _ = Suite(&testBackupDDLSuite{})
+
+type testBackupDDLSuite struct{}
+
+func (s *testBackupDDLSuite) TestExcludeNonPublicSchema(c *C) {
+	newColumn := func(id int64, name string, offset int, state model.SchemaState) *model.ColumnInfo {
+		return &model.ColumnInfo{ID: id, Name: model.NewCIStr(name), Offset: offset, State: state}
+	}
+	newIndex := func(id int64, name string, col *model.ColumnInfo, state model.SchemaState) *model.IndexInfo {
+		return &model.IndexInfo{
+			ID:      id,
+			Name:    model.NewCIStr(name),
+			Columns: []*model.IndexColumn{{Name: col.Name, Offset: col.Offset}},
+			State:   state,
+		}
+	}
+	a := newColumn(1, "a", 0, model.StatePublic)
+	b := newColumn(2, "b", 1, model.StateWriteOnly)
+	c2 := newColumn(3, "c", 2, model.StatePublic)
+	tableInfo := &model.TableInfo{
+		ID:      10,
+		Name:    model.NewCIStr("t"),
+		Columns: []*model.ColumnInfo{a, b, c2},
+		Indices: []*model.IndexInfo{
+			newIndex(1, "idx_a", a, model.StatePublic),
+			newIndex(2, "idx_c", c2, model.StateWriteReorganization),
+			newIndex(3, "idx_dropping", a, model.StateDeleteOnly),
+		},
+	}
+
+	// A table without in-flight DDL is unchanged.
+	publicInfo := &model.TableInfo{
+		ID:      11,
+		Columns: []*model.ColumnInfo{a},
+		Indices: tableInfo.Indices[:1],
+	}
+	info, extra := excludeNonPublicSchema(publicInfo, nil)
+	c.Assert(info, Equals, publicInfo)
+	c.Assert(extra.PendingIndices, HasLen, 0)
+
+	args, err := json.Marshal([]interface{}{false, model.NewCIStr("idx_c")})
+	c.Assert(err, IsNil)
+	jobs := []*model.Job{{Type: model.ActionAddIndex, TableID: 10, RawArgs: args}}
+	info, extra = excludeNonPublicSchema(tableInfo, jobs)
+	// The origin schema is not modified.
+	c.Assert(tableInfo.Columns, HasLen, 3)
+	c.Assert(tableInfo.Indices, HasLen, 3)
+
+	c.Assert(info.Columns, HasLen, 2)
+	c.Assert(info.Columns[1].Name.L, Equals, "c")
+	c.Assert(info.Columns[1].Offset, Equals, 1)
+	c.Assert(info.Indices, HasLen, 1)
+	c.Assert(info.Indices[0].Name.L, Equals, "idx_a")
+	c.Assert(extra.ExcludedColumns, HasLen, 1)
+	c.Assert(extra.ExcludedColumns[0].Name.L, Equals, "b")
+	c.Assert(extra.PendingIndices, HasLen, 1)
+	c.Assert(extra.PendingIndices[0].Name.L, Equals, "idx_c")
+	c.Assert(extra.PendingIndices[0].Columns[0].Offset, Equals, 1)
+	c.Assert(extra.ExcludedIndices, HasLen, 1)
+	c.Assert(extra.ExcludedIndices[0].Name.L, Equals, "idx_dropping")
+}
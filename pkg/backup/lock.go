@@ -0,0 +1,204 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	scanLockLimit         = 1024
+	resolveLockMaxBackoff = tikv.GcResolveLockMaxBackoff
+)
+
+// LockStats is the statistics of the locks older than the backup ts.
+type LockStats struct {
+	// Regions is the number of the regions scanned.
+	Regions int
+	// Locks is the number of the locks found.
+	Locks int
+	// Resolved is the number of the locks whose transactions are committed,
+	// rolled back or expired, they are resolved before backup.
+	Resolved int
+	// Pending is the number of the locks whose transactions are still alive,
+	// they are resolved when backup meets them.
+	Pending int
+}
+
+func (s *LockStats) merge(other LockStats) {
+	s.Regions += other.Regions
+	s.Locks += other.Locks
+	s.Resolved += other.Resolved
+	s.Pending += other.Pending
+}
+
+// ResolveLocks scans the lock CF in the ranges for the locks older than the
+// backup ts, and resolves the stale ones in batch, so that backup doesn't
+// fall into the fine grained backup for them.
+func (bc *Client) ResolveLocks(ctx context.Context, ranges []Range, backupTS uint64) (LockStats, error) {
+	start := time.Now()
+	ranges = coalesceRanges(ranges)
+	log.Info("resolve locks before backup",
+		zap.Int("ranges", len(ranges)), zap.Uint64("BackupTS", backupTS))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		stats    LockStats
+		firstErr error
+	)
+	pool := utils.NewWorkerPool(DefaultRangeConcurrency, "resolve locks")
+	wg := new(sync.WaitGroup)
+	for _, r := range ranges {
+		if ctx.Err() != nil {
+			break
+		}
+		rg := r
+		wg.Add(1)
+		pool.Apply(func() {
+			defer wg.Done()
+			rangeStats, err := bc.resolveRangeLocks(ctx, rg, backupTS)
+			mu.Lock()
+			defer mu.Unlock()
+			stats.merge(rangeStats)
+			if err != nil && firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+		})
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return stats, firstErr
+	}
+	log.Info("resolve locks finished",
+		zap.Reflect("stats", stats), zap.Duration("take", time.Since(start)))
+	return stats, errors.Trace(ctx.Err())
+}
+
+// resolveRangeLocks scans and resolves the locks in the range region by
+// region.
+func (bc *Client) resolveRangeLocks(ctx context.Context, rg Range, backupTS uint64) (LockStats, error) {
+	var stats LockStats
+	store := bc.mgr.GetTiKV()
+	lockResolver := bc.mgr.GetLockResolver()
+	req := tikvrpc.NewRequest(tikvrpc.CmdScanLock, &kvrpcpb.ScanLockRequest{
+		MaxVersion: backupTS,
+		Limit:      scanLockLimit,
+	})
+	key := rg.StartKey
+	bo := tikv.NewBackoffer(ctx, resolveLockMaxBackoff)
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, errors.Trace(err)
+		}
+		req.ScanLock().StartKey = key
+		loc, err := store.GetRegionCache().LocateKey(bo, key)
+		if err != nil {
+			return stats, errors.Trace(err)
+		}
+		resp, err := store.SendReq(bo, req, loc.Region, tikv.ReadTimeoutMedium)
+		if err != nil {
+			return stats, errors.Trace(err)
+		}
+		regionErr, err := resp.GetRegionError()
+		if err != nil {
+			return stats, errors.Trace(err)
+		}
+		if regionErr != nil {
+			err = bo.Backoff(tikv.BoRegionMiss, errors.New(regionErr.String()))
+			if err != nil {
+				return stats, errors.Trace(err)
+			}
+			continue
+		}
+		if resp.Resp == nil {
+			return stats, errors.Trace(tikv.ErrBodyMissing)
+		}
+		locksResp := resp.Resp.(*kvrpcpb.ScanLockResponse)
+		if locksResp.GetError() != nil {
+			return stats, errors.Errorf("unexpected scan lock error: %s", locksResp.GetError())
+		}
+		locksInfo := locksResp.GetLocks()
+		locks := make([]*tikv.Lock, 0, len(locksInfo))
+		for _, info := range locksInfo {
+			// The locks are in the order of keys, and the region may exceed
+			// the range.
+			if len(rg.EndKey) != 0 && bytes.Compare(info.GetKey(), rg.EndKey) >= 0 {
+				break
+			}
+			locks = append(locks, tikv.NewLock(info))
+		}
+		resolved, err := resolveLocksByTxn(bo, lockResolver, backupTS, locks)
+		if err != nil {
+			return stats, err
+		}
+		stats.Locks += len(locks)
+		stats.Resolved += resolved
+		stats.Pending += len(locks) - resolved
+
+		if len(locksInfo) < scanLockLimit {
+			stats.Regions++
+			key = loc.EndKey
+		} else {
+			log.Info("region has more locks than the limit",
+				zap.Uint64("region", loc.Region.GetID()), zap.Int("limit", scanLockLimit))
+			key = kv.Key(locksInfo[len(locksInfo)-1].GetKey()).Next()
+		}
+		if len(key) == 0 || (len(rg.EndKey) != 0 && bytes.Compare(key, rg.EndKey) >= 0) {
+			return stats, nil
+		}
+		bo = tikv.NewBackoffer(ctx, resolveLockMaxBackoff)
+	}
+}
+
+// resolveLocksByTxn resolves the locks of each transaction together, and
+// returns the number of the locks resolved. The locks of the alive
+// transactions are left.
+func resolveLocksByTxn(
+	bo *tikv.Backoffer,
+	lockResolver *tikv.LockResolver,
+	backupTS uint64,
+	locks []*tikv.Lock,
+) (int, error) {
+	txnLocks := make(map[uint64][]*tikv.Lock)
+	txns := make([]uint64, 0)
+	for _, lock := range locks {
+		if _, ok := txnLocks[lock.TxnID]; !ok {
+			txns = append(txns, lock.TxnID)
+		}
+		txnLocks[lock.TxnID] = append(txnLocks[lock.TxnID], lock)
+	}
+	resolved := 0
+	for _, txn := range txns {
+		msBeforeExpired, _, err := lockResolver.ResolveLocks(bo, backupTS, txnLocks[txn])
+		if err != nil {
+			return resolved, errors.Trace(err)
+		}
+		if msBeforeExpired > 0 {
+			log.Debug("transaction is alive", zap.Uint64("txn", txn),
+				zap.Int64("msBeforeExpired", msBeforeExpired))
+			continue
+		}
+		resolved += len(txnLocks[txn])
+	}
+	return resolved, nil
+}
+
+// MetLocks returns the number of the locks met in fine grained backup.
+func (bc *Client) MetLocks() int {
+	return int(atomic.LoadInt64(&bc.metLocks))
+}
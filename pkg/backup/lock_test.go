@@ -0,0 +1,142 @@
+package backup
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/tidb/store/mockstore"
+	"github.com/pingcap/tidb/store/mockstore/mocktikv"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+	"github.com/pingcap/tidb/tablecodec"
+)
+
+var _ = Suite(&testLockSuite{})
+
+type testLockSuite struct{}
+
+// lockTTLClient fills the TTLs of the scanned locks, which are not returned
+// by the mock TiKV.
+type lockTTLClient struct {
+	tikv.Client
+	mu   sync.Mutex
+	ttls map[uint64]uint64
+}
+
+func (c *lockTTLClient) SendRequest(
+	ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration,
+) (*tikvrpc.Response, error) {
+	if req.Type == tikvrpc.CmdPrewrite {
+		prewrite := req.Prewrite()
+		c.mu.Lock()
+		c.ttls[prewrite.StartVersion] = prewrite.LockTtl
+		c.mu.Unlock()
+	}
+	resp, err := c.Client.SendRequest(ctx, addr, req, timeout)
+	if err != nil || req.Type != tikvrpc.CmdScanLock {
+		return resp, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, lock := range resp.Resp.(*kvrpcpb.ScanLockResponse).GetLocks() {
+		lock.LockTtl = c.ttls[lock.LockVersion]
+	}
+	return resp, nil
+}
+
+// lockKeys prewrites the keys without committing them to leave locks, like
+// the locker of the br_key_locked integration test. The keys must be in the
+// same region.
+func lockKeys(store tikv.Storage, keys [][]byte, lockTTL time.Duration) error {
+	bo := tikv.NewBackoffer(context.Background(), 20000)
+	startTS, err := store.CurrentVersion()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	mutations := make([]*kvrpcpb.Mutation, 0, len(keys))
+	for _, key := range keys {
+		mutations = append(mutations, &kvrpcpb.Mutation{Op: kvrpcpb.Op_Put, Key: key, Value: []byte("v")})
+	}
+	req := tikvrpc.NewRequest(tikvrpc.CmdPrewrite, &kvrpcpb.PrewriteRequest{
+		Mutations:    mutations,
+		PrimaryLock:  keys[0],
+		StartVersion: startTS.Ver,
+		LockTtl:      uint64(lockTTL / time.Millisecond),
+	})
+	loc, err := store.GetRegionCache().LocateKey(bo, keys[0])
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := store.SendReq(bo, req, loc.Region, 20*time.Second)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	regionErr, err := resp.GetRegionError()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if regionErr != nil {
+		return errors.New(regionErr.String())
+	}
+	return nil
+}
+
+func recordKeys(tableID int64, rowIDs ...int64) [][]byte {
+	keys := make([][]byte, 0, len(rowIDs))
+	for _, rowID := range rowIDs {
+		keys = append(keys, tablecodec.EncodeRowKeyWithHandle(tableID, rowID))
+	}
+	return keys
+}
+
+func (s *testLockSuite) TestResolveLocks(c *C) {
+	// Split the table 1 into 2 regions at the row 5.
+	cluster := mocktikv.NewCluster()
+	_, _, regionID := mocktikv.BootstrapWithSingleStore(cluster)
+	ids := cluster.AllocIDs(2)
+	cluster.Split(regionID, ids[0], tablecodec.EncodeRowKeyWithHandle(1, 5), []uint64{ids[1]}, ids[1])
+	store, err := mockstore.NewMockTikvStore(
+		mockstore.WithCluster(cluster),
+		mockstore.WithHijackClient(func(client tikv.Client) tikv.Client {
+			return &lockTTLClient{Client: client, ttls: make(map[uint64]uint64)}
+		}),
+	)
+	c.Assert(err, IsNil)
+	defer store.Close()
+	tikvStore := store.(tikv.Storage)
+
+	// The transaction in the first region expires soon, the one in the
+	// second region is alive, and the one of the table 2 is not in range.
+	c.Assert(lockKeys(tikvStore, recordKeys(1, 1, 2, 3), time.Millisecond), IsNil)
+	c.Assert(lockKeys(tikvStore, recordKeys(1, 10, 11), time.Hour), IsNil)
+	c.Assert(lockKeys(tikvStore, recordKeys(2, 1), time.Millisecond), IsNil)
+	time.Sleep(10 * time.Millisecond)
+	backupTS, err := tikvStore.CurrentVersion()
+	c.Assert(err, IsNil)
+
+	bc, mgr := newFakeBackupClientWithCluster(cluster)
+	mgr.tikv = tikvStore
+	ranges := []Range{{
+		StartKey: tablecodec.GenTableRecordPrefix(1),
+		EndKey:   tablecodec.GenTableRecordPrefix(1).PrefixNext(),
+	}}
+	ctx := context.Background()
+	stats, err := bc.ResolveLocks(ctx, ranges, backupTS.Ver)
+	c.Assert(err, IsNil)
+	c.Assert(stats, DeepEquals, LockStats{Regions: 2, Locks: 5, Resolved: 3, Pending: 2})
+
+	// Only the locks of the alive transaction are left.
+	stats, err = bc.ResolveLocks(ctx, ranges, backupTS.Ver)
+	c.Assert(err, IsNil)
+	c.Assert(stats, DeepEquals, LockStats{Regions: 2, Locks: 2, Resolved: 0, Pending: 2})
+
+	// The locks newer than the backup ts are ignored.
+	c.Assert(lockKeys(tikvStore, recordKeys(1, 20), time.Millisecond), IsNil)
+	stats, err = bc.ResolveLocks(ctx, ranges, backupTS.Ver)
+	c.Assert(err, IsNil)
+	c.Assert(stats.Locks, Equals, 2)
+}
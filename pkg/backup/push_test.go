@@ -0,0 +1,447 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/parser/model"
+	pd "github.com/pingcap/pd/client"
+	"github.com/pingcap/tidb/store/mockstore/mocktikv"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/tablecodec"
+	"google.golang.org/grpc"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+var _ = Suite(&testPushSuite{})
+
+type testPushSuite struct{}
+
+// fakeBackupMgr is a ClientMgr whose backup clients back up a range as one
+// file named after the range, and record how the ranges are scheduled.
+type fakeBackupMgr struct {
+	pdClient pd.Client
+	tikv     tikv.Storage
+	// failKey makes the request starting with it fail.
+	failKey []byte
+
+	mu          sync.Mutex
+	inflight    int
+	maxInflight int
+	requests    []backup.BackupRequest
+	stores      []uint64
+	// respErrors are the errors responded to the requests starting with the
+	// key in order, before the request succeeds.
+	respErrors map[string][]*backup.Error
+}
+
+func (mgr *fakeBackupMgr) GetBackupClient(ctx context.Context, storeID uint64) (backup.BackupClient, error) {
+	return &fakeBackupClient{mgr: mgr, storeID: storeID}, nil
+}
+
+func (mgr *fakeBackupMgr) GetPDClient() pd.Client {
+	return mgr.pdClient
+}
+
+func (mgr *fakeBackupMgr) GetTiKV() tikv.Storage {
+	return mgr.tikv
+}
+
+func (mgr *fakeBackupMgr) GetLockResolver() *tikv.LockResolver {
+	if mgr.tikv == nil {
+		return nil
+	}
+	return mgr.tikv.GetLockResolver()
+}
+
+func (mgr *fakeBackupMgr) Close() {}
+
+type fakeBackupClient struct {
+	mgr     *fakeBackupMgr
+	storeID uint64
+}
+
+func (c *fakeBackupClient) Backup(
+	ctx context.Context, req *backup.BackupRequest, opts ...grpc.CallOption,
+) (backup.Backup_BackupClient, error) {
+	mgr := c.mgr
+	mgr.mu.Lock()
+	mgr.requests = append(mgr.requests, *req)
+	mgr.stores = append(mgr.stores, c.storeID)
+	mgr.inflight++
+	if mgr.inflight > mgr.maxInflight {
+		mgr.maxInflight = mgr.inflight
+	}
+	var respErr *backup.Error
+	if errs := mgr.respErrors[string(req.StartKey)]; len(errs) > 0 {
+		respErr = errs[0]
+		mgr.respErrors[string(req.StartKey)] = errs[1:]
+	}
+	mgr.mu.Unlock()
+
+	// Hold the request for a while so that the concurrent ones overlap.
+	time.Sleep(20 * time.Millisecond)
+	if mgr.failKey != nil && bytes.Equal(req.StartKey, mgr.failKey) {
+		mgr.finish()
+		return nil, errors.New("backup failed")
+	}
+	return &fakeBackupStream{mgr: mgr, resp: &backup.BackupResponse{
+		StartKey: req.StartKey,
+		EndKey:   req.EndKey,
+		Files:    []*backup.File{{Name: string(req.StartKey) + ".sst"}},
+		Error:    respErr,
+	}}, nil
+}
+
+func (mgr *fakeBackupMgr) finish() {
+	mgr.mu.Lock()
+	mgr.inflight--
+	mgr.mu.Unlock()
+}
+
+type fakeBackupStream struct {
+	grpc.ClientStream
+	mgr  *fakeBackupMgr
+	resp *backup.BackupResponse
+}
+
+func (s *fakeBackupStream) Recv() (*backup.BackupResponse, error) {
+	if s.resp == nil {
+		s.mgr.finish()
+		return nil, io.EOF
+	}
+	resp := s.resp
+	s.resp = nil
+	return resp, nil
+}
+
+func newFakeBackupClient() (*Client, *fakeBackupMgr) {
+	cluster := mocktikv.NewCluster()
+	mocktikv.BootstrapWithSingleStore(cluster)
+	return newFakeBackupClientWithCluster(cluster)
+}
+
+func newFakeBackupClientWithCluster(cluster *mocktikv.Cluster) (*Client, *fakeBackupMgr) {
+	mgr := &fakeBackupMgr{
+		pdClient:   mocktikv.NewPDClient(cluster),
+		respErrors: make(map[string][]*backup.Error),
+	}
+	s := &memStorage{files: make(map[string][]byte)}
+	return &Client{
+		mgr:         mgr,
+		storage:     s,
+		backend:     &backup.StorageBackend{Backend: &backup.StorageBackend_Noop{Noop: &backup.Noop{}}},
+		metaVersion: utils.MetaV2,
+		// Each shard of the backup meta has 2 files.
+		metaWriter: utils.NewMetaWriter(s, utils.MetaV2, 2),
+		fileStats:  make(map[int64]*fileStats),
+
+		rangeConcurrency: DefaultRangeConcurrency,
+	}, mgr
+}
+
+// memStorage is an ExternalStorage in memory.
+type memStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (s *memStorage) Write(ctx context.Context, name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = data
+	return nil
+}
+
+func (s *memStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[name]
+	if !ok {
+		return nil, errors.Errorf("%s not found", name)
+	}
+	return data, nil
+}
+
+func (s *memStorage) DeleteFile(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, name)
+	return nil
+}
+
+func (s *memStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	data, err := s.Read(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memStorage) WalkDir(ctx context.Context, fn func(name string, size int64) error) error {
+	s.mu.Lock()
+	sizes := make(map[string]int64, len(s.files))
+	for name, data := range s.files {
+		sizes[name] = int64(len(data))
+	}
+	s.mu.Unlock()
+	for name, size := range sizes {
+		if err := fn(name, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.files[name]
+	return ok, nil
+}
+
+// savedMeta saves the backup meta and reads it back.
+func savedMeta(c *C, bc *Client) (*backup.BackupMeta, []string) {
+	c.Assert(bc.SaveBackupMeta(context.Background(), nil), IsNil)
+	reader, err := utils.NewMetaReader(context.Background(), bc.storage)
+	c.Assert(err, IsNil)
+	c.Assert(reader.Version(), Equals, utils.MetaV2)
+	meta, err := reader.ReadAll(context.Background())
+	c.Assert(err, IsNil)
+	names := make([]string, 0, len(meta.Files))
+	for _, file := range meta.Files {
+		names = append(names, file.Name)
+	}
+	return meta, names
+}
+
+func drainUpdates() (chan<- struct{}, func()) {
+	updateCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		for range updateCh {
+		}
+		close(done)
+	}()
+	return updateCh, func() {
+		close(updateCh)
+		<-done
+	}
+}
+
+func (s *testPushSuite) TestBackupRanges(c *C) {
+	bc, mgr := newFakeBackupClient()
+	keys := []string{"a", "b", "d", "e", "g", "h", "j", "k", "m", "n", "p", "q"}
+	ranges := make([]Range, 0, len(keys))
+	for i := 0; i < len(keys); i += 2 {
+		ranges = append(ranges, Range{StartKey: []byte(keys[i]), EndKey: []byte(keys[i+1])})
+	}
+	// These ranges are adjacent to the others, so they are coalesced.
+	ranges = append(ranges,
+		Range{StartKey: []byte("b"), EndKey: []byte("c")},
+		Range{StartKey: []byte("c"), EndKey: []byte("d")},
+	)
+
+	updateCh, wait := drainUpdates()
+	err := bc.BackupRanges(context.Background(), ranges, 1, 2, 100, 8, updateCh)
+	wait()
+	c.Assert(err, IsNil)
+
+	// "a"-"b", "b"-"c", "c"-"d" and "d"-"e" are pushed as one range.
+	c.Assert(mgr.requests, HasLen, 5)
+	c.Assert(mgr.maxInflight, Greater, 1)
+	c.Assert(mgr.maxInflight <= DefaultRangeConcurrency, IsTrue, Commentf("%d", mgr.maxInflight))
+	for _, req := range mgr.requests {
+		c.Assert(req.StartVersion, Equals, uint64(1))
+		c.Assert(req.EndVersion, Equals, uint64(2))
+		// The limits are divided among the concurrent ranges.
+		c.Assert(req.RateLimit, Equals, uint64(25))
+		c.Assert(req.Concurrency, Equals, uint32(2))
+	}
+
+	// The files of all ranges are merged in order.
+	meta, names := savedMeta(c, bc)
+	c.Assert(names, DeepEquals, []string{"a.sst", "g.sst", "j.sst", "m.sst", "p.sst"})
+	c.Assert(meta.StartVersion, Equals, uint64(1))
+	c.Assert(meta.EndVersion, Equals, uint64(2))
+}
+
+func (s *testPushSuite) TestDivideRangeLimits(c *C) {
+	cases := []struct {
+		ranges           int
+		rangeConcurrency uint
+		rateLimit        uint64
+		concurrency      uint32
+
+		n              uint
+		rangeRateLimit uint64
+		rangeThreads   uint32
+	}{
+		{10, 4, 100, 4, 4, 25, 1},
+		{10, 4, 0, 4, 4, 0, 1},
+		// There are fewer ranges or threads than the range concurrency.
+		{2, 4, 100, 4, 2, 50, 2},
+		{10, 4, 100, 2, 2, 50, 1},
+		{10, 4, 3, 4, 4, 1, 1},
+		{0, 4, 100, 4, 1, 100, 4},
+	}
+	for _, cs := range cases {
+		n, rateLimit, concurrency := divideRangeLimits(cs.ranges, cs.rangeConcurrency, cs.rateLimit, cs.concurrency)
+		c.Assert([]interface{}{n, rateLimit, concurrency}, DeepEquals,
+			[]interface{}{cs.n, cs.rangeRateLimit, cs.rangeThreads}, Commentf("%+v", cs))
+	}
+}
+
+func (s *testPushSuite) TestBackupRangesFailed(c *C) {
+	bc, mgr := newFakeBackupClient()
+	mgr.failKey = []byte("c")
+	ranges := []Range{
+		{StartKey: []byte("a"), EndKey: []byte("b")},
+		{StartKey: []byte("c"), EndKey: []byte("d")},
+		{StartKey: []byte("e"), EndKey: []byte("f")},
+	}
+
+	updateCh, wait := drainUpdates()
+	err := bc.BackupRanges(context.Background(), ranges, 0, 1, 0, 4, updateCh)
+	wait()
+	c.Assert(err, ErrorMatches, "backup failed")
+	_, names := savedMeta(c, bc)
+	c.Assert(names, HasLen, 0)
+}
+
+func (s *testPushSuite) TestFineGrainedBackup(c *C) {
+	// Regions: ["", "c") on store 1, ["c", "e") on store 2, ["e", "") on store 3.
+	cluster := mocktikv.NewCluster()
+	storeIDs, peerIDs, regionID, _ := mocktikv.BootstrapWithMultiStores(cluster, 3)
+	regionIDs := []uint64{regionID}
+	for i, key := range []string{"c", "e"} {
+		ids := cluster.AllocIDs(len(storeIDs) + 1)
+		cluster.Split(regionIDs[i], ids[0], []byte(key), ids[1:], ids[i+2])
+		regionIDs = append(regionIDs, ids[0])
+	}
+	cluster.ChangeLeader(regionID, peerIDs[0])
+	eligibleStores := make(map[uint64]bool)
+	for _, id := range storeIDs {
+		eligibleStores[id] = true
+	}
+	bc, mgr := newFakeBackupClientWithCluster(cluster)
+	bc.SetFineGrainedConcurrency(1)
+
+	// The range ["a", "b") is backed up, the rest crosses all regions.
+	rangeTree := newRangeTree()
+	rangeTree.put([]byte("a"), []byte("b"), []*backup.File{{Name: "a.sst"}})
+	mgr.respErrors["c"] = []*backup.Error{
+		{Detail: &backup.Error_RegionError{RegionError: &errorpb.Error{NotLeader: &errorpb.NotLeader{}}}},
+	}
+	mgr.respErrors["e"] = []*backup.Error{
+		{Detail: &backup.Error_RegionError{RegionError: &errorpb.Error{EpochNotMatch: &errorpb.EpochNotMatch{}}}},
+	}
+	updateCh, wait := drainUpdates()
+	err := bc.fineGrainedBackup(context.Background(), []byte("a"), []byte(""), 0, 1, 0, 4,
+		eligibleStores, rangeTree, updateCh)
+	wait()
+	c.Assert(err, IsNil)
+	c.Assert(mgr.maxInflight, Equals, 1)
+	c.Assert(rangeTree.getIncompleteRange([]byte("a"), []byte("")), HasLen, 0)
+
+	// Each part is sent to the leader of its region, and the failed parts
+	// are retried.
+	keys := make([]string, 0, len(mgr.requests))
+	for i, req := range mgr.requests {
+		keys = append(keys, fmt.Sprintf("%s-%s@%d", req.StartKey, req.EndKey, mgr.stores[i]))
+	}
+	c.Assert(keys, DeepEquals, []string{
+		fmt.Sprintf("b-c@%d", storeIDs[0]),
+		fmt.Sprintf("c-e@%d", storeIDs[1]),
+		fmt.Sprintf("e-@%d", storeIDs[2]),
+		fmt.Sprintf("c-e@%d", storeIDs[1]),
+		fmt.Sprintf("e-@%d", storeIDs[2]),
+	})
+
+	// Unexpected errors are returned.
+	bc, mgr = newFakeBackupClientWithCluster(cluster)
+	mgr.respErrors["c"] = []*backup.Error{
+		{Detail: &backup.Error_KvError{KvError: &kvrpcpb.KeyError{Abort: "abort"}}},
+	}
+	updateCh, wait = drainUpdates()
+	err = bc.fineGrainedBackup(context.Background(), []byte("a"), []byte(""), 0, 1, 0, 4,
+		eligibleStores, newRangeTree(), updateCh)
+	wait()
+	c.Assert(err, ErrorMatches, "backup region [0-9]+ on store [0-9]+: onBackupResponse error.*")
+}
+
+func (s *testPushSuite) TestSaveShardedBackupMeta(c *C) {
+	bc, _ := newFakeBackupClient()
+	files := []*backup.File{
+		{Name: "1_1.sst", StartKey: tablecodec.EncodeRowKeyWithHandle(1, 1), Crc64Xor: 1, TotalKvs: 1, TotalBytes: 10},
+		{Name: "1_2.sst", StartKey: tablecodec.EncodeRowKeyWithHandle(1, 2), Crc64Xor: 2, TotalKvs: 2, TotalBytes: 20},
+		{Name: "3_1.sst", StartKey: tablecodec.EncodeRowKeyWithHandle(3, 1), Crc64Xor: 4, TotalKvs: 3, TotalBytes: 30},
+	}
+	c.Assert(bc.appendFiles(context.Background(), files[:2]), IsNil)
+	c.Assert(bc.appendFiles(context.Background(), files[2:]), IsNil)
+
+	dbData, err := json.Marshal(&model.DBInfo{ID: 100, Name: model.NewCIStr("test")})
+	c.Assert(err, IsNil)
+	t1, err := json.Marshal(&model.TableInfo{ID: 1, Name: model.NewCIStr("t1")})
+	c.Assert(err, IsNil)
+	// The files of the partitions belong to the partitioned table.
+	t2, err := json.Marshal(&model.TableInfo{ID: 2, Name: model.NewCIStr("t2"), Partition: &model.PartitionInfo{
+		Definitions: []model.PartitionDefinition{{ID: 3, Name: model.NewCIStr("p0")}},
+	}})
+	c.Assert(err, IsNil)
+	schemas := []*backup.Schema{
+		{Db: dbData, Table: t1, Crc64Xor: 1 ^ 2, TotalKvs: 3, TotalBytes: 30},
+		{Db: dbData, Table: t2, Crc64Xor: 4, TotalKvs: 3, TotalBytes: 30},
+	}
+	for _, schema := range schemas {
+		valid, err := bc.FastChecksum(schema)
+		c.Assert(err, IsNil)
+		c.Assert(valid, IsTrue)
+	}
+	c.Assert(bc.CompleteMeta(context.Background(), finishedSchemas(schemas), true), IsNil)
+
+	bc.SetBackupInfo(&utils.BackupInfo{ClusterID: 1, ClusterVersion: "4.0.0"})
+	meta, names := savedMeta(c, bc)
+	c.Assert(names, DeepEquals, []string{"1_1.sst", "1_2.sst", "3_1.sst"})
+	c.Assert(meta.Schemas, HasLen, 2)
+	c.Assert(meta.ClusterId, Equals, uint64(1))
+	c.Assert(meta.ClusterVersion, Equals, "4.0.0")
+	storage := bc.storage.(*memStorage)
+	for _, name := range []string{
+		utils.MetaIndexFile, utils.MetaInfoFile, "backupmeta.files.000001", "backupmeta.files.000002", "backupmeta.schemas.000001",
+	} {
+		c.Assert(storage.files, HasKey, name)
+	}
+	c.Assert(storage.files, Not(HasKey), utils.MetaFile)
+
+	schemas[1].TotalKvs = 4
+	valid, err := bc.FastChecksum(schemas[1])
+	c.Assert(err, IsNil)
+	c.Assert(valid, IsFalse)
+	err = bc.CompleteMeta(context.Background(), finishedSchemas(schemas), true)
+	c.Assert(err, ErrorMatches, "mismatched checksum")
+}
+
+// finishedSchemas returns the schemas whose checksums have finished.
+func finishedSchemas(schemas []*backup.Schema) *Schemas {
+	pending := newBackupSchemas()
+	go func() {
+		for _, schema := range schemas {
+			pending.backupSchemaCh <- *schema
+		}
+		close(pending.backupSchemaCh)
+	}()
+	return pending
+}
@@ -3,6 +3,7 @@ package backup
 import (
 	"bytes"
 	"encoding/hex"
+	"sort"
 
 	"github.com/google/btree"
 	"github.com/pingcap/kvproto/pkg/backup"
@@ -145,6 +146,14 @@ func (rangeTree *RangeTree) put(
 	rangeTree.update(rg)
 }
 
+// merge puts all ranges of the other tree into the tree.
+func (rangeTree *RangeTree) merge(other RangeTree) {
+	other.tree.Ascend(func(i btree.Item) bool {
+		rangeTree.update(i.(*Range))
+		return true
+	})
+}
+
 func (rangeTree *RangeTree) getIncompleteRange(
 	startKey, endKey []byte,
 ) []Range {
@@ -205,3 +214,29 @@ func (rangeTree *RangeTree) checkDupFiles() {
 		return true
 	})
 }
+
+// coalesceRanges sorts the ranges and merges the adjacent or overlapping ones,
+// so that fewer backup requests are pushed to the stores. An empty end key
+// means the range is unbounded.
+func coalesceRanges(ranges []Range) []Range {
+	if len(ranges) == 0 {
+		return ranges
+	}
+	sorted := append([]Range{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].StartKey, sorted[j].StartKey) < 0
+	})
+	coalesced := make([]Range, 0, len(sorted))
+	cur := Range{StartKey: sorted[0].StartKey, EndKey: sorted[0].EndKey}
+	for _, rg := range sorted[1:] {
+		if len(cur.EndKey) != 0 && bytes.Compare(rg.StartKey, cur.EndKey) > 0 {
+			coalesced = append(coalesced, cur)
+			cur = Range{StartKey: rg.StartKey, EndKey: rg.EndKey}
+			continue
+		}
+		if len(cur.EndKey) != 0 && (len(rg.EndKey) == 0 || bytes.Compare(rg.EndKey, cur.EndKey) > 0) {
+			cur.EndKey = rg.EndKey
+		}
+	}
+	return append(coalesced, cur)
+}
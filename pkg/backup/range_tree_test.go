@@ -179,6 +179,36 @@ func (s *testRangeTreeSuite) TestRangeTree(c *C) {
 	assertAllComplete()
 }
 
+func (s *testRangeTreeSuite) TestCoalesceRanges(c *C) {
+	assertCoalesce := func(ranges []Range, expect []Range) {
+		coalesced := coalesceRanges(ranges)
+		c.Assert(len(coalesced), Equals, len(expect), Commentf("%#v", coalesced))
+		for idx, rg := range coalesced {
+			c.Assert(rg.StartKey, DeepEquals, expect[idx].StartKey)
+			c.Assert(rg.EndKey, DeepEquals, expect[idx].EndKey)
+		}
+	}
+	assertCoalesce(nil, nil)
+	// Adjacent ranges are merged regardless of the order.
+	assertCoalesce([]Range{*newRange([]byte("b"), []byte("c")), *newRange([]byte("a"), []byte("b"))},
+		[]Range{*newRange([]byte("a"), []byte("c"))})
+	// Ranges with a gap are kept apart.
+	assertCoalesce([]Range{*newRange([]byte("a"), []byte("b")), *newRange([]byte("c"), []byte("d"))},
+		[]Range{*newRange([]byte("a"), []byte("b")), *newRange([]byte("c"), []byte("d"))})
+	// Overlapping and contained ranges are merged.
+	assertCoalesce([]Range{
+		*newRange([]byte("a"), []byte("d")),
+		*newRange([]byte("b"), []byte("c")),
+		*newRange([]byte("c"), []byte("e")),
+		*newRange([]byte("f"), []byte("g")),
+	}, []Range{*newRange([]byte("a"), []byte("e")), *newRange([]byte("f"), []byte("g"))})
+	// An empty end key is unbounded.
+	assertCoalesce([]Range{*newRange([]byte("a"), []byte("")), *newRange([]byte("b"), []byte("c"))},
+		[]Range{*newRange([]byte("a"), []byte(""))})
+	assertCoalesce([]Range{*newRange([]byte("b"), []byte("")), *newRange([]byte("a"), []byte("b"))},
+		[]Range{*newRange([]byte("a"), []byte(""))})
+}
+
 func BenchmarkRangeTreeUpdate(b *testing.B) {
 	rangeTree := newRangeTree()
 	for i := 0; i < b.N; i++ {
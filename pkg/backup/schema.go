@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -119,17 +120,20 @@ func (pending *Schemas) Start(
 	}()
 }
 
-func (pending *Schemas) finishTableChecksum() ([]*backup.Schema, error) {
-	schemas := make([]*backup.Schema, 0, len(pending.schemas))
+// finishTableChecksum calls fn on each schema once its checksum finishes, so
+// that the schemas are not all kept in memory.
+func (pending *Schemas) finishTableChecksum(fn func(*backup.Schema) error) error {
 	for {
 		select {
 		case s, ok := <-pending.backupSchemaCh:
 			if !ok {
-				return schemas, nil
+				return nil
+			}
+			if err := fn(&s); err != nil {
+				return err
 			}
-			schemas = append(schemas, &s)
 		case err := <-pending.errCh:
-			return nil, errors.Trace(err)
+			return errors.Trace(err)
 		}
 	}
 }
@@ -139,6 +143,16 @@ func (pending *Schemas) Len() int {
 	return len(pending.schemas)
 }
 
+// Names returns the sorted names of the tables like "`db`.`table`".
+func (pending *Schemas) Names() []string {
+	names := make([]string, 0, len(pending.schemas))
+	for name := range pending.schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func calculateChecksum(
 	ctx context.Context,
 	table *model.TableInfo,
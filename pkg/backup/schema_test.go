@@ -5,7 +5,11 @@ import (
 	"math"
 
 	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
 	"github.com/pingcap/tidb-tools/pkg/filter"
+	"github.com/pingcap/tidb/config"
+	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/util/testkit"
 	"github.com/pingcap/tidb/util/testleak"
 
@@ -40,7 +44,7 @@ func (s *testBackupSchemaSuite) TestBuildBackupRangeAndSchema(c *C) {
 	})
 	c.Assert(err, IsNil)
 	_, backupSchemas, err := BuildBackupRangeAndSchema(
-		s.mock.Domain, s.mock.Storage, testFilter, math.MaxUint64)
+		s.mock.Domain, s.mock.Storage, testFilter, nil, math.MaxUint64)
 	c.Assert(err, NotNil)
 	c.Assert(backupSchemas, IsNil)
 
@@ -50,7 +54,7 @@ func (s *testBackupSchemaSuite) TestBuildBackupRangeAndSchema(c *C) {
 	})
 	c.Assert(err, IsNil)
 	_, backupSchemas, err = BuildBackupRangeAndSchema(
-		s.mock.Domain, s.mock.Storage, fooFilter, math.MaxUint64)
+		s.mock.Domain, s.mock.Storage, fooFilter, nil, math.MaxUint64)
 	c.Assert(err, NotNil)
 	c.Assert(backupSchemas, IsNil)
 
@@ -58,7 +62,7 @@ func (s *testBackupSchemaSuite) TestBuildBackupRangeAndSchema(c *C) {
 	noFilter, err := filter.New(false, &filter.Rules{})
 	c.Assert(err, IsNil)
 	_, backupSchemas, err = BuildBackupRangeAndSchema(
-		s.mock.Domain, s.mock.Storage, noFilter, math.MaxUint64)
+		s.mock.Domain, s.mock.Storage, noFilter, nil, math.MaxUint64)
 	c.Assert(err, NotNil)
 	c.Assert(backupSchemas, IsNil)
 
@@ -68,12 +72,17 @@ func (s *testBackupSchemaSuite) TestBuildBackupRangeAndSchema(c *C) {
 	tk.MustExec("insert into t1 values (10);")
 
 	_, backupSchemas, err = BuildBackupRangeAndSchema(
-		s.mock.Domain, s.mock.Storage, testFilter, math.MaxUint64)
+		s.mock.Domain, s.mock.Storage, testFilter, nil, math.MaxUint64)
 	c.Assert(err, IsNil)
 	c.Assert(backupSchemas.Len(), Equals, 1)
 	updateCh := make(chan struct{}, 2)
 	backupSchemas.Start(context.Background(), s.mock.Storage, math.MaxUint64, 1, updateCh)
-	schemas, err := backupSchemas.finishTableChecksum()
+	schemas := make([]*backup.Schema, 0)
+	collect := func(schema *backup.Schema) error {
+		schemas = append(schemas, schema)
+		return nil
+	}
+	err = backupSchemas.finishTableChecksum(collect)
 	<-updateCh
 	c.Assert(err, IsNil)
 	c.Assert(len(schemas), Equals, 1)
@@ -88,11 +97,12 @@ func (s *testBackupSchemaSuite) TestBuildBackupRangeAndSchema(c *C) {
 	tk.MustExec("insert into t2 values (11);")
 
 	_, backupSchemas, err = BuildBackupRangeAndSchema(
-		s.mock.Domain, s.mock.Storage, noFilter, math.MaxUint64)
+		s.mock.Domain, s.mock.Storage, noFilter, nil, math.MaxUint64)
 	c.Assert(err, IsNil)
 	c.Assert(backupSchemas.Len(), Equals, 2)
 	backupSchemas.Start(context.Background(), s.mock.Storage, math.MaxUint64, 2, updateCh)
-	schemas, err = backupSchemas.finishTableChecksum()
+	schemas = schemas[:0]
+	err = backupSchemas.finishTableChecksum(collect)
 	<-updateCh
 	<-updateCh
 	c.Assert(err, IsNil)
@@ -105,3 +115,105 @@ func (s *testBackupSchemaSuite) TestBuildBackupRangeAndSchema(c *C) {
 	c.Assert(schemas[1].TotalKvs, Not(Equals), 0, Commentf("%v", schemas[1]))
 	c.Assert(schemas[1].TotalBytes, Not(Equals), 0, Commentf("%v", schemas[1]))
 }
+
+var _ = Suite(&testBackupAllocatorSuite{})
+
+type testBackupAllocatorSuite struct {
+	mock *utils.MockCluster
+}
+
+func (s *testBackupAllocatorSuite) SetUpSuite(c *C) {
+	var err error
+	s.mock, err = utils.NewMockCluster()
+	c.Assert(err, IsNil)
+	c.Assert(s.mock.Start(), IsNil)
+}
+
+func (s *testBackupAllocatorSuite) TearDownSuite(c *C) {
+	s.mock.Stop()
+	testleak.AfterTest(c)()
+}
+
+func (s *testBackupAllocatorSuite) TestBackupAllocators(c *C) {
+	conf := config.GetGlobalConfig()
+	conf.Experimental.AllowAutoRandom = true
+	defer func() {
+		conf.Experimental.AllowAutoRandom = false
+	}()
+
+	tk := testkit.NewTestKit(c, s.mock.Storage)
+	tk.MustExec("create database if not exists alloc_db;")
+	tk.MustExec("create database if not exists alloc_db2;")
+	tk.MustExec("use alloc_db")
+	tk.MustExec("create table t_random (a bigint primary key auto_random(5), b int);")
+	tk.MustExec("create table t_inc (a int primary key auto_increment, b int);")
+	tk.MustExec("insert into t_random (b) values (1), (2);")
+	tk.MustExec("insert into t_inc (b) values (1), (2);")
+	// Renaming the table moves its allocators to the new database.
+	tk.MustExec("rename table t_inc to alloc_db2.t_inc;")
+
+	testFilter, err := filter.New(false, &filter.Rules{
+		DoDBs: []string{"alloc_db", "alloc_db2"},
+	})
+	c.Assert(err, IsNil)
+	_, backupSchemas, err := BuildBackupRangeAndSchema(
+		s.mock.Domain, s.mock.Storage, testFilter, nil, math.MaxUint64)
+	c.Assert(err, IsNil)
+	c.Assert(backupSchemas.Len(), Equals, 2)
+
+	random := backupSchemas.schemas["`alloc_db`.`t_random`"]
+	tableInfo, extra, err := utils.UnmarshalTableInfo(random.Table)
+	c.Assert(err, IsNil)
+	c.Assert(tableInfo.ContainsAutoRandomBits(), IsTrue)
+	c.Assert(extra.AutoRandomID, Greater, int64(2))
+
+	inc := backupSchemas.schemas["`alloc_db2`.`t_inc`"]
+	tableInfo, extra, err = utils.UnmarshalTableInfo(inc.Table)
+	c.Assert(err, IsNil)
+	c.Assert(tableInfo.AutoIncID, Greater, int64(2))
+	c.Assert(extra.AutoRandomID, Equals, int64(0))
+}
+
+func (s *testBackupAllocatorSuite) TestBuildBackupRangeAndSchemaWithPartitions(c *C) {
+	tk := testkit.NewTestKit(c, s.mock.Storage)
+	tk.MustExec("create database if not exists part_db;")
+	tk.MustExec("use part_db")
+	tk.MustExec("create table t_part (a int, b int, index i_b (b)) partition by range (a) (" +
+		"partition p0 values less than (10)," +
+		"partition p1 values less than (20)," +
+		"partition p2 values less than maxvalue);")
+	tk.MustExec("insert into t_part values (1, 1), (11, 11), (21, 21);")
+
+	testFilter, err := filter.New(false, &filter.Rules{
+		DoTables: []*filter.Table{{Schema: "part_db", Name: "t_part"}},
+	})
+	c.Assert(err, IsNil)
+	ranges, backupSchemas, err := BuildBackupRangeAndSchema(
+		s.mock.Domain, s.mock.Storage, testFilter, []string{"p0", "P2"}, math.MaxUint64)
+	c.Assert(err, IsNil)
+	c.Assert(backupSchemas.Len(), Equals, 1)
+
+	schema := backupSchemas.schemas["`part_db`.`t_part`"]
+	tableInfo, _, err := utils.UnmarshalTableInfo(schema.Table)
+	c.Assert(err, IsNil)
+	defs := tableInfo.Partition.Definitions
+	c.Assert(defs, HasLen, 2)
+	c.Assert(defs[0].Name.L, Equals, "p0")
+	c.Assert(defs[1].Name.L, Equals, "p2")
+	c.Assert(tableInfo.Partition.Num, Equals, uint64(2))
+	for _, r := range ranges {
+		id := tablecodec.DecodeTableID(r.StartKey)
+		c.Assert(id == defs[0].ID || id == defs[1].ID, IsTrue, Commentf("table id %d", id))
+	}
+
+	// The schema in the domain is not changed.
+	info, err := s.mock.Domain.GetSnapshotInfoSchema(math.MaxUint64)
+	c.Assert(err, IsNil)
+	table, err := info.TableByName(model.NewCIStr("part_db"), model.NewCIStr("t_part"))
+	c.Assert(err, IsNil)
+	c.Assert(table.Meta().Partition.Definitions, HasLen, 3)
+
+	_, _, err = BuildBackupRangeAndSchema(
+		s.mock.Domain, s.mock.Storage, testFilter, []string{"p9"}, math.MaxUint64)
+	c.Assert(err, ErrorMatches, ".*partition p9 not found.*")
+}
@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/conn"
+)
+
+// StoreFilter selects the stores which the backup requests are sent to by
+// their labels.
+type StoreFilter struct {
+	// Include are the labels of the stores to backup. A store is selected if
+	// it has any of them. All stores are selected if it is empty.
+	Include []*metapb.StoreLabel
+	// Exclude are the labels of the stores not to backup. A store is not
+	// selected if it has any of them.
+	Exclude []*metapb.StoreLabel
+}
+
+// Match checks whether the store is selected by the filter.
+func (f *StoreFilter) Match(store *metapb.Store) bool {
+	if hasAnyLabel(store, f.Exclude) {
+		return false
+	}
+	return len(f.Include) == 0 || hasAnyLabel(store, f.Include)
+}
+
+func hasAnyLabel(store *metapb.Store, labels []*metapb.StoreLabel) bool {
+	for _, label := range labels {
+		for _, storeLabel := range store.GetLabels() {
+			if storeLabel.GetKey() == label.GetKey() && storeLabel.GetValue() == label.GetValue() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getEligibleStores returns the up TiKV stores selected by the store filter.
+// The stores of the other engines, e.g. TiFlash, are always skipped.
+func (bc *Client) getEligibleStores(ctx context.Context) ([]*metapb.Store, error) {
+	stores, err := conn.GetAllTiKVStores(ctx, bc.mgr.GetPDClient())
+	if err != nil {
+		return nil, err
+	}
+	eligible := make([]*metapb.Store, 0, len(stores))
+	for _, store := range stores {
+		if store.GetState() != metapb.StoreState_Up {
+			log.Warn("skip store", zap.Uint64("StoreID", store.GetId()), zap.Stringer("State", store.GetState()))
+			continue
+		}
+		if !bc.storeFilter.Match(store) {
+			log.Info("skip store excluded by labels",
+				zap.Uint64("StoreID", store.GetId()), zap.Reflect("Labels", store.GetLabels()))
+			continue
+		}
+		eligible = append(eligible, store)
+	}
+	if len(eligible) == 0 {
+		return nil, errors.New("no store is eligible for backup, please check the store labels")
+	}
+	return eligible, nil
+}
@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	pd "github.com/pingcap/pd/client"
+	"github.com/pingcap/tidb/store/mockstore/mocktikv"
+
+	"github.com/pingcap/br/pkg/conn"
+)
+
+type testStoreSuite struct{}
+
+var _ = Suite(&testStoreSuite{})
+
+// labeledPDClient returns the stores with labels, which are not supported by
+// the mock cluster.
+type labeledPDClient struct {
+	pd.Client
+	stores []*metapb.Store
+}
+
+func (c labeledPDClient) GetAllStores(context.Context, ...pd.GetStoreOption) ([]*metapb.Store, error) {
+	return append([]*metapb.Store{}, c.stores...), nil
+}
+
+func (s *testStoreSuite) TestStoreFilter(c *C) {
+	store := &metapb.Store{Id: 1, Labels: []*metapb.StoreLabel{
+		{Key: "zone", Value: "z1"},
+		{Key: "disk", Value: "ssd"},
+	}}
+	zone1 := &metapb.StoreLabel{Key: "zone", Value: "z1"}
+	zone2 := &metapb.StoreLabel{Key: "zone", Value: "z2"}
+	ssd := &metapb.StoreLabel{Key: "disk", Value: "ssd"}
+
+	cases := []struct {
+		filter StoreFilter
+		match  bool
+	}{
+		{StoreFilter{}, true},
+		{StoreFilter{Include: []*metapb.StoreLabel{zone1}}, true},
+		{StoreFilter{Include: []*metapb.StoreLabel{zone2}}, false},
+		{StoreFilter{Include: []*metapb.StoreLabel{zone2, zone1}}, true},
+		{StoreFilter{Exclude: []*metapb.StoreLabel{zone2}}, true},
+		{StoreFilter{Exclude: []*metapb.StoreLabel{ssd}}, false},
+		{StoreFilter{Include: []*metapb.StoreLabel{zone1}, Exclude: []*metapb.StoreLabel{ssd}}, false},
+	}
+	for i, cs := range cases {
+		c.Assert(cs.filter.Match(store), Equals, cs.match, Commentf("case %d", i))
+	}
+}
+
+func (s *testStoreSuite) TestEligibleStores(c *C) {
+	cluster := mocktikv.NewCluster()
+	storeIDs, peerIDs, regionID, _ := mocktikv.BootstrapWithMultiStores(cluster, 4)
+	stores := cluster.GetAllStores()
+	for _, store := range stores {
+		switch store.GetId() {
+		case storeIDs[0]:
+			store.Labels = []*metapb.StoreLabel{{Key: "disk", Value: "hdd"}}
+		case storeIDs[3]:
+			store.Labels = []*metapb.StoreLabel{{Key: conn.EngineLabelKey, Value: conn.EngineLabelTiFlash}}
+		}
+	}
+	mgr := &conn.Mgr{}
+	mgr.SetPDClient(labeledPDClient{Client: mocktikv.NewPDClient(cluster), stores: stores})
+	bc := &Client{mgr: mgr}
+	bc.SetStoreFilter(StoreFilter{Exclude: []*metapb.StoreLabel{{Key: "disk", Value: "hdd"}}})
+	ctx := context.Background()
+
+	eligible, err := bc.getEligibleStores(ctx)
+	c.Assert(err, IsNil)
+	eligibleStores := make(map[uint64]bool)
+	for _, store := range eligible {
+		eligibleStores[store.GetId()] = true
+	}
+	// The store with the excluded label and the TiFlash store are skipped.
+	c.Assert(eligibleStores, DeepEquals, map[uint64]bool{storeIDs[1]: true, storeIDs[2]: true})
+
+	// The leader is on the excluded store.
+	_, err = bc.findRegionLeader(ctx, []byte("a"), eligibleStores)
+	c.Assert(err, ErrorMatches, "the leader of region [0-9]+ is on store [0-9]+, which is not eligible for backup")
+	cluster.ChangeLeader(regionID, peerIDs[2])
+	leader, err := bc.findRegionLeader(ctx, []byte("a"), eligibleStores)
+	c.Assert(err, IsNil)
+	c.Assert(leader.GetStoreId(), Equals, storeIDs[2])
+
+	bc.SetStoreFilter(StoreFilter{Include: []*metapb.StoreLabel{{Key: "zone", Value: "z1"}}})
+	_, err = bc.getEligibleStores(ctx)
+	c.Assert(err, ErrorMatches, "no store is eligible for backup.*")
+}
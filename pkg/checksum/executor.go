@@ -2,9 +2,9 @@ package checksum
 
 import (
 	"context"
-	"log"
 
 	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/tidb/distsql"
 	"github.com/pingcap/tidb/kv"
@@ -73,10 +73,14 @@ func buildChecksumRequest(
 		var oldPartID int64
 		if oldTable != nil {
 			for _, oldPartDef := range oldTable.Info.Partition.Definitions {
-				if oldPartDef.Name == partDef.Name {
+				if oldPartDef.Name.L == partDef.Name.L {
 					oldPartID = oldPartDef.ID
 				}
 			}
+			// The partitions which are not restored are skipped.
+			if oldPartID == 0 {
+				continue
+			}
 		}
 		rs, err := buildRequest(newTable, partDef.ID, oldTable, oldPartID, startTS)
 		if err != nil {
@@ -109,16 +113,19 @@ func buildRequest(
 		var oldIndexInfo *model.IndexInfo
 		if oldTable != nil {
 			for _, oldIndex := range oldTable.Info.Indices {
-				if oldIndex.Name == indexInfo.Name {
+				// The data of non-public indices is not backed up.
+				if oldIndex.State == model.StatePublic && oldIndex.Name.L == indexInfo.Name.L {
 					oldIndexInfo = oldIndex
 					break
 				}
 			}
 			if oldIndexInfo == nil {
-				log.Panic("index not found",
-					zap.Reflect("table", tableInfo),
-					zap.Reflect("oldTable", oldTable.Info),
+				log.Error("index not found",
+					zap.Stringer("table", tableInfo.Name),
+					zap.Stringer("oldTable", oldTable.Info.Name),
 					zap.Stringer("index", indexInfo.Name))
+				return nil, errors.Errorf("index %s of table %s not found in the backup",
+					indexInfo.Name, oldTable.Info.Name)
 			}
 		}
 		req, err = buildIndexRequest(
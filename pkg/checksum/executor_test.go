@@ -8,6 +8,7 @@ import (
 	"github.com/gogo/protobuf/proto"
 	. "github.com/pingcap/check"
 	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/util/testkit"
 	"github.com/pingcap/tidb/util/testleak"
 	"github.com/pingcap/tipb/go-tipb"
@@ -100,3 +101,60 @@ func (s *testChecksumSuite) TestChecksum(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(resp2, NotNil)
 }
+
+func (s *testChecksumSuite) TestChecksumIndexNotFound(c *C) {
+	tableInfo := &model.TableInfo{
+		ID:   1,
+		Name: model.NewCIStr("t1"),
+		Indices: []*model.IndexInfo{{
+			ID:    1,
+			Name:  model.NewCIStr("i1"),
+			State: model.StatePublic,
+		}},
+	}
+	// The index is not public in the backup.
+	oldInfo := tableInfo.Clone()
+	oldInfo.ID = 2
+	oldInfo.Indices[0].State = model.StateWriteReorganization
+	_, err := NewExecutorBuilder(tableInfo, math.MaxUint64).
+		SetOldTable(&utils.Table{Info: oldInfo}).Build()
+	c.Assert(err, ErrorMatches, "index i1 of table t1 not found in the backup")
+}
+
+func (s *testChecksumSuite) TestChecksumPartitionsNotRestored(c *C) {
+	tableInfo := &model.TableInfo{
+		ID:   1,
+		Name: model.NewCIStr("t1"),
+		Partition: &model.PartitionInfo{
+			Type: model.PartitionTypeRange,
+			Definitions: []model.PartitionDefinition{
+				{ID: 2, Name: model.NewCIStr("p0")},
+				{ID: 3, Name: model.NewCIStr("p1")},
+				{ID: 4, Name: model.NewCIStr("p2")},
+			},
+		},
+	}
+	// Only p1 is restored.
+	oldInfo := &model.TableInfo{
+		ID:   11,
+		Name: model.NewCIStr("t1"),
+		Partition: &model.PartitionInfo{
+			Type:        model.PartitionTypeRange,
+			Definitions: []model.PartitionDefinition{{ID: 13, Name: model.NewCIStr("p1")}},
+		},
+	}
+	exe, err := NewExecutorBuilder(tableInfo, math.MaxUint64).
+		SetOldTable(&utils.Table{Info: oldInfo}).Build()
+	c.Assert(err, IsNil)
+	// The requests of the table and p1.
+	c.Assert(exe.reqs, HasLen, 2)
+	for _, req := range exe.reqs {
+		id := tablecodec.DecodeTableID(req.KeyRanges[0].StartKey)
+		c.Assert(id == 1 || id == 3, IsTrue, Commentf("table id %d", id))
+	}
+
+	// All partitions are checksummed without the old table.
+	exe, err = NewExecutorBuilder(tableInfo, math.MaxUint64).Build()
+	c.Assert(err, IsNil)
+	c.Assert(exe.reqs, HasLen, 4)
+}
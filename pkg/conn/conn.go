@@ -19,14 +19,18 @@ import (
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/log"
 	pd "github.com/pingcap/pd/client"
+	"github.com/pingcap/pd/pkg/typeutil"
 	"github.com/pingcap/tidb/domain"
 	"github.com/pingcap/tidb/session"
+	"github.com/pingcap/tidb/sessionctx/variable"
 	"github.com/pingcap/tidb/store/tikv"
 	"github.com/pingcap/tidb/util/codec"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/keepalive"
+
+	"github.com/pingcap/br/pkg/utils"
 )
 
 const (
@@ -34,8 +38,44 @@ const (
 	clusterVersionPrefix = "pd/api/v1/config/cluster-version"
 	regionCountPrefix    = "pd/api/v1/stats/region"
 	schdulerPrefix       = "pd/api/v1/schedulers"
+	storesPrefix         = "pd/api/v1/stores"
+	replicatePrefix      = "pd/api/v1/config/replicate"
 )
 
+const (
+	// EngineLabelKey is the label key of the storage engine of a store.
+	EngineLabelKey = "engine"
+	// EngineLabelTiFlash is the label value of the TiFlash stores.
+	EngineLabelTiFlash = "tiflash"
+)
+
+// IsTiFlash checks whether the store is a TiFlash store.
+func IsTiFlash(store *metapb.Store) bool {
+	for _, label := range store.GetLabels() {
+		if label.GetKey() == EngineLabelKey && label.GetValue() == EngineLabelTiFlash {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAllTiKVStores returns the stores of TiKV, excluding the tombstone stores
+// and the TiFlash stores, which don't serve the requests of BR.
+func GetAllTiKVStores(ctx context.Context, pdClient pd.Client) ([]*metapb.Store, error) {
+	stores, err := pdClient.GetAllStores(ctx, pd.WithExcludeTombstone())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	tikvStores := stores[:0]
+	for _, store := range stores {
+		if IsTiFlash(store) {
+			continue
+		}
+		tikvStores = append(tikvStores, store)
+	}
+	return tikvStores, nil
+}
+
 // Mgr manages connections to a TiDB cluster.
 type Mgr struct {
 	pdClient pd.Client
@@ -86,15 +126,21 @@ func pdRequest(
 	return r, nil
 }
 
-// NewMgr creates a new Mgr.
-func NewMgr(ctx context.Context, pdAddrs string, storage tikv.Storage) (*Mgr, error) {
+// NewMgr creates a new Mgr. If needDomain is false, it does not bootstrap
+// the embedded TiDB domain, and GetDomain returns nil.
+func NewMgr(
+	ctx context.Context,
+	pdAddrs string,
+	storage tikv.Storage,
+	needDomain bool,
+) (*Mgr, error) {
 	addrs := strings.Split(pdAddrs, ",")
 
 	failure := errors.Errorf("pd address (%s) has wrong format", pdAddrs)
 	cli := &http.Client{Timeout: 30 * time.Second}
+	var clusterVersion []byte
 	for _, addr := range addrs {
-		_, failure = pdRequest(ctx, addr, clusterVersionPrefix, cli, http.MethodGet, nil)
-		// TODO need check cluster version >= 3.1 when br release
+		clusterVersion, failure = pdRequest(ctx, addr, clusterVersionPrefix, cli, http.MethodGet, nil)
 		if failure == nil {
 			break
 		}
@@ -102,6 +148,13 @@ func NewMgr(ctx context.Context, pdAddrs string, storage tikv.Storage) (*Mgr, er
 	if failure != nil {
 		return nil, errors.Annotatef(failure, "pd address (%s) not available, please check network", pdAddrs)
 	}
+	// The versions of the development builds may not be parsed, they are not
+	// checked.
+	if _, err := utils.ParseVersion(string(clusterVersion)); err != nil {
+		log.Warn("cannot check cluster version", zap.Error(err))
+	} else if err = utils.CheckClusterVersion(string(clusterVersion)); err != nil {
+		return nil, err
+	}
 
 	pdClient, err := pd.NewClient(addrs, pd.SecurityOption{})
 	if err != nil {
@@ -130,9 +183,12 @@ func NewMgr(ctx context.Context, pdAddrs string, storage tikv.Storage) (*Mgr, er
 		return nil, errors.Errorf("tikv cluster not health %+v", stores)
 	}
 
-	dom, err := session.BootstrapSession(storage)
-	if err != nil {
-		return nil, errors.Trace(err)
+	var dom *domain.Domain
+	if needDomain {
+		dom, err = session.BootstrapSession(storage)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
 	}
 
 	mgr := &Mgr{
@@ -170,12 +226,43 @@ func (mgr *Mgr) getClusterVersionWith(ctx context.Context, get pdHTTPRequest) (s
 			err = e
 			continue
 		}
-		return string(v), nil
+		return strings.Trim(strings.TrimSpace(string(v)), `"`), nil
 	}
 
 	return "", err
 }
 
+// GetGlobalVariables returns the global variables of the cluster, it needs
+// the embedded TiDB domain.
+func (mgr *Mgr) GetGlobalVariables(names []string) (map[string]string, error) {
+	if mgr.dom == nil {
+		return nil, errors.New("the global variables are not available without the domain")
+	}
+	se, err := session.CreateSession(mgr.storage)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer se.Close()
+	variables := make(map[string]string, len(names))
+	for _, name := range names {
+		value, err := variable.GetGlobalSystemVar(se.GetSessionVars(), name)
+		if err != nil {
+			return nil, errors.Annotatef(err, "get global variable %s failed", name)
+		}
+		variables[name] = value
+	}
+	return variables, nil
+}
+
+// RegionStats is the statistics of the regions in a range reported to PD.
+type RegionStats struct {
+	Count int `json:"count"`
+	// StorageSize is the approximate size of the regions in MiB.
+	StorageSize int64 `json:"storage_size"`
+	// StorageKeys is the approximate number of the keys of the regions.
+	StorageKeys int64 `json:"storage_keys"`
+}
+
 // GetRegionCount returns the region count in the specified range.
 func (mgr *Mgr) GetRegionCount(ctx context.Context, startKey, endKey []byte) (int, error) {
 	return mgr.getRegionCountWith(ctx, pdRequest, startKey, endKey)
@@ -184,6 +271,22 @@ func (mgr *Mgr) GetRegionCount(ctx context.Context, startKey, endKey []byte) (in
 func (mgr *Mgr) getRegionCountWith(
 	ctx context.Context, get pdHTTPRequest, startKey, endKey []byte,
 ) (int, error) {
+	stats, err := mgr.getRegionStatsWith(ctx, get, startKey, endKey)
+	if err != nil {
+		return 0, err
+	}
+	return stats.Count, nil
+}
+
+// GetRegionStats returns the statistics of the regions in the specified
+// range.
+func (mgr *Mgr) GetRegionStats(ctx context.Context, startKey, endKey []byte) (*RegionStats, error) {
+	return mgr.getRegionStatsWith(ctx, pdRequest, startKey, endKey)
+}
+
+func (mgr *Mgr) getRegionStatsWith(
+	ctx context.Context, get pdHTTPRequest, startKey, endKey []byte,
+) (*RegionStats, error) {
 	// TiKV reports region start/end keys to PD in memcomparable-format.
 	var start, end string
 	start = url.QueryEscape(string(codec.EncodeBytes(nil, startKey)))
@@ -200,14 +303,14 @@ func (mgr *Mgr) getRegionCountWith(
 			err = e
 			continue
 		}
-		regionsMap := make(map[string]interface{})
-		err = json.Unmarshal(v, &regionsMap)
+		stats := &RegionStats{}
+		err = json.Unmarshal(v, stats)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		return int(regionsMap["count"].(float64)), nil
+		return stats, nil
 	}
-	return 0, err
+	return nil, err
 }
 
 func (mgr *Mgr) getGrpcConnLocked(ctx context.Context, storeID uint64) (*grpc.ClientConn, error) {
@@ -312,6 +415,78 @@ func (mgr *Mgr) addSchedulerWith(ctx context.Context, scheduler string, post pdH
 	return err
 }
 
+// StoreCapacity is the capacity of a store reported to PD.
+type StoreCapacity struct {
+	StoreID   uint64
+	Capacity  uint64
+	Available uint64
+}
+
+// GetStoresCapacity returns the capacity of the stores which are not
+// tombstone.
+func (mgr *Mgr) GetStoresCapacity(ctx context.Context) ([]StoreCapacity, error) {
+	return mgr.getStoresCapacityWith(ctx, pdRequest)
+}
+
+func (mgr *Mgr) getStoresCapacityWith(ctx context.Context, get pdHTTPRequest) ([]StoreCapacity, error) {
+	var err error
+	for _, addr := range mgr.pdHTTP.addrs {
+		v, e := get(ctx, addr, storesPrefix, mgr.pdHTTP.cli, http.MethodGet, nil)
+		if e != nil {
+			err = e
+			continue
+		}
+		stores := struct {
+			Stores []struct {
+				Store struct {
+					ID uint64 `json:"id"`
+				} `json:"store"`
+				Status struct {
+					Capacity  typeutil.ByteSize `json:"capacity"`
+					Available typeutil.ByteSize `json:"available"`
+				} `json:"status"`
+			} `json:"stores"`
+		}{}
+		if err = json.Unmarshal(v, &stores); err != nil {
+			return nil, errors.Trace(err)
+		}
+		capacities := make([]StoreCapacity, 0, len(stores.Stores))
+		for _, s := range stores.Stores {
+			capacities = append(capacities, StoreCapacity{
+				StoreID:   s.Store.ID,
+				Capacity:  uint64(s.Status.Capacity),
+				Available: uint64(s.Status.Available),
+			})
+		}
+		return capacities, nil
+	}
+	return nil, err
+}
+
+// GetMaxReplicas returns the number of the replicas of each region.
+func (mgr *Mgr) GetMaxReplicas(ctx context.Context) (uint64, error) {
+	return mgr.getMaxReplicasWith(ctx, pdRequest)
+}
+
+func (mgr *Mgr) getMaxReplicasWith(ctx context.Context, get pdHTTPRequest) (uint64, error) {
+	var err error
+	for _, addr := range mgr.pdHTTP.addrs {
+		v, e := get(ctx, addr, replicatePrefix, mgr.pdHTTP.cli, http.MethodGet, nil)
+		if e != nil {
+			err = e
+			continue
+		}
+		replicate := struct {
+			MaxReplicas uint64 `json:"max-replicas"`
+		}{}
+		if err = json.Unmarshal(v, &replicate); err != nil {
+			return 0, errors.Trace(err)
+		}
+		return replicate.MaxReplicas, nil
+	}
+	return 0, err
+}
+
 // ListSchedulers list all pd scheduler
 func (mgr *Mgr) ListSchedulers(ctx context.Context) ([]string, error) {
 	return mgr.listSchedulersWith(ctx, pdRequest)
@@ -348,7 +523,9 @@ func (mgr *Mgr) Close() {
 
 	// Gracefully shutdown domain so it does not affect other TiDB DDL.
 	// Must close domain before closing storage, otherwise it gets stuck forever.
-	mgr.dom.Close()
+	if mgr.dom != nil {
+		mgr.dom.Close()
+	}
 
 	atomic.StoreUint32(&tikv.ShuttingDown, 1)
 	mgr.storage.Close()
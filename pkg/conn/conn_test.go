@@ -13,6 +13,7 @@ import (
 	. "github.com/pingcap/check"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/metapb"
+	pd "github.com/pingcap/pd/client"
 	"github.com/pingcap/pd/server/core"
 	"github.com/pingcap/pd/server/statistics"
 	"github.com/pingcap/tidb/util/codec"
@@ -63,6 +64,41 @@ func (s *testClientSuite) TestGetClusterVersion(c *C) {
 	}
 	_, err = s.mgr.getClusterVersionWith(ctx, mock)
 	c.Assert(err, NotNil)
+
+	// The quotes of the JSON string are trimmed.
+	mock = func(context.Context, string, string, *http.Client, string, io.Reader) ([]byte, error) {
+		return []byte("\"4.0.0-beta\"\n"), nil
+	}
+	respString, err = s.mgr.getClusterVersionWith(ctx, mock)
+	c.Assert(err, IsNil)
+	c.Assert(respString, Equals, "4.0.0-beta")
+}
+
+func (s *testClientSuite) TestGetStoresCapacity(c *C) {
+	s.mgr.pdHTTP.addrs = []string{""}
+	mock := func(_ context.Context, _ string, prefix string, _ *http.Client, _ string, _ io.Reader) ([]byte, error) {
+		switch prefix {
+		case storesPrefix:
+			return []byte(`{"count":2,"stores":[
+				{"store":{"id":1,"state_name":"Up"},"status":{"capacity":"100GiB","available":"40GiB"}},
+				{"store":{"id":4,"state_name":"Offline"},"status":{"capacity":"1TiB","available":"512MiB"}}
+			]}`), nil
+		case replicatePrefix:
+			return []byte(`{"max-replicas":3,"location-labels":""}`), nil
+		}
+		return nil, errors.Errorf("unexpected prefix %s", prefix)
+	}
+
+	ctx := context.Background()
+	capacities, err := s.mgr.getStoresCapacityWith(ctx, mock)
+	c.Assert(err, IsNil)
+	c.Assert(capacities, DeepEquals, []StoreCapacity{
+		{StoreID: 1, Capacity: 100 << 30, Available: 40 << 30},
+		{StoreID: 4, Capacity: 1 << 40, Available: 512 << 20},
+	})
+	replicas, err := s.mgr.getMaxReplicasWith(ctx, mock)
+	c.Assert(err, IsNil)
+	c.Assert(replicas, Equals, uint64(3))
 }
 
 func (s *testClientSuite) TestScheduler(c *C) {
@@ -128,7 +164,7 @@ func (s *testClientSuite) TestRegionCount(c *C) {
 		c.Log(hex.EncodeToString([]byte(start)))
 		c.Log(hex.EncodeToString([]byte(end)))
 		regions := s.regions.ScanRange([]byte(start), []byte(end), 0)
-		stats := statistics.RegionStats{Count: len(regions)}
+		stats := statistics.RegionStats{Count: len(regions), StorageSize: int64(len(regions)) * 96}
 		ret, err := json.Marshal(stats)
 		c.Assert(err, IsNil)
 		return ret, nil
@@ -146,4 +182,38 @@ func (s *testClientSuite) TestRegionCount(c *C) {
 	resp, err = s.mgr.getRegionCountWith(ctx, mock, []byte{1, 2}, []byte{1, 4})
 	c.Assert(err, IsNil)
 	c.Assert(resp, Equals, 2)
+
+	stats, err := s.mgr.getRegionStatsWith(ctx, mock, []byte{1, 2}, []byte{1, 4})
+	c.Assert(err, IsNil)
+	c.Assert(stats.Count, Equals, 2)
+	c.Assert(stats.StorageSize, Equals, int64(192))
+}
+
+type fakePDClient struct {
+	pd.Client
+	stores []*metapb.Store
+}
+
+func (c fakePDClient) GetAllStores(context.Context, ...pd.GetStoreOption) ([]*metapb.Store, error) {
+	return append([]*metapb.Store{}, c.stores...), nil
+}
+
+func (s *testClientSuite) TestGetAllTiKVStores(c *C) {
+	tiflashLabels := []*metapb.StoreLabel{{Key: EngineLabelKey, Value: EngineLabelTiFlash}}
+	pdClient := fakePDClient{stores: []*metapb.Store{
+		{Id: 1},
+		{Id: 2, Labels: tiflashLabels},
+		{Id: 3, Labels: []*metapb.StoreLabel{{Key: "zone", Value: "z1"}}},
+		{Id: 4, Labels: append([]*metapb.StoreLabel{{Key: "zone", Value: "z1"}}, tiflashLabels...)},
+	}}
+	c.Assert(IsTiFlash(pdClient.stores[0]), IsFalse)
+	c.Assert(IsTiFlash(pdClient.stores[3]), IsTrue)
+
+	stores, err := GetAllTiKVStores(context.Background(), pdClient)
+	c.Assert(err, IsNil)
+	ids := make([]uint64, 0, len(stores))
+	for _, store := range stores {
+		ids = append(ids, store.GetId())
+	}
+	c.Assert(ids, DeepEquals, []uint64{1, 3})
 }
@@ -0,0 +1,250 @@
+// Package export exports the rows of the tables of a backup to the files of
+// CSV or SQL, without restoring the backup to a cluster.
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/tablecodec"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/sst"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// TableResult is the result of exporting a table.
+type TableResult struct {
+	Name  string   `json:"name"`
+	Files []string `json:"files"`
+	Rows  uint64   `json:"rows"`
+}
+
+// Exporter exports the rows of the tables of a backup. Each file of the
+// write column family of a table is exported to a file named
+// `{db}.{table}.{seq}.{format}`, where the names of the database and the
+// table are escaped by escapeFileName. The files without rows are skipped.
+type Exporter struct {
+	backup      storage.ExternalStorage
+	output      storage.ExternalStorage
+	meta        *backup.BackupMeta
+	format      Format
+	concurrency uint
+	loc         *time.Location
+}
+
+// NewExporter returns an exporter of the backup in the storage, which
+// exports concurrency files concurrently. The time values are exported in
+// the time zone loc.
+func NewExporter(
+	backupStorage storage.ExternalStorage,
+	output storage.ExternalStorage,
+	meta *backup.BackupMeta,
+	format Format,
+	concurrency uint,
+	loc *time.Location,
+) *Exporter {
+	return &Exporter{
+		backup:      backupStorage,
+		output:      output,
+		meta:        meta,
+		format:      format,
+		concurrency: concurrency,
+		loc:         loc,
+	}
+}
+
+// exportJob exports a file of the write column family of a table, with the
+// file of the default column family of the same range.
+type exportJob struct {
+	result  *TableResult
+	table   *utils.Table
+	decoder *rowDecoder
+	ids     map[int64]bool
+	group   []*backup.File
+	name    string
+}
+
+// ExportTables exports the tables, and returns the results in the order of
+// the tables. Only the newest version of each row committed before the end
+// version of the backup is exported, so an incremental backup exports the
+// rows changed in it.
+func (e *Exporter) ExportTables(c context.Context, tables []*utils.Table) ([]*TableResult, error) {
+	if e.meta.IsRawKv {
+		return nil, errors.New("the backup of raw kv can't be exported")
+	}
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	results := make([]*TableResult, 0, len(tables))
+	jobs := make([]*exportJob, 0)
+	for _, table := range tables {
+		result := &TableResult{
+			Name:  utils.EncloseName(table.Db.Name.O) + "." + utils.EncloseName(table.Info.Name.O),
+			Files: make([]string, 0),
+		}
+		results = append(results, result)
+		decoder, err := newRowDecoder(table.Info, e.loc)
+		if err != nil {
+			return nil, errors.Annotatef(err, "export %s failed", result.Name)
+		}
+		ids := map[int64]bool{table.Info.ID: true}
+		if table.Info.Partition != nil {
+			for _, def := range table.Info.Partition.Definitions {
+				ids[def.ID] = true
+			}
+		}
+		seq := 0
+		for _, group := range utils.GroupFiles(table.Files()) {
+			// The files of the default column family without the write
+			// column family have no committed rows.
+			if !strings.Contains(group[0].Name, "write") {
+				continue
+			}
+			jobs = append(jobs, &exportJob{
+				result:  result,
+				table:   table,
+				decoder: decoder,
+				ids:     ids,
+				group:   group,
+				name: fmt.Sprintf("%s.%s.%09d.%s",
+					escapeFileName(table.Db.Name.O), escapeFileName(table.Info.Name.O), seq, e.format),
+			})
+			seq++
+		}
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	wg := new(sync.WaitGroup)
+	pool := utils.NewWorkerPool(e.concurrency, "export")
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		job := job
+		wg.Add(1)
+		pool.Apply(func() {
+			defer wg.Done()
+			rows, err := e.exportFile(ctx, job)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = errors.Annotatef(err, "export %s failed", job.group[0].Name)
+					cancel()
+				}
+				return
+			}
+			if rows > 0 {
+				job.result.Files = append(job.result.Files, job.name)
+				job.result.Rows += rows
+			}
+		})
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := c.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, result := range results {
+		sort.Strings(result.Files)
+	}
+	return results, nil
+}
+
+// escapeFileName escapes an identifier to a part of the file names. The
+// separators of the paths and the parts, the percent signs and the control
+// characters are percent encoded, so the names can't escape the output
+// directory, and the names of different tables don't collide.
+func escapeFileName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		if ch == '/' || ch == '\\' || ch == '.' || ch == '%' || ch < 0x20 || ch == 0x7f {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		} else {
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
+
+// exportFile exports the rows of the files of the job, and returns the
+// number of the rows exported.
+func (e *Exporter) exportFile(ctx context.Context, job *exportJob) (uint64, error) {
+	readers := make([]*sst.Reader, 0, len(job.group))
+	for _, file := range job.group {
+		data, err := e.backup.Read(ctx, file.Name)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		reader, err := sst.NewReader(data)
+		if err != nil {
+			return 0, errors.Annotatef(err, "decode %s failed", file.Name)
+		}
+		readers = append(readers, reader)
+	}
+	var def *sst.Reader
+	if len(readers) > 1 {
+		def = readers[1]
+	}
+
+	writer, err := newRowWriter(e.format, job.table.Db.Name.O, job.table.Info.Name.O, job.decoder.columns)
+	if err != nil {
+		return 0, err
+	}
+	var rows uint64
+	// The versions of a key are ordered by the commit ts descending, the
+	// first put or delete record no later than the end version decides the
+	// row, and the locks and rollbacks are skipped.
+	var lastKey []byte
+	decided := false
+	err = sst.IterateWrites(readers[0], def, func(raw []byte, commitTS uint64, record *sst.Write, value []byte) error {
+		if !bytes.Equal(raw, lastKey) {
+			lastKey = append(lastKey[:0], raw...)
+			decided = false
+		}
+		if decided || commitTS > e.meta.EndVersion ||
+			record.Type == sst.WriteLock || record.Type == sst.WriteRollback {
+			return nil
+		}
+		decided = true
+		if record.Type == sst.WriteDelete {
+			return nil
+		}
+		tableID, handle, err := tablecodec.DecodeRecordKey(raw)
+		// The keys of the indices are skipped.
+		if err != nil || !job.ids[tableID] {
+			return nil
+		}
+		row, err := job.decoder.decode(handle, value)
+		if err != nil {
+			return err
+		}
+		rows++
+		return writer.writeRow(row)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if rows == 0 {
+		return 0, nil
+	}
+	if err = e.output.Write(ctx, job.name, writer.finish()); err != nil {
+		return 0, errors.Annotatef(err, "write %s failed", job.name)
+	}
+	log.Info("export file", zap.String("file", job.name), zap.Uint64("rows", rows))
+	return rows, nil
+}
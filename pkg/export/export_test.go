@@ -0,0 +1,141 @@
+package export
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/charset"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/rowcodec"
+)
+
+func TestT(t *testing.T) {
+	TestingT(t)
+}
+
+type testExportSuite struct{}
+
+var _ = Suite(&testExportSuite{})
+
+func mockColumn(id int64, name string, tp byte) *model.ColumnInfo {
+	col := &model.ColumnInfo{
+		ID:        id,
+		Name:      model.NewCIStr(name),
+		Offset:    int(id - 1),
+		State:     model.StatePublic,
+		FieldType: *types.NewFieldType(tp),
+	}
+	if types.IsString(tp) {
+		col.Charset, col.Collate = charset.CharsetUTF8MB4, charset.CollationUTF8MB4
+	}
+	return col
+}
+
+func mockTableInfo() *model.TableInfo {
+	id := mockColumn(1, "id", mysql.TypeLonglong)
+	id.Flag = mysql.PriKeyFlag | mysql.NotNullFlag
+	name := mockColumn(2, "name", mysql.TypeVarchar)
+	data := mockColumn(3, "data", mysql.TypeBlob)
+	data.Charset, data.Collate = charset.CharsetBin, charset.CollationBin
+	// The column added after the rows are written.
+	added := mockColumn(4, "added", mysql.TypeLong)
+	added.OriginDefaultValue = "5"
+	virtual := mockColumn(5, "virtual", mysql.TypeLonglong)
+	virtual.GeneratedExprString = "id + 1"
+	dropping := mockColumn(6, "dropping", mysql.TypeLonglong)
+	dropping.State = model.StateWriteOnly
+	return &model.TableInfo{
+		ID:         10,
+		Name:       model.NewCIStr("t"),
+		Columns:    []*model.ColumnInfo{id, name, data, added, virtual, dropping},
+		PKIsHandle: true,
+	}
+}
+
+func (r *testExportSuite) TestRowDecoder(c *C) {
+	info := mockTableInfo()
+	decoder, err := newRowDecoder(info, time.UTC)
+	c.Assert(err, IsNil)
+	c.Assert(decoder.columns, HasLen, 4)
+
+	sc := &stmtctx.StatementContext{TimeZone: time.UTC}
+	datums := []types.Datum{types.NewStringDatum("a'b"), types.NewBytesDatum([]byte{0, 1})}
+	oldRow, err := tablecodec.EncodeOldRow(sc, datums, []int64{2, 3}, nil, nil)
+	c.Assert(err, IsNil)
+	newRow, err := tablecodec.EncodeRow(sc, datums, []int64{2, 3}, nil, nil, &rowcodec.Encoder{})
+	c.Assert(err, IsNil)
+	for _, value := range [][]byte{oldRow, newRow} {
+		row, err := decoder.decode(7, value)
+		c.Assert(err, IsNil)
+		c.Assert(row, HasLen, 4)
+		c.Assert(row[0].GetInt64(), Equals, int64(7))
+		c.Assert(row[1].GetString(), Equals, "a'b")
+		c.Assert(row[2].GetBytes(), DeepEquals, []byte{0, 1})
+		c.Assert(row[3].GetInt64(), Equals, int64(5))
+	}
+
+	// The NULL values are decoded as NULL, rather than the defaults.
+	value, err := tablecodec.EncodeRow(sc, []types.Datum{{}, {}, {}}, []int64{2, 3, 4}, nil, nil, &rowcodec.Encoder{})
+	c.Assert(err, IsNil)
+	row, err := decoder.decode(8, value)
+	c.Assert(err, IsNil)
+	c.Assert(row[1].IsNull(), IsTrue)
+	c.Assert(row[3].IsNull(), IsTrue)
+
+	_, err = decoder.decode(9, []byte{0xff})
+	c.Assert(err, ErrorMatches, "decode the row of handle 9 failed.*")
+}
+
+func (r *testExportSuite) TestWriters(c *C) {
+	info := mockTableInfo()
+	columns := exportedColumns(info)
+	rows := [][]types.Datum{
+		{types.NewIntDatum(1), types.NewStringDatum("a\"b'c\n"), types.NewBytesDatum([]byte{0, 'x'}), types.NewIntDatum(5)},
+		{types.NewIntDatum(2), {}, {}, types.NewDatum(nil)},
+	}
+
+	writer, err := newRowWriter(FormatCSV, "db", "t", columns)
+	c.Assert(err, IsNil)
+	for _, row := range rows {
+		c.Assert(writer.writeRow(row), IsNil)
+	}
+	c.Assert(string(writer.finish()), Equals, "id,name,data,added\n"+
+		"1,\"a\"\"b'c\n\",\"\x00x\",5\n"+
+		"2,\\N,\\N,\\N\n")
+
+	writer, err = newRowWriter(FormatSQL, "db", "t", columns)
+	c.Assert(err, IsNil)
+	for _, row := range rows {
+		c.Assert(writer.writeRow(row), IsNil)
+	}
+	c.Assert(string(writer.finish()), Equals, "/*!40101 SET NAMES binary*/;\n"+
+		"INSERT INTO `db`.`t` (`id`,`name`,`data`,`added`) VALUES\n"+
+		"(1,'a\"b\\'c\\n',x'0078',5),\n"+
+		"(2,NULL,NULL,NULL);\n")
+
+	// The statements are split every rowsPerStatement rows.
+	writer, err = newRowWriter(FormatSQL, "db", "t", columns[:1])
+	c.Assert(err, IsNil)
+	for i := 0; i <= rowsPerStatement; i++ {
+		c.Assert(writer.writeRow([]types.Datum{types.NewIntDatum(int64(i))}), IsNil)
+	}
+	c.Assert(string(writer.finish()), Matches, "(?s).*\\(255\\);\nINSERT INTO `db`.`t` \\(`id`\\) VALUES\n\\(256\\);\n")
+
+	_, err = newRowWriter("xml", "db", "t", columns)
+	c.Assert(err, ErrorMatches, `unknown export format "xml"`)
+}
+
+func (r *testExportSuite) TestEscapeFileName(c *C) {
+	c.Assert(escapeFileName("usertable"), Equals, "usertable")
+	c.Assert(escapeFileName("表"), Equals, "表")
+	c.Assert(escapeFileName("../a/b"), Equals, "%2E%2E%2Fa%2Fb")
+	c.Assert(escapeFileName("a\\b%2E\n"), Equals, "a%5Cb%252E%0A")
+	// `a.b`.`c` and `a`.`b.c` are exported to different files.
+	c.Assert(escapeFileName("a.b")+"."+escapeFileName("c"), Not(Equals),
+		escapeFileName("a")+"."+escapeFileName("b.c"))
+}
@@ -0,0 +1,96 @@
+package export
+
+import (
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/mock"
+)
+
+// rowDecoder decodes the rows of a table into the datums of its exported
+// columns.
+type rowDecoder struct {
+	columns []*model.ColumnInfo
+	types   map[int64]*types.FieldType
+	// handle is the offset of the column which is the handle, or -1 if the
+	// table has no integer primary key.
+	handle int
+	// defaults are the values of the columns absent from the rows, which
+	// are written before the columns are added.
+	defaults []types.Datum
+	loc      *time.Location
+}
+
+// exportedColumns returns the public columns of the table, except the
+// virtual generated columns, which aren't stored.
+func exportedColumns(info *model.TableInfo) []*model.ColumnInfo {
+	columns := make([]*model.ColumnInfo, 0, len(info.Columns))
+	for _, col := range info.Columns {
+		if col.State != model.StatePublic || (col.IsGenerated() && !col.GeneratedStored) {
+			continue
+		}
+		columns = append(columns, col)
+	}
+	return columns
+}
+
+func newRowDecoder(info *model.TableInfo, loc *time.Location) (*rowDecoder, error) {
+	ctx := mock.NewContext()
+	ctx.GetSessionVars().TimeZone = loc
+	ctx.GetSessionVars().StmtCtx.TimeZone = loc
+
+	decoder := &rowDecoder{
+		columns:  exportedColumns(info),
+		types:    make(map[int64]*types.FieldType),
+		handle:   -1,
+		defaults: make([]types.Datum, 0),
+		loc:      loc,
+	}
+	for i, col := range decoder.columns {
+		if info.PKIsHandle && mysql.HasPriKeyFlag(col.Flag) {
+			decoder.handle = i
+		} else {
+			decoder.types[col.ID] = &col.FieldType
+		}
+		var value types.Datum
+		if col.OriginDefaultValue != nil {
+			var err error
+			value, err = table.GetColOriginDefaultValue(ctx, col)
+			if err != nil {
+				return nil, errors.Annotatef(err, "get the default value of column %s failed", col.Name.O)
+			}
+		}
+		decoder.defaults = append(decoder.defaults, value)
+	}
+	return decoder, nil
+}
+
+// decode decodes the row value of the handle.
+func (d *rowDecoder) decode(handle int64, value []byte) ([]types.Datum, error) {
+	datums, err := tablecodec.DecodeRow(value, d.types, d.loc)
+	if err != nil {
+		return nil, errors.Annotatef(err, "decode the row of handle %d failed", handle)
+	}
+	row := make([]types.Datum, 0, len(d.columns))
+	for i, col := range d.columns {
+		if i == d.handle {
+			if mysql.HasUnsignedFlag(col.Flag) {
+				row = append(row, types.NewUintDatum(uint64(handle)))
+			} else {
+				row = append(row, types.NewIntDatum(handle))
+			}
+			continue
+		}
+		datum, ok := datums[col.ID]
+		if !ok {
+			datum = d.defaults[i]
+		}
+		row = append(row, datum)
+	}
+	return row, nil
+}
@@ -0,0 +1,195 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/charset"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// Format is the format of the exported files.
+type Format string
+
+// The formats of the exported files.
+const (
+	FormatCSV Format = "csv"
+	FormatSQL Format = "sql"
+)
+
+// rowsPerStatement is the number of the rows of an INSERT statement.
+const rowsPerStatement = 256
+
+// rowWriter writes the rows of a table into a buffer in a format.
+type rowWriter interface {
+	writeRow(row []types.Datum) error
+	// finish finishes the file, and returns its content.
+	finish() []byte
+}
+
+func newRowWriter(format Format, db, tableName string, columns []*model.ColumnInfo) (rowWriter, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(columns), nil
+	case FormatSQL:
+		return newSQLWriter(db, tableName, columns), nil
+	default:
+		return nil, errors.Errorf("unknown export format %q", format)
+	}
+}
+
+// isBinary returns whether the column is of the binary string types.
+func isBinary(col *model.ColumnInfo) bool {
+	return types.IsString(col.Tp) && col.Charset == charset.CharsetBin
+}
+
+// formatValue formats the non-NULL datum as its literal, which is quoted by
+// quote if it is a string.
+func formatValue(datum types.Datum, quote func(string) string) (string, error) {
+	switch datum.Kind() {
+	case types.KindInt64, types.KindUint64, types.KindFloat32, types.KindFloat64, types.KindMysqlDecimal:
+		return datum.ToString()
+	case types.KindMysqlBit, types.KindBinaryLiteral:
+		value, err := datum.GetBinaryLiteral().ToInt(nil)
+		return strconv.FormatUint(value, 10), errors.Trace(err)
+	default:
+		value, err := datum.ToString()
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		return quote(value), nil
+	}
+}
+
+// csvWriter writes the rows as CSV, with a header of the column names. The
+// NULL values are written as \N, and the strings are quoted by ".
+type csvWriter struct {
+	buf bytes.Buffer
+}
+
+func quoteCSV(value string) string {
+	return `"` + strings.Replace(value, `"`, `""`, -1) + `"`
+}
+
+func newCSVWriter(columns []*model.ColumnInfo) *csvWriter {
+	w := &csvWriter{}
+	for i, col := range columns {
+		if i > 0 {
+			w.buf.WriteByte(',')
+		}
+		w.buf.WriteString(col.Name.O)
+	}
+	w.buf.WriteByte('\n')
+	return w
+}
+
+func (w *csvWriter) writeRow(row []types.Datum) error {
+	for i, datum := range row {
+		if i > 0 {
+			w.buf.WriteByte(',')
+		}
+		if datum.IsNull() {
+			w.buf.WriteString(`\N`)
+			continue
+		}
+		value, err := formatValue(datum, quoteCSV)
+		if err != nil {
+			return err
+		}
+		w.buf.WriteString(value)
+	}
+	w.buf.WriteByte('\n')
+	return nil
+}
+
+func (w *csvWriter) finish() []byte {
+	return w.buf.Bytes()
+}
+
+// sqlWriter writes the rows as INSERT statements of rowsPerStatement rows.
+type sqlWriter struct {
+	buf     bytes.Buffer
+	insert  string
+	binary  []bool
+	pending int
+}
+
+var sqlEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`'`, `\'`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\x00", `\0`,
+	"\x1a", `\Z`,
+)
+
+func quoteSQL(value string) string {
+	return "'" + sqlEscaper.Replace(value) + "'"
+}
+
+func quoteHex(value string) string {
+	return fmt.Sprintf("x'%x'", value)
+}
+
+func newSQLWriter(db, tableName string, columns []*model.ColumnInfo) *sqlWriter {
+	names := make([]string, 0, len(columns))
+	binary := make([]bool, 0, len(columns))
+	for _, col := range columns {
+		names = append(names, utils.EncloseName(col.Name.O))
+		binary = append(binary, isBinary(col))
+	}
+	w := &sqlWriter{
+		insert: fmt.Sprintf("INSERT INTO %s.%s (%s) VALUES\n",
+			utils.EncloseName(db), utils.EncloseName(tableName), strings.Join(names, ",")),
+		binary: binary,
+	}
+	w.buf.WriteString("/*!40101 SET NAMES binary*/;\n")
+	return w
+}
+
+func (w *sqlWriter) writeRow(row []types.Datum) error {
+	if w.pending == 0 {
+		w.buf.WriteString(w.insert)
+	} else {
+		w.buf.WriteString(",\n")
+	}
+	w.buf.WriteByte('(')
+	for i, datum := range row {
+		if i > 0 {
+			w.buf.WriteByte(',')
+		}
+		if datum.IsNull() {
+			w.buf.WriteString("NULL")
+			continue
+		}
+		quote := quoteSQL
+		if w.binary[i] {
+			quote = quoteHex
+		}
+		value, err := formatValue(datum, quote)
+		if err != nil {
+			return err
+		}
+		w.buf.WriteString(value)
+	}
+	w.buf.WriteByte(')')
+	if w.pending++; w.pending == rowsPerStatement {
+		w.buf.WriteString(";\n")
+		w.pending = 0
+	}
+	return nil
+}
+
+func (w *sqlWriter) finish() []byte {
+	if w.pending > 0 {
+		w.buf.WriteString(";\n")
+		w.pending = 0
+	}
+	return w.buf.Bytes()
+}
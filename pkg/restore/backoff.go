@@ -19,6 +19,7 @@ var (
 	errRewriteRuleNotFound = errors.NewNoStackError("rewrite rule not found")
 	errRangeIsEmpty        = errors.NewNoStackError("range is empty")
 	errGrpc                = errors.NewNoStackError("gRPC error")
+	errServerBusy          = errors.NewNoStackError("server is busy")
 
 	// TODO: add `error` field to `DownloadResponse` for distinguish the errors of gRPC
 	// and the errors of request
@@ -66,7 +67,7 @@ func newDownloadSSTBackoffer() utils.Backoffer {
 
 func (bo *importerBackoffer) NextBackoff(err error) time.Duration {
 	switch errors.Cause(err) {
-	case errResp, errGrpc, errEpochNotMatch, errNotLeader:
+	case errResp, errGrpc, errEpochNotMatch, errNotLeader, errServerBusy:
 		bo.delayTime = 2 * bo.delayTime
 		bo.attempt--
 	case errRangeIsEmpty, errRewriteRuleNotFound:
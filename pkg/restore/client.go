@@ -16,20 +16,26 @@ import (
 	pd "github.com/pingcap/pd/client"
 	"github.com/pingcap/tidb/domain"
 	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/meta/autoid"
 	"github.com/pingcap/tidb/store/tikv/oracle"
+	"github.com/pingcap/tidb/tablecodec"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/keepalive"
 
 	"github.com/pingcap/br/pkg/checksum"
+	"github.com/pingcap/br/pkg/conn"
 	"github.com/pingcap/br/pkg/summary"
 	"github.com/pingcap/br/pkg/utils"
 )
 
 // defaultChecksumConcurrency is the default number of the concurrent
 // checksum tasks.
-const defaultChecksumConcurrency = 64
+const (
+	defaultChecksumConcurrency = 64
+	logStoreStatesInterval     = 30 * time.Second
+)
 
 // Client sends requests to restore files
 type Client struct {
@@ -37,6 +43,7 @@ type Client struct {
 	cancel context.CancelFunc
 
 	pdClient        pd.Client
+	toolClient      SplitClient
 	fileImporter    FileImporter
 	workerPool      *utils.WorkerPool
 	tableWorkerPool *utils.WorkerPool
@@ -44,31 +51,55 @@ type Client struct {
 	databases       map[string]*utils.Database
 	ddlJobs         []*model.Job
 	backupMeta      *backup.BackupMeta
+	store           kv.Storage
 	db              *DB
 	rateLimit       uint64
 	isOnline        bool
 	hasSpeedLimited bool
+	// storeConcurrency is the maximum number of the concurrent requests on
+	// each store.
+	storeConcurrency uint
+	// splitConcurrency is the number of the regions split and scattered
+	// concurrently.
+	splitConcurrency uint
+	// scatterWaitTimeout is the maximum time waiting for the regions to be
+	// scattered after split.
+	scatterWaitTimeout time.Duration
+	// restorePartitions is true means only some partitions of the tables are
+	// restored, and exchangePartitions is true means they replace the
+	// partitions of the existing tables.
+	restorePartitions  bool
+	exchangePartitions bool
+	// restoreStores are the stores which the restored regions are placed on
+	// in online restore.
+	restoreStores []uint64
+	// restoreStoreLabels are the values of the restore label of the restore
+	// stores before online restore, which are restored after it.
+	restoreStoreLabels map[uint64]string
+	// placementWaitTimeout is the maximum time waiting for the regions to be
+	// placed on the restore stores.
+	placementWaitTimeout time.Duration
 }
 
-// NewRestoreClient returns a new RestoreClient
+// NewRestoreClient returns a new RestoreClient, the client takes the
+// ownership of db.
 func NewRestoreClient(
 	ctx context.Context,
 	pdClient pd.Client,
 	store kv.Storage,
+	db *DB,
 ) (*Client, error) {
 	ctx, cancel := context.WithCancel(ctx)
-	db, err := NewDB(store)
-	if err != nil {
-		cancel()
-		return nil, errors.Trace(err)
-	}
-
 	return &Client{
 		ctx:             ctx,
 		cancel:          cancel,
 		pdClient:        pdClient,
+		toolClient:      NewSplitClient(pdClient),
 		tableWorkerPool: utils.NewWorkerPool(128, "table"),
+		store:           store,
 		db:              db,
+
+		placementWaitTimeout: DefaultPlacementWaitTimeout,
 	}, nil
 }
 
@@ -94,12 +125,15 @@ func (rc *Client) Close() {
 	log.Info("Restore client closed")
 }
 
-// InitBackupMeta loads schemas from BackupMeta to initialize RestoreClient
-func (rc *Client) InitBackupMeta(backupMeta *backup.BackupMeta, backend *backup.StorageBackend) error {
-	databases, err := utils.LoadBackupTables(backupMeta)
+// InitBackupMeta loads schemas from the backup meta to initialize
+// RestoreClient. The files and the schemas of the sharded backup meta are
+// read shard by shard.
+func (rc *Client) InitBackupMeta(reader *utils.MetaReader, backend *backup.StorageBackend) error {
+	databases, err := utils.ReadBackupTables(rc.ctx, reader)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	backupMeta := reader.Meta()
 	var ddlJobs []*model.Job
 	err = json.Unmarshal(backupMeta.GetDdls(), &ddlJobs)
 	if err != nil {
@@ -113,9 +147,33 @@ func (rc *Client) InitBackupMeta(backupMeta *backup.BackupMeta, backend *backup.
 	metaClient := NewSplitClient(rc.pdClient)
 	importClient := NewImportClient(metaClient)
 	rc.fileImporter = NewFileImporter(rc.ctx, metaClient, importClient, backend, rc.rateLimit)
+	rc.fileImporter.SetStoreConcurrency(rc.storeConcurrency)
 	return nil
 }
 
+// SetStoreConcurrency sets the maximum number of the concurrent download and
+// ingest requests on each store. It must be called before InitBackupMeta.
+func (rc *Client) SetStoreConcurrency(c uint) {
+	rc.storeConcurrency = c
+}
+
+// SetSplitConcurrency sets the number of the regions split and scattered
+// concurrently.
+func (rc *Client) SetSplitConcurrency(c uint) {
+	rc.splitConcurrency = c
+}
+
+// SetScatterWaitTimeout sets the maximum time waiting for the regions to be
+// scattered after split.
+func (rc *Client) SetScatterWaitTimeout(timeout time.Duration) {
+	rc.scatterWaitTimeout = timeout
+}
+
+// GetStoreStates returns the scheduling states of the stores in restore.
+func (rc *Client) GetStoreStates() []StoreState {
+	return rc.fileImporter.GetStoreStates()
+}
+
 // SetConcurrency sets the concurrency of dbs tables files
 func (rc *Client) SetConcurrency(c uint) {
 	rc.workerPool = utils.NewWorkerPool(c, "file")
@@ -126,6 +184,15 @@ func (rc *Client) EnableOnline() {
 	rc.isOnline = true
 }
 
+// SetRestorePartitions sets that only some partitions of the tables are
+// restored. The same partitions of the existing tables must be empty, unless
+// exchange is set, which makes the restored partitions replace their data by
+// truncating them before restore.
+func (rc *Client) SetRestorePartitions(exchange bool) {
+	rc.restorePartitions = true
+	rc.exchangePartitions = exchange
+}
+
 // GetTS gets a new timestamp from PD
 func (rc *Client) GetTS(ctx context.Context) (uint64, error) {
 	p, l, err := rc.pdClient.GetTS(ctx)
@@ -190,7 +257,6 @@ func (rc *Client) CreateDatabase(db *model.DBInfo) error {
 
 // CreateTables creates multiple tables, and returns their rewrite rules.
 func (rc *Client) CreateTables(
-	dom *domain.Domain,
 	tables []*utils.Table,
 	newTS uint64,
 ) (*RewriteRules, []*model.TableInfo, error) {
@@ -200,14 +266,38 @@ func (rc *Client) CreateTables(
 	}
 	newTables := make([]*model.TableInfo, 0, len(tables))
 	for _, table := range tables {
+		for _, col := range table.Extra.ExcludedColumns {
+			log.Warn("column is not public at backup time, skip restoring it",
+				zap.Stringer("db", table.Db.Name),
+				zap.Stringer("table", table.Info.Name),
+				zap.Stringer("column", col.Name),
+				zap.Stringer("state", col.State))
+		}
 		err := rc.db.CreateTable(rc.ctx, table)
 		if err != nil {
 			return nil, nil, err
 		}
-		newTableInfo, err := rc.GetTableSchema(dom, table.Db.Name, table.Info.Name)
+		newTableInfo, err := rc.db.GetTableInfo(rc.ctx, table.Db.Name, table.Info.Name)
 		if err != nil {
 			return nil, nil, err
 		}
+		if err = checkPartitions(newTableInfo, table.Info); err != nil {
+			return nil, nil, err
+		}
+		if rc.restorePartitions && table.Info.Partition != nil {
+			if !rc.exchangePartitions {
+				err = rc.checkPartitionsEmpty(newTableInfo, table.Info)
+			} else if err = rc.db.TruncatePartitions(rc.ctx, table); err == nil {
+				// Truncating partitions allocates new partition IDs.
+				newTableInfo, err = rc.db.GetTableInfo(rc.ctx, table.Db.Name, table.Info.Name)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if err = rc.rebaseAutoRandomID(table, newTableInfo); err != nil {
+			return nil, nil, err
+		}
 		rules := GetRewriteRules(newTableInfo, table.Info, newTS)
 		rewriteRules.Table = append(rewriteRules.Table, rules.Table...)
 		rewriteRules.Data = append(rewriteRules.Data, rules.Data...)
@@ -216,15 +306,137 @@ func (rc *Client) CreateTables(
 	return rewriteRules, newTables, nil
 }
 
-// ExecDDLs executes the queries of the ddl jobs.
+// checkPartitionsEmpty checks whether the restored partitions of the table
+// have no rows, so that the restored rows are not mixed with the existing
+// rows.
+func (rc *Client) checkPartitionsEmpty(newTableInfo, oldTableInfo *model.TableInfo) error {
+	restored := make(map[string]bool, len(oldTableInfo.Partition.Definitions))
+	for _, def := range oldTableInfo.Partition.Definitions {
+		restored[def.Name.L] = true
+	}
+	ver, err := rc.store.CurrentVersion()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	snapshot, err := rc.store.GetSnapshot(ver)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, def := range newTableInfo.Partition.Definitions {
+		if !restored[def.Name.L] {
+			continue
+		}
+		prefix := tablecodec.GenTableRecordPrefix(def.ID)
+		iter, err := snapshot.Iter(prefix, prefix.PrefixNext())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		empty := !iter.Valid() || !iter.Key().HasPrefix(prefix)
+		iter.Close()
+		if !empty {
+			return errors.Errorf("partition %s of table %s is not empty, "+
+				"the restored partitions must be empty unless they are exchanged", def.Name, newTableInfo.Name)
+		}
+	}
+	return nil
+}
+
+// rebaseAutoRandomID rebases the AUTO_RANDOM allocator of a restored table.
+// Unlike the row ID allocator, which is rebased by `ALTER TABLE ...
+// AUTO_INCREMENT`, it has no SQL to rebase, so it is rebased in the meta.
+func (rc *Client) rebaseAutoRandomID(table *utils.Table, newTableInfo *model.TableInfo) error {
+	if table.Extra.AutoRandomID <= 0 || !newTableInfo.ContainsAutoRandomBits() {
+		return nil
+	}
+	dbInfo, err := rc.db.GetDBInfo(rc.ctx, table.Db.Name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	alloc := autoid.NewAllocator(rc.store, newTableInfo.GetDBID(dbInfo.ID),
+		newTableInfo.IsAutoRandomBitColUnsigned(), autoid.AutoRandomType)
+	// The allocator allocates IDs greater than the base.
+	err = alloc.Rebase(newTableInfo.ID, table.Extra.AutoRandomID-1, false)
+	if err != nil {
+		log.Error("rebase auto random id failed",
+			zap.Stringer("db", table.Db.Name),
+			zap.Stringer("table", table.Info.Name),
+			zap.Int64("autoRandomID", table.Extra.AutoRandomID),
+			zap.Error(err))
+	}
+	return errors.Trace(err)
+}
+
+// RecoverTiFlashReplica sets the TiFlash replicas of the restored tables as
+// they were at the backup time. The tables are created without TiFlash
+// replicas, so that TiFlash doesn't replicate the data during restore.
+func (rc *Client) RecoverTiFlashReplica(tables []*utils.Table) error {
+	for _, table := range tables {
+		if table.Info.TiFlashReplica == nil || table.Info.TiFlashReplica.Count == 0 {
+			continue
+		}
+		err := rc.db.SetTiFlashReplica(rc.ctx, table, table.Info.TiFlashReplica)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildPendingIndices adds the indices which were being added at the backup
+// time, since their data is not backed up. It only warns if an index cannot be
+// rebuilt, and returns the number of such indices.
+func (rc *Client) RebuildPendingIndices(tables []*utils.Table) int {
+	failed := 0
+	for _, table := range tables {
+		excludedColumns := make(map[string]bool)
+		for _, col := range table.Extra.ExcludedColumns {
+			excludedColumns[col.Name.L] = true
+		}
+	nextIndex:
+		for _, index := range table.Extra.PendingIndices {
+			for _, col := range index.Columns {
+				if excludedColumns[col.Name.L] {
+					log.Warn("pending index depends on a non-public column, please add it manually",
+						zap.Stringer("db", table.Db.Name),
+						zap.Stringer("table", table.Info.Name),
+						zap.Stringer("index", index.Name),
+						zap.Stringer("column", col.Name))
+					failed++
+					continue nextIndex
+				}
+			}
+			log.Info("rebuild pending index",
+				zap.Stringer("db", table.Db.Name),
+				zap.Stringer("table", table.Info.Name),
+				zap.Stringer("index", index.Name))
+			if err := rc.db.AddIndex(rc.ctx, table, index); err != nil {
+				log.Warn("rebuild pending index failed, please add it manually",
+					zap.Stringer("db", table.Db.Name),
+					zap.Stringer("table", table.Info.Name),
+					zap.Stringer("index", index.Name),
+					zap.Error(err))
+				failed++
+			}
+		}
+	}
+	return failed
+}
+
+// ExecDDLs replays the ddl jobs from their recorded schema states, and falls
+// back to executing their queries if a job cannot be replayed that way.
 func (rc *Client) ExecDDLs(ddlJobs []*model.Job) error {
 	// Sort the ddl jobs by schema version in ascending order.
 	sort.Slice(ddlJobs, func(i, j int) bool {
 		return ddlJobs[i].BinlogInfo.SchemaVersion < ddlJobs[j].BinlogInfo.SchemaVersion
 	})
 
+	dbInfos := make([]*model.DBInfo, 0, len(rc.databases))
+	for _, db := range rc.databases {
+		dbInfos = append(dbInfos, db.Info)
+	}
+	replayer := newDDLReplayer(rc.db, dbInfos)
 	for _, job := range ddlJobs {
-		err := rc.db.ExecDDL(rc.ctx, job)
+		err := replayer.Replay(rc.ctx, job)
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -238,7 +450,7 @@ func (rc *Client) ExecDDLs(ddlJobs []*model.Job) error {
 
 func (rc *Client) setSpeedLimit() error {
 	if !rc.hasSpeedLimited && rc.rateLimit != 0 {
-		stores, err := rc.pdClient.GetAllStores(rc.ctx, pd.WithExcludeTombstone())
+		stores, err := conn.GetAllTiKVStores(rc.ctx, rc.pdClient)
 		if err != nil {
 			return err
 		}
@@ -281,6 +493,8 @@ func (rc *Client) RestoreFiles(
 	if err != nil {
 		return err
 	}
+	stopLogStores := rc.logStoreStates()
+	defer stopLogStores()
 
 	for _, file := range files {
 		wg.Add(1)
@@ -311,6 +525,33 @@ func (rc *Client) RestoreFiles(
 	return nil
 }
 
+// logStoreStates logs the scheduling states of the stores periodically until
+// the returned function is called.
+func (rc *Client) logStoreStates() func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(logStoreStatesInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				log.Info("restore store states", zap.Reflect("stores", rc.GetStoreStates()))
+			case <-done:
+				log.Info("restore store states", zap.Reflect("stores", rc.GetStoreStates()))
+				return
+			case <-rc.ctx.Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
 //SwitchToImportMode switch tikv cluster to import mode
 func (rc *Client) SwitchToImportMode(ctx context.Context) error {
 	return rc.switchTiKVMode(ctx, import_sstpb.SwitchMode_Import)
@@ -322,7 +563,7 @@ func (rc *Client) SwitchToNormalMode(ctx context.Context) error {
 }
 
 func (rc *Client) switchTiKVMode(ctx context.Context, mode import_sstpb.SwitchMode) error {
-	stores, err := rc.pdClient.GetAllStores(ctx, pd.WithExcludeTombstone())
+	stores, err := conn.GetAllTiKVStores(ctx, rc.pdClient)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -1,6 +1,7 @@
 package restore
 
 import (
+	"bytes"
 	"context"
 	"math"
 	"strconv"
@@ -9,7 +10,10 @@ import (
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/parser/mysql"
 	"github.com/pingcap/parser/types"
+	"github.com/pingcap/tidb/config"
+	"github.com/pingcap/tidb/meta/autoid"
 	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/testkit"
 	"github.com/pingcap/tidb/util/testleak"
 
 	"github.com/pingcap/br/pkg/utils"
@@ -66,7 +70,7 @@ func (s *testRestoreClientSuite) TestCreateTables(c *C) {
 			},
 		}
 	}
-	rules, newTables, err := client.CreateTables(s.mock.Domain, tables, 0)
+	rules, newTables, err := client.CreateTables(tables, 0)
 	c.Assert(err, IsNil)
 	for _, nt := range newTables {
 		c.Assert(nt.Name.String(), Matches, "test[0-3]")
@@ -102,3 +106,135 @@ func (s *testRestoreClientSuite) TestIsOnline(c *C) {
 	client.EnableOnline()
 	c.Assert(client.IsOnline(), IsTrue)
 }
+
+func (s *testRestoreClientSuite) TestCreateTablesRebaseAllocators(c *C) {
+	c.Assert(s.mock.Start(), IsNil)
+	defer s.mock.Stop()
+	conf := config.GetGlobalConfig()
+	conf.Experimental.AllowAutoRandom = true
+	defer func() {
+		conf.Experimental.AllowAutoRandom = false
+	}()
+
+	tk := testkit.NewTestKit(c, s.mock.Storage)
+	tk.MustExec("use test")
+	tk.MustExec("create table t_random (a bigint primary key auto_random(5), b int);")
+	tk.MustExec("create table t_shard (a int, b int) shard_row_id_bits = 4;")
+	info, err := s.mock.Domain.GetSnapshotInfoSchema(math.MaxInt64)
+	c.Assert(err, IsNil)
+	dbInfo, ok := info.SchemaByName(model.NewCIStr("test"))
+	c.Assert(ok, IsTrue)
+	tables := make([]*utils.Table, 0, 2)
+	for _, name := range []string{"t_random", "t_shard"} {
+		table, err := info.TableByName(model.NewCIStr("test"), model.NewCIStr(name))
+		c.Assert(err, IsNil)
+		tableInfo := table.Meta().Clone()
+		tableInfo.AutoIncID = 2000
+		tables = append(tables, &utils.Table{
+			Db:    dbInfo,
+			Info:  tableInfo,
+			Extra: utils.TableExtra{AutoRandomID: 1000},
+		})
+	}
+	tk.MustExec("drop table t_random, t_shard;")
+
+	db, err := NewDB(s.mock.Storage)
+	c.Assert(err, IsNil)
+	client := Client{ctx: context.Background(), store: s.mock.Storage, db: db}
+	_, newTables, err := client.CreateTables(tables, 0)
+	c.Assert(err, IsNil)
+	c.Assert(newTables, HasLen, 2)
+
+	for _, newTable := range newTables {
+		rowIDAlloc := autoid.NewAllocator(s.mock.Storage, dbInfo.ID, false, autoid.RowIDAllocType)
+		rowID, err := rowIDAlloc.NextGlobalAutoID(newTable.ID)
+		c.Assert(err, IsNil)
+		c.Assert(rowID, Equals, int64(2000), Commentf("table %s", newTable.Name))
+		randAlloc := autoid.NewAllocator(s.mock.Storage, dbInfo.ID, false, autoid.AutoRandomType)
+		randID, err := randAlloc.NextGlobalAutoID(newTable.ID)
+		c.Assert(err, IsNil)
+		if newTable.ContainsAutoRandomBits() {
+			c.Assert(randID, Equals, int64(1000))
+		} else {
+			// Tables without AUTO_RANDOM are not rebased.
+			c.Assert(randID, Equals, int64(1))
+		}
+	}
+	// The new rows do not conflict with the restored IDs.
+	tk.MustExec("insert into t_shard (a) values (1);")
+	tk.MustQuery("select _tidb_rowid & ((1 << 59) - 1) >= 2000 from t_shard").Check(testkit.Rows("1"))
+	tk.MustExec("insert into t_random (b) values (1);")
+	tk.MustQuery("select a & ((1 << 58) - 1) >= 1000 from t_random").Check(testkit.Rows("1"))
+}
+
+func (s *testRestoreClientSuite) TestCreateTablesExchangePartitions(c *C) {
+	c.Assert(s.mock.Start(), IsNil)
+	defer s.mock.Stop()
+
+	tk := testkit.NewTestKit(c, s.mock.Storage)
+	tk.MustExec("use test")
+	tk.MustExec("create table t_part (a int, b int) partition by range (a) (" +
+		"partition p0 values less than (10)," +
+		"partition p1 values less than (20)," +
+		"partition p2 values less than maxvalue);")
+	tk.MustExec("insert into t_part values (1, 1), (11, 11), (21, 21);")
+	info, err := s.mock.Domain.GetSnapshotInfoSchema(math.MaxInt64)
+	c.Assert(err, IsNil)
+	dbInfo, ok := info.SchemaByName(model.NewCIStr("test"))
+	c.Assert(ok, IsTrue)
+	table, err := info.TableByName(model.NewCIStr("test"), model.NewCIStr("t_part"))
+	c.Assert(err, IsNil)
+	oldInfo, err := utils.FilterPartitions(table.Meta(), []string{"p1"})
+	c.Assert(err, IsNil)
+	oldPartID := oldInfo.Partition.Definitions[0].ID
+
+	db, err := NewDB(s.mock.Storage)
+	c.Assert(err, IsNil)
+	client := Client{ctx: context.Background(), store: s.mock.Storage, db: db}
+	// The restored partitions must be empty without exchange.
+	client.SetRestorePartitions(false)
+	_, _, err = client.CreateTables([]*utils.Table{{Db: dbInfo, Info: oldInfo}}, 0)
+	c.Assert(err, ErrorMatches, "partition p1 of table t_part is not empty.*")
+	tk.MustQuery("select a from t_part order by a").Check(testkit.Rows("1", "11", "21"))
+
+	client.SetRestorePartitions(true)
+	rules, newTables, err := client.CreateTables([]*utils.Table{{Db: dbInfo, Info: oldInfo}}, 0)
+	c.Assert(err, IsNil)
+	c.Assert(newTables, HasLen, 1)
+
+	// The restored partition is truncated, and the others are kept.
+	tk.MustQuery("select a from t_part order by a").Check(testkit.Rows("1", "21"))
+	var newPartID int64
+	for _, def := range newTables[0].Partition.Definitions {
+		if def.Name.L == "p1" {
+			newPartID = def.ID
+		}
+	}
+	c.Assert(newPartID, Not(Equals), oldPartID)
+	c.Assert(newPartID, Not(Equals), int64(0))
+	found := false
+	for _, rule := range rules.Table {
+		if bytes.Equal(rule.OldKeyPrefix, tablecodec.EncodeTablePrefix(oldPartID)) {
+			c.Assert(bytes.Equal(rule.NewKeyPrefix, tablecodec.EncodeTablePrefix(newPartID)), IsTrue)
+			found = true
+		}
+	}
+	c.Assert(found, IsTrue)
+
+	// The truncated partition is empty.
+	client.SetRestorePartitions(false)
+	_, _, err = client.CreateTables([]*utils.Table{{Db: dbInfo, Info: oldInfo}}, 0)
+	c.Assert(err, IsNil)
+
+	// The partition definitions must match.
+	mismatch := oldInfo.Clone()
+	partition := *mismatch.Partition
+	partition.Definitions = []model.PartitionDefinition{partition.Definitions[0]}
+	partition.Definitions[0].LessThan = []string{"30"}
+	mismatch.Partition = &partition
+	_, _, err = client.CreateTables([]*utils.Table{{Db: dbInfo, Info: mismatch}}, 0)
+	c.Assert(err, ErrorMatches, "partition p1 of table t_part mismatch.*")
+	mismatch.Partition.Definitions[0].Name = model.NewCIStr("p9")
+	_, _, err = client.CreateTables([]*utils.Table{{Db: dbInfo, Info: mismatch}}, 0)
+	c.Assert(err, ErrorMatches, "partition p9 not found in table t_part")
+}
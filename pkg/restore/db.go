@@ -4,39 +4,115 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"math"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/domain"
 	"github.com/pingcap/tidb/executor"
 	"github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/session"
+	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/sessionctx/variable"
+	"github.com/pingcap/tidb/types"
 	"go.uber.org/zap"
 
 	"github.com/pingcap/br/pkg/utils"
 )
 
+// sqlExecutor executes SQL statements inside a single TiDB session.
+type sqlExecutor interface {
+	// Execute executes a SQL statement and discards the results.
+	Execute(ctx context.Context, sql string) error
+	// GetDBInfo returns the latest schema of a database.
+	GetDBInfo(ctx context.Context, dbName model.CIStr) (*model.DBInfo, error)
+	// GetTableInfo returns the latest schema of a table.
+	GetTableInfo(ctx context.Context, dbName, tableName model.CIStr) (*model.TableInfo, error)
+	// GetGlobalVariable returns the value of a global variable.
+	GetGlobalVariable(ctx context.Context, name string) (string, error)
+	// SessionContext returns the context used to construct SQL statements.
+	SessionContext() sessionctx.Context
+	// Close closes the session.
+	Close()
+}
+
+// embeddedExecutor executes SQL statements in a TiDB session embedded in BR.
+type embeddedExecutor struct {
+	se session.Session
+}
+
+func (e *embeddedExecutor) Execute(ctx context.Context, sql string) error {
+	_, err := e.se.Execute(ctx, sql)
+	return errors.Trace(err)
+}
+
+func (e *embeddedExecutor) GetDBInfo(ctx context.Context, dbName model.CIStr) (*model.DBInfo, error) {
+	info, err := domain.GetDomain(e.se).GetSnapshotInfoSchema(math.MaxInt64)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	dbInfo, ok := info.SchemaByName(dbName)
+	if !ok {
+		return nil, errors.Errorf("database %s not exists", dbName)
+	}
+	return dbInfo, nil
+}
+
+func (e *embeddedExecutor) GetTableInfo(
+	ctx context.Context, dbName, tableName model.CIStr,
+) (*model.TableInfo, error) {
+	info, err := domain.GetDomain(e.se).GetSnapshotInfoSchema(math.MaxInt64)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	table, err := info.TableByName(dbName, tableName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return table.Meta(), nil
+}
+
+func (e *embeddedExecutor) GetGlobalVariable(ctx context.Context, name string) (string, error) {
+	value, err := variable.GetGlobalSystemVar(e.se.GetSessionVars(), name)
+	return value, errors.Trace(err)
+}
+
+func (e *embeddedExecutor) SessionContext() sessionctx.Context {
+	return e.se
+}
+
+func (e *embeddedExecutor) Close() {
+	e.se.Close()
+}
+
 // DB is a TiDB instance, not thread-safe.
 type DB struct {
-	se session.Session
+	se sqlExecutor
 }
 
-// NewDB returns a new DB
+// NewDB returns a new DB which executes SQL in an embedded TiDB session.
 func NewDB(store kv.Storage) (*DB, error) {
 	se, err := session.CreateSession(store)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	// Set SQL mode to None for avoiding SQL compatibility problem
-	_, err = se.Execute(context.Background(), "set @@sql_mode=''")
-	if err != nil {
+	db := &DB{
+		se: &embeddedExecutor{se: se},
+	}
+	if err = db.init(); err != nil {
+		db.Close()
 		return nil, errors.Trace(err)
 	}
-	return &DB{
-		se: se,
-	}, nil
+	return db, nil
+}
+
+func (db *DB) init() error {
+	// Set SQL mode to None for avoiding SQL compatibility problem
+	return db.se.Execute(context.Background(), "set @@sql_mode=''")
 }
 
 // ExecDDL executes the query of a ddl job.
@@ -44,7 +120,7 @@ func (db *DB) ExecDDL(ctx context.Context, ddlJob *model.Job) error {
 	var err error
 	if ddlJob.BinlogInfo.TableInfo != nil {
 		switchDbSQL := fmt.Sprintf("use %s;", ddlJob.SchemaName)
-		_, err = db.se.Execute(ctx, switchDbSQL)
+		err = db.se.Execute(ctx, switchDbSQL)
 		if err != nil {
 			log.Error("switch db failed",
 				zap.String("query", switchDbSQL),
@@ -53,7 +129,7 @@ func (db *DB) ExecDDL(ctx context.Context, ddlJob *model.Job) error {
 			return errors.Trace(err)
 		}
 	}
-	_, err = db.se.Execute(ctx, ddlJob.Query)
+	err = db.se.Execute(ctx, ddlJob.Query)
 	if err != nil {
 		log.Error("execute ddl query failed",
 			zap.String("query", ddlJob.Query),
@@ -67,13 +143,13 @@ func (db *DB) ExecDDL(ctx context.Context, ddlJob *model.Job) error {
 // CreateDatabase executes a CREATE DATABASE SQL.
 func (db *DB) CreateDatabase(ctx context.Context, schema *model.DBInfo) error {
 	var buf bytes.Buffer
-	err := executor.ConstructResultOfShowCreateDatabase(db.se, schema, true, &buf)
+	err := executor.ConstructResultOfShowCreateDatabase(db.se.SessionContext(), schema, true, &buf)
 	if err != nil {
 		log.Error("build create database SQL failed", zap.Stringer("db", schema.Name), zap.Error(err))
 		return errors.Trace(err)
 	}
 	createSQL := buf.String()
-	_, err = db.se.Execute(ctx, createSQL)
+	err = db.se.Execute(ctx, createSQL)
 	if err != nil {
 		log.Error("create database failed", zap.String("query", createSQL), zap.Error(err))
 	}
@@ -84,7 +160,8 @@ func (db *DB) CreateDatabase(ctx context.Context, schema *model.DBInfo) error {
 func (db *DB) CreateTable(ctx context.Context, table *utils.Table) error {
 	var buf bytes.Buffer
 	schema := table.Info
-	err := executor.ConstructResultOfShowCreateTable(db.se, schema, newIDAllocator(schema.AutoIncID), &buf)
+	err := executor.ConstructResultOfShowCreateTable(
+		db.se.SessionContext(), schema, newIDAllocator(schema.AutoIncID), &buf)
 	if err != nil {
 		log.Error(
 			"build create table SQL failed",
@@ -94,7 +171,7 @@ func (db *DB) CreateTable(ctx context.Context, table *utils.Table) error {
 		return errors.Trace(err)
 	}
 	switchDbSQL := fmt.Sprintf("use %s;", table.Db.Name)
-	_, err = db.se.Execute(ctx, switchDbSQL)
+	err = db.se.Execute(ctx, switchDbSQL)
 	if err != nil {
 		log.Error("switch db failed",
 			zap.String("SQL", switchDbSQL),
@@ -108,7 +185,7 @@ func (db *DB) CreateTable(ctx context.Context, table *utils.Table) error {
 	if len(words) > 2 && strings.ToUpper(words[0]) == "CREATE" && strings.ToUpper(words[1]) == "TABLE" {
 		createSQL = "CREATE TABLE IF NOT EXISTS " + words[2]
 	}
-	_, err = db.se.Execute(ctx, createSQL)
+	err = db.se.Execute(ctx, createSQL)
 	if err != nil {
 		log.Error("create table failed",
 			zap.String("SQL", createSQL),
@@ -121,7 +198,7 @@ func (db *DB) CreateTable(ctx context.Context, table *utils.Table) error {
 		"alter table %s auto_increment = %d",
 		escapeTableName(schema.Name),
 		schema.AutoIncID)
-	_, err = db.se.Execute(ctx, alterAutoIncIDSQL)
+	err = db.se.Execute(ctx, alterAutoIncIDSQL)
 	if err != nil {
 		log.Error("alter AutoIncID failed",
 			zap.String("query", alterAutoIncIDSQL),
@@ -132,6 +209,107 @@ func (db *DB) CreateTable(ctx context.Context, table *utils.Table) error {
 	return errors.Trace(err)
 }
 
+// AddIndex adds an index to a restored table.
+func (db *DB) AddIndex(ctx context.Context, table *utils.Table, index *model.IndexInfo) error {
+	cols := make([]string, 0, len(index.Columns))
+	for _, col := range index.Columns {
+		colSQL := utils.EncloseName(col.Name.O)
+		if col.Length != types.UnspecifiedLength {
+			colSQL += fmt.Sprintf("(%d)", col.Length)
+		}
+		cols = append(cols, colSQL)
+	}
+	var indexSQL string
+	switch {
+	case index.Primary:
+		indexSQL = "PRIMARY KEY"
+	case index.Unique:
+		indexSQL = "UNIQUE INDEX " + utils.EncloseName(index.Name.O)
+	default:
+		indexSQL = "INDEX " + utils.EncloseName(index.Name.O)
+	}
+	addIndexSQL := fmt.Sprintf("ALTER TABLE %s.%s ADD %s (%s)",
+		utils.EncloseName(table.Db.Name.O), utils.EncloseName(table.Info.Name.O),
+		indexSQL, strings.Join(cols, ", "))
+	err := db.se.Execute(ctx, addIndexSQL)
+	if err != nil {
+		log.Error("add index failed",
+			zap.String("SQL", addIndexSQL),
+			zap.Stringer("db", table.Db.Name),
+			zap.Stringer("table", table.Info.Name),
+			zap.Error(err))
+	}
+	return errors.Trace(err)
+}
+
+// TruncatePartitions truncates the partitions of a restored table, which are
+// in the backup.
+func (db *DB) TruncatePartitions(ctx context.Context, table *utils.Table) error {
+	names := make([]string, 0, len(table.Info.Partition.Definitions))
+	for _, def := range table.Info.Partition.Definitions {
+		names = append(names, utils.EncloseName(def.Name.O))
+	}
+	truncateSQL := fmt.Sprintf("ALTER TABLE %s.%s TRUNCATE PARTITION %s",
+		utils.EncloseName(table.Db.Name.O), utils.EncloseName(table.Info.Name.O),
+		strings.Join(names, ", "))
+	err := db.se.Execute(ctx, truncateSQL)
+	if err != nil {
+		log.Error("truncate partitions failed",
+			zap.String("SQL", truncateSQL),
+			zap.Stringer("db", table.Db.Name),
+			zap.Stringer("table", table.Info.Name),
+			zap.Error(err))
+	}
+	return errors.Trace(err)
+}
+
+// SetTiFlashReplica sets the TiFlash replicas of a restored table.
+func (db *DB) SetTiFlashReplica(
+	ctx context.Context, table *utils.Table, replica *model.TiFlashReplicaInfo,
+) error {
+	replicaSQL := fmt.Sprintf("ALTER TABLE %s.%s SET TIFLASH REPLICA %d",
+		utils.EncloseName(table.Db.Name.O), utils.EncloseName(table.Info.Name.O), replica.Count)
+	if len(replica.LocationLabels) > 0 {
+		labels := make([]string, 0, len(replica.LocationLabels))
+		for _, label := range replica.LocationLabels {
+			labels = append(labels, strconv.Quote(label))
+		}
+		replicaSQL += " LOCATION LABELS " + strings.Join(labels, ", ")
+	}
+	err := db.se.Execute(ctx, replicaSQL)
+	if err != nil {
+		log.Error("set tiflash replica failed",
+			zap.String("SQL", replicaSQL),
+			zap.Stringer("db", table.Db.Name),
+			zap.Stringer("table", table.Info.Name),
+			zap.Error(err))
+	}
+	return errors.Trace(err)
+}
+
+// GetDBInfo returns the schema of a database from TiDB.
+func (db *DB) GetDBInfo(ctx context.Context, dbName model.CIStr) (*model.DBInfo, error) {
+	return db.se.GetDBInfo(ctx, dbName)
+}
+
+// GetTableInfo returns the schema of a table from TiDB.
+func (db *DB) GetTableInfo(ctx context.Context, dbName, tableName model.CIStr) (*model.TableInfo, error) {
+	return db.se.GetTableInfo(ctx, dbName, tableName)
+}
+
+// GetGlobalVariables returns the values of the global variables from TiDB.
+func (db *DB) GetGlobalVariables(ctx context.Context, names []string) (map[string]string, error) {
+	variables := make(map[string]string, len(names))
+	for _, name := range names {
+		value, err := db.se.GetGlobalVariable(ctx, name)
+		if err != nil {
+			return nil, errors.Annotatef(err, "get global variable %s failed", name)
+		}
+		variables[name] = value
+	}
+	return variables, nil
+}
+
 // Close closes the connection
 func (db *DB) Close() {
 	db.se.Close()
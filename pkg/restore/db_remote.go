@@ -0,0 +1,166 @@
+package restore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/model"
+	tmysql "github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/util/mock"
+)
+
+const (
+	// defaultTiDBStatusPort is the default port of the TiDB status server.
+	defaultTiDBStatusPort = "10080"
+	tidbSchemaPrefix      = "schema"
+	tidbRequestTimeout    = 30 * time.Second
+)
+
+// remoteExecutor executes SQL statements in a session of a remote TiDB
+// server, and reads schemas back from the TiDB status server.
+type remoteExecutor struct {
+	db   *sql.DB
+	conn *sql.Conn
+
+	statusAddr string
+	httpCli    *http.Client
+	sctx       sessionctx.Context
+}
+
+func (e *remoteExecutor) Execute(ctx context.Context, query string) error {
+	_, err := e.conn.ExecContext(ctx, query)
+	return errors.Trace(err)
+}
+
+func (e *remoteExecutor) GetDBInfo(ctx context.Context, dbName model.CIStr) (*model.DBInfo, error) {
+	// The status server only lists the schemas of all databases.
+	dbInfos := make([]*model.DBInfo, 0)
+	if err := e.getSchema(ctx, tidbSchemaPrefix, &dbInfos); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, dbInfo := range dbInfos {
+		if dbInfo.Name.L == dbName.L {
+			return dbInfo, nil
+		}
+	}
+	return nil, errors.Errorf("database %s not exists", dbName)
+}
+
+func (e *remoteExecutor) GetTableInfo(
+	ctx context.Context, dbName, tableName model.CIStr,
+) (*model.TableInfo, error) {
+	tableInfo := &model.TableInfo{}
+	prefix := fmt.Sprintf("%s/%s/%s", tidbSchemaPrefix,
+		url.PathEscape(dbName.O), url.PathEscape(tableName.O))
+	if err := e.getSchema(ctx, prefix, tableInfo); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return tableInfo, nil
+}
+
+// getSchema reads a schema from the TiDB status server.
+func (e *remoteExecutor) getSchema(ctx context.Context, prefix string, schema interface{}) error {
+	reqURL := fmt.Sprintf("%s/%s", e.statusAddr, prefix)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := e.httpCli.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("[%d] %s %s", resp.StatusCode, body, reqURL)
+	}
+	return errors.Trace(json.Unmarshal(body, schema))
+}
+
+func (e *remoteExecutor) GetGlobalVariable(ctx context.Context, name string) (string, error) {
+	var value string
+	err := e.conn.QueryRowContext(ctx, fmt.Sprintf("SELECT @@GLOBAL.%s", name)).Scan(&value)
+	return value, errors.Trace(err)
+}
+
+func (e *remoteExecutor) SessionContext() sessionctx.Context {
+	return e.sctx
+}
+
+func (e *remoteExecutor) Close() {
+	e.conn.Close()
+	e.db.Close()
+}
+
+// NewRemoteDB returns a new DB which executes SQL in a remote TiDB server
+// through the MySQL protocol. statusAddr is the address of the TiDB status
+// server, if it is empty, the host of dsn with the default status port is used.
+func NewRemoteDB(ctx context.Context, dsn string, statusAddr string) (*DB, error) {
+	if len(statusAddr) == 0 {
+		var err error
+		statusAddr, err = defaultStatusAddr(dsn)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if !strings.HasPrefix(statusAddr, "http") {
+		statusAddr = "http://" + statusAddr
+	}
+
+	sqlDB, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// All statements must be executed in the same session, since some of them
+	// depend on the current database selected by `USE`.
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		sqlDB.Close()
+		return nil, errors.Annotate(err, "connect to tidb failed")
+	}
+	sctx := mock.NewContext()
+	sctx.GetSessionVars().SQLMode = tmysql.ModeNone
+	db := &DB{
+		se: &remoteExecutor{
+			db:         sqlDB,
+			conn:       conn,
+			statusAddr: strings.TrimRight(statusAddr, "/"),
+			httpCli:    &http.Client{Timeout: tidbRequestTimeout},
+			sctx:       sctx,
+		},
+	}
+	if err = db.init(); err != nil {
+		db.Close()
+		return nil, errors.Trace(err)
+	}
+	return db, nil
+}
+
+func defaultStatusAddr(dsn string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", errors.Annotate(err, "parse tidb dsn failed")
+	}
+	host := cfg.Addr
+	if h, _, err := net.SplitHostPort(cfg.Addr); err == nil {
+		host = h
+	}
+	if len(host) == 0 {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, defaultTiDBStatusPort), nil
+}
@@ -2,13 +2,16 @@ package restore
 
 import (
 	"context"
+	"encoding/json"
 	"math"
+	"sort"
 	"strconv"
 	"testing"
 
 	. "github.com/pingcap/check"
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/tidb/meta/autoid"
+	"github.com/pingcap/tidb/types"
 	"github.com/pingcap/tidb/util/testkit"
 	"github.com/pingcap/tidb/util/testleak"
 
@@ -128,3 +131,310 @@ func (s *testRestoreSchemaSuite) TestFilterDDLJobs(c *C) {
 	}
 	c.Assert(len(ddlJobs), Equals, 7)
 }
+
+func (s *testRestoreSchemaSuite) TestRestoreWithRemoteDB(c *C) {
+	tk := testkit.NewTestKit(c, s.mock.Storage)
+	tk.MustExec("create database if not exists remote_db;")
+	tk.MustExec("use remote_db")
+	tk.MustExec("drop table if exists t;")
+	tk.MustExec("create table t (a int primary key auto_increment, b int, index idx_b(b));")
+	info, err := s.mock.Domain.GetSnapshotInfoSchema(math.MaxUint64)
+	c.Assert(err, IsNil)
+	dbInfo, exists := info.SchemaByName(model.NewCIStr("remote_db"))
+	c.Assert(exists, IsTrue)
+	tableInfo, err := info.TableByName(model.NewCIStr("remote_db"), model.NewCIStr("t"))
+	c.Assert(err, IsNil)
+	table := utils.Table{
+		Info: tableInfo.Meta().Clone(),
+		Db:   dbInfo.Clone(),
+	}
+	table.Info.AutoIncID = 1000
+	tk.MustExec("drop database remote_db;")
+
+	ctx := context.Background()
+	db, err := NewRemoteDB(ctx, s.mock.DSN, s.mock.StatusAddr)
+	c.Assert(err, IsNil, Commentf("Error create remote DB: %s %s", err, s.mock.DSN))
+	defer db.Close()
+	err = db.CreateDatabase(ctx, table.Db)
+	c.Assert(err, IsNil)
+	err = db.CreateTable(ctx, &table)
+	c.Assert(err, IsNil)
+
+	newTableInfo, err := db.GetTableInfo(ctx, table.Db.Name, table.Info.Name)
+	c.Assert(err, IsNil)
+	c.Assert(newTableInfo.ID, Not(Equals), table.Info.ID)
+	c.Assert(newTableInfo.Indices, HasLen, 1)
+	c.Assert(newTableInfo.Indices[0].Name.L, Equals, "idx_b")
+	autoIncID, err := strconv.ParseUint(tk.MustQuery("admin show remote_db.t next_row_id").Rows()[0][3].(string), 10, 64)
+	c.Assert(err, IsNil)
+	c.Assert(autoIncID, Equals, uint64(1000))
+
+	// The DDL jobs are executed in the same session.
+	err = db.ExecDDL(ctx, &model.Job{
+		SchemaName: "remote_db",
+		Query:      "alter table t add column c int",
+		BinlogInfo: &model.HistoryInfo{TableInfo: newTableInfo},
+	})
+	c.Assert(err, IsNil)
+	newTableInfo, err = db.GetTableInfo(ctx, table.Db.Name, table.Info.Name)
+	c.Assert(err, IsNil)
+	c.Assert(newTableInfo.Columns, HasLen, 3)
+
+	// The global variables are the same as the embedded session's.
+	tk.MustExec("set @@global.collation_server = 'utf8mb4_general_ci'")
+	variables, err := db.GetGlobalVariables(ctx, utils.CompatibilitySettings)
+	c.Assert(err, IsNil)
+	embeddedDB, err := NewDB(s.mock.Storage)
+	c.Assert(err, IsNil)
+	defer embeddedDB.Close()
+	embeddedVariables, err := embeddedDB.GetGlobalVariables(ctx, utils.CompatibilitySettings)
+	c.Assert(err, IsNil)
+	c.Assert(variables, DeepEquals, embeddedVariables)
+	c.Assert(variables["collation_server"], Equals, "utf8mb4_general_ci")
+}
+
+func (s *testRestoreSchemaSuite) TestDefaultStatusAddr(c *C) {
+	addr, err := defaultStatusAddr("root:@tcp(10.0.1.1:4000)/")
+	c.Assert(err, IsNil)
+	c.Assert(addr, Equals, "10.0.1.1:10080")
+	addr, err = defaultStatusAddr("root:@/")
+	c.Assert(err, IsNil)
+	c.Assert(addr, Equals, "127.0.0.1:10080")
+	_, err = defaultStatusAddr("root:@tcp(10.0.1.1:4000)")
+	c.Assert(err, NotNil)
+}
+
+func (s *testRestoreSchemaSuite) TestReplayDDLJobs(c *C) {
+	tk := testkit.NewTestKit(c, s.mock.Storage)
+	tk.MustExec("create database if not exists replay_db;")
+	tk.MustExec("use replay_db")
+	tk.MustExec("create table t (a int primary key, b int, c int, index idx_b(b), index idx_c(c));")
+	lastTS, err := s.mock.GetOracle().GetTimestamp(context.Background())
+	c.Assert(err, IsNil)
+	lastInfo, err := s.mock.Domain.GetSnapshotInfoSchema(lastTS)
+	c.Assert(err, IsNil)
+	lastTable, err := lastInfo.TableByName(model.NewCIStr("replay_db"), model.NewCIStr("t"))
+	c.Assert(err, IsNil)
+	lastDB, ok := lastInfo.SchemaByName(model.NewCIStr("replay_db"))
+	c.Assert(ok, IsTrue)
+
+	tk.MustExec("alter table t add column d varchar(10) not null default 'x' comment 'new column' after a;")
+	tk.MustExec("alter table t drop index idx_c;")
+	tk.MustExec("alter table t drop column c;")
+	tk.MustExec("alter table t change b b2 bigint;")
+	tk.MustExec("alter table t add unique index uk_d(d);")
+	tk.MustExec("alter table t rename index idx_b to idx_b2;")
+	tk.MustExec("alter table t alter column d set default 'y';")
+	tk.MustExec("alter table t comment = 'replayed';")
+	tk.MustExec("create table t1 (a int);")
+	tk.MustExec("rename table t1 to t2;")
+	tk.MustExec("create table t3 (a int);")
+	tk.MustExec("drop table t3;")
+	ts, err := s.mock.GetOracle().GetTimestamp(context.Background())
+	c.Assert(err, IsNil)
+	allJobs, err := backup.GetBackupDDLJobs(s.mock.Domain, lastTS, ts)
+	c.Assert(err, IsNil)
+	jobs := make([]*model.Job, 0)
+	for _, job := range allJobs {
+		if job.SchemaName == "replay_db" {
+			// The jobs must be replayed without their queries.
+			job.Query = ""
+			jobs = append(jobs, job)
+		}
+	}
+	c.Assert(jobs, HasLen, 12)
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].BinlogInfo.SchemaVersion < jobs[j].BinlogInfo.SchemaVersion
+	})
+	info, err := s.mock.Domain.GetSnapshotInfoSchema(ts)
+	c.Assert(err, IsNil)
+	expected, err := info.TableByName(model.NewCIStr("replay_db"), model.NewCIStr("t"))
+	c.Assert(err, IsNil)
+
+	// Restore the table at the last backup, then replay the ddl jobs.
+	tk.MustExec("drop database replay_db;")
+	db, err := NewDB(s.mock.Storage)
+	c.Assert(err, IsNil)
+	defer db.Close()
+	ctx := context.Background()
+	c.Assert(db.CreateDatabase(ctx, lastDB), IsNil)
+	c.Assert(db.CreateTable(ctx, &utils.Table{Db: lastDB, Info: lastTable.Meta()}), IsNil)
+	replayer := newDDLReplayer(db, []*model.DBInfo{lastDB})
+	for _, job := range jobs {
+		c.Assert(replayer.Replay(ctx, job), IsNil, Commentf("replay %s", job))
+	}
+	// Replaying an applied job is a no-op.
+	stmts, ok, err := replayer.plan(ctx, jobs[7])
+	c.Assert(err, IsNil)
+	c.Assert(ok, IsTrue)
+	c.Assert(stmts, HasLen, 0)
+
+	tk.MustQuery("show tables in replay_db").Check(testkit.Rows("t", "t2"))
+	actual, err := db.GetTableInfo(ctx, model.NewCIStr("replay_db"), model.NewCIStr("t"))
+	c.Assert(err, IsNil)
+	expectedDefs, err := buildTableDefs(tk.Se, expected.Meta())
+	c.Assert(err, IsNil)
+	actualDefs, err := buildTableDefs(tk.Se, actual)
+	c.Assert(err, IsNil)
+	c.Assert(actualDefs, DeepEquals, expectedDefs)
+	c.Assert(actual.Comment, Equals, "replayed")
+}
+
+func (s *testRestoreSchemaSuite) TestReplayRenameTableAcrossDatabases(c *C) {
+	tk := testkit.NewTestKit(c, s.mock.Storage)
+	tk.MustExec("create database if not exists rename_db1;")
+	tk.MustExec("create database if not exists rename_db2;")
+	tk.MustExec("create table rename_db1.t (a int);")
+	tk.MustExec("create table rename_db1.u (a int);")
+	lastTS, err := s.mock.GetOracle().GetTimestamp(context.Background())
+	c.Assert(err, IsNil)
+	lastInfo, err := s.mock.Domain.GetSnapshotInfoSchema(lastTS)
+	c.Assert(err, IsNil)
+	db1, ok := lastInfo.SchemaByName(model.NewCIStr("rename_db1"))
+	c.Assert(ok, IsTrue)
+	db2, ok := lastInfo.SchemaByName(model.NewCIStr("rename_db2"))
+	c.Assert(ok, IsTrue)
+	tables := make([]*utils.Table, 0, 2)
+	for _, name := range []string{"t", "u"} {
+		table, err := lastInfo.TableByName(model.NewCIStr("rename_db1"), model.NewCIStr(name))
+		c.Assert(err, IsNil)
+		tables = append(tables, &utils.Table{Db: db1, Info: table.Meta()})
+	}
+
+	// The tables are created before the incremental range, so the replayer
+	// does not track them.
+	tk.MustExec("rename table rename_db1.t to rename_db2.t2;")
+	tk.MustExec("rename table rename_db1.u to rename_db2.u;")
+	ts, err := s.mock.GetOracle().GetTimestamp(context.Background())
+	c.Assert(err, IsNil)
+	allJobs, err := backup.GetBackupDDLJobs(s.mock.Domain, lastTS, ts)
+	c.Assert(err, IsNil)
+	jobs := make([]*model.Job, 0)
+	for _, job := range allJobs {
+		if job.Type == model.ActionRenameTable {
+			jobs = append(jobs, job)
+		}
+	}
+	c.Assert(jobs, HasLen, 2)
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].BinlogInfo.SchemaVersion < jobs[j].BinlogInfo.SchemaVersion
+	})
+	// The old schema is resolved from the arguments, and the old name falls
+	// back to the table info without the query.
+	jobs[1].Query = ""
+	jobs[1].RawArgs, err = json.Marshal([]interface{}{db1.ID, model.NewCIStr("u")})
+	c.Assert(err, IsNil)
+
+	tk.MustExec("drop database rename_db1;")
+	tk.MustExec("drop database rename_db2;")
+	db, err := NewDB(s.mock.Storage)
+	c.Assert(err, IsNil)
+	defer db.Close()
+	ctx := context.Background()
+	c.Assert(db.CreateDatabase(ctx, db1), IsNil)
+	c.Assert(db.CreateDatabase(ctx, db2), IsNil)
+	for _, table := range tables {
+		c.Assert(db.CreateTable(ctx, table), IsNil)
+	}
+	replayer := newDDLReplayer(db, []*model.DBInfo{db1, db2})
+	stmts, ok, err := replayer.plan(ctx, jobs[0])
+	c.Assert(err, IsNil)
+	c.Assert(ok, IsTrue)
+	c.Assert(stmts, DeepEquals, []string{"RENAME TABLE `rename_db1`.`t` TO `rename_db2`.`t2`"})
+	for _, job := range jobs {
+		c.Assert(replayer.Replay(ctx, job), IsNil, Commentf("replay %s", job))
+	}
+
+	tk.MustQuery("show tables in rename_db1").Check(testkit.Rows())
+	tk.MustQuery("show tables in rename_db2").Check(testkit.Rows("t2", "u"))
+}
+
+func (s *testRestoreSchemaSuite) TestAddIndex(c *C) {
+	tk := testkit.NewTestKit(c, s.mock.Storage)
+	tk.MustExec("create database if not exists pending_db;")
+	tk.MustExec("use pending_db")
+	tk.MustExec("drop table if exists t;")
+	tk.MustExec("create table t (a int, b varchar(20));")
+	info, err := s.mock.Domain.GetSnapshotInfoSchema(math.MaxUint64)
+	c.Assert(err, IsNil)
+	dbInfo, exists := info.SchemaByName(model.NewCIStr("pending_db"))
+	c.Assert(exists, IsTrue)
+	tableInfo, err := info.TableByName(model.NewCIStr("pending_db"), model.NewCIStr("t"))
+	c.Assert(err, IsNil)
+	table := &utils.Table{Db: dbInfo, Info: tableInfo.Meta()}
+
+	db, err := NewDB(s.mock.Storage)
+	c.Assert(err, IsNil)
+	defer db.Close()
+	ctx := context.Background()
+	err = db.AddIndex(ctx, table, &model.IndexInfo{
+		Name:   model.NewCIStr("idx_ab"),
+		Unique: true,
+		Columns: []*model.IndexColumn{
+			{Name: model.NewCIStr("a"), Length: types.UnspecifiedLength},
+			{Name: model.NewCIStr("b"), Length: 10},
+		},
+	})
+	c.Assert(err, IsNil)
+	tk.MustQuery("show create table t").Check(testkit.Rows("t CREATE TABLE `t` (\n" +
+		"  `a` int(11) DEFAULT NULL,\n" +
+		"  `b` varchar(20) DEFAULT NULL,\n" +
+		"  UNIQUE KEY `idx_ab` (`a`,`b`(10))\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin"))
+}
+
+func (s *testRestoreSchemaSuite) TestRecoverTiFlashReplica(c *C) {
+	tk := testkit.NewTestKit(c, s.mock.Storage)
+	tk.MustExec("create database if not exists tiflash_db;")
+	tk.MustExec("use tiflash_db")
+	tk.MustExec("drop table if exists t, t_replica;")
+	tk.MustExec("create table t (a int);")
+	tk.MustExec("create table t_replica (a int);")
+	tk.MustExec("alter table t_replica set tiflash replica 2 location labels 'zone', 'host';")
+	info, err := s.mock.Domain.GetSnapshotInfoSchema(math.MaxUint64)
+	c.Assert(err, IsNil)
+	dbInfo, exists := info.SchemaByName(model.NewCIStr("tiflash_db"))
+	c.Assert(exists, IsTrue)
+	tables := make([]*utils.Table, 0, 2)
+	for _, name := range []string{"t", "t_replica"} {
+		table, err := info.TableByName(model.NewCIStr("tiflash_db"), model.NewCIStr(name))
+		c.Assert(err, IsNil)
+		tables = append(tables, &utils.Table{Db: dbInfo, Info: table.Meta().Clone()})
+	}
+	c.Assert(tables[1].Info.TiFlashReplica, NotNil)
+	tk.MustExec("drop table t, t_replica;")
+
+	db, err := NewDB(s.mock.Storage)
+	c.Assert(err, IsNil)
+	defer db.Close()
+	client := Client{ctx: context.Background(), store: s.mock.Storage, db: db}
+	_, newTables, err := client.CreateTables(tables, 0)
+	c.Assert(err, IsNil)
+	// The tables are created without TiFlash replicas.
+	for _, newTable := range newTables {
+		c.Assert(newTable.TiFlashReplica, IsNil)
+	}
+
+	// The replicas are not set when replaying the DDL jobs.
+	stmts, ok, err := newDDLReplayer(db, nil).plan(context.Background(), &model.Job{
+		Type:       model.ActionSetTiFlashReplica,
+		SchemaName: "tiflash_db",
+		BinlogInfo: &model.HistoryInfo{TableInfo: tables[1].Info},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(ok, IsTrue)
+	c.Assert(stmts, HasLen, 0)
+
+	c.Assert(client.RecoverTiFlashReplica(tables), IsNil)
+	info, err = s.mock.Domain.GetSnapshotInfoSchema(math.MaxUint64)
+	c.Assert(err, IsNil)
+	table, err := info.TableByName(model.NewCIStr("tiflash_db"), model.NewCIStr("t"))
+	c.Assert(err, IsNil)
+	c.Assert(table.Meta().TiFlashReplica, IsNil)
+	table, err = info.TableByName(model.NewCIStr("tiflash_db"), model.NewCIStr("t_replica"))
+	c.Assert(err, IsNil)
+	replica := table.Meta().TiFlashReplica
+	c.Assert(replica, NotNil)
+	c.Assert(replica.Count, Equals, uint64(2))
+	c.Assert(replica.LocationLabels, DeepEquals, []string{"zone", "host"})
+}
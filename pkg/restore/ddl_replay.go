@@ -0,0 +1,487 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/executor"
+	"github.com/pingcap/tidb/sessionctx"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const primaryKeyName = "primary"
+
+// tableName is the qualified name of a table.
+type tableName struct {
+	db    model.CIStr
+	table model.CIStr
+}
+
+func (n tableName) String() string {
+	return utils.EncloseName(n.db.O) + "." + utils.EncloseName(n.table.O)
+}
+
+// ddlReplayer replays DDL jobs from the schema states recorded in them.
+//
+// Instead of re-executing `job.Query`, it takes the schema of the database or
+// table after the job (`job.BinlogInfo.DBInfo/TableInfo`), diffs it against the
+// schema in the cluster, and issues the minimal DDL to reach it. Jobs it does
+// not know how to replay fall back to executing the original query.
+type ddlReplayer struct {
+	db *DB
+	// tables tracks the current names of the tables touched by the replayed
+	// jobs, keyed by the table IDs in the backup.
+	tables map[int64]tableName
+	// schemas tracks the names of the databases, keyed by the schema IDs in
+	// the backup.
+	schemas map[int64]model.CIStr
+}
+
+// newDDLReplayer creates a ddlReplayer. The databases in the backup are used
+// to resolve the schema IDs recorded in the jobs.
+func newDDLReplayer(db *DB, dbInfos []*model.DBInfo) *ddlReplayer {
+	schemas := make(map[int64]model.CIStr, len(dbInfos))
+	for _, dbInfo := range dbInfos {
+		schemas[dbInfo.ID] = dbInfo.Name
+	}
+	return &ddlReplayer{
+		db:      db,
+		tables:  make(map[int64]tableName),
+		schemas: schemas,
+	}
+}
+
+// Replay replays a ddl job against the cluster.
+func (r *ddlReplayer) Replay(ctx context.Context, job *model.Job) error {
+	stmts, ok, err := r.plan(ctx, job)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !ok {
+		log.Info("fallback to replay ddl query",
+			zap.Stringer("type", job.Type),
+			zap.String("db", job.SchemaName),
+			zap.String("query", job.Query))
+		return r.db.ExecDDL(ctx, job)
+	}
+	for _, stmt := range stmts {
+		if err = r.db.se.Execute(ctx, stmt); err != nil {
+			log.Error("replay ddl failed",
+				zap.String("SQL", stmt),
+				zap.String("query", job.Query),
+				zap.Int64("historySchemaVersion", job.BinlogInfo.SchemaVersion),
+				zap.Error(err))
+			return errors.Trace(err)
+		}
+	}
+	if len(stmts) == 0 {
+		log.Info("ddl already applied, skip it",
+			zap.String("query", job.Query),
+			zap.Int64("historySchemaVersion", job.BinlogInfo.SchemaVersion))
+	}
+	r.track(job)
+	return nil
+}
+
+// plan returns the SQL statements to replay the job. It returns false if the
+// job can only be replayed by its query.
+func (r *ddlReplayer) plan(ctx context.Context, job *model.Job) ([]string, bool, error) {
+	if job.Type == model.ActionSetTiFlashReplica || job.Type == model.ActionUpdateTiFlashReplicaStatus {
+		// The TiFlash replicas are set after the data is restored, see
+		// `Client.RecoverTiFlashReplica`.
+		return nil, true, nil
+	}
+	if dbInfo := job.BinlogInfo.DBInfo; dbInfo != nil {
+		return r.planSchema(job, dbInfo)
+	}
+	tableInfo := job.BinlogInfo.TableInfo
+	if tableInfo == nil || len(job.SchemaName) == 0 || tableInfo.IsView() {
+		return nil, false, nil
+	}
+	name := tableName{db: model.NewCIStr(job.SchemaName), table: tableInfo.Name}
+	switch job.Type {
+	case model.ActionCreateTable:
+		var buf bytes.Buffer
+		err := executor.ConstructResultOfShowCreateTable(
+			r.db.se.SessionContext(), tableInfo, newIDAllocator(tableInfo.AutoIncID), &buf)
+		if err != nil {
+			return nil, false, errors.Trace(err)
+		}
+		createSQL := strings.Replace(buf.String(),
+			"CREATE TABLE ", "CREATE TABLE IF NOT EXISTS "+utils.EncloseName(name.db.O)+".", 1)
+		return []string{createSQL}, true, nil
+	case model.ActionDropTable:
+		return []string{fmt.Sprintf("DROP TABLE IF EXISTS %s", name)}, true, nil
+	case model.ActionTruncateTable:
+		return []string{fmt.Sprintf("TRUNCATE TABLE %s", name)}, true, nil
+	case model.ActionRenameTable:
+		old, ok := r.tables[job.TableID]
+		if !ok {
+			// The table is created before the incremental range, so its old
+			// name is resolved from the job.
+			if old, ok = r.renamedFrom(job); !ok {
+				return nil, false, nil
+			}
+		}
+		if old == name {
+			return nil, true, nil
+		}
+		return []string{fmt.Sprintf("RENAME TABLE %s TO %s", old, name)}, true, nil
+	case model.ActionAddColumn, model.ActionDropColumn, model.ActionModifyColumn,
+		model.ActionSetDefaultValue, model.ActionAddIndex, model.ActionDropIndex,
+		model.ActionAddPrimaryKey, model.ActionDropPrimaryKey, model.ActionRenameIndex,
+		model.ActionModifyTableComment, model.ActionModifyTableCharsetAndCollate,
+		model.ActionRebaseAutoID, model.ActionShardRowID:
+		current, err := r.db.GetTableInfo(ctx, name.db, name.table)
+		if err != nil {
+			return nil, false, errors.Annotatef(err, "get schema of %s", name)
+		}
+		return r.planAlterTable(job, name, current, tableInfo)
+	default:
+		return nil, false, nil
+	}
+}
+
+func (r *ddlReplayer) planSchema(job *model.Job, dbInfo *model.DBInfo) ([]string, bool, error) {
+	name := utils.EncloseName(dbInfo.Name.O)
+	switch job.Type {
+	case model.ActionCreateSchema:
+		var buf bytes.Buffer
+		err := executor.ConstructResultOfShowCreateDatabase(r.db.se.SessionContext(), dbInfo, true, &buf)
+		if err != nil {
+			return nil, false, errors.Trace(err)
+		}
+		return []string{buf.String()}, true, nil
+	case model.ActionDropSchema:
+		return []string{fmt.Sprintf("DROP DATABASE IF EXISTS %s", name)}, true, nil
+	case model.ActionModifySchemaCharsetAndCollate:
+		if len(dbInfo.Charset) == 0 || len(dbInfo.Collate) == 0 {
+			return nil, false, nil
+		}
+		return []string{fmt.Sprintf("ALTER DATABASE %s CHARACTER SET = %s COLLATE = %s",
+			name, dbInfo.Charset, dbInfo.Collate)}, true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
+// planAlterTable diffs the current schema of a table against the target
+// schema, and returns one ALTER TABLE statement per change, since TiDB does
+// not support multiple schema changes in one statement.
+func (r *ddlReplayer) planAlterTable(
+	job *model.Job, name tableName, current, target *model.TableInfo,
+) ([]string, bool, error) {
+	// Partitioned tables are not diffed, since partitions are not compared.
+	if current.Partition != nil || target.Partition != nil {
+		return nil, false, nil
+	}
+	sctx := r.db.se.SessionContext()
+	currentDefs, err := buildTableDefs(sctx, current)
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+	targetDefs, err := buildTableDefs(sctx, target)
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+
+	var (
+		dropIndices, addIndices []string
+		dropCols, addCols       []string
+		modifyCols              []string
+	)
+	for _, col := range currentDefs.columns {
+		if _, ok := targetDefs.columnDefs[col]; !ok {
+			dropCols = append(dropCols, col)
+		}
+	}
+	for _, col := range targetDefs.columns {
+		def, ok := currentDefs.columnDefs[col]
+		if !ok {
+			addCols = append(addCols, col)
+		} else if def != targetDefs.columnDefs[col] {
+			modifyCols = append(modifyCols, col)
+		}
+	}
+
+	specs := make([]string, 0)
+	// A renamed column is dropped and added in the same modify column job.
+	if job.Type == model.ActionModifyColumn && len(dropCols) == 1 && len(addCols) == 1 {
+		from, to := dropCols[0], addCols[0]
+		specs = append(specs, fmt.Sprintf("CHANGE COLUMN %s %s%s",
+			utils.EncloseName(currentDefs.columnNames[from]),
+			targetDefs.columnDefs[to], targetDefs.position(to)))
+		// The indices on the column are renamed along with it.
+		currentDefs.renameIndexColumn(currentDefs.columnNames[from], targetDefs.columnNames[to])
+		dropCols, addCols = nil, nil
+	}
+
+	for _, idx := range currentDefs.indices {
+		if def, ok := targetDefs.indexDefs[idx]; !ok || def != currentDefs.indexDefs[idx] {
+			dropIndices = append(dropIndices, idx)
+		}
+	}
+	for _, idx := range targetDefs.indices {
+		if def, ok := currentDefs.indexDefs[idx]; !ok || def != targetDefs.indexDefs[idx] {
+			addIndices = append(addIndices, idx)
+		}
+	}
+	// A renamed index has the same definition except its name.
+	if job.Type == model.ActionRenameIndex && len(dropIndices) == 1 && len(addIndices) == 1 {
+		from, to := dropIndices[0], addIndices[0]
+		if stripIndexName(currentDefs.indexDefs[from]) == stripIndexName(targetDefs.indexDefs[to]) {
+			specs = append(specs, fmt.Sprintf("RENAME INDEX %s TO %s",
+				utils.EncloseName(currentDefs.indexNames[from]), utils.EncloseName(targetDefs.indexNames[to])))
+			dropIndices, addIndices = nil, nil
+		}
+	}
+	// Drop the indices before the columns they depend on.
+	for _, idx := range dropIndices {
+		if idx == primaryKeyName {
+			specs = append(specs, "DROP PRIMARY KEY")
+		} else {
+			specs = append(specs, "DROP INDEX "+utils.EncloseName(currentDefs.indexNames[idx]))
+		}
+	}
+	for _, col := range dropCols {
+		specs = append(specs, "DROP COLUMN "+utils.EncloseName(currentDefs.columnNames[col]))
+	}
+	for _, col := range modifyCols {
+		specs = append(specs, fmt.Sprintf("MODIFY COLUMN %s%s",
+			targetDefs.columnDefs[col], targetDefs.position(col)))
+	}
+	for _, col := range addCols {
+		specs = append(specs, fmt.Sprintf("ADD COLUMN %s%s",
+			targetDefs.columnDefs[col], targetDefs.position(col)))
+	}
+	for _, idx := range addIndices {
+		specs = append(specs, "ADD "+targetDefs.indexDefs[idx])
+	}
+
+	if current.Comment != target.Comment {
+		specs = append(specs, fmt.Sprintf("COMMENT = '%s'", format.OutputFormat(target.Comment)))
+	}
+	if len(target.Charset) != 0 && len(target.Collate) != 0 &&
+		(current.Charset != target.Charset || current.Collate != target.Collate) {
+		specs = append(specs, fmt.Sprintf("CHARACTER SET = %s COLLATE = %s", target.Charset, target.Collate))
+	}
+	if current.ShardRowIDBits != target.ShardRowIDBits {
+		specs = append(specs, fmt.Sprintf("SHARD_ROW_ID_BITS = %d", target.ShardRowIDBits))
+	}
+	// Only a rebase job records the target auto increment ID, other jobs do
+	// not update it.
+	if job.Type == model.ActionRebaseAutoID && target.AutoIncID > 0 {
+		specs = append(specs, fmt.Sprintf("AUTO_INCREMENT = %d", target.AutoIncID))
+	}
+
+	stmts := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s %s", name, spec))
+	}
+	return stmts, true, nil
+}
+
+// renamedFrom returns the name of a table before a rename table job. The
+// old schema is resolved from the schema ID in the job arguments, and the old
+// table name is taken from the query, or from the table info if the query does
+// not name it.
+func (r *ddlReplayer) renamedFrom(job *model.Job) (tableName, bool) {
+	var (
+		oldSchemaID int64
+		newName     model.CIStr
+		old         = tableName{table: job.BinlogInfo.TableInfo.Name}
+	)
+	if err := job.DecodeArgs(&oldSchemaID, &newName); err != nil {
+		log.Warn("failed to decode the arguments of rename table job",
+			zap.String("query", job.Query), zap.Error(err))
+	}
+	old.db = r.schemas[oldSchemaID]
+	if len(job.Query) != 0 {
+		stmt, err := parser.New().ParseOneStmt(job.Query, "", "")
+		if err != nil {
+			log.Warn("failed to parse the query of rename table job",
+				zap.String("query", job.Query), zap.Error(err))
+		}
+		var oldTable *ast.TableName
+		switch s := stmt.(type) {
+		case *ast.RenameTableStmt:
+			oldTable = s.OldTable
+		case *ast.AlterTableStmt:
+			oldTable = s.Table
+		}
+		if oldTable != nil {
+			old.table = oldTable.Name
+			// Some TiDB versions do not keep the arguments in the history
+			// jobs, then the old schema can only be found in the query.
+			if len(old.db.O) == 0 {
+				old.db = oldTable.Schema
+			}
+		}
+	}
+	return old, len(old.db.O) != 0
+}
+
+// track records the names of the databases and tables after a job is
+// replayed.
+func (r *ddlReplayer) track(job *model.Job) {
+	if dbInfo := job.BinlogInfo.DBInfo; dbInfo != nil {
+		if job.Type == model.ActionDropSchema {
+			delete(r.schemas, dbInfo.ID)
+		} else {
+			r.schemas[dbInfo.ID] = dbInfo.Name
+		}
+		return
+	}
+	// The schema name of a job is in lower case, so it does not override the
+	// name in the backup.
+	if _, ok := r.schemas[job.SchemaID]; !ok && len(job.SchemaName) != 0 {
+		r.schemas[job.SchemaID] = model.NewCIStr(job.SchemaName)
+	}
+	tableInfo := job.BinlogInfo.TableInfo
+	if tableInfo == nil {
+		return
+	}
+	if job.Type == model.ActionDropTable {
+		delete(r.tables, job.TableID)
+		return
+	}
+	name := tableName{db: model.NewCIStr(job.SchemaName), table: tableInfo.Name}
+	r.tables[job.TableID] = name
+	// The table ID is changed after truncating.
+	r.tables[tableInfo.ID] = name
+}
+
+// tableDefs holds the definitions of the columns and indices of a table, as
+// they are shown in `SHOW CREATE TABLE`.
+type tableDefs struct {
+	// columns and indices are lower case names in their original order.
+	columns     []string
+	indices     []string
+	columnNames map[string]string
+	columnDefs  map[string]string
+	indexNames  map[string]string
+	indexDefs   map[string]string
+}
+
+func buildTableDefs(sctx sessionctx.Context, info *model.TableInfo) (*tableDefs, error) {
+	var buf bytes.Buffer
+	err := executor.ConstructResultOfShowCreateTable(sctx, info, newIDAllocator(info.AutoIncID), &buf)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// The first line is `CREATE TABLE ... (`, and the last line is the
+	// table options. Others are the definitions of columns and indices.
+	lines := strings.Split(buf.String(), "\n")
+	if len(lines) < 2 {
+		return nil, errors.Errorf("unexpected create table statement: %s", buf.String())
+	}
+	lines = lines[1 : len(lines)-1]
+	next := func() (string, error) {
+		if len(lines) == 0 {
+			return "", errors.Errorf("unexpected create table statement: %s", buf.String())
+		}
+		line := strings.TrimSuffix(strings.TrimSpace(lines[0]), ",")
+		lines = lines[1:]
+		return line, nil
+	}
+
+	defs := &tableDefs{
+		columnNames: make(map[string]string),
+		columnDefs:  make(map[string]string),
+		indexNames:  make(map[string]string),
+		indexDefs:   make(map[string]string),
+	}
+	var pkIsHandle bool
+	for _, col := range info.Cols() {
+		if col.Hidden {
+			continue
+		}
+		def, err := next()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		defs.columns = append(defs.columns, col.Name.L)
+		defs.columnNames[col.Name.L] = col.Name.O
+		defs.columnDefs[col.Name.L] = def
+		if info.PKIsHandle && mysql.HasPriKeyFlag(col.Flag) {
+			pkIsHandle = true
+		}
+	}
+	if pkIsHandle {
+		def, err := next()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		defs.addIndex(primaryKeyName, "PRIMARY", def)
+	}
+	for _, idx := range info.Indices {
+		if idx.State != model.StatePublic {
+			continue
+		}
+		def, err := next()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if idx.Primary {
+			defs.addIndex(primaryKeyName, "PRIMARY", def)
+		} else {
+			defs.addIndex(idx.Name.L, idx.Name.O, def)
+		}
+	}
+	return defs, nil
+}
+
+func (defs *tableDefs) addIndex(key, name, def string) {
+	defs.indices = append(defs.indices, key)
+	defs.indexNames[key] = name
+	defs.indexDefs[key] = def
+}
+
+// position returns the position clause to place a column after its
+// preceding column.
+func (defs *tableDefs) position(col string) string {
+	for i, c := range defs.columns {
+		if c != col {
+			continue
+		}
+		if i == 0 {
+			return " FIRST"
+		}
+		return " AFTER " + utils.EncloseName(defs.columnNames[defs.columns[i-1]])
+	}
+	return ""
+}
+
+// renameIndexColumn replaces a column name in the definitions of indices.
+func (defs *tableDefs) renameIndexColumn(from, to string) {
+	from, to = utils.EncloseName(from), utils.EncloseName(to)
+	for idx, def := range defs.indexDefs {
+		pos := strings.LastIndex(def, " (")
+		if pos < 0 {
+			continue
+		}
+		defs.indexDefs[idx] = def[:pos] + strings.Replace(def[pos:], from, to, -1)
+	}
+}
+
+// stripIndexName removes the name from an index definition, e.g.
+// "UNIQUE KEY `idx` (`a`)" becomes "UNIQUE KEY (`a`)".
+func stripIndexName(def string) string {
+	start := strings.Index(def, "`")
+	end := strings.LastIndex(def, " (")
+	if start < 0 || end < start {
+		return def
+	}
+	return def[:start] + def[end+1:]
+}
@@ -11,16 +11,21 @@ import (
 	"github.com/pingcap/kvproto/pkg/backup"
 	"github.com/pingcap/kvproto/pkg/import_sstpb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/log"
 	"github.com/pingcap/pd/pkg/codec"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 
+	"github.com/pingcap/br/pkg/conn"
 	"github.com/pingcap/br/pkg/summary"
 	"github.com/pingcap/br/pkg/utils"
 )
 
-const importScanRegionTime = 10 * time.Second
+const (
+	importScanRegionTime = 10 * time.Second
+	ingestBusyRetryTimes = 8
+)
 
 // ImporterClient is used to import a file to TiKV
 type ImporterClient interface {
@@ -122,6 +127,10 @@ type FileImporter struct {
 	importClient ImporterClient
 	backend      *backup.StorageBackend
 	rateLimit    uint64
+	// tiflashStores caches whether the stores are TiFlash stores.
+	tiflashStores *sync.Map
+	// scheduler limits the concurrent requests on each store.
+	scheduler *storeScheduler
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -137,15 +146,28 @@ func NewFileImporter(
 ) FileImporter {
 	ctx, cancel := context.WithCancel(ctx)
 	return FileImporter{
-		metaClient:   metaClient,
-		backend:      backend,
-		ctx:          ctx,
-		cancel:       cancel,
-		importClient: importClient,
-		rateLimit:    rateLimit,
+		metaClient:    metaClient,
+		backend:       backend,
+		ctx:           ctx,
+		cancel:        cancel,
+		importClient:  importClient,
+		rateLimit:     rateLimit,
+		tiflashStores: new(sync.Map),
+		scheduler:     newStoreScheduler(DefaultStoreConcurrency),
 	}
 }
 
+// SetStoreConcurrency sets the maximum number of the concurrent download and
+// ingest requests on each store.
+func (importer *FileImporter) SetStoreConcurrency(concurrency uint) {
+	importer.scheduler = newStoreScheduler(concurrency)
+}
+
+// GetStoreStates returns the scheduling states of the stores.
+func (importer *FileImporter) GetStoreStates() []StoreState {
+	return importer.scheduler.states()
+}
+
 // Import tries to import a file.
 // All rules must contain encoded keys.
 func (importer *FileImporter) Import(file *backup.File, rewriteRules *RewriteRules) error {
@@ -171,15 +193,12 @@ func (importer *FileImporter) Import(file *backup.File, rewriteRules *RewriteRul
 		// Try to download and ingest the file in every region
 		for _, regionInfo := range regionInfos {
 			info := regionInfo
-			// Try to download file.
+			// Try to download file, the failed downloads are retried on
+			// each peer.
 			var downloadMeta *import_sstpb.SSTMeta
-			err1 = utils.WithRetry(importer.ctx, func() error {
-				var e error
-				downloadMeta, e = importer.downloadSST(info, file, rewriteRules)
-				return e
-			}, newDownloadSSTBackoffer())
+			downloadMeta, err1 = importer.downloadSST(info, file, rewriteRules)
 			if err1 != nil {
-				if err1 == errRewriteRuleNotFound || err1 == errRangeIsEmpty {
+				if errors.Cause(err1) == errRewriteRuleNotFound || errors.Cause(err1) == errRangeIsEmpty {
 					// Skip this region
 					continue
 				}
@@ -192,6 +211,12 @@ func (importer *FileImporter) Import(file *backup.File, rewriteRules *RewriteRul
 				return err1
 			}
 			err1 = importer.ingestSST(downloadMeta, info)
+			// If the store is busy, retry after the store is backed off.
+			for i := 0; errors.Cause(err1) == errServerBusy && i < ingestBusyRetryTimes; i++ {
+				log.Debug("ingest sst returns server busy error, retry it",
+					zap.Stringer("region", info.Region))
+				err1 = importer.ingestSST(downloadMeta, info)
+			}
 			// If error is `NotLeader`, update the region info and retry
 			for errors.Cause(err1) == errNotLeader {
 				log.Debug("ingest sst returns not leader error, retry it",
@@ -264,21 +289,93 @@ func (importer *FileImporter) downloadSST(
 		zap.Stringer("sstMeta", &sstMeta),
 		zap.Stringer("region", regionInfo.Region),
 	)
-	var resp *import_sstpb.DownloadResponse
+	peers := make([]*metapb.Peer, 0, len(regionInfo.Region.GetPeers()))
 	for _, peer := range regionInfo.Region.GetPeers() {
-		resp, err = importer.importClient.DownloadSST(importer.ctx, peer.GetStoreId(), req)
+		isTiFlash, err := importer.isTiFlashStore(peer.GetStoreId())
 		if err != nil {
-			return nil, extractDownloadSSTError(err)
+			return nil, err
+		}
+		if isTiFlash {
+			// TiFlash replicates the data from TiKV after ingesting.
+			continue
 		}
-		if resp.GetIsEmpty() {
-			return nil, errors.Trace(errRangeIsEmpty)
+		peers = append(peers, peer)
+	}
+	if len(peers) == 0 {
+		return nil, errors.Errorf("region %d has no TiKV peer", regionInfo.Region.GetId())
+	}
+
+	// Download the SST to all peers concurrently, the SST can be ingested
+	// only after every peer has it.
+	resps := make([]*import_sstpb.DownloadResponse, len(peers))
+	errs := make([]error, len(peers))
+	wg := new(sync.WaitGroup)
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, storeID uint64) {
+			defer wg.Done()
+			errs[i] = utils.WithRetry(importer.ctx, func() error {
+				var e error
+				resps[i], e = importer.downloadSSTToStore(storeID, req)
+				return e
+			}, newDownloadSSTBackoffer())
+		}(i, peer.GetStoreId())
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			if errors.Cause(err) != errRangeIsEmpty {
+				log.Error("download SST to peer failed",
+					zap.Stringer("region", regionInfo.Region),
+					zap.Uint64("store", peers[i].GetStoreId()),
+					zap.Error(err))
+			}
+			return nil, err
 		}
 	}
+	resp := resps[0]
 	sstMeta.Range.Start = truncateTS(resp.Range.GetStart())
 	sstMeta.Range.End = truncateTS(resp.Range.GetEnd())
 	return &sstMeta, nil
 }
 
+// downloadSSTToStore downloads the SST to a store.
+func (importer *FileImporter) downloadSSTToStore(
+	storeID uint64,
+	req *import_sstpb.DownloadRequest,
+) (*import_sstpb.DownloadResponse, error) {
+	release, err := importer.scheduler.acquire(importer.ctx, storeID)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	resp, err := importer.importClient.DownloadSST(importer.ctx, storeID, req)
+	if err != nil {
+		err = extractDownloadSSTError(err)
+		release(errors.Cause(err) == errServerBusy)
+		return nil, err
+	}
+	release(false)
+	if resp.GetIsEmpty() {
+		return nil, errors.Trace(errRangeIsEmpty)
+	}
+	return resp, nil
+}
+
+// isTiFlashStore checks whether the store is a TiFlash store, which doesn't
+// support downloading and ingesting SST files.
+func (importer *FileImporter) isTiFlashStore(storeID uint64) (bool, error) {
+	if isTiFlash, ok := importer.tiflashStores.Load(storeID); ok {
+		return isTiFlash.(bool), nil
+	}
+	store, err := importer.metaClient.GetStore(importer.ctx, storeID)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	isTiFlash := conn.IsTiFlash(store)
+	importer.tiflashStores.Store(storeID, isTiFlash)
+	return isTiFlash, nil
+}
+
 func (importer *FileImporter) ingestSST(
 	sstMeta *import_sstpb.SSTMeta,
 	regionInfo *RegionInfo,
@@ -297,16 +394,29 @@ func (importer *FileImporter) ingestSST(
 		Sst:     sstMeta,
 	}
 	log.Debug("download SST", zap.Stringer("sstMeta", sstMeta))
+	release, err := importer.scheduler.acquire(importer.ctx, leader.GetStoreId())
+	if err != nil {
+		return errors.Trace(err)
+	}
 	resp, err := importer.importClient.IngestSST(importer.ctx, leader.GetStoreId(), req)
 	if err != nil {
+		busy := isServerBusy(err.Error())
+		release(busy)
 		if strings.Contains(err.Error(), "RegionNotFound") {
 			return errors.Trace(errRegionNotFound)
 		}
+		if busy {
+			return errors.Trace(errServerBusy)
+		}
 		return errors.Trace(err)
 	}
 	respErr := resp.GetError()
+	release(respErr.GetServerIsBusy() != nil)
 	if respErr != nil {
 		log.Debug("ingest sst resp error", zap.Stringer("error", respErr))
+		if respErr.GetServerIsBusy() != nil {
+			return errors.Trace(errServerBusy)
+		}
 		if respErr.GetKeyNotInRegion() != nil {
 			return errors.Trace(errKeyNotInRegion)
 		}
@@ -338,6 +448,17 @@ func extractDownloadSSTError(e error) error {
 		err = errFileCorrupted
 	case strings.Contains(e.Error(), "Cannot read"):
 		err = errCannotRead
+	case isServerBusy(e.Error()):
+		err = errServerBusy
 	}
 	return errors.Trace(err)
 }
+
+// isServerBusy checks whether the error message means the store is busy or
+// in write stall.
+func isServerBusy(msg string) bool {
+	msg = strings.ToLower(msg)
+	return strings.Contains(msg, "server is busy") ||
+		strings.Contains(msg, "serverisbusy") ||
+		strings.Contains(msg, "write stall")
+}
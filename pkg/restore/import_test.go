@@ -0,0 +1,206 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+
+	"github.com/pingcap/br/pkg/conn"
+)
+
+var _ = Suite(&testImportSuite{})
+
+type testImportSuite struct{}
+
+type testImporterClient struct {
+	mu             sync.Mutex
+	downloadStores []uint64
+	// ingestBusy is the number of the ingest requests responded busy.
+	ingestBusy int
+	// downloadFailures is the number of the failed downloads of each store.
+	downloadFailures map[uint64]int
+	// downloadDelay makes the concurrent downloads overlap.
+	downloadDelay time.Duration
+	downloading   int
+	maxDownloads  int
+	// events are the successful downloads and ingests in order.
+	events []string
+}
+
+func (c *testImporterClient) DownloadSST(
+	ctx context.Context,
+	storeID uint64,
+	req *import_sstpb.DownloadRequest,
+) (*import_sstpb.DownloadResponse, error) {
+	c.mu.Lock()
+	c.downloadStores = append(c.downloadStores, storeID)
+	c.downloading++
+	if c.downloading > c.maxDownloads {
+		c.maxDownloads = c.downloading
+	}
+	c.mu.Unlock()
+
+	time.Sleep(c.downloadDelay)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.downloading--
+	if c.downloadFailures[storeID] > 0 {
+		c.downloadFailures[storeID]--
+		return nil, errors.New("rpc error: connection reset")
+	}
+	c.events = append(c.events, fmt.Sprintf("download %d", storeID))
+	return &import_sstpb.DownloadResponse{Range: *req.Sst.Range}, nil
+}
+
+func (c *testImporterClient) IngestSST(
+	ctx context.Context,
+	storeID uint64,
+	req *import_sstpb.IngestRequest,
+) (*import_sstpb.IngestResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ingestBusy > 0 {
+		c.ingestBusy--
+		return &import_sstpb.IngestResponse{Error: &errorpb.Error{ServerIsBusy: &errorpb.ServerIsBusy{}}}, nil
+	}
+	c.events = append(c.events, fmt.Sprintf("ingest %d", storeID))
+	return &import_sstpb.IngestResponse{}, nil
+}
+
+func (c *testImporterClient) SetDownloadSpeedLimit(
+	ctx context.Context,
+	storeID uint64,
+	req *import_sstpb.SetDownloadSpeedLimitRequest,
+) (*import_sstpb.SetDownloadSpeedLimitResponse, error) {
+	return &import_sstpb.SetDownloadSpeedLimitResponse{}, nil
+}
+
+func (s *testImportSuite) TestDownloadSSTSkipTiFlash(c *C) {
+	tiflashLabels := []*metapb.StoreLabel{{Key: conn.EngineLabelKey, Value: conn.EngineLabelTiFlash}}
+	stores := map[uint64]*metapb.Store{
+		1: {Id: 1},
+		2: {Id: 2},
+		3: {Id: 3, Labels: tiflashLabels},
+		4: {Id: 4, Labels: tiflashLabels},
+	}
+	metaClient := newTestClient(stores, map[uint64]*RegionInfo{}, 1)
+	importClient := &testImporterClient{}
+	importer := NewFileImporter(context.Background(), metaClient, importClient, nil, 0)
+
+	oldPrefix := append(tablecodec.EncodeTablePrefix(1), recordPrefixSep...)
+	newPrefix := append(tablecodec.EncodeTablePrefix(2), recordPrefixSep...)
+	rewriteRules := &RewriteRules{Data: []*import_sstpb.RewriteRule{{
+		OldKeyPrefix: oldPrefix,
+		NewKeyPrefix: newPrefix,
+	}}}
+	file := &backup.File{
+		Name:     "1_write.sst",
+		StartKey: append(append([]byte{}, oldPrefix...), 'a'),
+		EndKey:   append(append([]byte{}, oldPrefix...), 'z'),
+	}
+	region := &RegionInfo{Region: &metapb.Region{
+		Id:       1,
+		StartKey: codec.EncodeBytes(nil, newPrefix),
+		EndKey:   codec.EncodeBytes(nil, tablecodec.EncodeTablePrefix(3)),
+		Peers:    []*metapb.Peer{{StoreId: 1}, {StoreId: 3}, {StoreId: 2}},
+	}}
+	_, err := importer.downloadSST(region, file, rewriteRules)
+	c.Assert(err, IsNil)
+	sort.Slice(importClient.downloadStores, func(i, j int) bool {
+		return importClient.downloadStores[i] < importClient.downloadStores[j]
+	})
+	c.Assert(importClient.downloadStores, DeepEquals, []uint64{1, 2})
+
+	// A region must have a TiKV peer to download.
+	region.Region.Peers = []*metapb.Peer{{StoreId: 3}, {StoreId: 4}}
+	_, err = importer.downloadSST(region, file, rewriteRules)
+	c.Assert(err, ErrorMatches, "region 1 has no TiKV peer")
+}
+
+func (s *testImportSuite) TestIngestSSTServerBusy(c *C) {
+	stores := map[uint64]*metapb.Store{1: {Id: 1}}
+	metaClient := newTestClient(stores, map[uint64]*RegionInfo{}, 1)
+	importClient := &testImporterClient{ingestBusy: 1}
+	importer := NewFileImporter(context.Background(), metaClient, importClient, nil, 0)
+	importer.SetStoreConcurrency(2)
+
+	region := &RegionInfo{Region: &metapb.Region{Id: 1, Peers: []*metapb.Peer{{StoreId: 1}}}}
+	sstMeta := &import_sstpb.SSTMeta{}
+	err := importer.ingestSST(sstMeta, region)
+	c.Assert(errors.Cause(err), Equals, errServerBusy)
+	c.Assert(importer.GetStoreStates(), DeepEquals, []StoreState{{StoreID: 1, Limit: 1, Finished: 1, Busy: 1}})
+
+	// The store is backed off before the retry.
+	start := time.Now()
+	c.Assert(importer.ingestSST(sstMeta, region), IsNil)
+	c.Assert(time.Since(start) > storeBusyBackoff/2, IsTrue)
+
+	c.Assert(isServerBusy("rpc error: code = Unknown desc = Engine(\"write stall\")"), IsTrue)
+	c.Assert(errors.Cause(extractDownloadSSTError(errors.New("server is busy"))), Equals, errServerBusy)
+}
+
+func (s *testImportSuite) TestDownloadSSTToAllPeers(c *C) {
+	stores := map[uint64]*metapb.Store{1: {Id: 1}, 2: {Id: 2}, 3: {Id: 3}}
+	oldPrefix := append(tablecodec.EncodeTablePrefix(1), recordPrefixSep...)
+	newPrefix := append(tablecodec.EncodeTablePrefix(2), recordPrefixSep...)
+	region := &RegionInfo{
+		Region: &metapb.Region{
+			Id:       1,
+			StartKey: codec.EncodeBytes(nil, newPrefix),
+			EndKey:   codec.EncodeBytes(nil, tablecodec.EncodeTablePrefix(3)),
+			Peers:    []*metapb.Peer{{StoreId: 1}, {StoreId: 2}, {StoreId: 3}},
+		},
+		Leader: &metapb.Peer{StoreId: 1},
+	}
+	metaClient := newTestClient(stores, map[uint64]*RegionInfo{1: region}, 1)
+	rewriteRules := &RewriteRules{Data: []*import_sstpb.RewriteRule{{
+		OldKeyPrefix: oldPrefix,
+		NewKeyPrefix: newPrefix,
+	}}}
+	file := &backup.File{
+		Name:     "1_write.sst",
+		StartKey: append(append([]byte{}, oldPrefix...), 'a'),
+		EndKey:   append(append([]byte{}, oldPrefix...), 'z'),
+	}
+
+	// The download to store 2 fails twice, and it is retried on the store
+	// only.
+	importClient := &testImporterClient{
+		downloadFailures: map[uint64]int{2: 2},
+		downloadDelay:    20 * time.Millisecond,
+	}
+	importer := NewFileImporter(context.Background(), metaClient, importClient, nil, 0)
+	c.Assert(importer.Import(file, rewriteRules), IsNil)
+	c.Assert(importClient.maxDownloads, Equals, 3)
+	counts := make(map[uint64]int)
+	for _, storeID := range importClient.downloadStores {
+		counts[storeID]++
+	}
+	c.Assert(counts, DeepEquals, map[uint64]int{1: 1, 2: 3, 3: 1})
+	// The SST is ingested after every peer has downloaded it.
+	c.Assert(importClient.events, HasLen, 4)
+	c.Assert(importClient.events[3], Equals, "ingest 1")
+
+	// The region fails if a peer keeps failing.
+	importClient = &testImporterClient{downloadFailures: map[uint64]int{3: downloadSSTRetryTimes}}
+	importer = NewFileImporter(context.Background(), metaClient, importClient, nil, 0)
+	_, err := importer.downloadSST(region, file, rewriteRules)
+	c.Assert(errors.Cause(err), Equals, errGrpc)
+	counts = make(map[uint64]int)
+	for _, storeID := range importClient.downloadStores {
+		counts[storeID]++
+	}
+	c.Assert(counts, DeepEquals, map[uint64]int{1: 1, 2: 1, 3: downloadSSTRetryTimes})
+	c.Assert(importClient.events, DeepEquals, []string{"download 1", "download 2"})
+}
@@ -0,0 +1,122 @@
+package restore
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/tablecodec"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultMergeRegionSizeBytes is the default maximum size of the merged
+	// ranges, it is the default region split size of TiKV.
+	DefaultMergeRegionSizeBytes uint64 = 96 * 1024 * 1024
+	// DefaultMergeRegionKeyCount is the default maximum number of the keys of
+	// the merged ranges, it is the default region split keys of TiKV.
+	DefaultMergeRegionKeyCount uint64 = 960000
+)
+
+// MergeRangesStat is the statistics of merging the ranges of the files.
+type MergeRangesStat struct {
+	TotalFiles  int
+	TotalRanges int
+	// MergedRanges is the number of the ranges after merging.
+	MergedRanges int
+	// TotalBytes and TotalKvs are the size and the number of the keys of all
+	// the ranges.
+	TotalBytes uint64
+	TotalKvs   uint64
+}
+
+// fileRange is the range of the write and default CF files backed up
+// together.
+type fileRange struct {
+	Range
+	tableID int64
+	bytes   uint64
+	kvs     uint64
+	// hasWrite is true if the write CF file of the range exists, the range
+	// without it has nothing to restore.
+	hasWrite bool
+}
+
+// MergeFileRanges checks the ranges of the files like ValidateFileRanges,
+// and merges the adjacent ranges of the same table until the merged range
+// reaches splitSizeBytes or splitKeyCount, so that the small files don't
+// split the regions into pieces. The ranges of different tables or
+// partitions are never merged, as they are rewritten by different rules. A
+// zero threshold disables merging.
+func MergeFileRanges(
+	files []*backup.File,
+	rewriteRules *RewriteRules,
+	splitSizeBytes, splitKeyCount uint64,
+) ([]Range, *MergeRangesStat, error) {
+	stat := &MergeRangesStat{TotalFiles: len(files)}
+	rangeMap := make(map[string]*fileRange)
+	fileRanges := make([]*fileRange, 0, len(files))
+	for _, file := range files {
+		key := string(file.GetStartKey()) + "\x00" + string(file.GetEndKey())
+		rg, ok := rangeMap[key]
+		if !ok {
+			rg = &fileRange{
+				Range:   Range{StartKey: file.GetStartKey(), EndKey: file.GetEndKey()},
+				tableID: tablecodec.DecodeTableID(file.GetStartKey()),
+			}
+			rangeMap[key] = rg
+			fileRanges = append(fileRanges, rg)
+		}
+		rg.bytes += file.GetTotalBytes()
+		// We skips all default cf files because we don't range overlap.
+		if !strings.Contains(file.GetName(), "write") {
+			continue
+		}
+		if err := ValidateFileRewriteRule(file, rewriteRules); err != nil {
+			return nil, nil, err
+		}
+		endID := tablecodec.DecodeTableID(file.GetEndKey())
+		if rg.tableID != endID {
+			log.Error("table ids dont match",
+				zap.Int64("startID", rg.tableID),
+				zap.Int64("endID", endID),
+				zap.Stringer("file", file))
+			return nil, nil, errors.New("table ids dont match")
+		}
+		rg.kvs += file.GetTotalKvs()
+		rg.hasWrite = true
+	}
+
+	sort.Slice(fileRanges, func(i, j int) bool {
+		return bytes.Compare(fileRanges[i].StartKey, fileRanges[j].StartKey) < 0
+	})
+	ranges := make([]Range, 0, len(fileRanges))
+	var merged *fileRange
+	for _, rg := range fileRanges {
+		if !rg.hasWrite {
+			continue
+		}
+		stat.TotalRanges++
+		stat.TotalBytes += rg.bytes
+		stat.TotalKvs += rg.kvs
+		if merged != nil && merged.tableID == rg.tableID &&
+			merged.bytes+rg.bytes <= splitSizeBytes && merged.kvs+rg.kvs <= splitKeyCount {
+			merged.EndKey = rg.EndKey
+			merged.bytes += rg.bytes
+			merged.kvs += rg.kvs
+			continue
+		}
+		if merged != nil {
+			ranges = append(ranges, merged.Range)
+		}
+		merged = &fileRange{Range: rg.Range, tableID: rg.tableID, bytes: rg.bytes, kvs: rg.kvs}
+	}
+	if merged != nil {
+		ranges = append(ranges, merged.Range)
+	}
+	stat.MergedRanges = len(ranges)
+	return ranges, stat, nil
+}
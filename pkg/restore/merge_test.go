@@ -0,0 +1,111 @@
+package restore
+
+import (
+	"fmt"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/tidb/tablecodec"
+)
+
+type testMergeSuite struct{}
+
+var _ = Suite(&testMergeSuite{})
+
+// fileBuilder builds the write and default CF files of the adjacent record
+// ranges of tables.
+type fileBuilder struct {
+	index map[int64]int64
+}
+
+func (fb *fileBuilder) build(tableID int64, kvs, bytes uint64) []*backup.File {
+	if fb.index == nil {
+		fb.index = make(map[int64]int64)
+	}
+	i := fb.index[tableID]
+	fb.index[tableID]++
+	startKey := tablecodec.EncodeRowKeyWithHandle(tableID, i*10)
+	endKey := tablecodec.EncodeRowKeyWithHandle(tableID, (i+1)*10)
+	return []*backup.File{
+		{
+			Name:       fmt.Sprintf("%d_%d_write.sst", tableID, i),
+			StartKey:   startKey,
+			EndKey:     endKey,
+			TotalKvs:   kvs,
+			TotalBytes: bytes / 2,
+		},
+		{
+			Name:       fmt.Sprintf("%d_%d_default.sst", tableID, i),
+			StartKey:   startKey,
+			EndKey:     endKey,
+			TotalKvs:   kvs,
+			TotalBytes: bytes / 2,
+		},
+	}
+}
+
+func (s *testMergeSuite) TestMergeFileRanges(c *C) {
+	fb := &fileBuilder{}
+	files := make([]*backup.File, 0)
+	// Table 1 has 4 files of 10 bytes and 1 key.
+	for i := 0; i < 4; i++ {
+		files = append(files, fb.build(1, 1, 10)...)
+	}
+	// Table 2 has 3 files of 10 bytes and 2 keys.
+	for i := 0; i < 3; i++ {
+		files = append(files, fb.build(2, 2, 10)...)
+	}
+	rules := &RewriteRules{Table: []*import_sstpb.RewriteRule{
+		{OldKeyPrefix: tablecodec.EncodeTablePrefix(1), NewKeyPrefix: tablecodec.EncodeTablePrefix(11)},
+		{OldKeyPrefix: tablecodec.EncodeTablePrefix(2), NewKeyPrefix: tablecodec.EncodeTablePrefix(12)},
+	}}
+	rowKey := tablecodec.EncodeRowKeyWithHandle
+
+	// The ranges of different tables are never merged.
+	ranges, stat, err := MergeFileRanges(files, rules, 1000, 1000)
+	c.Assert(err, IsNil)
+	c.Assert(ranges, RangeEquals, []Range{
+		{StartKey: rowKey(1, 0), EndKey: rowKey(1, 40)},
+		{StartKey: rowKey(2, 0), EndKey: rowKey(2, 30)},
+	})
+	c.Assert(*stat, DeepEquals, MergeRangesStat{
+		TotalFiles:   14,
+		TotalRanges:  7,
+		MergedRanges: 2,
+		TotalBytes:   70,
+		TotalKvs:     10,
+	})
+
+	// The merged ranges are limited by the size.
+	ranges, _, err = MergeFileRanges(files, rules, 20, 1000)
+	c.Assert(err, IsNil)
+	c.Assert(ranges, RangeEquals, []Range{
+		{StartKey: rowKey(1, 0), EndKey: rowKey(1, 20)},
+		{StartKey: rowKey(1, 20), EndKey: rowKey(1, 40)},
+		{StartKey: rowKey(2, 0), EndKey: rowKey(2, 20)},
+		{StartKey: rowKey(2, 20), EndKey: rowKey(2, 30)},
+	})
+
+	// The merged ranges are limited by the key count.
+	ranges, _, err = MergeFileRanges(files, rules, 1000, 3)
+	c.Assert(err, IsNil)
+	c.Assert(ranges, RangeEquals, []Range{
+		{StartKey: rowKey(1, 0), EndKey: rowKey(1, 30)},
+		{StartKey: rowKey(1, 30), EndKey: rowKey(1, 40)},
+		{StartKey: rowKey(2, 0), EndKey: rowKey(2, 10)},
+		{StartKey: rowKey(2, 10), EndKey: rowKey(2, 20)},
+		{StartKey: rowKey(2, 20), EndKey: rowKey(2, 30)},
+	})
+
+	// Zero thresholds disable merging.
+	ranges, stat, err = MergeFileRanges(files, rules, 0, 0)
+	c.Assert(err, IsNil)
+	c.Assert(ranges, HasLen, 7)
+	c.Assert(stat.MergedRanges, Equals, 7)
+
+	// The files are validated by the rewrite rules.
+	files = append(files, fb.build(3, 1, 10)...)
+	_, _, err = MergeFileRanges(files, rules, 1000, 1000)
+	c.Assert(err, ErrorMatches, ".*cannot find rewrite rule.*")
+}
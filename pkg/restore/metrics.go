@@ -0,0 +1,37 @@
+package restore
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	restoreStoreConcurrencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "br",
+			Subsystem: "restore",
+			Name:      "store_concurrency",
+			Help:      "The number of the concurrent requests allowed on each store.",
+		}, []string{"store"})
+
+	restoreStoreRunningGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "br",
+			Subsystem: "restore",
+			Name:      "store_running",
+			Help:      "The number of the running requests on each store.",
+		}, []string{"store"})
+
+	restoreStoreBusyCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "br",
+			Subsystem: "restore",
+			Name:      "store_busy",
+			Help:      "The number of the times each store reported busy.",
+		}, []string{"store"})
+)
+
+func init() {
+	prometheus.MustRegister(restoreStoreConcurrencyGauge)
+	prometheus.MustRegister(restoreStoreRunningGauge)
+	prometheus.MustRegister(restoreStoreBusyCounter)
+}
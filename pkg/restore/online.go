@@ -0,0 +1,278 @@
+package restore
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/pd/server/schedule/placement"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/conn"
+)
+
+const (
+	// restoreLabelKey and restoreLabelValue label the stores which the
+	// restored regions are placed on in online restore.
+	restoreLabelKey   = "exclusive"
+	restoreLabelValue = "restore"
+	// resetLabelValue replaces the restore label after online restore. PD
+	// can't remove a label of a store and rejects an empty label value, so
+	// the label is set to a value matched by no placement rule instead.
+	resetLabelValue = "none"
+
+	// The rules of the restored tables override the default rule of PD, so
+	// they must be in the same group with a greater index.
+	placementRuleGroupID = "pd"
+	defaultRuleID        = "default"
+	restoreRuleIndex     = 100
+
+	waitPlacementScheduleInterval = 10 * time.Second
+
+	// DefaultPlacementWaitTimeout is the default maximum time waiting for the
+	// regions to be placed on the restore stores in online restore.
+	DefaultPlacementWaitTimeout = 30 * time.Minute
+)
+
+// SetRestoreStores sets the stores which the restored regions are placed on
+// in online restore. If it is not set, the stores are chosen by the replica
+// count of the default placement rule.
+func (rc *Client) SetRestoreStores(stores []uint64) {
+	rc.restoreStores = stores
+}
+
+// SetPlacementWaitTimeout sets the maximum time waiting for the regions to be
+// placed on the restore stores in online restore.
+func (rc *Client) SetPlacementWaitTimeout(timeout time.Duration) {
+	rc.placementWaitTimeout = timeout
+}
+
+// GetRestoreStores returns the stores which the restored regions are placed
+// on in online restore.
+func (rc *Client) GetRestoreStores() []uint64 {
+	return rc.restoreStores
+}
+
+// SetupPlacementRules labels the restore stores, and sets the placement rules
+// which keep the regions of the tables on these stores, so that the ingestion
+// doesn't affect the other stores in online restore.
+func (rc *Client) SetupPlacementRules(ctx context.Context, tables []*model.TableInfo) error {
+	if !rc.isOnline {
+		return nil
+	}
+	rule, err := rc.toolClient.GetPlacementRule(ctx, placementRuleGroupID, defaultRuleID)
+	if err != nil {
+		return errors.Annotate(err, "placement rules must be enabled for online restore")
+	}
+	if len(rc.restoreStores) == 0 {
+		rc.restoreStores, err = rc.chooseRestoreStores(ctx, rule.Count)
+		if err != nil {
+			return err
+		}
+	}
+	if len(rc.restoreStores) < rule.Count {
+		return errors.Errorf("online restore needs at least %d stores, got %v", rule.Count, rc.restoreStores)
+	}
+	log.Info("setup placement rules for online restore",
+		zap.Uint64s("stores", rc.restoreStores), zap.Int("tables", len(tables)))
+	rc.restoreStoreLabels = make(map[uint64]string, len(rc.restoreStores))
+	for _, id := range rc.restoreStores {
+		store, err := rc.toolClient.GetStore(ctx, id)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, label := range store.GetLabels() {
+			if label.GetKey() == restoreLabelKey {
+				rc.restoreStoreLabels[id] = label.GetValue()
+			}
+		}
+	}
+	err = rc.toolClient.SetStoresLabel(ctx, rc.restoreStores, restoreLabelKey, restoreLabelValue)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	rule.Index = restoreRuleIndex
+	rule.Override = true
+	rule.LabelConstraints = append(rule.LabelConstraints, placement.LabelConstraint{
+		Key:    restoreLabelKey,
+		Op:     placement.In,
+		Values: []string{restoreLabelValue},
+	})
+	for _, id := range getPhysicalIDs(tables) {
+		rule.ID = getRuleID(id)
+		startKey, endKey := getTableRange(id)
+		rule.StartKeyHex = hex.EncodeToString(startKey)
+		rule.EndKeyHex = hex.EncodeToString(endKey)
+		if err = rc.toolClient.SetPlacementRule(ctx, rule); err != nil {
+			log.Error("set placement rule failed", zap.String("rule", rule.ID), zap.Error(err))
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// WaitPlacementSchedule waits until all regions of the tables are placed on
+// the restore stores. It fails after the placement wait timeout, e.g. if PD
+// can't place the replicas since some restore stores are down.
+func (rc *Client) WaitPlacementSchedule(ctx context.Context, tables []*model.TableInfo) error {
+	if !rc.isOnline {
+		return nil
+	}
+	log.Info("wait for regions to be placed on the restore stores",
+		zap.Duration("timeout", rc.placementWaitTimeout))
+	ticker := time.NewTicker(waitPlacementScheduleInterval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(rc.placementWaitTimeout)
+	defer timeout.Stop()
+	for {
+		progress, err := rc.checkRegionsPlaced(ctx, tables)
+		if err != nil {
+			return err
+		}
+		if len(progress) == 0 {
+			log.Info("all regions are placed on the restore stores")
+			return nil
+		}
+		log.Info("regions are not placed on the restore stores yet", zap.Strings("progress", progress))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return errors.Errorf("regions are not placed on the restore stores %v after %s: %s",
+				rc.restoreStores, rc.placementWaitTimeout, strings.Join(progress, ", "))
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkRegionsPlaced returns the progress of the tables whose regions are
+// not all placed on the restore stores.
+func (rc *Client) checkRegionsPlaced(ctx context.Context, tables []*model.TableInfo) ([]string, error) {
+	restoreStores := make(map[uint64]bool, len(rc.restoreStores))
+	for _, id := range rc.restoreStores {
+		restoreStores[id] = true
+	}
+	var progress []string
+	for _, id := range getPhysicalIDs(tables) {
+		startKey, endKey := getTableRange(id)
+		regions, err := rc.toolClient.ScanRegions(ctx, startKey, endKey, 0)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		placed := 0
+		for _, region := range regions {
+			ok := true
+			for _, peer := range region.Region.GetPeers() {
+				ok = ok && restoreStores[peer.GetStoreId()]
+			}
+			if ok {
+				placed++
+			}
+		}
+		if placed < len(regions) {
+			progress = append(progress, fmt.Sprintf("table %d: %d/%d", id, placed, len(regions)))
+		}
+	}
+	return progress, nil
+}
+
+// ResetPlacementRules removes the placement rules of the tables, so that PD
+// rebalances their regions to the whole cluster.
+func (rc *Client) ResetPlacementRules(ctx context.Context, tables []*model.TableInfo) error {
+	if !rc.isOnline {
+		return nil
+	}
+	log.Info("reset placement rules for online restore", zap.Int("tables", len(tables)))
+	var failed []int64
+	for _, id := range getPhysicalIDs(tables) {
+		err := rc.toolClient.DeletePlacementRule(ctx, placementRuleGroupID, getRuleID(id))
+		if err != nil {
+			log.Error("delete placement rule failed", zap.String("rule", getRuleID(id)), zap.Error(err))
+			failed = append(failed, id)
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("failed to delete placement rules of tables %v", failed)
+	}
+	return nil
+}
+
+// ResetRestoreLabels restores the labels of the restore stores to their
+// values before online restore. The labels which didn't exist are set to
+// resetLabelValue.
+func (rc *Client) ResetRestoreLabels(ctx context.Context) error {
+	if !rc.isOnline || rc.restoreStoreLabels == nil {
+		return nil
+	}
+	log.Info("reset labels of the restore stores", zap.Uint64s("stores", rc.restoreStores))
+	stores := make(map[string][]uint64)
+	for _, id := range rc.restoreStores {
+		value, ok := rc.restoreStoreLabels[id]
+		if !ok || value == restoreLabelValue {
+			value = resetLabelValue
+		}
+		stores[value] = append(stores[value], id)
+	}
+	for value, ids := range stores {
+		if err := rc.toolClient.SetStoresLabel(ctx, ids, restoreLabelKey, value); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// chooseRestoreStores chooses the up TiKV stores with the least IDs.
+func (rc *Client) chooseRestoreStores(ctx context.Context, count int) ([]uint64, error) {
+	stores, err := conn.GetAllTiKVStores(ctx, rc.pdClient)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint64, 0, len(stores))
+	for _, store := range stores {
+		if store.GetState() == metapb.StoreState_Up {
+			ids = append(ids, store.GetId())
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > count {
+		ids = ids[:count]
+	}
+	return ids, nil
+}
+
+func getRuleID(tableID int64) string {
+	return fmt.Sprintf("restore-t%d", tableID)
+}
+
+// getTableRange returns the encoded key range of a physical table.
+func getTableRange(tableID int64) (startKey, endKey []byte) {
+	startKey = codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(tableID))
+	endKey = codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(tableID+1))
+	return
+}
+
+// getPhysicalIDs returns the IDs of the tables, or their partitions if they
+// are partitioned.
+func getPhysicalIDs(tables []*model.TableInfo) []int64 {
+	ids := make([]int64, 0, len(tables))
+	for _, table := range tables {
+		if table.Partition == nil {
+			ids = append(ids, table.ID)
+			continue
+		}
+		for _, def := range table.Partition.Definitions {
+			ids = append(ids, def.ID)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,181 @@
+package restore
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/parser/model"
+	pd "github.com/pingcap/pd/client"
+	"github.com/pingcap/pd/server/schedule/placement"
+)
+
+var _ = Suite(&testOnlineRestoreSuite{})
+
+type testOnlineRestoreSuite struct{}
+
+func newRegionOnStores(id uint64, tableID int64, stores ...uint64) *RegionInfo {
+	startKey, endKey := getTableRange(tableID)
+	peers := make([]*metapb.Peer, 0, len(stores))
+	for _, storeID := range stores {
+		peers = append(peers, &metapb.Peer{StoreId: storeID})
+	}
+	return &RegionInfo{Region: &metapb.Region{
+		Id:       id,
+		StartKey: startKey,
+		EndKey:   endKey,
+		Peers:    peers,
+	}}
+}
+
+func (s *testOnlineRestoreSuite) TestPlacementRules(c *C) {
+	stores := make(map[uint64]*metapb.Store)
+	for i := uint64(1); i <= 5; i++ {
+		stores[i] = &metapb.Store{Id: i, Labels: []*metapb.StoreLabel{{Key: "zone", Value: "z1"}}}
+	}
+	// The label of store 2 is restored after online restore.
+	stores[2].Labels = append(stores[2].Labels, &metapb.StoreLabel{Key: restoreLabelKey, Value: "other"})
+	regions := map[uint64]*RegionInfo{
+		1: newRegionOnStores(1, 10, 1, 2, 3),
+		2: newRegionOnStores(2, 12, 1, 2, 4),
+	}
+	toolClient := newTestClient(stores, regions, 3)
+	defaultRule := placement.Rule{
+		GroupID: placementRuleGroupID,
+		ID:      defaultRuleID,
+		Role:    placement.Voter,
+		Count:   3,
+	}
+	c.Assert(toolClient.SetPlacementRule(context.Background(), defaultRule), IsNil)
+	tables := []*model.TableInfo{
+		{ID: 10},
+		{ID: 11, Partition: &model.PartitionInfo{
+			Definitions: []model.PartitionDefinition{{ID: 12}, {ID: 13}},
+		}},
+	}
+	ctx := context.Background()
+	client := Client{toolClient: toolClient}
+
+	// Nothing is done in offline restore.
+	c.Assert(client.SetupPlacementRules(ctx, tables), IsNil)
+	c.Assert(toolClient.rules, HasLen, 1)
+
+	client.EnableOnline()
+	client.SetRestoreStores([]uint64{1})
+	err := client.SetupPlacementRules(ctx, tables)
+	c.Assert(err, ErrorMatches, "online restore needs at least 3 stores.*")
+
+	client.SetRestoreStores([]uint64{1, 2, 3})
+	c.Assert(client.SetupPlacementRules(ctx, tables), IsNil)
+	c.Assert(toolClient.rules, HasLen, 4)
+	for _, id := range []int64{10, 12, 13} {
+		rule, err := toolClient.GetPlacementRule(ctx, placementRuleGroupID, getRuleID(id))
+		c.Assert(err, IsNil)
+		c.Assert(rule.Override, IsTrue)
+		c.Assert(rule.Index, Greater, defaultRule.Index)
+		c.Assert(rule.Count, Equals, defaultRule.Count)
+		c.Assert(rule.LabelConstraints, DeepEquals, []placement.LabelConstraint{{
+			Key:    restoreLabelKey,
+			Op:     placement.In,
+			Values: []string{restoreLabelValue},
+		}})
+		startKey, endKey := getTableRange(id)
+		c.Assert(rule.StartKeyHex, Equals, hex.EncodeToString(startKey))
+		c.Assert(rule.EndKeyHex, Equals, hex.EncodeToString(endKey))
+	}
+	for id, store := range stores {
+		labeled := false
+		for _, label := range store.Labels {
+			labeled = labeled || (label.Key == restoreLabelKey && label.Value == restoreLabelValue)
+		}
+		c.Assert(labeled, Equals, id <= 3, Commentf("store %d", id))
+	}
+
+	// The region of partition 12 has a peer on store 4.
+	progress, err := client.checkRegionsPlaced(ctx, tables)
+	c.Assert(err, IsNil)
+	c.Assert(progress, DeepEquals, []string{"table 12: 0/1"})
+	client.SetPlacementWaitTimeout(10 * time.Millisecond)
+	err = client.WaitPlacementSchedule(ctx, tables)
+	c.Assert(err, ErrorMatches, `regions are not placed on the restore stores \[1 2 3\] after 10ms: table 12: 0/1`)
+	regions[2].Region.Peers[2].StoreId = 3
+	c.Assert(client.WaitPlacementSchedule(ctx, tables), IsNil)
+
+	c.Assert(client.ResetPlacementRules(ctx, tables), IsNil)
+	c.Assert(client.ResetRestoreLabels(ctx), IsNil)
+	c.Assert(toolClient.rules, HasLen, 1)
+	_, err = toolClient.GetPlacementRule(ctx, placementRuleGroupID, defaultRuleID)
+	c.Assert(err, IsNil)
+	zone := &metapb.StoreLabel{Key: "zone", Value: "z1"}
+	for id, store := range stores {
+		switch id {
+		case 1, 3:
+			c.Assert(store.Labels, DeepEquals, []*metapb.StoreLabel{zone, {Key: restoreLabelKey, Value: resetLabelValue}})
+		case 2:
+			c.Assert(store.Labels, DeepEquals, []*metapb.StoreLabel{zone, {Key: restoreLabelKey, Value: "other"}})
+		default:
+			c.Assert(store.Labels, DeepEquals, []*metapb.StoreLabel{zone})
+		}
+	}
+
+	// Placement rules must be enabled.
+	c.Assert(toolClient.DeletePlacementRule(ctx, placementRuleGroupID, defaultRuleID), IsNil)
+	err = client.SetupPlacementRules(ctx, tables)
+	c.Assert(err, ErrorMatches, "placement rules must be enabled for online restore.*")
+}
+
+// leaderClient is a PD client which only returns the address of the leader.
+type leaderClient struct {
+	pd.Client
+	addr string
+}
+
+func (c leaderClient) GetLeaderAddr() string {
+	return c.addr
+}
+
+func (s *testOnlineRestoreSuite) TestSetStoresLabelAPI(c *C) {
+	// PD only accepts setting the labels of a store by
+	// `POST /pd/api/v1/store/{id}/label`, with the labels in a JSON object.
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var labels map[string]string
+		if r.Method != http.MethodPost || !regexp.MustCompile(`^/pd/api/v1/store/\d+/label$`).MatchString(r.URL.Path) {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&labels); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for key, value := range labels {
+			if !pdLabelPattern.MatchString(key) || !pdLabelPattern.MatchString(value) {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "invalid label: %s", value)
+				return
+			}
+			requests = append(requests, fmt.Sprintf("%s %s=%s", r.URL.Path, key, value))
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewSplitClient(leaderClient{addr: server.URL})
+	ctx := context.Background()
+	c.Assert(client.SetStoresLabel(ctx, []uint64{1, 2}, restoreLabelKey, restoreLabelValue), IsNil)
+	c.Assert(client.SetStoresLabel(ctx, []uint64{1}, restoreLabelKey, resetLabelValue), IsNil)
+	c.Assert(requests, DeepEquals, []string{
+		"/pd/api/v1/store/1/label exclusive=restore",
+		"/pd/api/v1/store/2/label exclusive=restore",
+		"/pd/api/v1/store/1/label exclusive=none",
+	})
+	err := client.SetStoresLabel(ctx, []uint64{1}, restoreLabelKey, "")
+	c.Assert(err, ErrorMatches, `failed to set label of store 1: \[400\] invalid label:`)
+}
@@ -3,16 +3,22 @@ package restore
 import (
 	"bytes"
 	"context"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/kvproto/pkg/pdpb"
 	"github.com/pingcap/log"
 	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/util/codec"
 	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/utils"
 )
 
 // Constants for split retry machinery.
@@ -30,18 +36,48 @@ const (
 	ScatterMaxWaitInterval   = time.Second
 
 	ScatterWaitUpperInterval = 180 * time.Second
+
+	// DefaultSplitConcurrency is the default number of the regions split and
+	// scattered concurrently.
+	DefaultSplitConcurrency = 16
 )
 
 // RegionSplitter is a executor of region split by rules.
 type RegionSplitter struct {
 	client SplitClient
+	// concurrency is the number of the regions split and scattered
+	// concurrently.
+	concurrency uint
+	// scatterWaitTimeout is the maximum time waiting for the regions to be
+	// scattered.
+	scatterWaitTimeout time.Duration
 }
 
 // NewRegionSplitter returns a new RegionSplitter.
 func NewRegionSplitter(client SplitClient) *RegionSplitter {
 	return &RegionSplitter{
-		client: client,
+		client:             client,
+		concurrency:        DefaultSplitConcurrency,
+		scatterWaitTimeout: ScatterWaitUpperInterval,
+	}
+}
+
+// SetConcurrency sets the number of the regions split and scattered
+// concurrently.
+func (rs *RegionSplitter) SetConcurrency(concurrency uint) {
+	if concurrency == 0 {
+		concurrency = DefaultSplitConcurrency
 	}
+	rs.concurrency = concurrency
+}
+
+// SetScatterWaitTimeout sets the maximum time waiting for the regions to be
+// scattered, restore goes on without waiting for the rest after the timeout.
+func (rs *RegionSplitter) SetScatterWaitTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = ScatterWaitUpperInterval
+	}
+	rs.scatterWaitTimeout = timeout
 }
 
 // OnSplitFunc is called before split a range.
@@ -87,7 +123,6 @@ func (rs *RegionSplitter) Split(
 	}
 	interval := SplitRetryInterval
 	scatterRegions := make([]*RegionInfo, 0)
-SplitRegions:
 	for i := 0; i < SplitRetryTimes; i++ {
 		var regions []*RegionInfo
 		regions, err = rs.client.ScanRegions(ctx, minKey, maxKey, 0)
@@ -98,40 +133,29 @@ SplitRegions:
 			log.Warn("cannot scan any region")
 			return nil
 		}
+		// The keys split in the previous rounds are the boundaries of the
+		// regions now, so only the keys of the failed regions are left.
 		splitKeyMap := getSplitKeys(rewriteRules, sortedRanges, regions)
-		regionMap := make(map[uint64]*RegionInfo)
-		for _, region := range regions {
-			regionMap[region.Region.GetId()] = region
+		if len(splitKeyMap) == 0 {
+			break
 		}
-		for regionID, keys := range splitKeyMap {
-			var newRegions []*RegionInfo
-			region := regionMap[regionID]
-			newRegions, err = rs.splitAndScatterRegions(ctx, region, keys)
-			if err != nil {
-				if strings.Contains(err.Error(), "no valid key") {
-					for _, key := range keys {
-						log.Error("no valid key",
-							zap.Binary("startKey", region.Region.StartKey),
-							zap.Binary("endKey", region.Region.EndKey),
-							zap.Binary("key", codec.EncodeBytes([]byte{}, key)))
-					}
-					return errors.Trace(err)
-				}
-				interval = 2 * interval
-				if interval > SplitMaxRetryInterval {
-					interval = SplitMaxRetryInterval
-				}
-				time.Sleep(interval)
-				if i > 3 {
-					log.Warn("splitting regions failed, retry it", zap.Error(err), zap.ByteStrings("keys", keys))
-				}
-				continue SplitRegions
-			}
-			log.Debug("split regions", zap.Stringer("region", region.Region), zap.ByteStrings("keys", keys))
-			scatterRegions = append(scatterRegions, newRegions...)
-			onSplit(keys)
+		var newRegions []*RegionInfo
+		newRegions, err = rs.splitRegions(ctx, regions, splitKeyMap, onSplit)
+		scatterRegions = append(scatterRegions, newRegions...)
+		if err == nil {
+			break
+		}
+		if strings.Contains(err.Error(), "no valid key") || ctx.Err() != nil {
+			return errors.Trace(err)
+		}
+		interval = 2 * interval
+		if interval > SplitMaxRetryInterval {
+			interval = SplitMaxRetryInterval
+		}
+		time.Sleep(interval)
+		if i > 3 {
+			log.Warn("splitting regions failed, retry it", zap.Error(err))
 		}
-		break
 	}
 	if err != nil {
 		return errors.Trace(err)
@@ -139,14 +163,7 @@ SplitRegions:
 	log.Info("splitting regions done, wait for scattering regions",
 		zap.Int("regions", len(scatterRegions)), zap.Duration("take", time.Since(startTime)))
 	startTime = time.Now()
-	scatterCount := 0
-	for _, region := range scatterRegions {
-		rs.waitForScatterRegion(ctx, region)
-		if time.Since(startTime) > ScatterWaitUpperInterval {
-			break
-		}
-		scatterCount++
-	}
+	scatterCount := rs.waitForScatterRegions(ctx, scatterRegions)
 	if scatterCount == len(scatterRegions) {
 		log.Info("waiting for scattering regions done",
 			zap.Int("regions", len(scatterRegions)), zap.Duration("take", time.Since(startTime)))
@@ -159,6 +176,83 @@ SplitRegions:
 	return nil
 }
 
+// splitRegions splits and scatters the regions by the keys concurrently. It
+// returns the new regions of the succeeded ones and the first error, the
+// failed regions are left to the next round.
+func (rs *RegionSplitter) splitRegions(
+	ctx context.Context,
+	regions []*RegionInfo,
+	splitKeyMap map[uint64][][]byte,
+	onSplit OnSplitFunc,
+) ([]*RegionInfo, error) {
+	regionMap := make(map[uint64]*RegionInfo)
+	for _, region := range regions {
+		regionMap[region.Region.GetId()] = region
+	}
+	var (
+		mu         sync.Mutex
+		newRegions = make([]*RegionInfo, 0)
+		firstErr   error
+	)
+	pool := utils.NewWorkerPool(rs.concurrency, "split regions")
+	wg := new(sync.WaitGroup)
+	for regionID, keys := range splitKeyMap {
+		region, splitKeys := regionMap[regionID], keys
+		wg.Add(1)
+		pool.Apply(func() {
+			defer wg.Done()
+			regions, err := rs.splitAndScatterRegions(ctx, region, splitKeys)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if strings.Contains(err.Error(), "no valid key") {
+					for _, key := range splitKeys {
+						log.Error("no valid key",
+							zap.Binary("startKey", region.Region.StartKey),
+							zap.Binary("endKey", region.Region.EndKey),
+							zap.Binary("key", codec.EncodeBytes([]byte{}, key)))
+					}
+				}
+				log.Debug("split region failed", zap.Stringer("region", region.Region),
+					zap.ByteStrings("keys", splitKeys), zap.Error(err))
+				// Keep the fatal error rather than the retryable ones.
+				if firstErr == nil || !strings.Contains(firstErr.Error(), "no valid key") {
+					firstErr = err
+				}
+				return
+			}
+			log.Debug("split regions", zap.Stringer("region", region.Region), zap.ByteStrings("keys", splitKeys))
+			newRegions = append(newRegions, regions...)
+			onSplit(splitKeys)
+		})
+	}
+	wg.Wait()
+	return newRegions, firstErr
+}
+
+// waitForScatterRegions waits for the regions to be scattered concurrently
+// until the scatter wait timeout, and returns the number of the regions
+// waited.
+func (rs *RegionSplitter) waitForScatterRegions(ctx context.Context, regions []*RegionInfo) int {
+	ctx, cancel := context.WithTimeout(ctx, rs.scatterWaitTimeout)
+	defer cancel()
+	var scatterCount int32
+	pool := utils.NewWorkerPool(rs.concurrency, "wait for scatter")
+	wg := new(sync.WaitGroup)
+	for _, r := range regions {
+		region := r
+		wg.Add(1)
+		pool.Apply(func() {
+			defer wg.Done()
+			if rs.waitForScatterRegion(ctx, region) {
+				atomic.AddInt32(&scatterCount, 1)
+			}
+		})
+	}
+	wg.Wait()
+	return int(scatterCount)
+}
+
 func (rs *RegionSplitter) hasRegion(ctx context.Context, regionID uint64) (bool, error) {
 	regionInfo, err := rs.client.GetRegionByID(ctx, regionID)
 	if err != nil {
@@ -212,16 +306,24 @@ type retryTimeKey struct{}
 
 var retryTimes = new(retryTimeKey)
 
-func (rs *RegionSplitter) waitForScatterRegion(ctx context.Context, regionInfo *RegionInfo) {
+// waitForScatterRegion waits for the region to be scattered, it returns false
+// if the context is done before that.
+func (rs *RegionSplitter) waitForScatterRegion(ctx context.Context, regionInfo *RegionInfo) bool {
 	interval := ScatterWaitInterval
 	regionID := regionInfo.Region.GetId()
 	for i := 0; i < ScatterWaitMaxRetryTimes; i++ {
+		if ctx.Err() != nil {
+			return false
+		}
 		ctx1 := context.WithValue(ctx, retryTimes, i)
 		ok, err := rs.isScatterRegionFinished(ctx1, regionID)
 		if err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
 			log.Warn("scatter region failed: do not have the region",
 				zap.Stringer("region", regionInfo.Region))
-			return
+			return true
 		}
 		if ok {
 			break
@@ -230,8 +332,13 @@ func (rs *RegionSplitter) waitForScatterRegion(ctx context.Context, regionInfo *
 		if interval > ScatterMaxWaitInterval {
 			interval = ScatterMaxWaitInterval
 		}
-		time.Sleep(interval)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(interval):
+		}
 	}
+	return true
 }
 
 func (rs *RegionSplitter) splitAndScatterRegions(
@@ -281,6 +388,31 @@ func getSplitKeys(rewriteRules *RewriteRules, ranges []Range, regions []*RegionI
 	return splitKeyMap
 }
 
+// PlanSplitKeys returns the keys which the ranges rewritten by the rules are
+// split at, assuming that the whole key space is a single region. They are
+// the most keys Split may split at, and are sorted and deduplicated.
+func PlanSplitKeys(ranges []Range, rewriteRules *RewriteRules) ([][]byte, error) {
+	if len(ranges) == 0 {
+		return nil, nil
+	}
+	sortedRanges, err := sortRanges(ranges, rewriteRules)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	region := &RegionInfo{Region: &metapb.Region{}}
+	keys := getSplitKeys(rewriteRules, sortedRanges, []*RegionInfo{region})[region.Region.GetId()]
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	splitKeys := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		if len(splitKeys) == 0 || !bytes.Equal(splitKeys[len(splitKeys)-1], key) {
+			splitKeys = append(splitKeys, key)
+		}
+	}
+	return splitKeys, nil
+}
+
 // needSplit checks whether a key is necessary to split, if true returns the split region
 func needSplit(splitKey []byte, regions []*RegionInfo) *RegionInfo {
 	// If splitKey is the max key.
@@ -49,8 +49,7 @@ type SplitClient interface {
 	SetPlacementRule(ctx context.Context, rule placement.Rule) error
 	// DeletePlacementRule removes a placement rule from PD.
 	DeletePlacementRule(ctx context.Context, groupID, ruleID string) error
-	// SetStoreLabel add or update specified label of stores. If labelValue
-	// is empty, it clears the label.
+	// SetStoreLabel add or update specified label of stores.
 	SetStoresLabel(ctx context.Context, stores []uint64, labelKey, labelValue string) error
 }
 
@@ -336,14 +335,27 @@ func (c *pdClient) SetStoresLabel(
 		if err != nil {
 			return errors.WithStack(err)
 		}
-		err = res.Body.Close()
-		if err != nil {
-			return errors.Trace(err)
+		if err = checkResponse(res); err != nil {
+			return errors.Annotatef(err, "failed to set label of store %d", id)
 		}
 	}
 	return nil
 }
 
+// checkResponse closes the body of the response, and returns the error
+// responded by PD if the status isn't OK.
+func checkResponse(res *http.Response) error {
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Errorf("[%d] %s", res.StatusCode, strings.TrimSpace(string(body)))
+}
+
 func (c *pdClient) getPDAPIAddr() string {
 	addr := c.client.GetLeaderAddr()
 	if addr != "" && !strings.HasPrefix(addr, "http") {
@@ -3,7 +3,11 @@ package restore
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"regexp"
 	"sync"
+	"testing"
+	"time"
 
 	. "github.com/pingcap/check"
 	"github.com/pingcap/errors"
@@ -14,11 +18,25 @@ import (
 	"github.com/pingcap/tidb/util/codec"
 )
 
+// pdLabelPattern is the pattern of the valid label keys and values in PD.
+var pdLabelPattern = regexp.MustCompile("^[A-Za-z0-9]([-A-Za-z0-9_./]*[A-Za-z0-9])?$")
+
 type testClient struct {
 	mu           sync.RWMutex
 	stores       map[uint64]*metapb.Store
 	regions      map[uint64]*RegionInfo
 	nextRegionID uint64
+	rules        map[[2]string]placement.Rule
+
+	// delay is the latency of the split and scatter requests.
+	delay time.Duration
+	// splitFailures is the number of the failed splits of each region.
+	splitFailures map[uint64]int
+	// splitCalls is the number of the splits of each region.
+	splitCalls   map[uint64]int
+	splitting    int
+	maxSplitting int
+	scattering   bool
 }
 
 func newTestClient(stores map[uint64]*metapb.Store, regions map[uint64]*RegionInfo, nextRegionID uint64) *testClient {
@@ -26,6 +44,8 @@ func newTestClient(stores map[uint64]*metapb.Store, regions map[uint64]*RegionIn
 		stores:       stores,
 		regions:      regions,
 		nextRegionID: nextRegionID,
+		rules:        make(map[[2]string]placement.Rule),
+		splitCalls:   make(map[uint64]int),
 	}
 }
 
@@ -99,8 +119,22 @@ func (c *testClient) SplitRegion(ctx context.Context, regionInfo *RegionInfo, ke
 func (c *testClient) BatchSplitRegions(
 	ctx context.Context, regionInfo *RegionInfo, keys [][]byte,
 ) ([]*RegionInfo, error) {
+	c.mu.Lock()
+	c.splitCalls[regionInfo.Region.GetId()]++
+	c.splitting++
+	if c.splitting > c.maxSplitting {
+		c.maxSplitting = c.splitting
+	}
+	c.mu.Unlock()
+
+	time.Sleep(c.delay)
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.splitting--
+	if c.splitFailures[regionInfo.Region.GetId()] > 0 {
+		c.splitFailures[regionInfo.Region.GetId()]--
+		return nil, errors.New("epoch not match")
+	}
 	newRegions := make([]*RegionInfo, 0)
 	for _, key := range keys {
 		var target *RegionInfo
@@ -132,16 +166,27 @@ func (c *testClient) BatchSplitRegions(
 }
 
 func (c *testClient) ScatterRegion(ctx context.Context, regionInfo *RegionInfo) error {
+	time.Sleep(c.delay)
 	return nil
 }
 
 func (c *testClient) GetOperator(ctx context.Context, regionID uint64) (*pdpb.GetOperatorResponse, error) {
+	time.Sleep(c.delay)
+	if c.scattering {
+		return &pdpb.GetOperatorResponse{
+			Header: new(pdpb.ResponseHeader),
+			Desc:   []byte("scatter-region"),
+			Status: pdpb.OperatorStatus_RUNNING,
+		}, nil
+	}
 	return &pdpb.GetOperatorResponse{
 		Header: new(pdpb.ResponseHeader),
 	}, nil
 }
 
 func (c *testClient) ScanRegions(ctx context.Context, key, endKey []byte, limit int) ([]*RegionInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	regions := make([]*RegionInfo, 0)
 	for _, region := range c.regions {
 		if limit > 0 && len(regions) >= limit {
@@ -156,19 +201,51 @@ func (c *testClient) ScanRegions(ctx context.Context, key, endKey []byte, limit
 	return regions, nil
 }
 
-func (c *testClient) GetPlacementRule(ctx context.Context, groupID, ruleID string) (r placement.Rule, err error) {
-	return
+func (c *testClient) GetPlacementRule(ctx context.Context, groupID, ruleID string) (placement.Rule, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rule, ok := c.rules[[2]string{groupID, ruleID}]
+	if !ok {
+		return rule, errors.Errorf("rule not found: %s/%s", groupID, ruleID)
+	}
+	return rule, nil
 }
 
 func (c *testClient) SetPlacementRule(ctx context.Context, rule placement.Rule) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rule.LabelConstraints = append([]placement.LabelConstraint{}, rule.LabelConstraints...)
+	c.rules[rule.Key()] = rule
 	return nil
 }
 
 func (c *testClient) DeletePlacementRule(ctx context.Context, groupID, ruleID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.rules, [2]string{groupID, ruleID})
 	return nil
 }
 
 func (c *testClient) SetStoresLabel(ctx context.Context, stores []uint64, labelKey, labelValue string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range stores {
+		store, ok := c.stores[id]
+		if !ok {
+			return errors.Errorf("store not found: id=%d", id)
+		}
+		// Like PD, the label can only be updated to a valid value.
+		if !pdLabelPattern.MatchString(labelValue) {
+			return errors.Errorf("invalid label: %s", labelValue)
+		}
+		labels := make([]*metapb.StoreLabel, 0, len(store.Labels)+1)
+		for _, label := range store.Labels {
+			if label.Key != labelKey {
+				labels = append(labels, label)
+			}
+		}
+		store.Labels = append(labels, &metapb.StoreLabel{Key: labelKey, Value: labelValue})
+	}
 	return nil
 }
 
@@ -199,6 +276,101 @@ func (s *testRestoreUtilSuite) TestSplit(c *C) {
 	}
 }
 
+func (s *testRestoreUtilSuite) TestPlanSplitKeys(c *C) {
+	keys, err := PlanSplitKeys(initRanges(), initRewriteRules())
+	c.Assert(err, IsNil)
+	// The prefixes of the rules and the end keys of the ranges.
+	expected := []string{"bb", "bbf", "bbj", "xx", "xxe", "xxz"}
+	c.Assert(keys, HasLen, len(expected))
+	for i, key := range keys {
+		c.Assert(string(key), Equals, expected[i])
+	}
+}
+
+func (s *testRestoreUtilSuite) TestSplitConcurrently(c *C) {
+	client := initTestClient()
+	client.delay = 20 * time.Millisecond
+	// The split of region [bba, bbh) fails twice.
+	client.splitFailures = map[uint64]int{3: 2}
+	regionSplitter := NewRegionSplitter(client)
+
+	var mu sync.Mutex
+	splitKeys := 0
+	err := regionSplitter.Split(context.Background(), initRanges(), initRewriteRules(), func(keys [][]byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		splitKeys += len(keys)
+	})
+	c.Assert(err, IsNil)
+	c.Assert(validateRegions(client.GetAllRegions()), IsTrue)
+	// The new key prefixes are in both the table and data rules.
+	c.Assert(splitKeys, Equals, 8)
+	c.Assert(client.maxSplitting, Greater, 1)
+	// Only the failed region is split again.
+	c.Assert(client.splitCalls, DeepEquals, map[uint64]int{2: 1, 3: 3, 4: 1, 5: 1})
+}
+
+func (s *testRestoreUtilSuite) TestSplitScatterWaitTimeout(c *C) {
+	client := initTestClient()
+	client.scattering = true
+	regionSplitter := NewRegionSplitter(client)
+	regionSplitter.SetScatterWaitTimeout(200 * time.Millisecond)
+
+	start := time.Now()
+	err := regionSplitter.Split(context.Background(), initRanges(), initRewriteRules(), func([][]byte) {})
+	c.Assert(err, IsNil)
+	c.Assert(validateRegions(client.GetAllRegions()), IsTrue)
+	c.Assert(time.Since(start) < 2*time.Second, IsTrue)
+}
+
+// BenchmarkSplit splits 256 regions into 4096 regions with the latency of
+// 10ms for each request.
+func BenchmarkSplit(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		client, ranges := initBenchmarkClient(256, 16)
+		client.delay = 10 * time.Millisecond
+		rewriteRules := &RewriteRules{Data: []*import_sstpb.RewriteRule{{
+			OldKeyPrefix: []byte("0"),
+			NewKeyPrefix: []byte("0"),
+		}}}
+		regionSplitter := NewRegionSplitter(client)
+		b.StartTimer()
+		err := regionSplitter.Split(context.Background(), ranges, rewriteRules, func([][]byte) {})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// initBenchmarkClient returns a client of the regions, and the ranges each
+// of which splits a region into pieces.
+func initBenchmarkClient(regionCount, pieces int) (*testClient, []Range) {
+	peers := []*metapb.Peer{{Id: 1, StoreId: 1}}
+	regions := make(map[uint64]*RegionInfo)
+	ranges := make([]Range, 0, regionCount*pieces)
+	for i := 0; i < regionCount; i++ {
+		var startKey, endKey []byte
+		if i > 0 {
+			startKey = codec.EncodeBytes([]byte{}, []byte(fmt.Sprintf("%08d", i*pieces)))
+		}
+		if i < regionCount-1 {
+			endKey = codec.EncodeBytes([]byte{}, []byte(fmt.Sprintf("%08d", (i+1)*pieces)))
+		}
+		regions[uint64(i+1)] = &RegionInfo{
+			Region: &metapb.Region{Id: uint64(i + 1), Peers: peers, StartKey: startKey, EndKey: endKey},
+		}
+	}
+	for i := 0; i < regionCount*pieces; i++ {
+		ranges = append(ranges, Range{
+			StartKey: []byte(fmt.Sprintf("%08d", i)),
+			EndKey:   []byte(fmt.Sprintf("%08d", i+1)),
+		})
+	}
+	stores := map[uint64]*metapb.Store{1: {Id: 1}}
+	return newTestClient(stores, regions, uint64(regionCount+1)), ranges
+}
+
 // region: [, aay), [aay, bba), [bba, bbh), [bbh, cca), [cca, )
 func initTestClient() *testClient {
 	peers := make([]*metapb.Peer, 1)
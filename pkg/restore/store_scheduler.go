@@ -0,0 +1,175 @@
+package restore
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultStoreConcurrency is the default maximum number of the concurrent
+	// download and ingest requests on each store.
+	DefaultStoreConcurrency = 8
+
+	storeBusyBackoff    = 500 * time.Millisecond
+	storeBusyMaxBackoff = 8 * time.Second
+)
+
+// StoreState is the scheduling state of a store in restore.
+type StoreState struct {
+	StoreID uint64
+	// Limit is the number of the concurrent requests allowed on the store.
+	Limit int
+	// Running is the number of the running requests on the store.
+	Running int
+	// Finished is the number of the finished requests on the store.
+	Finished int
+	// Busy is the number of the times the store reported busy.
+	Busy int
+}
+
+type storeState struct {
+	StoreState
+	// successes is the number of the successful requests since the limit is
+	// changed.
+	successes    int
+	backoff      time.Duration
+	backoffUntil time.Time
+	// notify is closed and replaced when the state changes.
+	notify chan struct{}
+}
+
+// storeScheduler limits the concurrent requests on each store, so that a slow
+// store doesn't take all the workers. The limit of a store is halved and the
+// store is backed off when it reports busy, and the limit grows by one and the
+// backoff is reset after the store handles as many requests as the limit
+// without being busy.
+type storeScheduler struct {
+	mu             sync.Mutex
+	maxConcurrency int
+	stores         map[uint64]*storeState
+}
+
+func newStoreScheduler(maxConcurrency uint) *storeScheduler {
+	if maxConcurrency == 0 {
+		maxConcurrency = DefaultStoreConcurrency
+	}
+	return &storeScheduler{
+		maxConcurrency: int(maxConcurrency),
+		stores:         make(map[uint64]*storeState),
+	}
+}
+
+func (s *storeScheduler) getState(storeID uint64) *storeState {
+	state, ok := s.stores[storeID]
+	if !ok {
+		// Start from the half of the limit, and grow while the store is idle.
+		state = &storeState{
+			StoreState: StoreState{StoreID: storeID, Limit: (s.maxConcurrency + 1) / 2},
+			notify:     make(chan struct{}),
+		}
+		s.stores[storeID] = state
+	}
+	return state
+}
+
+// acquire waits until a request can be sent to the store. The returned
+// function must be called after the request finishes, with whether the store
+// reported busy.
+func (s *storeScheduler) acquire(ctx context.Context, storeID uint64) (func(busy bool), error) {
+	for {
+		s.mu.Lock()
+		state := s.getState(storeID)
+		now := time.Now()
+		if state.Running < state.Limit && !now.Before(state.backoffUntil) {
+			state.Running++
+			s.updateMetrics(state)
+			s.mu.Unlock()
+			return func(busy bool) { s.release(storeID, busy) }, nil
+		}
+		notify := state.notify
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if now.Before(state.backoffUntil) {
+			timer = time.NewTimer(state.backoffUntil.Sub(now))
+			timeout = timer.C
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return nil, ctx.Err()
+		case <-notify:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (s *storeScheduler) release(storeID uint64, busy bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.getState(storeID)
+	state.Running--
+	state.Finished++
+	if busy {
+		state.Busy++
+		state.successes = 0
+		if state.Limit > 1 {
+			state.Limit /= 2
+		}
+		if state.backoff == 0 {
+			state.backoff = storeBusyBackoff
+		} else if state.backoff < storeBusyMaxBackoff {
+			state.backoff *= 2
+		}
+		state.backoffUntil = time.Now().Add(state.backoff)
+		log.Warn("store is busy, throttle restore",
+			zap.Uint64("store", storeID),
+			zap.Int("limit", state.Limit),
+			zap.Duration("backoff", state.backoff))
+		restoreStoreBusyCounter.WithLabelValues(strconv.FormatUint(storeID, 10)).Inc()
+	} else {
+		state.successes++
+		// The store recovers after as many successes as the limit, so the
+		// backoff keeps growing if the store is busy again before that.
+		if state.successes >= state.Limit {
+			state.backoff = 0
+			if state.Limit < s.maxConcurrency {
+				state.Limit++
+				state.successes = 0
+			}
+		}
+	}
+	s.updateMetrics(state)
+	close(state.notify)
+	state.notify = make(chan struct{})
+}
+
+func (s *storeScheduler) updateMetrics(state *storeState) {
+	store := strconv.FormatUint(state.StoreID, 10)
+	restoreStoreConcurrencyGauge.WithLabelValues(store).Set(float64(state.Limit))
+	restoreStoreRunningGauge.WithLabelValues(store).Set(float64(state.Running))
+}
+
+// states returns the states of the stores in the order of the store IDs.
+func (s *storeScheduler) states() []StoreState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states := make([]StoreState, 0, len(s.stores))
+	for _, state := range s.stores {
+		states = append(states, state.StoreState)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].StoreID < states[j].StoreID })
+	return states
+}
@@ -0,0 +1,74 @@
+package restore
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testStoreSchedulerSuite{})
+
+type testStoreSchedulerSuite struct{}
+
+func (s *testStoreSchedulerSuite) TestStoreScheduler(c *C) {
+	scheduler := newStoreScheduler(4)
+	ctx := context.Background()
+	tryAcquire := func(storeID uint64) (func(bool), error) {
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		return scheduler.acquire(ctx, storeID)
+	}
+
+	// The limit starts from the half of the maximum concurrency.
+	releases := make([]func(bool), 0, 4)
+	for i := 0; i < 2; i++ {
+		release, err := tryAcquire(1)
+		c.Assert(err, IsNil)
+		releases = append(releases, release)
+	}
+	_, err := tryAcquire(1)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+	// The other stores are not affected.
+	release, err := tryAcquire(2)
+	c.Assert(err, IsNil)
+	release(false)
+
+	// The limit grows after as many successes as the limit, up to the
+	// maximum concurrency.
+	for _, release := range releases {
+		release(false)
+	}
+	c.Assert(scheduler.states()[0], DeepEquals, StoreState{StoreID: 1, Limit: 3, Finished: 2})
+	for i := 0; i < 10; i++ {
+		release, err = tryAcquire(1)
+		c.Assert(err, IsNil)
+		release(false)
+	}
+	c.Assert(scheduler.states()[0].Limit, Equals, 4)
+
+	// The limit is halved and the store is backed off when it is busy.
+	release, err = tryAcquire(1)
+	c.Assert(err, IsNil)
+	release(true)
+	c.Assert(scheduler.states()[0], DeepEquals, StoreState{StoreID: 1, Limit: 2, Finished: 13, Busy: 1})
+	_, err = tryAcquire(1)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+	start := time.Now()
+	release, err = scheduler.acquire(ctx, 1)
+	c.Assert(err, IsNil)
+	c.Assert(time.Since(start) > storeBusyBackoff/2, IsTrue)
+	release(false)
+
+	// The backoff is reset after as many successes as the limit.
+	c.Assert(scheduler.stores[1].backoff, Equals, storeBusyBackoff)
+	release, err = tryAcquire(1)
+	c.Assert(err, IsNil)
+	release(false)
+	c.Assert(scheduler.stores[1].backoff, Equals, time.Duration(0))
+	c.Assert(scheduler.stores[1].Limit, Equals, 3)
+
+	states := scheduler.states()
+	c.Assert(states, HasLen, 2)
+	c.Assert(states[1], DeepEquals, StoreState{StoreID: 2, Limit: 2, Finished: 1})
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/log"
 	"github.com/pingcap/parser/model"
+	"github.com/pingcap/pd/pkg/mock/mockid"
 	"github.com/pingcap/tidb/meta/autoid"
 	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/util/codec"
@@ -56,6 +57,38 @@ func (alloc *idAllocator) GetType() autoid.AllocatorType {
 	return autoid.RowIDAllocType
 }
 
+// MockCreatedTable returns the table created from the backed up table in
+// TiDB, whose table and partition IDs are allocated by tableIDAlloc. It
+// simulates creating the table to get the rewrite rules without a cluster.
+func MockCreatedTable(table *model.TableInfo, tableIDAlloc *mockid.IDAllocator) *model.TableInfo {
+	newTable := new(model.TableInfo)
+	tableID, _ := tableIDAlloc.Alloc()
+	newTable.ID = int64(tableID)
+	newTable.Name = table.Name
+	indexIDAlloc := mockid.NewIDAllocator()
+	newTable.Indices = make([]*model.IndexInfo, len(table.Indices))
+	for i, indexInfo := range table.Indices {
+		indexID, _ := indexIDAlloc.Alloc()
+		newTable.Indices[i] = &model.IndexInfo{
+			ID:   int64(indexID),
+			Name: indexInfo.Name,
+		}
+	}
+	if table.Partition != nil {
+		newTable.Partition = &model.PartitionInfo{
+			Definitions: make([]model.PartitionDefinition, len(table.Partition.Definitions)),
+		}
+		for i, def := range table.Partition.Definitions {
+			partitionID, _ := tableIDAlloc.Alloc()
+			newTable.Partition.Definitions[i] = model.PartitionDefinition{
+				ID:   int64(partitionID),
+				Name: def.Name,
+			}
+		}
+	}
+	return newTable
+}
+
 // GetRewriteRules returns the rewrite rule of the new table and the old table.
 func GetRewriteRules(
 	newTable *model.TableInfo,
@@ -75,6 +108,10 @@ func GetRewriteRules(
 	}
 	indexIDs := make(map[int64]int64)
 	for _, srcIndex := range oldTable.Indices {
+		// The data of non-public indices is not backed up.
+		if srcIndex.State != model.StatePublic {
+			continue
+		}
 		for _, destIndex := range newTable.Indices {
 			if srcIndex.Name == destIndex.Name {
 				indexIDs[srcIndex.ID] = destIndex.ID
@@ -110,6 +147,67 @@ func GetRewriteRules(
 	}
 }
 
+// checkPartitions checks whether the partitions of the backed up table can be
+// restored into the table, which may exist before restore. Each backed up
+// partition must have a partition of the same name and the same definition in
+// the table.
+func checkPartitions(newTable, oldTable *model.TableInfo) error {
+	oldPart, newPart := oldTable.Partition, newTable.Partition
+	if oldPart == nil {
+		return nil
+	}
+	if newPart == nil {
+		return errors.Errorf("table %s is not partitioned", newTable.Name)
+	}
+	if oldPart.Type != newPart.Type || oldPart.Expr != newPart.Expr ||
+		!equalCIStrs(oldPart.Columns, newPart.Columns) {
+		return errors.Errorf("partition type or expression of table %s mismatch, expect %s(%s), got %s(%s)",
+			newTable.Name, oldPart.Type, oldPart.Expr, newPart.Type, newPart.Expr)
+	}
+	for _, oldDef := range oldPart.Definitions {
+		var newDef *model.PartitionDefinition
+		for i := range newPart.Definitions {
+			if newPart.Definitions[i].Name.L == oldDef.Name.L {
+				newDef = &newPart.Definitions[i]
+				break
+			}
+		}
+		if newDef == nil {
+			return errors.Errorf("partition %s not found in table %s", oldDef.Name, newTable.Name)
+		}
+		if !equalStrings(oldDef.LessThan, newDef.LessThan) {
+			return errors.Errorf("partition %s of table %s mismatch, expect values less than (%s), got (%s)",
+				oldDef.Name, newTable.Name,
+				strings.Join(oldDef.LessThan, ", "), strings.Join(newDef.LessThan, ", "))
+		}
+	}
+	return nil
+}
+
+func equalCIStrs(a, b []model.CIStr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].L != b[i].L {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // getSSTMetaFromFile compares the keys in file, region and rewrite rules, then returns a sst conn.
 // The range of the returned sst meta is [regionRule.NewKeyPrefix, append(regionRule.NewKeyPrefix, 0xff)]
 func getSSTMetaFromFile(
@@ -285,6 +383,8 @@ func SplitRanges(
 		summary.CollectDuration("split region", elapsed)
 	}()
 	splitter := NewRegionSplitter(NewSplitClient(client.GetPDClient()))
+	splitter.SetConcurrency(client.splitConcurrency)
+	splitter.SetScatterWaitTimeout(client.scatterWaitTimeout)
 	return splitter.Split(ctx, ranges, rewriteRules, func(keys [][]byte) {
 		for range keys {
 			updateCh <- struct{}{}
@@ -5,6 +5,8 @@ import (
 	"github.com/pingcap/kvproto/pkg/backup"
 	"github.com/pingcap/kvproto/pkg/import_sstpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/pd/pkg/mock/mockid"
 	"github.com/pingcap/tidb/tablecodec"
 )
 
@@ -32,6 +34,35 @@ func (s *testRestoreUtilSuite) TestGetSSTMetaFromFile(c *C) {
 	c.Assert(string(sstMeta.GetRange().GetEnd()), Equals, "t2\xff")
 }
 
+func (s *testRestoreUtilSuite) TestMockCreatedTable(c *C) {
+	table := &model.TableInfo{
+		ID:      41,
+		Name:    model.NewCIStr("t"),
+		Indices: []*model.IndexInfo{{ID: 3, Name: model.NewCIStr("idx"), State: model.StatePublic}},
+		Partition: &model.PartitionInfo{Definitions: []model.PartitionDefinition{
+			{ID: 42, Name: model.NewCIStr("p0")},
+			{ID: 43, Name: model.NewCIStr("p1")},
+		}},
+	}
+	alloc := mockid.NewIDAllocator()
+	newTable := MockCreatedTable(table, alloc)
+	c.Assert(newTable.ID, Equals, int64(1))
+	c.Assert(newTable.Indices[0].ID, Equals, int64(1))
+	c.Assert(newTable.Partition.Definitions[0].ID, Equals, int64(2))
+	c.Assert(newTable.Partition.Definitions[1].ID, Equals, int64(3))
+	c.Assert(MockCreatedTable(table, alloc).ID, Equals, int64(4))
+
+	// The partitions are rewritten by their names.
+	rules := GetRewriteRules(newTable, table, 0)
+	c.Assert(rules.Table, HasLen, 3)
+	file := &backup.File{
+		Name:     "43_write.sst",
+		StartKey: tablecodec.EncodeRowKeyWithHandle(43, 1),
+		EndKey:   tablecodec.EncodeRowKeyWithHandle(43, 10),
+	}
+	c.Assert(ValidateFileRewriteRule(file, rules), IsNil)
+}
+
 func (s *testRestoreUtilSuite) TestValidateFileRanges(c *C) {
 	rules := &RewriteRules{
 		Table: []*import_sstpb.RewriteRule{&import_sstpb.RewriteRule{
@@ -0,0 +1,368 @@
+package sst
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pingcap/errors"
+)
+
+// The compression types of the blocks.
+const (
+	noCompression      = 0x0
+	snappyCompression  = 0x1
+	zlibCompression    = 0x2
+	lz4Compression     = 0x4
+	lz4hcCompression   = 0x5
+	zstdCompression    = 0x7
+	zstdNotFinal       = 0x40
+	blockTrailerSize   = 5
+	noChecksumType     = 0
+	crc32cChecksumType = 1
+	crc32cMaskDelta    = 0xa282ead8
+	// maxLZ4Ratio is the maximum compression ratio of LZ4, every byte of the
+	// length of a literal run or a match adds at most 255 to it.
+	maxLZ4Ratio = 255
+	// dataBlockHashFlag is set in the number of the restarts if the block
+	// contains a hash index.
+	dataBlockHashFlag = 1 << 31
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// blockHandle is the position of a block in the file.
+type blockHandle struct {
+	offset uint64
+	size   uint64
+}
+
+// decodeBlockHandle decodes a block handle, and returns the rest of the
+// input.
+func decodeBlockHandle(data []byte) (blockHandle, []byte, error) {
+	offset, n := binary.Uvarint(data)
+	if n <= 0 {
+		return blockHandle{}, nil, errors.New("invalid block handle")
+	}
+	size, m := binary.Uvarint(data[n:])
+	if m <= 0 {
+		return blockHandle{}, nil, errors.New("invalid block handle")
+	}
+	return blockHandle{offset: offset, size: size}, data[n+m:], nil
+}
+
+// readBlock reads the block at the handle, checks its checksum and
+// decompresses it.
+func (r *Reader) readBlock(handle blockHandle) ([]byte, error) {
+	end := handle.offset + handle.size + blockTrailerSize
+	if end > uint64(len(r.data)) || end < handle.offset {
+		return nil, errors.Errorf("block at %d of size %d is out of the file of size %d",
+			handle.offset, handle.size, len(r.data))
+	}
+	data := r.data[handle.offset : handle.offset+handle.size]
+	compression := r.data[handle.offset+handle.size]
+	if r.checksumType == crc32cChecksumType {
+		expected := binary.LittleEndian.Uint32(r.data[handle.offset+handle.size+1 : end])
+		crc := crc32.Update(crc32.Checksum(data, crc32cTable), crc32cTable, []byte{compression})
+		if crc = (crc>>15 | crc<<17) + crc32cMaskDelta; crc != expected {
+			return nil, errors.Errorf("block at %d is corrupted, checksum is %d, but %d in the trailer",
+				handle.offset, crc, expected)
+		}
+	}
+	return r.decompress(compression, data)
+}
+
+func (r *Reader) decompress(compression byte, data []byte) ([]byte, error) {
+	if compression == noCompression {
+		return data, nil
+	}
+	if compression == snappyCompression {
+		decoded, err := snappy.Decode(nil, data)
+		return decoded, errors.Annotate(err, "decompress snappy block failed")
+	}
+	// Since format version 2, the other compressed blocks are prefixed by
+	// the size of the decompressed block.
+	if r.formatVersion < 2 {
+		return nil, errors.Errorf("compression type %d of format version %d is not supported",
+			compression, r.formatVersion)
+	}
+	size, n := binary.Uvarint(data)
+	if n <= 0 || size >= 1<<62 {
+		return nil, errors.New("invalid size of the decompressed block")
+	}
+	data = data[n:]
+	// The size in the header is not trusted before the block is decompressed,
+	// so the buffers allocated beforehand are bounded by the size of the
+	// compressed block.
+	switch compression {
+	case zlibCompression:
+		reader := flate.NewReader(bytes.NewReader(data))
+		decoded, err := ioutil.ReadAll(io.LimitReader(reader, int64(size)+1))
+		if err != nil {
+			return nil, errors.Annotate(err, "decompress zlib block failed")
+		}
+		return decoded, checkDecompressedSize(decoded, size)
+	case lz4Compression, lz4hcCompression:
+		if size > uint64(len(data))*maxLZ4Ratio {
+			return nil, errors.Errorf("decompressed block size %d in the header is too large for the lz4 block of size %d",
+				size, len(data))
+		}
+		decoded := make([]byte, size)
+		if err := decodeLZ4Block(decoded, data); err != nil {
+			return nil, errors.Annotate(err, "decompress lz4 block failed")
+		}
+		return decoded, nil
+	case zstdCompression, zstdNotFinal:
+		decoder, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		defer decoder.Close()
+		// zstd may compress better than LZ4, the buffer grows in that case.
+		capacity := uint64(len(data)) * maxLZ4Ratio
+		if size < capacity {
+			capacity = size
+		}
+		decoded, err := decoder.DecodeAll(data, make([]byte, 0, capacity))
+		if err != nil {
+			return nil, errors.Annotate(err, "decompress zstd block failed")
+		}
+		return decoded, checkDecompressedSize(decoded, size)
+	default:
+		return nil, errors.Errorf("compression type %d is not supported", compression)
+	}
+}
+
+func checkDecompressedSize(decoded []byte, size uint64) error {
+	if uint64(len(decoded)) != size {
+		return errors.Errorf("decompressed block size is %d, but %d in the header", len(decoded), size)
+	}
+	return nil
+}
+
+// decodeLZ4Block decodes an LZ4 block into dst, which has the exact size of
+// the decoded block.
+func decodeLZ4Block(dst, src []byte) error {
+	d, s := 0, 0
+	readLength := func(length int) (int, error) {
+		if length != 0xf {
+			return length, nil
+		}
+		for {
+			if s >= len(src) {
+				return 0, errors.New("unexpected end of the block")
+			}
+			b := src[s]
+			s++
+			length += int(b)
+			if b != 0xff {
+				return length, nil
+			}
+		}
+	}
+	for s < len(src) {
+		token := src[s]
+		s++
+		literals, err := readLength(int(token >> 4))
+		if err != nil {
+			return err
+		}
+		if s+literals > len(src) || d+literals > len(dst) {
+			return errors.New("literals are out of range")
+		}
+		d += copy(dst[d:], src[s:s+literals])
+		s += literals
+		// The last sequence has only the literals.
+		if s == len(src) {
+			break
+		}
+		if s+2 > len(src) {
+			return errors.New("unexpected end of the block")
+		}
+		offset := int(binary.LittleEndian.Uint16(src[s:]))
+		s += 2
+		if offset == 0 || offset > d {
+			return errors.Errorf("invalid match offset %d", offset)
+		}
+		length, err := readLength(int(token & 0xf))
+		if err != nil {
+			return err
+		}
+		length += 4
+		if d+length > len(dst) {
+			return errors.New("match is out of range")
+		}
+		// The match may overlap the bytes being copied.
+		for i := 0; i < length; i++ {
+			dst[d] = dst[d-offset]
+			d++
+		}
+	}
+	if d != len(dst) {
+		return errors.Errorf("decompressed block size is %d, but %d in the header", d, len(dst))
+	}
+	return nil
+}
+
+// blockIter iterates the entries of a block. The keys are prefix
+// compressed, and restarted at the restart points.
+type blockIter struct {
+	data []byte
+	// end is the end of the entries, where the restart points begin.
+	end int
+	pos int
+	key []byte
+	err error
+}
+
+func newBlockIter(data []byte) (*blockIter, error) {
+	if len(data) < 4 {
+		return nil, errors.New("block is too short")
+	}
+	numRestarts := binary.LittleEndian.Uint32(data[len(data)-4:])
+	end := len(data) - 4
+	if numRestarts&dataBlockHashFlag != 0 {
+		// The hash index is between the restart points and the footer, it
+		// is the buckets followed by the number of the buckets.
+		numRestarts &^= dataBlockHashFlag
+		if end < 2 {
+			return nil, errors.New("block is too short")
+		}
+		numBuckets := int(binary.LittleEndian.Uint16(data[end-2:]))
+		end -= numBuckets + 2
+	}
+	end -= int(numRestarts) * 4
+	if end < 0 {
+		return nil, errors.Errorf("invalid number of the restarts %d", numRestarts)
+	}
+	return &blockIter{data: data, end: end}, nil
+}
+
+func (it *blockIter) uvarint() uint64 {
+	if it.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(it.data[it.pos:it.end])
+	if n <= 0 {
+		it.err = errors.Errorf("invalid block entry at %d", it.pos)
+		return 0
+	}
+	it.pos += n
+	return v
+}
+
+func (it *blockIter) varint() int64 {
+	if it.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(it.data[it.pos:it.end])
+	if n <= 0 {
+		it.err = errors.Errorf("invalid block entry at %d", it.pos)
+		return 0
+	}
+	it.pos += n
+	return v
+}
+
+func (it *blockIter) bytes(n uint64) []byte {
+	if it.err != nil {
+		return nil
+	}
+	if n > uint64(it.end-it.pos) {
+		it.err = errors.Errorf("invalid block entry at %d", it.pos)
+		return nil
+	}
+	b := it.data[it.pos : it.pos+int(n)]
+	it.pos += int(n)
+	return b
+}
+
+// nextKey decodes the key of the next entry, and returns the number of the
+// bytes shared with the previous key. The returned key is reused by the
+// following calls.
+func (it *blockIter) nextKey() ([]byte, uint64, bool) {
+	if it.err != nil || it.pos >= it.end {
+		return nil, 0, false
+	}
+	shared := it.uvarint()
+	nonShared := it.uvarint()
+	if it.err == nil && shared > uint64(len(it.key)) {
+		it.err = errors.Errorf("invalid block entry at %d", it.pos)
+	}
+	suffix := it.bytes(nonShared)
+	if it.err != nil {
+		return nil, 0, false
+	}
+	it.key = append(it.key[:shared], suffix...)
+	return it.key, shared, true
+}
+
+// next returns the next entry whose value is prefixed by its length. The
+// returned key is reused by the following calls.
+func (it *blockIter) next() (key, value []byte, ok bool) {
+	if it.err != nil || it.pos >= it.end {
+		return nil, nil, false
+	}
+	shared := it.uvarint()
+	nonShared := it.uvarint()
+	valueLen := it.uvarint()
+	if it.err == nil && shared > uint64(len(it.key)) {
+		it.err = errors.Errorf("invalid block entry at %d", it.pos)
+	}
+	suffix := it.bytes(nonShared)
+	value = it.bytes(valueLen)
+	if it.err != nil {
+		return nil, nil, false
+	}
+	it.key = append(it.key[:shared], suffix...)
+	return it.key, value, true
+}
+
+// decodeIndex returns the handles of the data blocks in the index block.
+// If deltaValue is true, the handles of the entries except the restart
+// points only contain the delta of the size. If firstKey is true, the first
+// key of the block follows each handle.
+func decodeIndex(data []byte, deltaValue, firstKey bool) ([]blockHandle, error) {
+	it, err := newBlockIter(data)
+	if err != nil {
+		return nil, err
+	}
+	handles := make([]blockHandle, 0)
+	for {
+		if deltaValue {
+			_, shared, ok := it.nextKey()
+			if !ok {
+				break
+			}
+			var handle blockHandle
+			if shared == 0 || len(handles) == 0 {
+				handle.offset = it.uvarint()
+				handle.size = it.uvarint()
+			} else {
+				prev := handles[len(handles)-1]
+				handle.offset = prev.offset + prev.size + blockTrailerSize
+				handle.size = uint64(int64(prev.size) + it.varint())
+			}
+			if firstKey {
+				it.bytes(it.uvarint())
+			}
+			handles = append(handles, handle)
+			continue
+		}
+		_, value, ok := it.next()
+		if !ok {
+			break
+		}
+		handle, _, err := decodeBlockHandle(value)
+		if err != nil {
+			return nil, err
+		}
+		handles = append(handles, handle)
+	}
+	return handles, errors.Trace(it.err)
+}
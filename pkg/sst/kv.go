@@ -0,0 +1,239 @@
+package sst
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc64"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+)
+
+// dataPrefix is the prefix of the keys of the data written by TiKV.
+const dataPrefix = 'z'
+
+// tsSize is the size of the timestamp appended to the MVCC keys.
+const tsSize = 8
+
+// WriteType is the type of a record in the write column family.
+type WriteType byte
+
+// The types of the records in the write column family.
+const (
+	WritePut      WriteType = 'P'
+	WriteDelete   WriteType = 'D'
+	WriteLock     WriteType = 'L'
+	WriteRollback WriteType = 'R'
+)
+
+func (t WriteType) String() string {
+	switch t {
+	case WritePut:
+		return "put"
+	case WriteDelete:
+		return "delete"
+	case WriteLock:
+		return "lock"
+	case WriteRollback:
+		return "rollback"
+	default:
+		return fmt.Sprintf("unknown(%d)", t)
+	}
+}
+
+// The flags of the optional fields of a write record.
+const (
+	shortValuePrefix = 'v'
+	protectedFlag    = 'R'
+	gcFencePrefix    = 'F'
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// Write is a decoded record of the write column family.
+type Write struct {
+	Type       WriteType
+	StartTS    uint64
+	ShortValue []byte
+}
+
+// DecodeWrite decodes a record of the write column family.
+func DecodeWrite(value []byte) (*Write, error) {
+	if len(value) == 0 {
+		return nil, errors.New("write record is empty")
+	}
+	write := &Write{Type: WriteType(value[0])}
+	switch write.Type {
+	case WritePut, WriteDelete, WriteLock, WriteRollback:
+	default:
+		return nil, errors.Errorf("invalid write type %d", value[0])
+	}
+	startTS, n := binary.Uvarint(value[1:])
+	if n <= 0 {
+		return nil, errors.New("invalid start ts of the write record")
+	}
+	write.StartTS = startTS
+	value = value[1+n:]
+	for len(value) > 0 {
+		switch value[0] {
+		case shortValuePrefix:
+			if len(value) < 2 || len(value) < 2+int(value[1]) {
+				return nil, errors.New("invalid short value of the write record")
+			}
+			write.ShortValue = value[2 : 2+int(value[1])]
+			value = value[2+int(value[1]):]
+		case protectedFlag:
+			value = value[1:]
+		case gcFencePrefix:
+			if _, n = binary.Uvarint(value[1:]); n <= 0 {
+				return nil, errors.New("invalid gc fence of the write record")
+			}
+			value = value[1+n:]
+		default:
+			// The fields unknown to this version are skipped.
+			return write, nil
+		}
+	}
+	return write, nil
+}
+
+// DecodeKey decodes a key of the transactional data written by TiKV into
+// the raw key and the timestamp.
+func DecodeKey(key []byte) ([]byte, uint64, error) {
+	if len(key) == 0 || key[0] != dataPrefix {
+		return nil, 0, errors.Errorf("invalid data key %x", key)
+	}
+	if len(key) < 1+tsSize {
+		return nil, 0, errors.Errorf("key %x has no timestamp", key)
+	}
+	encoded := key[1 : len(key)-tsSize]
+	ts := ^binary.BigEndian.Uint64(key[len(key)-tsSize:])
+	_, raw, err := codec.DecodeBytes(encoded, nil)
+	if err != nil {
+		return nil, 0, errors.Annotatef(err, "decode key %x failed", key)
+	}
+	return raw, ts, nil
+}
+
+// DecodeRawKey decodes a key of the raw data written by TiKV.
+func DecodeRawKey(key []byte) ([]byte, error) {
+	if len(key) == 0 || key[0] != dataPrefix {
+		return nil, errors.Errorf("invalid data key %x", key)
+	}
+	return key[1:], nil
+}
+
+// DescribeKey describes a raw key of TiDB by the table ID, and the handle
+// of a row or the index ID and the values of an index.
+func DescribeKey(raw []byte) string {
+	tableID, indexID, isRecord, err := tablecodec.DecodeKeyHead(raw)
+	if err != nil {
+		return fmt.Sprintf("key %x", raw)
+	}
+	if isRecord {
+		_, handle, err := tablecodec.DecodeRecordKey(raw)
+		if err != nil {
+			return fmt.Sprintf("table %d, key %x", tableID, raw)
+		}
+		return fmt.Sprintf("table %d, handle %d", tableID, handle)
+	}
+	_, _, values, err := tablecodec.DecodeIndexKey(raw)
+	if err != nil {
+		return fmt.Sprintf("table %d, index %d, key %x", tableID, indexID, raw)
+	}
+	return fmt.Sprintf("table %d, index %d, values [%s]", tableID, indexID, strings.Join(values, ", "))
+}
+
+// Checksum is the checksum of the key-value pairs, as TiKV computes it for
+// the backup files.
+type Checksum struct {
+	Crc64Xor   uint64
+	TotalKvs   uint64
+	TotalBytes uint64
+}
+
+// Update adds a key-value pair to the checksum.
+func (c *Checksum) Update(key, value []byte) {
+	c.Crc64Xor ^= crc64.Update(crc64.Update(0, crcTable, key), crcTable, value)
+	c.TotalKvs++
+	c.TotalBytes += uint64(len(key) + len(value))
+}
+
+// IterateWrites calls fn with each record of the write file in order, the
+// records of a key are ordered by the commit ts descending. The value is
+// set only for the put records, the long values are looked up in the
+// default file, which is nil if all the values are short values. The raw
+// key and the value are reused by the following calls.
+func IterateWrites(write, def *Reader, fn func(raw []byte, commitTS uint64, record *Write, value []byte) error) error {
+	values := make(map[string][]byte)
+	if def != nil {
+		err := def.Iterate(func(entry *Entry) error {
+			values[string(entry.Key)] = entry.Value
+			return nil
+		})
+		if err != nil {
+			return errors.Annotate(err, "read the default file failed")
+		}
+	}
+
+	lookup := make([]byte, 0)
+	err := write.Iterate(func(entry *Entry) error {
+		raw, commitTS, err := DecodeKey(entry.Key)
+		if err != nil {
+			return err
+		}
+		record, err := DecodeWrite(entry.Value)
+		if err != nil {
+			return errors.Annotatef(err, "decode the write record of key %x failed", raw)
+		}
+		if record.Type != WritePut {
+			return fn(raw, commitTS, record, nil)
+		}
+		value := record.ShortValue
+		if value == nil {
+			// The long value is in the default column family, with the start
+			// ts instead of the commit ts.
+			lookup = append(lookup[:0], entry.Key...)
+			binary.BigEndian.PutUint64(lookup[len(lookup)-tsSize:], ^record.StartTS)
+			var ok bool
+			if value, ok = values[string(lookup)]; !ok {
+				return errors.Errorf("value of key %x at %d is missing in the default file", raw, record.StartTS)
+			}
+		}
+		return fn(raw, commitTS, record, value)
+	})
+	return errors.Annotate(err, "read the write file failed")
+}
+
+// ComputeChecksum recomputes the checksum of the backup files of a range.
+// The write and the default files are the files of the write and the
+// default column families, the default one is nil if all the values are
+// short values. The checksum covers the raw key and the value of each put
+// record.
+func ComputeChecksum(write, def *Reader) (Checksum, error) {
+	var checksum Checksum
+	err := IterateWrites(write, def, func(raw []byte, _ uint64, record *Write, value []byte) error {
+		if record.Type == WritePut {
+			checksum.Update(raw, value)
+		}
+		return nil
+	})
+	return checksum, err
+}
+
+// ComputeRawChecksum recomputes the checksum of a backup file of the raw
+// data.
+func ComputeRawChecksum(r *Reader) (Checksum, error) {
+	var checksum Checksum
+	err := r.Iterate(func(entry *Entry) error {
+		raw, err := DecodeRawKey(entry.Key)
+		if err != nil {
+			return err
+		}
+		checksum.Update(raw, entry.Value)
+		return nil
+	})
+	return checksum, errors.Trace(err)
+}
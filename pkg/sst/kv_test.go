@@ -0,0 +1,123 @@
+package sst
+
+import (
+	"encoding/binary"
+	"hash/crc64"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+)
+
+type testKVSuite struct{}
+
+var _ = Suite(&testKVSuite{})
+
+func mvccKey(raw []byte, ts uint64) []byte {
+	key := append([]byte{dataPrefix}, codec.EncodeBytes(nil, raw)...)
+	var tsBytes [tsSize]byte
+	binary.BigEndian.PutUint64(tsBytes[:], ^ts)
+	return append(key, tsBytes[:]...)
+}
+
+func writeRecord(tp WriteType, startTS uint64, shortValue []byte) []byte {
+	record := appendUvarints([]byte{byte(tp)}, startTS)
+	if shortValue != nil {
+		record = append(append(record, shortValuePrefix, byte(len(shortValue))), shortValue...)
+	}
+	return record
+}
+
+func (r *testKVSuite) TestDecodeWrite(c *C) {
+	write, err := DecodeWrite(writeRecord(WritePut, 42, []byte("value")))
+	c.Assert(err, IsNil)
+	c.Assert(write, DeepEquals, &Write{Type: WritePut, StartTS: 42, ShortValue: []byte("value")})
+
+	// The protected rollback and the gc fence are skipped.
+	record := append(writeRecord(WriteRollback, 7, nil), protectedFlag, gcFencePrefix, 0x80, 0x01)
+	write, err = DecodeWrite(record)
+	c.Assert(err, IsNil)
+	c.Assert(write, DeepEquals, &Write{Type: WriteRollback, StartTS: 7})
+	c.Assert(write.Type.String(), Equals, "rollback")
+
+	for _, record := range [][]byte{
+		nil,
+		{'X', 1},
+		{'P'},
+		{'P', 1, shortValuePrefix, 3, 'a'},
+		{'P', 1, gcFencePrefix, 0x80},
+	} {
+		_, err = DecodeWrite(record)
+		c.Assert(err, NotNil, Commentf("record %x", record))
+	}
+}
+
+func (r *testKVSuite) TestDecodeKey(c *C) {
+	raw := tablecodec.EncodeRowKeyWithHandle(5, 7)
+	key, ts, err := DecodeKey(mvccKey(raw, 100))
+	c.Assert(err, IsNil)
+	c.Assert(key, DeepEquals, []byte(raw))
+	c.Assert(ts, Equals, uint64(100))
+	c.Assert(DescribeKey(key), Equals, "table 5, handle 7")
+
+	values, err := codec.EncodeKey(nil, nil, types.NewIntDatum(3), types.NewStringDatum("abc"))
+	c.Assert(err, IsNil)
+	c.Assert(DescribeKey(tablecodec.EncodeIndexSeekKey(5, 2, values)), Equals, "table 5, index 2, values [3, abc]")
+	c.Assert(DescribeKey([]byte("m\x01")), Equals, "key 6d01")
+
+	_, _, err = DecodeKey(raw)
+	c.Assert(err, ErrorMatches, "invalid data key.*")
+	_, _, err = DecodeKey([]byte("z\x01"))
+	c.Assert(err, ErrorMatches, ".*has no timestamp")
+	raw, err = DecodeRawKey([]byte("zabc"))
+	c.Assert(err, IsNil)
+	c.Assert(string(raw), Equals, "abc")
+}
+
+func (r *testKVSuite) TestComputeChecksum(c *C) {
+	table := &testTable{compression: snappyCompression, entriesPerBlock: 2}
+	k1 := tablecodec.EncodeRowKeyWithHandle(1, 1)
+	k2 := tablecodec.EncodeRowKeyWithHandle(1, 2)
+	k3 := tablecodec.EncodeRowKeyWithHandle(1, 3)
+	long := make([]byte, 300)
+	writeData := table.build(c,
+		[][]byte{mvccKey(k1, 20), mvccKey(k2, 21), mvccKey(k3, 22)},
+		[][]byte{
+			writeRecord(WritePut, 10, []byte("v1")),
+			writeRecord(WritePut, 11, nil),
+			writeRecord(WriteLock, 12, nil),
+		})
+	defaultData := table.build(c, [][]byte{mvccKey(k2, 11)}, [][]byte{long})
+	write, err := NewReader(writeData)
+	c.Assert(err, IsNil)
+	def, err := NewReader(defaultData)
+	c.Assert(err, IsNil)
+
+	checksum, err := ComputeChecksum(write, def)
+	c.Assert(err, IsNil)
+	sum := func(key, value []byte) uint64 {
+		table := crc64.MakeTable(crc64.ECMA)
+		return crc64.Update(crc64.Update(0, table, key), table, value)
+	}
+	c.Assert(checksum, DeepEquals, Checksum{
+		Crc64Xor:   sum(k1, []byte("v1")) ^ sum(k2, long),
+		TotalKvs:   2,
+		TotalBytes: uint64(len(k1) + 2 + len(k2) + len(long)),
+	})
+
+	// The long value is missing without the default file.
+	_, err = ComputeChecksum(write, nil)
+	c.Assert(err, ErrorMatches, ".*value of key .* at 11 is missing in the default file")
+
+	rawData := table.build(c, [][]byte{[]byte("za"), []byte("zb")}, [][]byte{[]byte("1"), []byte("22")})
+	rawReader, err := NewReader(rawData)
+	c.Assert(err, IsNil)
+	checksum, err = ComputeRawChecksum(rawReader)
+	c.Assert(err, IsNil)
+	c.Assert(checksum, DeepEquals, Checksum{
+		Crc64Xor:   sum([]byte("a"), []byte("1")) ^ sum([]byte("b"), []byte("22")),
+		TotalKvs:   2,
+		TotalBytes: 5,
+	})
+}
@@ -0,0 +1,242 @@
+// Package sst reads the SST files of the backups, which are the block based
+// tables of RocksDB, without depending on RocksDB.
+package sst
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+)
+
+const (
+	footerSize       = 53
+	legacyFooterSize = 48
+	// blockBasedTableMagic is the magic number of the block based tables.
+	blockBasedTableMagic = 0x88e241b785f4cff7
+	// legacyBlockBasedTableMagic is the magic number of the block based
+	// tables of format version 0, which is compatible with LevelDB.
+	legacyBlockBasedTableMagic = 0xdb4775248b80fb57
+
+	propertiesBlock = "rocksdb.properties"
+
+	// The properties read by the reader.
+	propNumEntries        = "rocksdb.num.entries"
+	propIndexType         = "rocksdb.block.based.table.index.type"
+	propIndexValueIsDelta = "rocksdb.index.value.is.delta.encoded"
+	propCompressionName   = "rocksdb.compression"
+
+	indexTypeTwoLevel      = 2
+	indexTypeWithFirstKey  = 3
+	internalKeyTrailerSize = 8
+)
+
+// The types of the internal keys.
+const (
+	TypeDeletion = 0x0
+	TypeValue    = 0x1
+)
+
+// Entry is an entry of an SST file. The key is the user key, the sequence
+// number and the type are decoded from the internal key.
+type Entry struct {
+	Key   []byte
+	Seq   uint64
+	Type  byte
+	Value []byte
+}
+
+// Reader reads an SST file in memory. Only the files of the block based
+// tables are supported, which are written by the SST writers of TiKV.
+type Reader struct {
+	data          []byte
+	checksumType  byte
+	formatVersion uint32
+	index         blockHandle
+	properties    map[string][]byte
+}
+
+// NewReader returns a reader of the SST file data.
+func NewReader(data []byte) (*Reader, error) {
+	r := &Reader{data: data, checksumType: crc32cChecksumType}
+	if len(data) < legacyFooterSize {
+		return nil, errors.Errorf("file size %d is too small for an SST file", len(data))
+	}
+	var handles []byte
+	switch binary.LittleEndian.Uint64(data[len(data)-8:]) {
+	case blockBasedTableMagic:
+		if len(data) < footerSize {
+			return nil, errors.Errorf("file size %d is too small for an SST file", len(data))
+		}
+		footer := data[len(data)-footerSize:]
+		r.checksumType = footer[0]
+		// Only the blocks checksummed by CRC32C are verified, the xxHash
+		// checksums are not supported.
+		if r.checksumType != noChecksumType && r.checksumType != crc32cChecksumType {
+			return nil, errors.Errorf("checksum type %d is not supported", r.checksumType)
+		}
+		r.formatVersion = binary.LittleEndian.Uint32(footer[footerSize-12:])
+		handles = footer[1:]
+	case legacyBlockBasedTableMagic:
+		handles = data[len(data)-legacyFooterSize:]
+	default:
+		return nil, errors.New("invalid magic number, the file is not an SST file")
+	}
+	metaIndex, handles, err := decodeBlockHandle(handles)
+	if err != nil {
+		return nil, errors.Annotate(err, "decode the handle of the meta index block failed")
+	}
+	if r.index, _, err = decodeBlockHandle(handles); err != nil {
+		return nil, errors.Annotate(err, "decode the handle of the index block failed")
+	}
+
+	// The meta index block maps the names of the meta blocks to their
+	// handles, the properties are the only meta block read.
+	block, err := r.readBlock(metaIndex)
+	if err != nil {
+		return nil, errors.Annotate(err, "read the meta index block failed")
+	}
+	it, err := newBlockIter(block)
+	if err != nil {
+		return nil, err
+	}
+	r.properties = make(map[string][]byte)
+	for key, value, ok := it.next(); ok; key, value, ok = it.next() {
+		if string(key) != propertiesBlock {
+			continue
+		}
+		handle, _, err := decodeBlockHandle(value)
+		if err != nil {
+			return nil, errors.Annotate(err, "decode the handle of the properties block failed")
+		}
+		if err = r.readProperties(handle); err != nil {
+			return nil, err
+		}
+	}
+	return r, errors.Trace(it.err)
+}
+
+func (r *Reader) readProperties(handle blockHandle) error {
+	block, err := r.readBlock(handle)
+	if err != nil {
+		return errors.Annotate(err, "read the properties block failed")
+	}
+	it, err := newBlockIter(block)
+	if err != nil {
+		return err
+	}
+	for key, value, ok := it.next(); ok; key, value, ok = it.next() {
+		r.properties[string(key)] = value
+	}
+	return errors.Trace(it.err)
+}
+
+// FormatVersion returns the format version of the block based table.
+func (r *Reader) FormatVersion() uint32 {
+	return r.formatVersion
+}
+
+// Properties returns the properties of the table. The numeric properties
+// are encoded as varints.
+func (r *Reader) Properties() map[string][]byte {
+	return r.properties
+}
+
+// stringProperties are the properties of RocksDB whose values are strings,
+// the other properties of RocksDB are numeric.
+var stringProperties = map[string]bool{
+	"rocksdb.column.family.name":    true,
+	"rocksdb.comparator":            true,
+	"rocksdb.compression":           true,
+	"rocksdb.compression_options":   true,
+	"rocksdb.filter.policy":         true,
+	"rocksdb.merge.operator":        true,
+	"rocksdb.prefix.extractor.name": true,
+	"rocksdb.property.collectors":   true,
+}
+
+// FormatProperty formats the value of the property. The properties collected
+// by TiKV are formatted in hex, since they are encoded by TiKV.
+func FormatProperty(name string, value []byte) string {
+	if !strings.HasPrefix(name, "rocksdb.") {
+		return fmt.Sprintf("%x", value)
+	}
+	if stringProperties[name] {
+		return string(value)
+	}
+	if v, n := binary.Uvarint(value); n == len(value) {
+		return strconv.FormatUint(v, 10)
+	}
+	return fmt.Sprintf("%x", value)
+}
+
+// uintProperty returns the numeric property, and whether it exists.
+func (r *Reader) uintProperty(name string) (uint64, bool) {
+	value, ok := r.properties[name]
+	if !ok {
+		return 0, false
+	}
+	v, n := binary.Uvarint(value)
+	return v, n > 0
+}
+
+// NumEntries returns the number of the entries recorded in the properties,
+// and whether it is recorded.
+func (r *Reader) NumEntries() (uint64, bool) {
+	return r.uintProperty(propNumEntries)
+}
+
+// Compression returns the name of the compression recorded in the
+// properties.
+func (r *Reader) Compression() string {
+	return string(r.properties[propCompressionName])
+}
+
+// Iterate calls fn with each entry of the table in order. The entry is
+// reused by the following calls.
+func (r *Reader) Iterate(fn func(*Entry) error) error {
+	indexType, _ := r.uintProperty(propIndexType)
+	if indexType == indexTypeTwoLevel {
+		return errors.New("partitioned index is not supported")
+	}
+	deltaValue, _ := r.uintProperty(propIndexValueIsDelta)
+	block, err := r.readBlock(r.index)
+	if err != nil {
+		return errors.Annotate(err, "read the index block failed")
+	}
+	handles, err := decodeIndex(block, deltaValue != 0, indexType == indexTypeWithFirstKey)
+	if err != nil {
+		return errors.Annotate(err, "decode the index block failed")
+	}
+
+	entry := &Entry{}
+	for _, handle := range handles {
+		block, err := r.readBlock(handle)
+		if err != nil {
+			return errors.Annotatef(err, "read the data block at %d failed", handle.offset)
+		}
+		it, err := newBlockIter(block)
+		if err != nil {
+			return err
+		}
+		for key, value, ok := it.next(); ok; key, value, ok = it.next() {
+			if len(key) < internalKeyTrailerSize {
+				return errors.Errorf("invalid internal key %x", key)
+			}
+			trailer := binary.LittleEndian.Uint64(key[len(key)-internalKeyTrailerSize:])
+			entry.Key = key[:len(key)-internalKeyTrailerSize]
+			entry.Seq = trailer >> 8
+			entry.Type = byte(trailer)
+			entry.Value = value
+			if err = fn(entry); err != nil {
+				return err
+			}
+		}
+		if it.err != nil {
+			return errors.Annotatef(it.err, "decode the data block at %d failed", handle.offset)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package sst
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	. "github.com/pingcap/check"
+)
+
+func TestT(t *testing.T) {
+	TestingT(t)
+}
+
+type testTableSuite struct{}
+
+var _ = Suite(&testTableSuite{})
+
+// testTable builds the SST files of the tests. The data blocks have one
+// entry per restart point, and entriesPerBlock entries.
+type testTable struct {
+	buf             bytes.Buffer
+	compression     byte
+	deltaIndex      bool
+	legacy          bool
+	entriesPerBlock int
+}
+
+func encodeBlock(keys, values [][]byte) []byte {
+	var buf bytes.Buffer
+	restarts := make([]uint32, 0, len(keys))
+	for i := range keys {
+		restarts = append(restarts, uint32(buf.Len()))
+		buf.Write(appendUvarints(nil, 0, uint64(len(keys[i])), uint64(len(values[i]))))
+		buf.Write(keys[i])
+		buf.Write(values[i])
+	}
+	if len(restarts) == 0 {
+		restarts = append(restarts, 0)
+	}
+	for _, restart := range restarts {
+		binary.Write(&buf, binary.LittleEndian, restart)
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(len(restarts)))
+	return buf.Bytes()
+}
+
+func appendUvarints(b []byte, values ...uint64) []byte {
+	for _, v := range values {
+		var tmp [binary.MaxVarintLen64]byte
+		b = append(b, tmp[:binary.PutUvarint(tmp[:], v)]...)
+	}
+	return b
+}
+
+// encodeLZ4Literals encodes the data as an LZ4 block of only the literals.
+func encodeLZ4Literals(data []byte) []byte {
+	if len(data) < 0xf {
+		return append([]byte{byte(len(data) << 4)}, data...)
+	}
+	block := []byte{0xf0}
+	n := len(data) - 0xf
+	for ; n >= 0xff; n -= 0xff {
+		block = append(block, 0xff)
+	}
+	return append(append(block, byte(n)), data...)
+}
+
+func (t *testTable) compress(c *C, data []byte, compression byte) []byte {
+	prefix := appendUvarints(nil, uint64(len(data)))
+	switch compression {
+	case noCompression:
+		return data
+	case snappyCompression:
+		return snappy.Encode(nil, data)
+	case zlibCompression:
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, flate.BestSpeed)
+		c.Assert(err, IsNil)
+		_, err = w.Write(data)
+		c.Assert(err, IsNil)
+		c.Assert(w.Close(), IsNil)
+		return append(prefix, buf.Bytes()...)
+	case lz4Compression:
+		return append(prefix, encodeLZ4Literals(data)...)
+	case zstdCompression:
+		encoder, err := zstd.NewWriter(nil)
+		c.Assert(err, IsNil)
+		return encoder.EncodeAll(data, prefix)
+	}
+	c.Fatalf("unknown compression %d", compression)
+	return nil
+}
+
+func (t *testTable) writeBlock(c *C, data []byte, compression byte) blockHandle {
+	data = t.compress(c, data, compression)
+	handle := blockHandle{offset: uint64(t.buf.Len()), size: uint64(len(data))}
+	t.buf.Write(data)
+	crc := crc32.Update(crc32.Checksum(data, crc32cTable), crc32cTable, []byte{compression})
+	t.buf.WriteByte(compression)
+	binary.Write(&t.buf, binary.LittleEndian, (crc>>15|crc<<17)+crc32cMaskDelta)
+	return handle
+}
+
+func encodeHandle(handle blockHandle) []byte {
+	return appendUvarints(nil, handle.offset, handle.size)
+}
+
+// encodeDeltaIndex encodes the index block with the delta encoded values,
+// and the restart interval of 2.
+func encodeDeltaIndex(keys [][]byte, handles []blockHandle) []byte {
+	var buf bytes.Buffer
+	restarts := make([]uint32, 0)
+	for i := range keys {
+		if i%2 == 0 {
+			restarts = append(restarts, uint32(buf.Len()))
+			buf.Write(appendUvarints(nil, 0, uint64(len(keys[i]))))
+			buf.Write(keys[i])
+			buf.Write(encodeHandle(handles[i]))
+			continue
+		}
+		// The keys of the tests share the first byte.
+		buf.Write(appendUvarints(nil, 1, uint64(len(keys[i])-1)))
+		buf.Write(keys[i][1:])
+		var tmp [binary.MaxVarintLen64]byte
+		buf.Write(tmp[:binary.PutVarint(tmp[:], int64(handles[i].size)-int64(handles[i-1].size))])
+	}
+	for _, restart := range restarts {
+		binary.Write(&buf, binary.LittleEndian, restart)
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(len(restarts)))
+	return buf.Bytes()
+}
+
+func internalKey(key []byte, seq uint64, tp byte) []byte {
+	var trailer [internalKeyTrailerSize]byte
+	binary.LittleEndian.PutUint64(trailer[:], seq<<8|uint64(tp))
+	return append(append([]byte{}, key...), trailer[:]...)
+}
+
+// build builds an SST file of the sorted keys and values.
+func (t *testTable) build(c *C, keys, values [][]byte) []byte {
+	t.buf.Reset()
+	indexKeys := make([][]byte, 0)
+	handles := make([]blockHandle, 0)
+	for i := 0; i < len(keys); i += t.entriesPerBlock {
+		end := i + t.entriesPerBlock
+		if end > len(keys) {
+			end = len(keys)
+		}
+		blockKeys := make([][]byte, 0, end-i)
+		for j := i; j < end; j++ {
+			blockKeys = append(blockKeys, internalKey(keys[j], uint64(j), TypeValue))
+		}
+		handles = append(handles, t.writeBlock(c, encodeBlock(blockKeys, values[i:end]), t.compression))
+		indexKeys = append(indexKeys, blockKeys[len(blockKeys)-1])
+	}
+
+	propNames := []string{propIndexType, propIndexValueIsDelta, propCompressionName, propNumEntries, "tikv.test"}
+	propValues := [][]byte{
+		appendUvarints(nil, 0),
+		appendUvarints(nil, 0),
+		[]byte("Snappy"),
+		appendUvarints(nil, uint64(len(keys))),
+		{0xff},
+	}
+	if t.deltaIndex {
+		propValues[1] = appendUvarints(nil, 1)
+	}
+	properties := t.writeBlock(c, encodeBlock(stringsToBytes(propNames), propValues), noCompression)
+	metaIndex := t.writeBlock(c, encodeBlock(
+		[][]byte{[]byte(propertiesBlock)}, [][]byte{encodeHandle(properties)}), noCompression)
+
+	var index []byte
+	if t.deltaIndex {
+		index = encodeDeltaIndex(indexKeys, handles)
+	} else {
+		values := make([][]byte, 0, len(handles))
+		for _, handle := range handles {
+			values = append(values, encodeHandle(handle))
+		}
+		index = encodeBlock(indexKeys, values)
+	}
+	indexHandle := t.writeBlock(c, index, noCompression)
+
+	handlesData := append(encodeHandle(metaIndex), encodeHandle(indexHandle)...)
+	if t.legacy {
+		footer := make([]byte, legacyFooterSize)
+		copy(footer, handlesData)
+		binary.LittleEndian.PutUint64(footer[legacyFooterSize-8:], legacyBlockBasedTableMagic)
+		t.buf.Write(footer)
+	} else {
+		footer := make([]byte, footerSize)
+		footer[0] = crc32cChecksumType
+		copy(footer[1:], handlesData)
+		binary.LittleEndian.PutUint32(footer[footerSize-12:], 2)
+		binary.LittleEndian.PutUint64(footer[footerSize-8:], blockBasedTableMagic)
+		t.buf.Write(footer)
+	}
+	return append([]byte{}, t.buf.Bytes()...)
+}
+
+func stringsToBytes(strs []string) [][]byte {
+	result := make([][]byte, 0, len(strs))
+	for _, s := range strs {
+		result = append(result, []byte(s))
+	}
+	return result
+}
+
+func testKeyValues(n int) ([][]byte, [][]byte) {
+	keys := make([][]byte, 0, n)
+	values := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("k%03d", i)))
+		values = append(values, bytes.Repeat([]byte{byte(i)}, i))
+	}
+	return keys, values
+}
+
+func (r *testTableSuite) TestReadTable(c *C) {
+	keys, values := testKeyValues(25)
+	tables := []*testTable{
+		{compression: noCompression, entriesPerBlock: 4},
+		{compression: snappyCompression, entriesPerBlock: 3, deltaIndex: true},
+		{compression: zlibCompression, entriesPerBlock: 5},
+		{compression: lz4Compression, entriesPerBlock: 7, deltaIndex: true},
+		{compression: zstdCompression, entriesPerBlock: 25},
+		{compression: snappyCompression, entriesPerBlock: 2, legacy: true},
+	}
+	for _, table := range tables {
+		comment := Commentf("table %+v", table)
+		reader, err := NewReader(table.build(c, keys, values))
+		c.Assert(err, IsNil, comment)
+		n, ok := reader.NumEntries()
+		c.Assert(ok, IsTrue, comment)
+		c.Assert(n, Equals, uint64(25), comment)
+		c.Assert(reader.Compression(), Equals, "Snappy", comment)
+
+		i := 0
+		err = reader.Iterate(func(entry *Entry) error {
+			c.Assert(entry.Key, DeepEquals, keys[i], comment)
+			c.Assert(entry.Value, DeepEquals, values[i], comment)
+			c.Assert(entry.Seq, Equals, uint64(i), comment)
+			c.Assert(entry.Type, Equals, byte(TypeValue), comment)
+			i++
+			return nil
+		})
+		c.Assert(err, IsNil, comment)
+		c.Assert(i, Equals, 25, comment)
+	}
+
+	properties := map[string]string{}
+	reader, err := NewReader(tables[0].build(c, keys, values))
+	c.Assert(err, IsNil)
+	for name, value := range reader.Properties() {
+		properties[name] = FormatProperty(name, value)
+	}
+	c.Assert(properties, DeepEquals, map[string]string{
+		propIndexType:         "0",
+		propIndexValueIsDelta: "0",
+		propCompressionName:   "Snappy",
+		propNumEntries:        "25",
+		"tikv.test":           "ff",
+	})
+}
+
+func (r *testTableSuite) TestCorruptedTable(c *C) {
+	keys, values := testKeyValues(10)
+	table := &testTable{compression: snappyCompression, entriesPerBlock: 4}
+	data := table.build(c, keys, values)
+
+	// The checksum of the data block mismatches.
+	corrupted := append([]byte{}, data...)
+	corrupted[1] ^= 0xff
+	reader, err := NewReader(corrupted)
+	c.Assert(err, IsNil)
+	err = reader.Iterate(func(*Entry) error { return nil })
+	c.Assert(err, ErrorMatches, "read the data block at 0 failed: block at 0 is corrupted.*")
+
+	// The xxHash checksums are not supported.
+	corrupted = append([]byte{}, data...)
+	corrupted[len(corrupted)-footerSize] = 2
+	_, err = NewReader(corrupted)
+	c.Assert(err, ErrorMatches, "checksum type 2 is not supported")
+
+	_, err = NewReader(data[:len(data)-1])
+	c.Assert(err, ErrorMatches, "invalid magic number.*")
+	_, err = NewReader(data[:10])
+	c.Assert(err, ErrorMatches, "file size 10 is too small.*")
+
+	// The errors of the callback are returned.
+	reader, err = NewReader(data)
+	c.Assert(err, IsNil)
+	err = reader.Iterate(func(*Entry) error { return fmt.Errorf("stop") })
+	c.Assert(err, ErrorMatches, "stop")
+}
+
+func (r *testTableSuite) TestDecodeLZ4Block(c *C) {
+	// "abc" and a match of 9 bytes at the offset 3, followed by "xyz".
+	src := []byte{0x35, 'a', 'b', 'c', 3, 0, 0x30, 'x', 'y', 'z'}
+	dst := make([]byte, 15)
+	c.Assert(decodeLZ4Block(dst, src), IsNil)
+	c.Assert(string(dst), Equals, "abcabcabcabcxyz")
+
+	long := bytes.Repeat([]byte("0123456789"), 40)
+	dst = make([]byte, len(long))
+	c.Assert(decodeLZ4Block(dst, encodeLZ4Literals(long)), IsNil)
+	c.Assert(dst, DeepEquals, long)
+
+	c.Assert(decodeLZ4Block(make([]byte, 14), src), NotNil)
+	c.Assert(decodeLZ4Block(make([]byte, 15), []byte{0x35, 'a', 'b', 'c', 4, 0}), ErrorMatches, "invalid match offset 4")
+}
+
+func (r *testTableSuite) TestDecompressSize(c *C) {
+	reader := &Reader{formatVersion: 2}
+	table := &testTable{}
+	data := []byte("abcabcabcabcxyz")
+	for _, compression := range []byte{zlibCompression, lz4Compression, zstdCompression} {
+		comment := Commentf("compression %d", compression)
+		block := table.compress(c, data, compression)
+		_, n := binary.Uvarint(block)
+		for _, size := range []uint64{uint64(len(data)) - 1, uint64(len(data)) + 1, 1 << 40} {
+			corrupted := append(appendUvarints(nil, size), block[n:]...)
+			_, err := reader.decompress(compression, corrupted)
+			c.Assert(err, NotNil, comment)
+		}
+		decoded, err := reader.decompress(compression, block)
+		c.Assert(err, IsNil, comment)
+		c.Assert(decoded, DeepEquals, data, comment)
+	}
+
+	// The size of the lz4 block is bounded by the compressed size.
+	block := append(appendUvarints(nil, 1<<40), encodeLZ4Literals(data)...)
+	_, err := reader.decompress(lz4Compression, block)
+	c.Assert(err, ErrorMatches, "decompressed block size 1099511627776 in the header is too large.*")
+	_, err = reader.decompress(lz4Compression, appendUvarints(nil, 1<<63))
+	c.Assert(err, ErrorMatches, "invalid size of the decompressed block")
+}
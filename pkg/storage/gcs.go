@@ -5,12 +5,14 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
 
 	"cloud.google.com/go/storage"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/backup"
 	"github.com/spf13/pflag"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
@@ -99,9 +101,20 @@ type gcsStorage struct {
 	bucket *storage.BucketHandle
 }
 
+// objectPrefix returns the prefix of the objects of the files, which ends
+// with "/" unless it is empty, so the files are in the directory of the
+// prefix like the files written by TiKV, and the objects of the sibling
+// prefixes are not listed.
+func (s *gcsStorage) objectPrefix() string {
+	if s.gcs.Prefix == "" || strings.HasSuffix(s.gcs.Prefix, "/") {
+		return s.gcs.Prefix
+	}
+	return s.gcs.Prefix + "/"
+}
+
 // Write file to storage
 func (s *gcsStorage) Write(ctx context.Context, name string, data []byte) error {
-	object := s.gcs.Prefix + name
+	object := s.objectPrefix() + name
 	wc := s.bucket.Object(object).NewWriter(ctx)
 	wc.StorageClass = s.gcs.StorageClass
 	wc.PredefinedACL = s.gcs.PredefinedAcl
@@ -114,7 +127,7 @@ func (s *gcsStorage) Write(ctx context.Context, name string, data []byte) error
 
 // Read storage file
 func (s *gcsStorage) Read(ctx context.Context, name string) ([]byte, error) {
-	object := s.gcs.Prefix + name
+	object := s.objectPrefix() + name
 	rc, err := s.bucket.Object(object).NewReader(ctx)
 	if err != nil {
 		return nil, err
@@ -128,7 +141,7 @@ func (s *gcsStorage) Read(ctx context.Context, name string) ([]byte, error) {
 
 // FileExists return true if file exists
 func (s *gcsStorage) FileExists(ctx context.Context, name string) (bool, error) {
-	object := s.gcs.Prefix + name
+	object := s.objectPrefix() + name
 	_, err := s.bucket.Object(object).Attrs(ctx)
 	if err != nil {
 		if err == storage.ErrObjectNotExist {
@@ -139,6 +152,40 @@ func (s *gcsStorage) FileExists(ctx context.Context, name string) (bool, error)
 	return true, nil
 }
 
+// DeleteFile deletes the file
+func (s *gcsStorage) DeleteFile(ctx context.Context, name string) error {
+	object := s.objectPrefix() + name
+	err := s.bucket.Object(object).Delete(ctx)
+	if err != nil && err != storage.ErrObjectNotExist {
+		return err
+	}
+	return nil
+}
+
+// Open opens the file for streaming reads
+func (s *gcsStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	object := s.objectPrefix() + name
+	return s.bucket.Object(object).NewReader(ctx)
+}
+
+// WalkDir walks the files under the prefix
+func (s *gcsStorage) WalkDir(ctx context.Context, fn func(name string, size int64) error) error {
+	prefix := s.objectPrefix()
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(strings.TrimPrefix(attrs.Name, prefix), attrs.Size); err != nil {
+			return err
+		}
+	}
+}
+
 func newGCSStorage(ctx context.Context, gcs *backup.GCS, sendCredential bool) (*gcsStorage, error) {
 	return newGCSStorageWithHTTPClient(ctx, gcs, nil, sendCredential)
 }
@@ -52,6 +52,71 @@ func (r *testStorageSuite) TestGCS(c *C) {
 	exist, err = stg.FileExists(ctx, "key_not_exist")
 	c.Assert(err, IsNil)
 	c.Assert(exist, IsFalse)
+
+	sr, err := stg.Open(ctx, "key")
+	c.Assert(err, IsNil)
+	d, err = ioutil.ReadAll(sr)
+	sr.Close()
+	c.Assert(err, IsNil)
+	c.Assert(d, DeepEquals, []byte("data"))
+
+	err = stg.Write(ctx, "dir/key2", []byte("data2"))
+	c.Assert(err, IsNil)
+	files := make(map[string]int64)
+	err = stg.WalkDir(ctx, func(name string, size int64) error {
+		files[name] = size
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(files, DeepEquals, map[string]int64{"key": 4, "dir/key2": 5})
+
+	err = stg.DeleteFile(ctx, "key")
+	c.Assert(err, IsNil)
+	exist, err = stg.FileExists(ctx, "key")
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsFalse)
+	err = stg.DeleteFile(ctx, "key")
+	c.Assert(err, IsNil)
+}
+
+func (r *testStorageSuite) TestGCSWalkDirPrefix(c *C) {
+	ctx := context.Background()
+
+	opts := fakestorage.Options{
+		NoListener: true,
+	}
+	server, err := fakestorage.NewServerWithOptions(opts)
+	c.Assert(err, IsNil)
+	bucketName := "testbucket"
+	server.CreateBucket(bucketName)
+
+	// The prefix without the trailing "/" is a directory, and the objects of
+	// the sibling prefix "a/bc" are not listed.
+	gcs := &backup.GCS{
+		Bucket:          bucketName,
+		Prefix:          "a/b",
+		CredentialsBlob: "Fake Credentials",
+	}
+	stg, err := newGCSStorageWithHTTPClient(ctx, gcs, server.HTTPClient(), false)
+	c.Assert(err, IsNil)
+	err = stg.Write(ctx, "key", []byte("data"))
+	c.Assert(err, IsNil)
+	_, err = server.Client().Bucket(bucketName).Object("a/b/key").Attrs(ctx)
+	c.Assert(err, IsNil)
+
+	sibling := server.Client().Bucket(bucketName).Object("a/bc/key").NewWriter(ctx)
+	_, err = sibling.Write([]byte("sibling"))
+	c.Assert(err, IsNil)
+	c.Assert(sibling.Close(), IsNil)
+
+	files := make(map[string]int64)
+	err = stg.WalkDir(ctx, func(name string, size int64) error {
+		files[name] = size
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(files, DeepEquals, map[string]int64{"key": 4})
+	c.Assert(gcs.Prefix, Equals, "a/b")
 }
 
 func (r *testStorageSuite) TestNewGCSStorage(c *C) {
@@ -2,9 +2,11 @@ package storage
 
 import (
 	"context"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 )
 
 // localStorage represents local file system storage
@@ -28,6 +30,39 @@ func (l *localStorage) FileExists(ctx context.Context, name string) (bool, error
 	return pathExists(filepath)
 }
 
+// DeleteFile implement ExternalStorage.DeleteFile
+func (l *localStorage) DeleteFile(ctx context.Context, name string) error {
+	filepath := path.Join(l.base, name)
+	err := os.Remove(filepath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Open implement ExternalStorage.Open
+func (l *localStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	filepath := path.Join(l.base, name)
+	return os.Open(filepath)
+}
+
+// WalkDir implement ExternalStorage.WalkDir
+func (l *localStorage) WalkDir(ctx context.Context, fn func(name string, size int64) error) error {
+	return filepath.Walk(l.base, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		name, err := filepath.Rel(l.base, path)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(name), info.Size())
+	})
+}
+
 func pathExists(_path string) (bool, error) {
 	_, err := os.Stat(_path)
 	if err != nil {
@@ -1,6 +1,11 @@
 package storage
 
-import "context"
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+)
 
 type noopStorage struct{}
 
@@ -19,6 +24,21 @@ func (*noopStorage) FileExists(ctx context.Context, name string) (bool, error) {
 	return false, nil
 }
 
+// DeleteFile deletes the file
+func (*noopStorage) DeleteFile(ctx context.Context, name string) error {
+	return nil
+}
+
+// Open opens an empty file
+func (*noopStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader("")), nil
+}
+
+// WalkDir walks no file
+func (*noopStorage) WalkDir(ctx context.Context, fn func(name string, size int64) error) error {
+	return nil
+}
+
 func newNoopStorage() *noopStorage {
 	return &noopStorage{}
 }
@@ -3,8 +3,10 @@ package storage
 import (
 	"bytes"
 	"context"
+	"io"
 	"io/ioutil"
 	"net/url"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -37,6 +39,9 @@ type s3Handlers interface {
 	PutObjectWithContext(context.Context, *s3.PutObjectInput, ...request.Option) (*s3.PutObjectOutput, error)
 	HeadBucketWithContext(context.Context, *s3.HeadBucketInput, ...request.Option) (*s3.HeadBucketOutput, error)
 	WaitUntilObjectExistsWithContext(context.Context, *s3.HeadObjectInput, ...request.WaiterOption) error
+	DeleteObjectWithContext(context.Context, *s3.DeleteObjectInput, ...request.Option) (*s3.DeleteObjectOutput, error)
+	ListObjectsV2PagesWithContext(context.Context, *s3.ListObjectsV2Input,
+		func(*s3.ListObjectsV2Output, bool) bool, ...request.Option) error
 }
 
 // S3Storage info for s3 storage
@@ -283,3 +288,48 @@ func (rs *S3Storage) FileExists(ctx context.Context, file string) (bool, error)
 
 	return true, err
 }
+
+// DeleteFile deletes the file from s3 storage
+func (rs *S3Storage) DeleteFile(ctx context.Context, file string) error {
+	input := &s3.DeleteObjectInput{
+		Bucket: aws.String(rs.options.Bucket),
+		Key:    aws.String(rs.options.Prefix + file),
+	}
+	_, err := rs.svc.DeleteObjectWithContext(ctx, input)
+	return err
+}
+
+// Open opens the file on s3 storage for streaming reads
+func (rs *S3Storage) Open(ctx context.Context, file string) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(rs.options.Bucket),
+		Key:    aws.String(rs.options.Prefix + file),
+	}
+	result, err := rs.svc.GetObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return result.Body, nil
+}
+
+// WalkDir walks the files under the prefix of s3 storage
+func (rs *S3Storage) WalkDir(ctx context.Context, fn func(name string, size int64) error) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(rs.options.Bucket),
+		Prefix: aws.String(rs.options.Prefix),
+	}
+	var walkErr error
+	err := rs.svc.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, object := range page.Contents {
+			name := strings.TrimPrefix(aws.StringValue(object.Key), rs.options.Prefix)
+			if walkErr = fn(name, aws.Int64Value(object.Size)); walkErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return walkErr
+}
@@ -391,6 +391,23 @@ func (r *testStorageSuite) TestS3Handlers(c *C) {
 		if err != nil {
 			c.Assert(err, Equals, test.mh.err)
 		}
+		err = ms3.DeleteFile(ctx, "file")
+		c.Assert(err, Equals, test.mh.err)
+		rc, err := ms3.Open(ctx, "file")
+		c.Assert(err, Equals, test.mh.err)
+		if err == nil {
+			rc.Close()
+		}
+		names := make([]string, 0)
+		err = ms3.WalkDir(ctx, func(name string, size int64) error {
+			names = append(names, name)
+			c.Assert(size, Equals, int64(len(name)))
+			return nil
+		})
+		c.Assert(err, Equals, test.mh.err)
+		if err == nil {
+			c.Assert(names, DeepEquals, []string{"a", "b/c"})
+		}
 	}
 	tests := []testcase{
 		{
@@ -479,3 +496,24 @@ func (c *mockS3Handler) WaitUntilObjectExistsWithContext(ctx context.Context,
 	input *s3.HeadObjectInput, opts ...request.WaiterOption) error {
 	return c.err
 }
+func (c *mockS3Handler) DeleteObjectWithContext(ctx context.Context,
+	input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	return nil, c.err
+}
+func (c *mockS3Handler) ListObjectsV2PagesWithContext(ctx context.Context,
+	input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+	if c.err != nil {
+		return c.err
+	}
+	prefix := aws.StringValue(input.Prefix)
+	for _, name := range []string{"a", "b/c"} {
+		page := &s3.ListObjectsV2Output{Contents: []*s3.Object{{
+			Key:  aws.String(prefix + name),
+			Size: aws.Int64(int64(len(name))),
+		}}}
+		if !fn(page, name == "b/c") {
+			break
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"io"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/backup"
@@ -15,6 +16,13 @@ type ExternalStorage interface {
 	Read(ctx context.Context, name string) ([]byte, error)
 	// FileExists return true if file exists
 	FileExists(ctx context.Context, name string) (bool, error)
+	// DeleteFile deletes the file, it is not an error if the file doesn't exist
+	DeleteFile(ctx context.Context, name string) error
+	// Open opens the file for streaming reads
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	// WalkDir calls fn with the name and the size of each file in the storage,
+	// the names are relative to the storage
+	WalkDir(ctx context.Context, fn func(name string, size int64) error) error
 }
 
 // Create creates ExternalStorage
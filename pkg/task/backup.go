@@ -2,9 +2,11 @@ package task
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/log"
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/tidb-tools/pkg/filter"
@@ -18,8 +20,17 @@ import (
 )
 
 const (
-	flagBackupTimeago = "timeago"
-	flagLastBackupTS  = "lastbackupts"
+	flagBackupTimeago      = "timeago"
+	flagLastBackupTS       = "lastbackupts"
+	flagIncludeStoreLabels = "include-store-labels"
+	flagExcludeStoreLabels = "exclude-store-labels"
+
+	flagFineGrainedConcurrency = "fine-grained-concurrency"
+	flagRangeConcurrency       = "range-concurrency"
+	flagResolveLocks           = "resolve-locks"
+
+	flagBackupMetaVersion   = "backupmeta-version"
+	flagBackupMetaShardSize = "backupmeta-shard-size"
 )
 
 // BackupConfig is the configuration specific for backup tasks.
@@ -28,6 +39,25 @@ type BackupConfig struct {
 
 	TimeAgo      time.Duration `json:"time-ago" toml:"time-ago"`
 	LastBackupTS uint64        `json:"last-backup-ts" toml:"last-backup-ts"`
+	// IncludeStoreLabels and ExcludeStoreLabels select the stores to backup by
+	// the labels in the format of "key=value".
+	IncludeStoreLabels []string `json:"include-store-labels" toml:"include-store-labels"`
+	ExcludeStoreLabels []string `json:"exclude-store-labels" toml:"exclude-store-labels"`
+	// FineGrainedConcurrency is the number of the regions retried
+	// concurrently after the stores fail to backup some ranges.
+	FineGrainedConcurrency uint `json:"fine-grained-concurrency" toml:"fine-grained-concurrency"`
+	// RangeConcurrency is the number of the ranges backed up concurrently.
+	// The rate limit and the concurrency are divided among them.
+	RangeConcurrency uint `json:"range-concurrency" toml:"range-concurrency"`
+	// ResolveLocks scans and resolves the stale locks in the backup ranges
+	// before backup.
+	ResolveLocks bool `json:"resolve-locks" toml:"resolve-locks"`
+	// MetaVersion is the version of the backup meta, MetaV2 splits the files
+	// and the schemas into the shards of MetaShardSize entries.
+	MetaVersion   int `json:"backupmeta-version" toml:"backupmeta-version"`
+	MetaShardSize int `json:"backupmeta-shard-size" toml:"backupmeta-shard-size"`
+	// DryRun plans the backup without backing up anything.
+	DryRun bool `json:"dry-run" toml:"dry-run"`
 }
 
 // DefineBackupFlags defines common flags for the backup command.
@@ -38,6 +68,29 @@ func DefineBackupFlags(flags *pflag.FlagSet) {
 
 	flags.Uint64(flagLastBackupTS, 0, "the last time backup ts")
 	_ = flags.MarkHidden(flagLastBackupTS)
+
+	flags.StringSlice(flagIncludeStoreLabels, nil,
+		`Only backup the stores with any of the labels, e.g. "zone=z1,zone=z2"`)
+	flags.StringSlice(flagExcludeStoreLabels, nil,
+		`Do not backup the stores with any of the labels, e.g. "disk=hdd"`)
+
+	flags.Uint(flagFineGrainedConcurrency, backup.DefaultFineGrainedConcurrency,
+		"The number of the regions retried concurrently in fine grained backup")
+	flags.Uint(flagRangeConcurrency, backup.DefaultRangeConcurrency,
+		"The number of the ranges backed up concurrently, "+
+			"the rate limit and the concurrency of each store are divided among them")
+	flags.Bool(flagResolveLocks, false,
+		"Resolve the stale locks older than the backup ts in the backup ranges before backup")
+
+	flags.Int(flagBackupMetaVersion, utils.MetaV1,
+		"The version of the backup meta, version 2 splits the files and the schemas into multiple meta files "+
+			"for large backups, which can't be read by the old versions of BR")
+	flags.Int(flagBackupMetaShardSize, utils.DefaultMetaShardSize,
+		"The number of the files or the schemas in a meta file of the backup meta version 2")
+	_ = flags.MarkHidden(flagBackupMetaShardSize)
+
+	flags.Bool(FlagDryRun, false,
+		"Print the tables, ranges and the estimated size to backup without backing up anything")
 }
 
 // ParseFromFlags parses the backup-related flags from the flag set.
@@ -54,6 +107,50 @@ func (cfg *BackupConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	cfg.IncludeStoreLabels, err = flags.GetStringSlice(flagIncludeStoreLabels)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.ExcludeStoreLabels, err = flags.GetStringSlice(flagExcludeStoreLabels)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.FineGrainedConcurrency, err = flags.GetUint(flagFineGrainedConcurrency)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.FineGrainedConcurrency == 0 {
+		return errors.New("fine grained concurrency must be positive")
+	}
+	cfg.RangeConcurrency, err = flags.GetUint(flagRangeConcurrency)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.RangeConcurrency == 0 {
+		return errors.New("range concurrency must be positive")
+	}
+	cfg.ResolveLocks, err = flags.GetBool(flagResolveLocks)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.MetaVersion, err = flags.GetInt(flagBackupMetaVersion)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.MetaVersion != utils.MetaV1 && cfg.MetaVersion != utils.MetaV2 {
+		return errors.Errorf("unsupported backupmeta version %d", cfg.MetaVersion)
+	}
+	cfg.MetaShardSize, err = flags.GetInt(flagBackupMetaShardSize)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.MetaShardSize <= 0 {
+		return errors.New("backupmeta shard size must be positive")
+	}
+	cfg.DryRun, err = flags.GetBool(FlagDryRun)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	if err = cfg.Config.ParseFromFlags(flags); err != nil {
 		return errors.Trace(err)
 	}
@@ -73,7 +170,14 @@ func RunBackup(c context.Context, cmdName string, cfg *BackupConfig) error {
 	if err != nil {
 		return err
 	}
-	mgr, err := newMgr(ctx, cfg.PD)
+	var storeFilter backup.StoreFilter
+	if storeFilter.Include, err = parseStoreLabels(cfg.IncludeStoreLabels); err != nil {
+		return err
+	}
+	if storeFilter.Exclude, err = parseStoreLabels(cfg.ExcludeStoreLabels); err != nil {
+		return err
+	}
+	mgr, err := newMgr(ctx, cfg.PD, true)
 	if err != nil {
 		return err
 	}
@@ -83,9 +187,21 @@ func RunBackup(c context.Context, cmdName string, cfg *BackupConfig) error {
 	if err != nil {
 		return err
 	}
+	client.SetMetaVersion(cfg.MetaVersion, cfg.MetaShardSize)
 	if err = client.SetStorage(ctx, u, cfg.SendCreds); err != nil {
 		return err
 	}
+	info, err := currentBackupInfo(ctx, mgr)
+	if err != nil {
+		return err
+	}
+	if info.Settings, err = mgr.GetGlobalVariables(utils.CompatibilitySettings); err != nil {
+		return err
+	}
+	client.SetBackupInfo(info)
+	client.SetStoreFilter(storeFilter)
+	client.SetFineGrainedConcurrency(cfg.FineGrainedConcurrency)
+	client.SetRangeConcurrency(cfg.RangeConcurrency)
 
 	backupTS, err := client.GetTS(ctx, cfg.TimeAgo)
 	if err != nil {
@@ -95,7 +211,7 @@ func RunBackup(c context.Context, cmdName string, cfg *BackupConfig) error {
 	defer summary.Summary(cmdName)
 
 	ranges, backupSchemas, err := backup.BuildBackupRangeAndSchema(
-		mgr.GetDomain(), mgr.GetTiKV(), tableFilter, backupTS)
+		mgr.GetDomain(), mgr.GetTiKV(), tableFilter, cfg.Partitions, backupTS)
 	if err != nil {
 		return err
 	}
@@ -126,6 +242,17 @@ func RunBackup(c context.Context, cmdName string, cfg *BackupConfig) error {
 
 	summary.CollectInt("backup total regions", approximateRegions)
 
+	if cfg.ResolveLocks {
+		var lockStats backup.LockStats
+		lockStats, err = client.ResolveLocks(ctx, ranges, backupTS)
+		if err != nil {
+			return err
+		}
+		summary.CollectInt("backup scanned locks", lockStats.Locks)
+		summary.CollectInt("backup resolved locks", lockStats.Resolved)
+		summary.CollectInt("backup pending locks", lockStats.Pending)
+	}
+
 	// Backup
 	// Redirect to log if there is no log file to avoid unreadable output.
 	updateCh := utils.StartProgress(
@@ -135,6 +262,7 @@ func RunBackup(c context.Context, cmdName string, cfg *BackupConfig) error {
 	if err != nil {
 		return err
 	}
+	summary.CollectInt("backup met locks", client.MetLocks())
 	// Backup has finished
 	close(updateCh)
 
@@ -149,26 +277,14 @@ func RunBackup(c context.Context, cmdName string, cfg *BackupConfig) error {
 	backupSchemas.Start(
 		ctx, mgr.GetTiKV(), backupTS, uint(backupSchemasConcurrency), updateCh)
 
-	err = client.CompleteMeta(backupSchemas)
+	// Since we don't support checksum for incremental data, fast checksum should be skipped.
+	if cfg.LastBackupTS != 0 {
+		log.Info("Skip fast checksum in incremental backup")
+	}
+	err = client.CompleteMeta(ctx, backupSchemas, cfg.LastBackupTS == 0)
 	if err != nil {
 		return err
 	}
-
-	if cfg.LastBackupTS == 0 {
-		var valid bool
-		valid, err = client.FastChecksum()
-		if err != nil {
-			return err
-		}
-		if !valid {
-			log.Error("backup FastChecksum mismatch!")
-			return errors.Errorf("mismatched checksum")
-		}
-
-	} else {
-		// Since we don't support checksum for incremental data, fast checksum should be skipped.
-		log.Info("Skip fast checksum in incremental backup")
-	}
 	// Checksum has finished
 	close(updateCh)
 
@@ -178,3 +294,16 @@ func RunBackup(c context.Context, cmdName string, cfg *BackupConfig) error {
 	}
 	return nil
 }
+
+// parseStoreLabels parses the store labels in the format of "key=value".
+func parseStoreLabels(labels []string) ([]*metapb.StoreLabel, error) {
+	storeLabels := make([]*metapb.StoreLabel, 0, len(labels))
+	for _, label := range labels {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			return nil, errors.Errorf("invalid store label %q, expect \"key=value\"", label)
+		}
+		storeLabels = append(storeLabels, &metapb.StoreLabel{Key: kv[0], Value: kv[1]})
+	}
+	return storeLabels, nil
+}
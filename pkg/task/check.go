@@ -0,0 +1,449 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/tidb-tools/pkg/filter"
+	"github.com/pingcap/tidb/store/tikv/oracle"
+
+	backupclient "github.com/pingcap/br/pkg/backup"
+	"github.com/pingcap/br/pkg/conn"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	// checkProbeFile is the object written to the storage to check the
+	// permissions, it is deleted after checking.
+	checkProbeFile = "backup.check"
+
+	// capacityWarnRatio is the ratio of the available capacity of the stores,
+	// above which the restored data is warned.
+	capacityWarnRatio = 0.8
+	// maxListedTables is the maximum number of the tables listed in a message.
+	maxListedTables = 10
+)
+
+// CheckStatus is the status of a pre-flight check.
+type CheckStatus int
+
+// The status of the pre-flight checks.
+const (
+	CheckPass CheckStatus = iota
+	CheckWarn
+	CheckFail
+)
+
+func (s CheckStatus) String() string {
+	switch s {
+	case CheckPass:
+		return "PASS"
+	case CheckWarn:
+		return "WARN"
+	case CheckFail:
+		return "FAIL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// CheckItem is the result of a pre-flight check.
+type CheckItem struct {
+	Name    string
+	Status  CheckStatus
+	Message string
+}
+
+// CheckReport is the results of the pre-flight checks of a backup or a
+// restore task.
+type CheckReport struct {
+	Items []CheckItem
+}
+
+func (r *CheckReport) add(status CheckStatus, name, format string, args ...interface{}) {
+	r.Items = append(r.Items, CheckItem{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *CheckReport) pass(name, format string, args ...interface{}) {
+	r.add(CheckPass, name, format, args...)
+}
+
+func (r *CheckReport) warn(name, format string, args ...interface{}) {
+	r.add(CheckWarn, name, format, args...)
+}
+
+func (r *CheckReport) fail(name, format string, args ...interface{}) {
+	r.add(CheckFail, name, format, args...)
+}
+
+// Failed returns whether any check fails.
+func (r *CheckReport) Failed() bool {
+	for _, item := range r.Items {
+		if item.Status == CheckFail {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the report as a line per check followed by the totals.
+func (r *CheckReport) String() string {
+	var buf strings.Builder
+	counts := make(map[CheckStatus]int)
+	for _, item := range r.Items {
+		fmt.Fprintf(&buf, "[%s] %s: %s\n", item.Status, item.Name, item.Message)
+		counts[item.Status]++
+	}
+	fmt.Fprintf(&buf, "%d passed, %d warned, %d failed",
+		counts[CheckPass], counts[CheckWarn], counts[CheckFail])
+	return buf.String()
+}
+
+// RunBackupCheck checks whether the backup task can run, without backing up
+// anything. The error is returned only if the checks can't run, the failed
+// checks are in the report.
+func RunBackupCheck(c context.Context, cfg *BackupConfig) (*CheckReport, error) {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	report := &CheckReport{}
+	tableFilter, err := filter.New(cfg.CaseSensitive, &cfg.Filter)
+	if err != nil {
+		return nil, err
+	}
+	mgr, ok := checkCluster(ctx, cfg.PD, true, report)
+	if !ok {
+		return report, nil
+	}
+	defer mgr.Close()
+	checkStores(ctx, mgr, report)
+	checkClusterVersion(ctx, mgr, report)
+
+	// The backup TS is checked against the GC safe point.
+	client, err := backupclient.NewBackupClient(ctx, mgr)
+	if err != nil {
+		return nil, err
+	}
+	backupTS, err := client.GetTS(ctx, cfg.TimeAgo)
+	if err != nil {
+		report.fail("gc safepoint", "%v", err)
+	} else {
+		report.pass("gc safepoint", "backup TS %d (%s) is after the GC safe point",
+			backupTS, oracle.GetTimeFromTS(backupTS))
+	}
+	if cfg.LastBackupTS > 0 {
+		if err = backupclient.CheckGCSafepoint(ctx, mgr.GetPDClient(), cfg.LastBackupTS); err != nil {
+			report.fail("gc safepoint", "last backup TS: %v", err)
+		} else {
+			report.pass("gc safepoint", "last backup TS %d is after the GC safe point", cfg.LastBackupTS)
+		}
+	}
+
+	checkBackupStorage(ctx, &cfg.Config, report)
+
+	if backupTS == 0 {
+		p, l, err := mgr.GetPDClient().GetTS(ctx)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		backupTS = oracle.ComposeTS(p, l)
+	}
+	ranges, schemas, err := backupclient.BuildBackupRangeAndSchema(
+		mgr.GetDomain(), mgr.GetTiKV(), tableFilter, cfg.Partitions, backupTS)
+	switch {
+	case err != nil:
+		report.fail("tables", "%v", err)
+	case schemas.Len() == 0:
+		report.warn("tables", "no table matches the filter, nothing will be backed up")
+	default:
+		report.pass("tables", "%d tables in %d ranges will be backed up", schemas.Len(), len(ranges))
+	}
+	return report, nil
+}
+
+// RunRestoreCheck checks whether the restore task can run, without
+// restoring anything. The error is returned only if the checks can't run,
+// the failed checks are in the report.
+func RunRestoreCheck(c context.Context, cfg *RestoreConfig) (*CheckReport, error) {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	report := &CheckReport{}
+	if _, err := filter.New(cfg.CaseSensitive, &cfg.Filter); err != nil {
+		return nil, err
+	}
+	mgr, ok := checkCluster(ctx, cfg.PD, len(cfg.TiDBDSN) == 0, report)
+	if !ok {
+		return report, nil
+	}
+	defer mgr.Close()
+	stores := checkStores(ctx, mgr, report)
+	checkClusterVersion(ctx, mgr, report)
+
+	reader, ok := checkRestoreStorage(ctx, &cfg.Config, report)
+	if !ok {
+		return report, nil
+	}
+	db, err := newRestoreDB(ctx, mgr, cfg)
+	if err != nil {
+		report.fail("tidb", "%v", err)
+		return report, nil
+	}
+	defer db.Close()
+
+	issues, err := restoreCompatibility(ctx, mgr, db, reader)
+	if err != nil {
+		report.fail("compatibility", "%v", err)
+	}
+	for _, issue := range issues {
+		if issue.Level == utils.CompatRefuse && !cfg.IgnoreIncompatibility {
+			report.fail("compatibility", "%s", issue.Message)
+		} else {
+			report.warn("compatibility", "%s", issue.Message)
+		}
+	}
+	if err == nil && len(issues) == 0 {
+		report.pass("compatibility", "the backup is compatible with the current BR and cluster")
+	}
+
+	databases, err := utils.ReadBackupTables(ctx, reader)
+	if err != nil {
+		report.fail("storage", "%v", err)
+		return report, nil
+	}
+	tables, err := filterTables(sortedDatabases(databases), &cfg.Config)
+	if err != nil {
+		report.fail("tables", "%v", err)
+		return report, nil
+	}
+	checkRestoreTables(ctx, db, tables, report)
+	checkCapacity(ctx, mgr, stores, tables, report)
+	return report, nil
+}
+
+// checkCluster checks whether PD and TiKV are reachable. The version of the
+// cluster is also checked by the mgr.
+func checkCluster(ctx context.Context, pds []string, needDomain bool, report *CheckReport) (*conn.Mgr, bool) {
+	mgr, err := newMgr(ctx, pds, needDomain)
+	if err != nil {
+		report.fail("cluster", "%v", err)
+		return nil, false
+	}
+	report.pass("cluster", "PD %s is reachable", strings.Join(pds, ","))
+	return mgr, true
+}
+
+// checkStores checks the states of the stores, and returns the up TiKV
+// stores.
+func checkStores(ctx context.Context, mgr *conn.Mgr, report *CheckReport) []*metapb.Store {
+	stores, err := conn.GetAllTiKVStores(ctx, mgr.GetPDClient())
+	if err != nil {
+		report.fail("stores", "%v", err)
+		return nil
+	}
+	upStores := make([]*metapb.Store, 0, len(stores))
+	notUp := make([]string, 0)
+	for _, store := range stores {
+		if store.GetState() != metapb.StoreState_Up {
+			notUp = append(notUp, fmt.Sprintf("%d(%s)", store.GetId(), store.GetState()))
+			continue
+		}
+		upStores = append(upStores, store)
+	}
+	switch {
+	case len(upStores) == 0:
+		report.fail("stores", "no TiKV store is up")
+	case len(notUp) > 0:
+		report.warn("stores", "%d TiKV stores are up, stores %s are not up",
+			len(upStores), strings.Join(notUp, ", "))
+	default:
+		report.pass("stores", "%d TiKV stores are up", len(upStores))
+	}
+	return upStores
+}
+
+func checkClusterVersion(ctx context.Context, mgr *conn.Mgr, report *CheckReport) {
+	version, err := mgr.GetClusterVersion(ctx)
+	if err != nil {
+		report.fail("cluster version", "%v", err)
+		return
+	}
+	if _, err = utils.ParseVersion(version); err != nil {
+		report.warn("cluster version", "%v", err)
+		return
+	}
+	if err = utils.CheckClusterVersion(version); err != nil {
+		report.fail("cluster version", "%v", err)
+		return
+	}
+	report.pass("cluster version", "%s is supported", version)
+}
+
+// checkStorage creates the storage, and warns the local storage, which is on
+// each TiKV node rather than shared.
+func checkStorage(ctx context.Context, cfg *Config, report *CheckReport) (storage.ExternalStorage, bool) {
+	u, err := storage.ParseBackend(cfg.Storage, &cfg.BackendOptions)
+	if err != nil {
+		report.fail("storage", "%v", err)
+		return nil, false
+	}
+	s, err := storage.Create(ctx, u, cfg.SendCreds)
+	if err != nil {
+		report.fail("storage", "%v", err)
+		return nil, false
+	}
+	if _, ok := u.Backend.(*backup.StorageBackend_Local); ok {
+		report.warn("storage", "the local storage is only checked on this node, "+
+			"make sure the path is accessible on every TiKV node")
+	}
+	return s, true
+}
+
+// checkBackupStorage checks that the storage is writable, and doesn't contain
+// a backup yet.
+func checkBackupStorage(ctx context.Context, cfg *Config, report *CheckReport) {
+	s, ok := checkStorage(ctx, cfg, report)
+	if !ok {
+		return
+	}
+	exist, err := utils.MetaExists(ctx, s)
+	if err != nil {
+		report.fail("storage", "%v", err)
+		return
+	}
+	if exist {
+		report.fail("storage", "backup meta exists, may be some backup files in the path already")
+		return
+	}
+
+	probe := []byte(fmt.Sprintf("probe from BR %s", utils.BRReleaseVersion))
+	if err = s.Write(ctx, checkProbeFile, probe); err != nil {
+		report.fail("storage", "cannot write %s: %v", checkProbeFile, err)
+		return
+	}
+	defer func() {
+		if err := s.DeleteFile(ctx, checkProbeFile); err != nil {
+			report.warn("storage", "cannot delete %s: %v", checkProbeFile, err)
+		}
+	}()
+	if exist, err = s.FileExists(ctx, checkProbeFile); err != nil || !exist {
+		report.fail("storage", "cannot find the written %s: %v", checkProbeFile, err)
+		return
+	}
+	data, err := s.Read(ctx, checkProbeFile)
+	if err != nil {
+		report.fail("storage", "cannot read %s: %v", checkProbeFile, err)
+		return
+	}
+	if string(data) != string(probe) {
+		report.fail("storage", "%s is corrupted after written", checkProbeFile)
+		return
+	}
+	report.pass("storage", "%s is readable and writable", cfg.Storage)
+}
+
+// checkRestoreStorage checks that the backup meta is readable.
+func checkRestoreStorage(ctx context.Context, cfg *Config, report *CheckReport) (*utils.MetaReader, bool) {
+	s, ok := checkStorage(ctx, cfg, report)
+	if !ok {
+		return nil, false
+	}
+	exist, err := utils.MetaExists(ctx, s)
+	if err != nil {
+		report.fail("storage", "%v", err)
+		return nil, false
+	}
+	if !exist {
+		report.fail("storage", "backup meta doesn't exist in %s", cfg.Storage)
+		return nil, false
+	}
+	reader, err := utils.NewMetaReader(ctx, s)
+	if err != nil {
+		report.fail("storage", "%v", err)
+		return nil, false
+	}
+	report.pass("storage", "backup meta of version %d is readable", reader.Version())
+	return reader, true
+}
+
+// checkRestoreTables checks whether the restored tables exist already.
+func checkRestoreTables(ctx context.Context, db *restore.DB, tables []*utils.Table, report *CheckReport) {
+	if len(tables) == 0 {
+		report.fail("tables", "all tables are filtered out from the backup archive, nothing to restore")
+		return
+	}
+	existing := make([]string, 0)
+	for _, table := range tables {
+		if _, err := db.GetTableInfo(ctx, table.Db.Name, table.Info.Name); err == nil {
+			existing = append(existing, fmt.Sprintf("%s.%s", table.Db.Name, table.Info.Name))
+		}
+	}
+	if len(existing) == 0 {
+		report.pass("tables", "%d tables will be restored, none of them exists", len(tables))
+		return
+	}
+	listed := existing
+	if len(listed) > maxListedTables {
+		listed = append(listed[:maxListedTables:maxListedTables],
+			fmt.Sprintf("and %d more", len(existing)-maxListedTables))
+	}
+	report.warn("tables", "%d of the %d restored tables exist already, their data will be overwritten: %s",
+		len(existing), len(tables), strings.Join(listed, ", "))
+}
+
+// checkCapacity checks whether the up TiKV stores have enough space for the
+// replicas of the restored data.
+func checkCapacity(
+	ctx context.Context,
+	mgr *conn.Mgr,
+	stores []*metapb.Store,
+	tables []*utils.Table,
+	report *CheckReport,
+) {
+	// The checksum of a table is absent if the backup skipped the checksum,
+	// so the size is summed from the files.
+	var totalBytes uint64
+	for _, table := range tables {
+		for _, file := range table.Files() {
+			totalBytes += file.GetTotalBytes()
+		}
+	}
+	replicas, err := mgr.GetMaxReplicas(ctx)
+	if err != nil {
+		report.warn("capacity", "cannot get the number of the replicas: %v", err)
+		return
+	}
+	capacities, err := mgr.GetStoresCapacity(ctx)
+	if err != nil {
+		report.warn("capacity", "cannot get the capacity of the stores: %v", err)
+		return
+	}
+	upStores := make(map[uint64]struct{}, len(stores))
+	for _, store := range stores {
+		upStores[store.GetId()] = struct{}{}
+	}
+	var available uint64
+	for _, capacity := range capacities {
+		if _, ok := upStores[capacity.StoreID]; ok {
+			available += capacity.Available
+		}
+	}
+
+	required := totalBytes * replicas
+	message := fmt.Sprintf("%s of data in %d replicas requires %s, the TiKV stores have %s available",
+		utils.FormatBytes(totalBytes), replicas, utils.FormatBytes(required), utils.FormatBytes(available))
+	switch {
+	case required > available:
+		report.fail("capacity", "%s", message)
+	case float64(required) > float64(available)*capacityWarnRatio:
+		report.warn("capacity", "%s", message)
+	default:
+		report.pass("capacity", "%s", message)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"regexp"
 	"strings"
 
-	"github.com/gogo/protobuf/proto"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/backup"
 	"github.com/pingcap/tidb-tools/pkg/filter"
@@ -33,13 +32,18 @@ const (
 	// flagKey is the name of TLS key flag.
 	flagKey = "key"
 
-	flagDatabase = "db"
-	flagTable    = "table"
+	flagDatabase  = "db"
+	flagTable     = "table"
+	flagPartition = "partition"
 
 	flagRateLimit     = "ratelimit"
 	flagRateLimitUnit = "ratelimit-unit"
 	flagConcurrency   = "concurrency"
 	flagChecksum      = "checksum"
+
+	// FlagDryRun is the name of the flag which plans a backup or restore
+	// without running it.
+	FlagDryRun = "dry-run"
 )
 
 // TLSConfig is the common configuration for TLS connection.
@@ -65,6 +69,9 @@ type Config struct {
 
 	CaseSensitive bool         `json:"case-sensitive" toml:"case-sensitive"`
 	Filter        filter.Rules `json:"black-white-list" toml:"black-white-list"`
+	// Partitions are the names of the partitions to backup or restore. It is
+	// only valid for a single table.
+	Partitions []string `json:"partitions" toml:"partitions"`
 }
 
 // DefineCommonFlags defines the flags common to all BRIE commands.
@@ -97,6 +104,8 @@ func DefineTableFlags(command *cobra.Command) {
 	DefineDatabaseFlags(command)
 	command.Flags().StringP(flagTable, "t", "", "table name")
 	_ = command.MarkFlagRequired(flagTable)
+	command.Flags().StringSlice(flagPartition, nil,
+		"partition names of the table, eg, \"p0,p1\"")
 }
 
 // ParseFromFlags parses the TLS config from the flag set.
@@ -166,6 +175,12 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 				return errors.New("empty table name is not allowed")
 			}
 			cfg.Filter.DoTables = []*filter.Table{{Schema: db, Name: tbl}}
+			if flags.Lookup(flagPartition) != nil {
+				cfg.Partitions, err = flags.GetStringSlice(flagPartition)
+				if err != nil {
+					return errors.Trace(err)
+				}
+			}
 		} else {
 			cfg.Filter.DoDBs = []string{db}
 		}
@@ -178,7 +193,7 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 }
 
 // newMgr creates a new mgr at the given PD address.
-func newMgr(ctx context.Context, pds []string) (*conn.Mgr, error) {
+func newMgr(ctx context.Context, pds []string, needDomain bool) (*conn.Mgr, error) {
 	pdAddress := strings.Join(pds, ",")
 	if len(pdAddress) == 0 {
 		return nil, errors.New("pd address can not be empty")
@@ -189,7 +204,22 @@ func newMgr(ctx context.Context, pds []string) (*conn.Mgr, error) {
 	if err != nil {
 		return nil, err
 	}
-	return conn.NewMgr(ctx, pdAddress, store.(tikv.Storage))
+	return conn.NewMgr(ctx, pdAddress, store.(tikv.Storage), needDomain)
+}
+
+// currentBackupInfo returns the environment of the current BR and cluster,
+// except the settings.
+func currentBackupInfo(ctx context.Context, mgr *conn.Mgr) (*utils.BackupInfo, error) {
+	clusterVersion, err := mgr.GetClusterVersion(ctx)
+	if err != nil {
+		return nil, errors.Annotate(err, "get cluster version failed")
+	}
+	return &utils.BackupInfo{
+		BRVersion:      utils.BRReleaseVersion,
+		BRGitHash:      utils.BRGitHash,
+		ClusterID:      mgr.GetPDClient().GetClusterID(ctx),
+		ClusterVersion: clusterVersion,
+	}, nil
 }
 
 // GetStorage gets the storage backend from the config.
@@ -208,26 +238,39 @@ func GetStorage(
 	return u, s, nil
 }
 
-// ReadBackupMeta reads the backupmeta file from the storage.
+// ReadBackupMeta reads the backupmeta from the storage, the sharded
+// backupmeta is read shard by shard and merged.
 func ReadBackupMeta(
 	ctx context.Context,
 	cfg *Config,
 ) (*backup.StorageBackend, storage.ExternalStorage, *backup.BackupMeta, error) {
-	u, s, err := GetStorage(ctx, cfg)
+	u, s, reader, err := NewBackupMetaReader(ctx, cfg)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	metaData, err := s.Read(ctx, utils.MetaFile)
+	backupMeta, err := reader.ReadAll(ctx)
 	if err != nil {
-		return nil, nil, nil, errors.Annotate(err, "load backupmeta failed")
-	}
-	backupMeta := &backup.BackupMeta{}
-	if err = proto.Unmarshal(metaData, backupMeta); err != nil {
-		return nil, nil, nil, errors.Annotate(err, "parse backupmeta failed")
+		return nil, nil, nil, err
 	}
 	return u, s, backupMeta, nil
 }
 
+// NewBackupMetaReader returns a reader of the backupmeta in the storage.
+func NewBackupMetaReader(
+	ctx context.Context,
+	cfg *Config,
+) (*backup.StorageBackend, storage.ExternalStorage, *utils.MetaReader, error) {
+	u, s, err := GetStorage(ctx, cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	reader, err := utils.NewMetaReader(ctx, s)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return u, s, reader, nil
+}
+
 func escapeFilterName(name string) string {
 	if !strings.HasPrefix(name, "~") {
 		return name
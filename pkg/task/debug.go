@@ -0,0 +1,189 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/sst"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	flagFile  = "file"
+	flagLimit = "limit"
+)
+
+// DebugSSTConfig is the configuration specific for inspecting a backup file.
+type DebugSSTConfig struct {
+	Config
+
+	// File is the name of the backup file in the storage.
+	File string `json:"file" toml:"file"`
+	// Limit is the number of the entries printed, 0 prints all of them.
+	Limit uint `json:"limit" toml:"limit"`
+}
+
+// DefineDebugSSTFlags defines the flags for inspecting a backup file.
+func DefineDebugSSTFlags(flags *pflag.FlagSet) {
+	flags.String(flagFile, "", "The name of the backup file to inspect")
+	flags.Uint(flagLimit, 20, "The number of the entries to print, 0 prints all of them")
+}
+
+// ParseFromFlags parses the debug sst config from the flag set.
+func (cfg *DebugSSTConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.File, err = flags.GetString(flagFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.File) == 0 {
+		return errors.New("the backup file must be set")
+	}
+	cfg.Limit, err = flags.GetUint(flagLimit)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return cfg.Config.ParseFromFlags(flags)
+}
+
+// RunDebugSST decodes a backup file, and writes its properties and entries
+// to w. If the file is in the backup meta, its checksum is recomputed and
+// compared with the backup meta.
+func RunDebugSST(c context.Context, cfg *DebugSSTConfig, w io.Writer) error {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	_, s, err := GetStorage(ctx, &cfg.Config)
+	if err != nil {
+		return err
+	}
+	// The files of a backup without the backup meta can still be decoded.
+	// Only the files are read, the schemas are not needed.
+	backupMeta := &backup.BackupMeta{}
+	var files []*backup.File
+	reader, err := utils.NewMetaReader(ctx, s)
+	if err == nil {
+		backupMeta = reader.Meta()
+		err = reader.WalkFiles(ctx, func(file *backup.File) error {
+			files = append(files, file)
+			return nil
+		})
+	}
+	if err != nil {
+		log.Warn("read backup meta failed, the checksum isn't compared", zap.Error(err))
+		backupMeta, files = &backup.BackupMeta{}, nil
+	}
+
+	data, err := s.Read(ctx, cfg.File)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	table, err := sst.NewReader(data)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "file: %s\nsize: %s\nformat version: %d\nproperties:\n",
+		cfg.File, utils.FormatBytes(uint64(len(data))), table.FormatVersion())
+	properties := table.Properties()
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s: %s\n", name, sst.FormatProperty(name, properties[name]))
+	}
+
+	fmt.Fprintln(w, "entries:")
+	var count uint
+	err = table.Iterate(func(entry *sst.Entry) error {
+		count++
+		if cfg.Limit != 0 && count > cfg.Limit {
+			return nil
+		}
+		fmt.Fprintf(w, "  %s\n", describeEntry(entry, cfg.File, backupMeta.IsRawKv))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if cfg.Limit != 0 && count > cfg.Limit {
+		fmt.Fprintf(w, "  ... %d more entries\n", count-cfg.Limit)
+	}
+
+	group := utils.FindFileGroup(files, cfg.File, backupMeta.IsRawKv)
+	if group == nil {
+		_, err = fmt.Fprintf(w, "%d entries, the file isn't in the backup meta\n", count)
+		return errors.Trace(err)
+	}
+	// The file of the default column family is grouped after the file of the
+	// write column family, whose checksum covers both of them.
+	groupData := make([][]byte, 0, len(group))
+	for _, file := range group {
+		if file.Name == cfg.File {
+			groupData = append(groupData, data)
+			continue
+		}
+		content, err := s.Read(ctx, file.Name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		groupData = append(groupData, content)
+	}
+	checksum, err := utils.RecomputeChecksum(group, groupData, backupMeta.IsRawKv)
+	if err != nil {
+		return err
+	}
+	file := group[0]
+	if file.Name != cfg.File {
+		fmt.Fprintf(w, "checksum of the write file %s, which includes this file:\n", file.Name)
+	}
+	fmt.Fprintf(w, "checksum: crc64xor %d, total kvs %d, total bytes %d\n",
+		checksum.Crc64Xor, checksum.TotalKvs, checksum.TotalBytes)
+	fmt.Fprintf(w, "backup meta: crc64xor %d, total kvs %d, total bytes %d\n",
+		file.Crc64Xor, file.TotalKvs, file.TotalBytes)
+	if reason := utils.ChecksumMismatch(file, checksum); len(reason) > 0 {
+		_, err = fmt.Fprintf(w, "MISMATCHED: %s\n", reason)
+	} else {
+		_, err = fmt.Fprintln(w, "OK")
+	}
+	return errors.Trace(err)
+}
+
+// describeEntry describes an entry of the backup file, whose key and value
+// are decoded by the column family of the file.
+func describeEntry(entry *sst.Entry, name string, rawKV bool) string {
+	if rawKV {
+		key, err := sst.DecodeRawKey(entry.Key)
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprintf("key %x: value %dB", key, len(entry.Value))
+	}
+	key, ts, err := sst.DecodeKey(entry.Key)
+	if err != nil {
+		return err.Error()
+	}
+	if !strings.Contains(name, "write") {
+		return fmt.Sprintf("%s, start ts %d: value %dB", sst.DescribeKey(key), ts, len(entry.Value))
+	}
+	write, err := sst.DecodeWrite(entry.Value)
+	if err != nil {
+		return fmt.Sprintf("%s, commit ts %d: %s", sst.DescribeKey(key), ts, err)
+	}
+	value := "long value"
+	if write.ShortValue != nil {
+		value = fmt.Sprintf("short value %dB", len(write.ShortValue))
+	}
+	return fmt.Sprintf("%s, commit ts %d: %s, start ts %d, %s",
+		sst.DescribeKey(key), ts, write.Type, write.StartTS, value)
+}
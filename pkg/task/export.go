@@ -0,0 +1,158 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-tools/pkg/filter"
+	"github.com/spf13/pflag"
+
+	"github.com/pingcap/br/pkg/export"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	flagOutput            = "output"
+	flagFilter            = "filter"
+	flagExportConcurrency = "export-concurrency"
+
+	defaultExportConcurrency = 4
+)
+
+// ExportConfig is the configuration specific for exporting a backup.
+type ExportConfig struct {
+	Config
+
+	// Output is the url of the storage where the exported files are written.
+	Output string `json:"output" toml:"output"`
+	// Format is the format of the exported files, "csv" or "sql".
+	Format export.Format `json:"format" toml:"format"`
+	// ExportConcurrency is the number of the files exported concurrently.
+	ExportConcurrency uint `json:"export-concurrency" toml:"export-concurrency"`
+}
+
+// DefineExportFlags defines the flags for exporting a backup.
+func DefineExportFlags(flags *pflag.FlagSet) {
+	flags.String(flagOutput, "", `The url of the storage where the files are exported, eg, "local:///path/to/export"`)
+	flags.String(flagFormat, string(export.FormatCSV), `The format of the exported files, "csv" or "sql"`)
+	flags.StringSlice(flagFilter, nil,
+		`The tables to export as "db.table", where * and ? match any characters and a character, eg, "db*.t?"`)
+	flags.Uint(flagExportConcurrency, defaultExportConcurrency, "The number of the files exported concurrently")
+}
+
+// parseTablePattern parses a pattern of the filter, the names with the
+// wildcards are converted to the regular expressions of the filter.
+func parseTablePattern(pattern string) (*filter.Table, error) {
+	parts := strings.SplitN(pattern, ".", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return nil, errors.Errorf("invalid table pattern %q, it should be \"db.table\"", pattern)
+	}
+	convert := func(name string) string {
+		if !strings.ContainsAny(name, "*?") {
+			return escapeFilterName(name)
+		}
+		re := regexp.QuoteMeta(name)
+		re = strings.Replace(re, `\*`, ".*", -1)
+		re = strings.Replace(re, `\?`, ".", -1)
+		return "~^" + re + "$"
+	}
+	return &filter.Table{Schema: convert(parts[0]), Name: convert(parts[1])}, nil
+}
+
+// ParseFromFlags parses the export config from the flag set.
+func (cfg *ExportConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.Output, err = flags.GetString(flagOutput)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.Output) == 0 {
+		return errors.New("the output storage must be set")
+	}
+	format, err := flags.GetString(flagFormat)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.Format = export.Format(format)
+	if cfg.Format != export.FormatCSV && cfg.Format != export.FormatSQL {
+		return errors.Errorf("unknown format %q, it should be %q or %q", format, export.FormatCSV, export.FormatSQL)
+	}
+	cfg.ExportConcurrency, err = flags.GetUint(flagExportConcurrency)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ExportConcurrency == 0 {
+		return errors.New("export concurrency must be positive")
+	}
+	if err = cfg.Config.ParseFromFlags(flags); err != nil {
+		return err
+	}
+	patterns, err := flags.GetStringSlice(flagFilter)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, pattern := range patterns {
+		table, err := parseTablePattern(pattern)
+		if err != nil {
+			return err
+		}
+		cfg.Filter.DoTables = append(cfg.Filter.DoTables, table)
+	}
+	return nil
+}
+
+// RunExport exports the rows of the tables of the backup to the output
+// storage, and writes the summary to w.
+func RunExport(c context.Context, cfg *ExportConfig, w io.Writer) error {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	_, s, reader, err := NewBackupMetaReader(ctx, &cfg.Config)
+	if err != nil {
+		return err
+	}
+	backupMeta := reader.Meta()
+	if backupMeta.IsRawKv {
+		return errors.New("cannot export a raw kv backup")
+	}
+	databases, err := utils.ReadBackupTables(ctx, reader)
+	if err != nil {
+		return err
+	}
+	tables, err := filterTables(sortedDatabases(databases), &cfg.Config)
+	if err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		return errors.New("no table matches the filter")
+	}
+
+	u, err := storage.ParseBackend(cfg.Output, &cfg.BackendOptions)
+	if err != nil {
+		return err
+	}
+	output, err := storage.Create(ctx, u, cfg.SendCreds)
+	if err != nil {
+		return errors.Annotate(err, "create output storage failed")
+	}
+	exporter := export.NewExporter(s, output, backupMeta, cfg.Format, cfg.ExportConcurrency, time.Local)
+	results, err := exporter.ExportTables(ctx, tables)
+	if err != nil {
+		return err
+	}
+	var rows uint64
+	var files int
+	for _, result := range results {
+		fmt.Fprintf(w, "%s: %d rows in %d files\n", result.Name, result.Rows, len(result.Files))
+		rows += result.Rows
+		files += len(result.Files)
+	}
+	_, err = fmt.Fprintf(w, "%d tables exported: %d rows in %d files\n", len(results), rows, files)
+	return errors.Trace(err)
+}
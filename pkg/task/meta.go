@@ -0,0 +1,200 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pingcap/errors"
+	"github.com/spf13/pflag"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	flagFormat  = "format"
+	flagField   = "field"
+	flagLargest = "largest"
+
+	// FormatTable is the readable text output format.
+	FormatTable = "table"
+	// FormatJSON is the JSON output format.
+	FormatJSON = "json"
+)
+
+// MetaShowConfig is the configuration specific for showing a backup.
+type MetaShowConfig struct {
+	Config
+
+	// Format is the output format, "table" or "json".
+	Format string `json:"format" toml:"format"`
+	// Field is a jq-style path selecting the fields of the JSON report, e.g.
+	// ".databases[].tables[].name".
+	Field string `json:"field" toml:"field"`
+	// Largest is the number of the largest tables listed.
+	Largest int `json:"largest" toml:"largest"`
+}
+
+// DefineMetaShowFlags defines the flags for showing a backup.
+func DefineMetaShowFlags(flags *pflag.FlagSet) {
+	flags.String(flagFormat, FormatTable, `The output format, "table" or "json"`)
+	flags.String(flagField, "",
+		`A jq-style path of the fields to print, e.g. ".databases[].tables[].name"`)
+	flags.Int(flagLargest, 10, "The number of the largest tables to list")
+}
+
+// ParseFromFlags parses the meta show config from the flag set.
+func (cfg *MetaShowConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.Format, err = flags.GetString(flagFormat)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Format != FormatTable && cfg.Format != FormatJSON {
+		return errors.Errorf("unknown format %q, it should be %q or %q", cfg.Format, FormatTable, FormatJSON)
+	}
+	cfg.Field, err = flags.GetString(flagField)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.Largest, err = flags.GetInt(flagLargest)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Largest < 0 {
+		return errors.New("the number of the largest tables must not be negative")
+	}
+	return cfg.Config.ParseFromFlags(flags)
+}
+
+// RunMetaShow writes the report of the backup to w.
+func RunMetaShow(c context.Context, cfg *MetaShowConfig, w io.Writer) error {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	_, _, reader, err := NewBackupMetaReader(ctx, &cfg.Config)
+	if err != nil {
+		return err
+	}
+	info, err := reader.Info(ctx)
+	if err != nil {
+		return err
+	}
+	report, err := utils.NewBackupReport(ctx, reader, info, cfg.Largest)
+	if err != nil {
+		return err
+	}
+	if len(cfg.Field) == 0 && cfg.Format == FormatTable {
+		return report.WriteText(w)
+	}
+	return writeJSON(w, report, cfg.Format, cfg.Field)
+}
+
+// writeJSON writes the value as the indented JSON. If the field is set, the
+// selected values are written one per line, and the strings are written
+// without quotes in the table format, like `jq -r`.
+func writeJSON(w io.Writer, value interface{}, format, field string) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(field) == 0 {
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return errors.Trace(err)
+	}
+	values, err := utils.SelectJSON(data, field)
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		if s, ok := v.(string); ok && format == FormatTable {
+			_, err = fmt.Fprintln(w, s)
+		} else {
+			data, err = json.MarshalIndent(v, "", "  ")
+			if err != nil {
+				return errors.Trace(err)
+			}
+			_, err = fmt.Fprintf(w, "%s\n", data)
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+const flagOld = "old"
+
+// MetaDiffConfig is the configuration specific for comparing two backups.
+type MetaDiffConfig struct {
+	Config
+
+	// Old is the url of the storage of the old backup, the storage of Config
+	// is the new backup.
+	Old string `json:"old" toml:"old"`
+	// Format is the output format, "table" or "json".
+	Format string `json:"format" toml:"format"`
+	// Field is a jq-style path selecting the fields of the JSON diff.
+	Field string `json:"field" toml:"field"`
+}
+
+// DefineMetaDiffFlags defines the flags for comparing two backups.
+func DefineMetaDiffFlags(flags *pflag.FlagSet) {
+	flags.String(flagOld, "", `The url of the storage of the old backup, eg, "local:///path/to/old"`)
+	flags.String(flagFormat, FormatTable, `The output format, "table" or "json"`)
+	flags.String(flagField, "", `A jq-style path of the fields to print, e.g. ".changed-tables[].name"`)
+}
+
+// ParseFromFlags parses the meta diff config from the flag set.
+func (cfg *MetaDiffConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.Old, err = flags.GetString(flagOld)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.Old) == 0 {
+		return errors.New("the storage of the old backup must be set")
+	}
+	cfg.Format, err = flags.GetString(flagFormat)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Format != FormatTable && cfg.Format != FormatJSON {
+		return errors.Errorf("unknown format %q, it should be %q or %q", cfg.Format, FormatTable, FormatJSON)
+	}
+	cfg.Field, err = flags.GetString(flagField)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return cfg.Config.ParseFromFlags(flags)
+}
+
+// RunMetaDiff writes the difference from the old backup to the new backup
+// to w.
+func RunMetaDiff(c context.Context, cfg *MetaDiffConfig, w io.Writer) error {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	_, _, newReader, err := NewBackupMetaReader(ctx, &cfg.Config)
+	if err != nil {
+		return errors.Annotate(err, "read the new backup failed")
+	}
+	oldCfg := cfg.Config
+	oldCfg.Storage = cfg.Old
+	_, _, oldReader, err := NewBackupMetaReader(ctx, &oldCfg)
+	if err != nil {
+		return errors.Annotate(err, "read the old backup failed")
+	}
+	if oldReader.Meta().IsRawKv || newReader.Meta().IsRawKv {
+		return errors.New("cannot compare a raw kv backup")
+	}
+	diff, err := utils.NewBackupDiff(ctx, oldReader, newReader)
+	if err != nil {
+		return err
+	}
+	if len(cfg.Field) == 0 && cfg.Format == FormatTable {
+		return diff.WriteText(w)
+	}
+	return writeJSON(w, diff, cfg.Format, cfg.Field)
+}
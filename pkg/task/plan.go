@@ -0,0 +1,183 @@
+package task
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/pd/pkg/mock/mockid"
+	"github.com/pingcap/tidb-tools/pkg/filter"
+	"github.com/pingcap/tidb/store/tikv/oracle"
+
+	backupclient "github.com/pingcap/br/pkg/backup"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// BackupPlan is what a backup task would back up.
+type BackupPlan struct {
+	BackupTS uint64
+	Tables   []string
+	Ranges   int
+	Regions  int
+	// ApproximateSize and ApproximateKeys are estimated from the approximate
+	// sizes of the regions reported by PD.
+	ApproximateSize uint64
+	ApproximateKeys uint64
+}
+
+func (p *BackupPlan) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "backup TS: %d (%s)\n", p.BackupTS, oracle.GetTimeFromTS(p.BackupTS))
+	fmt.Fprintf(&buf, "tables: %d\n", len(p.Tables))
+	for _, name := range p.Tables {
+		fmt.Fprintf(&buf, "  %s\n", name)
+	}
+	fmt.Fprintf(&buf, "ranges: %d\n", p.Ranges)
+	fmt.Fprintf(&buf, "regions: %d\n", p.Regions)
+	fmt.Fprintf(&buf, "approximate size: %s\n", utils.FormatBytes(p.ApproximateSize))
+	fmt.Fprintf(&buf, "approximate keys: %d", p.ApproximateKeys)
+	return buf.String()
+}
+
+// PlanBackup returns what the backup task would back up, without backing up
+// or writing anything to the storage.
+func PlanBackup(c context.Context, cfg *BackupConfig) (*BackupPlan, error) {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	tableFilter, err := filter.New(cfg.CaseSensitive, &cfg.Filter)
+	if err != nil {
+		return nil, err
+	}
+	mgr, err := newMgr(ctx, cfg.PD, true)
+	if err != nil {
+		return nil, err
+	}
+	defer mgr.Close()
+
+	client, err := backupclient.NewBackupClient(ctx, mgr)
+	if err != nil {
+		return nil, err
+	}
+	backupTS, err := client.GetTS(ctx, cfg.TimeAgo)
+	if err != nil {
+		return nil, err
+	}
+	ranges, schemas, err := backupclient.BuildBackupRangeAndSchema(
+		mgr.GetDomain(), mgr.GetTiKV(), tableFilter, cfg.Partitions, backupTS)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &BackupPlan{BackupTS: backupTS, Tables: schemas.Names(), Ranges: len(ranges)}
+	for _, r := range ranges {
+		stats, err := mgr.GetRegionStats(ctx, r.StartKey, r.EndKey)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		plan.Regions += stats.Count
+		plan.ApproximateSize += uint64(stats.StorageSize) * utils.MB
+		plan.ApproximateKeys += uint64(stats.StorageKeys)
+	}
+	return plan, nil
+}
+
+// RestorePlan is what a restore task would restore.
+type RestorePlan struct {
+	Databases []string
+	Tables    []string
+	Files     int
+	// TotalRanges is the number of the ranges of the files, they are merged
+	// into Ranges.
+	TotalRanges int
+	Ranges      int
+	TotalBytes  uint64
+	TotalKvs    uint64
+	// SplitKeys are the keys the regions would be split at, which are
+	// rewritten with the simulated table IDs, so they differ from the keys
+	// of an actual restore.
+	SplitKeys [][]byte
+}
+
+func (p *RestorePlan) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "databases to create: %d\n", len(p.Databases))
+	for _, name := range p.Databases {
+		fmt.Fprintf(&buf, "  %s\n", name)
+	}
+	fmt.Fprintf(&buf, "tables to create: %d\n", len(p.Tables))
+	for _, name := range p.Tables {
+		fmt.Fprintf(&buf, "  %s\n", name)
+	}
+	fmt.Fprintf(&buf, "files: %d\n", p.Files)
+	fmt.Fprintf(&buf, "ranges: %d, merged into %d\n", p.TotalRanges, p.Ranges)
+	fmt.Fprintf(&buf, "size: %s\n", utils.FormatBytes(p.TotalBytes))
+	fmt.Fprintf(&buf, "kvs: %d\n", p.TotalKvs)
+	fmt.Fprintf(&buf, "split keys: %d", len(p.SplitKeys))
+	for _, key := range p.SplitKeys {
+		fmt.Fprintf(&buf, "\n  %s", hex.EncodeToString(key))
+	}
+	return buf.String()
+}
+
+// PlanRestore returns what the restore task would restore. It only reads the
+// backupmeta, the tables are not created in the cluster, and their IDs are
+// simulated to compute the rewrite rules and the split keys.
+func PlanRestore(c context.Context, cfg *RestoreConfig) (*RestorePlan, error) {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	_, _, reader, err := NewBackupMetaReader(ctx, &cfg.Config)
+	if err != nil {
+		return nil, err
+	}
+	if reader.Meta().IsRawKv {
+		return nil, errors.New("cannot plan a restore of a raw kv backup")
+	}
+	databases, err := utils.ReadBackupTables(ctx, reader)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := filterTables(sortedDatabases(databases), &cfg.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &RestorePlan{}
+	files := make([]*backup.File, 0)
+	rewriteRules := &restore.RewriteRules{}
+	tableIDAlloc := mockid.NewIDAllocator()
+	createdDatabases := make(map[string]bool)
+	for _, table := range tables {
+		if !createdDatabases[table.Db.Name.L] {
+			plan.Databases = append(plan.Databases, table.Db.Name.O)
+			createdDatabases[table.Db.Name.L] = true
+		}
+		plan.Tables = append(plan.Tables, utils.EncloseName(table.Db.Name.O)+"."+utils.EncloseName(table.Info.Name.O))
+		files = append(files, table.Files()...)
+
+		newTable := restore.MockCreatedTable(table.Info, tableIDAlloc)
+		rules := restore.GetRewriteRules(newTable, table.Info, 0)
+		rewriteRules.Table = append(rewriteRules.Table, rules.Table...)
+		rewriteRules.Data = append(rewriteRules.Data, rules.Data...)
+	}
+
+	ranges, mergeStat, err := restore.MergeFileRanges(
+		files, rewriteRules, cfg.MergeRegionSizeBytes, cfg.MergeRegionKeyCount)
+	if err != nil {
+		return nil, err
+	}
+	plan.Files = mergeStat.TotalFiles
+	plan.TotalRanges = mergeStat.TotalRanges
+	plan.Ranges = mergeStat.MergedRanges
+	plan.TotalBytes = mergeStat.TotalBytes
+	plan.TotalKvs = mergeStat.TotalKvs
+	if plan.SplitKeys, err = restore.PlanSplitKeys(ranges, rewriteRules); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
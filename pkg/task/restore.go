@@ -2,11 +2,15 @@ package task
 
 import (
 	"context"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/backup"
 	"github.com/pingcap/log"
 	"github.com/pingcap/tidb-tools/pkg/filter"
+	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 
@@ -17,7 +21,25 @@ import (
 )
 
 const (
-	flagOnline = "online"
+	flagOnline            = "online"
+	flagOnlineStores      = "online-stores"
+	flagOnlineWaitTimeout = "online-wait-timeout"
+	flagTiDBDSN           = "tidb-dsn"
+	flagTiDBStatusAddr    = "tidb-status-addr"
+
+	flagStoreConcurrency   = "store-concurrency"
+	flagSplitConcurrency   = "split-concurrency"
+	flagScatterWaitTimeout = "scatter-wait-timeout"
+
+	flagMergeRegionSizeBytes = "merge-region-size-bytes"
+	flagMergeRegionKeyCount  = "merge-region-key-count"
+
+	flagIgnoreIncompatibility = "ignore-incompatibility"
+	flagExchangePartition     = "exchange-partition"
+
+	// onlineCleanupTimeout is the maximum time resetting the placement rules
+	// and the labels after online restore.
+	onlineCleanupTimeout = time.Minute
 )
 
 var schedulers = map[string]struct{}{
@@ -35,13 +57,87 @@ type RestoreConfig struct {
 	Config
 
 	Online bool `json:"online" toml:"online"`
+	// OnlineStores are the stores which the restored regions are placed on in
+	// online restore. If it is empty, the stores are chosen automatically.
+	OnlineStores []uint `json:"online-stores" toml:"online-stores"`
+	// OnlineWaitTimeout is the maximum time waiting for the regions to be
+	// placed on the online stores.
+	OnlineWaitTimeout time.Duration `json:"online-wait-timeout" toml:"online-wait-timeout"`
+	// TiDBDSN is the DSN of a TiDB server which executes the DDLs of restore,
+	// if it is empty, the DDLs are executed in an embedded TiDB session.
+	TiDBDSN        string `json:"tidb-dsn" toml:"tidb-dsn"`
+	TiDBStatusAddr string `json:"tidb-status-addr" toml:"tidb-status-addr"`
+	// StoreConcurrency is the maximum number of the concurrent download and
+	// ingest requests on each store.
+	StoreConcurrency uint `json:"store-concurrency" toml:"store-concurrency"`
+	// SplitConcurrency is the number of the regions split and scattered
+	// concurrently.
+	SplitConcurrency uint `json:"split-concurrency" toml:"split-concurrency"`
+	// ScatterWaitTimeout is the maximum time waiting for the regions to be
+	// scattered after split.
+	ScatterWaitTimeout time.Duration `json:"scatter-wait-timeout" toml:"scatter-wait-timeout"`
+	// MergeRegionSizeBytes and MergeRegionKeyCount are the maximum size and
+	// number of the keys of the ranges merged from the small files before
+	// split.
+	MergeRegionSizeBytes uint64 `json:"merge-region-size-bytes" toml:"merge-region-size-bytes"`
+	MergeRegionKeyCount  uint64 `json:"merge-region-key-count" toml:"merge-region-key-count"`
+	// IgnoreIncompatibility restores the backup even if it is refused by the
+	// compatibility check.
+	IgnoreIncompatibility bool `json:"ignore-incompatibility" toml:"ignore-incompatibility"`
+	// DryRun plans the restore without restoring anything.
+	DryRun bool `json:"dry-run" toml:"dry-run"`
+	// ExchangePartition makes the restored partitions replace the data of
+	// the same partitions of the existing table, which are truncated before
+	// restore. Otherwise the partitions must be empty.
+	ExchangePartition bool `json:"exchange-partition" toml:"exchange-partition"`
 }
 
 // DefineRestoreFlags defines common flags for the restore command.
 func DefineRestoreFlags(flags *pflag.FlagSet) {
-	flags.Bool("online", false, "Whether online when restore")
+	flags.Bool(flagOnline, false, "Whether online when restore")
 	// TODO remove hidden flag if it's stable
-	_ = flags.MarkHidden("online")
+	_ = flags.MarkHidden(flagOnline)
+	flags.UintSlice(flagOnlineStores, nil,
+		"The IDs of the stores which the restored regions are placed on in online restore, "+
+			"defaults to the up stores with the least IDs")
+	_ = flags.MarkHidden(flagOnlineStores)
+	flags.Duration(flagOnlineWaitTimeout, restore.DefaultPlacementWaitTimeout,
+		"The maximum time waiting for the regions to be placed on the restore stores in online restore, "+
+			"restore fails after the timeout")
+	_ = flags.MarkHidden(flagOnlineWaitTimeout)
+
+	flags.String(flagTiDBDSN, "",
+		"The DSN of a TiDB server to execute DDLs through, "+
+			`e.g. "root:@tcp(127.0.0.1:4000)/". If not set, DDLs are executed in an embedded TiDB session`)
+	flags.String(flagTiDBStatusAddr, "",
+		"The status address of the TiDB server specified by --tidb-dsn, defaults to port 10080 of its host")
+
+	flags.Uint(flagStoreConcurrency, restore.DefaultStoreConcurrency,
+		"The maximum number of the concurrent download and ingest requests on each store, "+
+			"the actual number adapts to the load of the store")
+	flags.Uint(flagSplitConcurrency, restore.DefaultSplitConcurrency,
+		"The number of the regions split and scattered concurrently")
+	flags.Duration(flagScatterWaitTimeout, restore.ScatterWaitUpperInterval,
+		"The maximum time waiting for the regions to be scattered after split, "+
+			"restore goes on without waiting after the timeout")
+	flags.Uint64(flagMergeRegionSizeBytes, restore.DefaultMergeRegionSizeBytes,
+		"The maximum size of the range merged from the adjacent small files of a table before split, "+
+			"0 disables merging")
+	flags.Uint64(flagMergeRegionKeyCount, restore.DefaultMergeRegionKeyCount,
+		"The maximum number of the keys of the range merged from the adjacent small files of a table before split, "+
+			"0 disables merging")
+	flags.Bool(flagIgnoreIncompatibility, false,
+		"Restore even if the backup is incompatible with the current BR or cluster")
+	flags.Bool(FlagDryRun, false,
+		"Print the tables, ranges and split keys to restore without restoring anything")
+}
+
+// DefineRestoreTableFlags defines the flags for restoring a table.
+func DefineRestoreTableFlags(command *cobra.Command) {
+	DefineTableFlags(command)
+	command.Flags().Bool(flagExchangePartition, false,
+		"Replace the data of the partitions given by --partition in the existing table, "+
+			"the partitions are truncated before restore")
 }
 
 // ParseFromFlags parses the restore-related flags from the flag set.
@@ -51,7 +147,75 @@ func (cfg *RestoreConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
-	return cfg.Config.ParseFromFlags(flags)
+	cfg.OnlineStores, err = flags.GetUintSlice(flagOnlineStores)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.OnlineWaitTimeout, err = flags.GetDuration(flagOnlineWaitTimeout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.OnlineWaitTimeout <= 0 {
+		return errors.New("online wait timeout must be positive")
+	}
+	cfg.TiDBDSN, err = flags.GetString(flagTiDBDSN)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.TiDBStatusAddr, err = flags.GetString(flagTiDBStatusAddr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.StoreConcurrency, err = flags.GetUint(flagStoreConcurrency)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.StoreConcurrency == 0 {
+		return errors.New("store concurrency must be positive")
+	}
+	cfg.SplitConcurrency, err = flags.GetUint(flagSplitConcurrency)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.SplitConcurrency == 0 {
+		return errors.New("split concurrency must be positive")
+	}
+	cfg.ScatterWaitTimeout, err = flags.GetDuration(flagScatterWaitTimeout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ScatterWaitTimeout <= 0 {
+		return errors.New("scatter wait timeout must be positive")
+	}
+	cfg.MergeRegionSizeBytes, err = flags.GetUint64(flagMergeRegionSizeBytes)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.MergeRegionKeyCount, err = flags.GetUint64(flagMergeRegionKeyCount)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.IgnoreIncompatibility, err = flags.GetBool(flagIgnoreIncompatibility)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.DryRun, err = flags.GetBool(FlagDryRun)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.Config.ParseFromFlags(flags); err != nil {
+		return err
+	}
+	if flags.Lookup(flagExchangePartition) != nil {
+		cfg.ExchangePartition, err = flags.GetBool(flagExchangePartition)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if cfg.ExchangePartition && len(cfg.Partitions) == 0 {
+			return errors.Errorf("--%s needs the partitions given by --%s", flagExchangePartition, flagPartition)
+		}
+	}
+	return nil
 }
 
 // RunRestore starts a restore task inside the current goroutine.
@@ -59,31 +223,52 @@ func RunRestore(c context.Context, cmdName string, cfg *RestoreConfig) error {
 	ctx, cancel := context.WithCancel(c)
 	defer cancel()
 
-	mgr, err := newMgr(ctx, cfg.PD)
+	// The embedded TiDB domain is not needed if DDLs are executed remotely.
+	mgr, err := newMgr(ctx, cfg.PD, len(cfg.TiDBDSN) == 0)
 	if err != nil {
 		return err
 	}
 	defer mgr.Close()
 
-	client, err := restore.NewRestoreClient(ctx, mgr.GetPDClient(), mgr.GetTiKV())
+	db, err := newRestoreDB(ctx, mgr, cfg)
+	if err != nil {
+		return err
+	}
+	client, err := restore.NewRestoreClient(ctx, mgr.GetPDClient(), mgr.GetTiKV(), db)
 	if err != nil {
+		db.Close()
 		return err
 	}
 	defer client.Close()
 
 	client.SetRateLimit(cfg.RateLimit)
 	client.SetConcurrency(uint(cfg.Concurrency))
+	client.SetStoreConcurrency(cfg.StoreConcurrency)
+	client.SetSplitConcurrency(cfg.SplitConcurrency)
+	client.SetScatterWaitTimeout(cfg.ScatterWaitTimeout)
 	if cfg.Online {
 		client.EnableOnline()
+		stores := make([]uint64, 0, len(cfg.OnlineStores))
+		for _, id := range cfg.OnlineStores {
+			stores = append(stores, uint64(id))
+		}
+		client.SetRestoreStores(stores)
+		client.SetPlacementWaitTimeout(cfg.OnlineWaitTimeout)
+	}
+	if len(cfg.Partitions) > 0 {
+		client.SetRestorePartitions(cfg.ExchangePartition)
 	}
 
 	defer summary.Summary(cmdName)
 
-	u, _, backupMeta, err := ReadBackupMeta(ctx, &cfg.Config)
+	u, _, reader, err := NewBackupMetaReader(ctx, &cfg.Config)
 	if err != nil {
 		return err
 	}
-	if err = client.InitBackupMeta(backupMeta, u); err != nil {
+	if err = checkRestoreCompatibility(ctx, mgr, db, reader, cfg.IgnoreIncompatibility); err != nil {
+		return err
+	}
+	if err = client.InitBackupMeta(reader, u); err != nil {
 		return err
 	}
 
@@ -111,15 +296,37 @@ func RunRestore(c context.Context, cmdName string, cfg *RestoreConfig) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
-	rewriteRules, newTables, err := client.CreateTables(mgr.GetDomain(), tables, newTS)
+	rewriteRules, newTables, err := client.CreateTables(tables, newTS)
 	if err != nil {
 		return err
 	}
 
-	ranges, err := restore.ValidateFileRanges(files, rewriteRules)
+	// Keep the regions of the restored tables on the restore stores in online
+	// restore, and let PD rebalance them after restore. The task context may
+	// be canceled already, so the cleanup has its own context.
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), onlineCleanupTimeout)
+		defer cancel()
+		if err := client.ResetPlacementRules(cleanupCtx, newTables); err != nil {
+			log.Warn("reset placement rules failed", zap.Error(err))
+		}
+		if err := client.ResetRestoreLabels(cleanupCtx); err != nil {
+			log.Warn("reset labels of restore stores failed", zap.Error(err))
+		}
+	}()
+	if err = client.SetupPlacementRules(ctx, newTables); err != nil {
+		return err
+	}
+
+	ranges, mergeStat, err := restore.MergeFileRanges(
+		files, rewriteRules, cfg.MergeRegionSizeBytes, cfg.MergeRegionKeyCount)
 	if err != nil {
 		return err
 	}
+	log.Info("merge file ranges",
+		zap.Int("files", mergeStat.TotalFiles),
+		zap.Int("ranges", mergeStat.TotalRanges),
+		zap.Int("merged ranges", mergeStat.MergedRanges))
 	summary.CollectInt("restore ranges", len(ranges))
 
 	// Redirect to log if there is no log file to avoid unreadable output.
@@ -143,6 +350,10 @@ func RunRestore(c context.Context, cmdName string, cfg *RestoreConfig) error {
 		}
 	}
 
+	if err = client.WaitPlacementSchedule(ctx, newTables); err != nil {
+		return err
+	}
+
 	removedSchedulers, err := restorePreWork(ctx, client, mgr)
 	if err != nil {
 		return err
@@ -171,38 +382,141 @@ func RunRestore(c context.Context, cmdName string, cfg *RestoreConfig) error {
 	}
 	close(updateCh)
 
+	// Set the TiFlash replicas after the data is restored, so that TiFlash
+	// doesn't replicate the data during restore.
+	if err = client.RecoverTiFlashReplica(tables); err != nil {
+		return err
+	}
+
+	// The indices being added at the backup time are not backed up, rebuild
+	// them after the checksum.
+	if failed := client.RebuildPendingIndices(tables); failed > 0 {
+		summary.CollectInt("pending indices not rebuilt", failed)
+	}
+
 	return nil
 }
 
-func filterRestoreFiles(
-	client *restore.Client,
-	cfg *RestoreConfig,
-) (files []*backup.File, tables []*utils.Table, err error) {
-	tableFilter, err := filter.New(cfg.CaseSensitive, &cfg.Filter)
+// newRestoreDB creates the DB which executes the DDLs of restore.
+func newRestoreDB(ctx context.Context, mgr *conn.Mgr, cfg *RestoreConfig) (*restore.DB, error) {
+	if len(cfg.TiDBDSN) == 0 {
+		return restore.NewDB(mgr.GetTiKV())
+	}
+	log.Info("execute DDLs through remote tidb", zap.String("status-addr", cfg.TiDBStatusAddr))
+	return restore.NewRemoteDB(ctx, cfg.TiDBDSN, cfg.TiDBStatusAddr)
+}
+
+// restoreCompatibility returns the incompatibilities between the backup and
+// the current BR and cluster.
+func restoreCompatibility(
+	ctx context.Context,
+	mgr *conn.Mgr,
+	db *restore.DB,
+	reader *utils.MetaReader,
+) ([]utils.CompatIssue, error) {
+	info, err := reader.Info(ctx)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	env, err := currentBackupInfo(ctx, mgr)
+	if err != nil {
+		return nil, err
+	}
+	if env.Settings, err = db.GetGlobalVariables(ctx, utils.CompatibilitySettings); err != nil {
+		return nil, err
+	}
+	return utils.CheckCompatibility(info, env), nil
+}
 
-	for _, db := range client.GetDatabases() {
-		createdDatabase := false
+// checkRestoreCompatibility checks whether the backup can be restored by the
+// current BR in the current cluster. The warnings are logged, and the backup
+// refused is restored only if ignoreIncompatibility is set.
+func checkRestoreCompatibility(
+	ctx context.Context,
+	mgr *conn.Mgr,
+	db *restore.DB,
+	reader *utils.MetaReader,
+	ignoreIncompatibility bool,
+) error {
+	issues, err := restoreCompatibility(ctx, mgr, db, reader)
+	if err != nil {
+		return err
+	}
+	refused := make([]string, 0)
+	for _, issue := range issues {
+		if issue.Level == utils.CompatRefuse {
+			log.Error("backup is incompatible", zap.String("reason", issue.Message))
+			refused = append(refused, issue.Message)
+		} else {
+			log.Warn("backup may be incompatible", zap.String("reason", issue.Message))
+		}
+	}
+	if len(refused) == 0 {
+		return nil
+	}
+	if ignoreIncompatibility {
+		log.Warn("restore the incompatible backup", zap.Bool(flagIgnoreIncompatibility, true))
+		return nil
+	}
+	return errors.Errorf("backup is incompatible: %s, use --%s to restore it anyway",
+		strings.Join(refused, "; "), flagIgnoreIncompatibility)
+}
+
+// sortedDatabases returns the databases loaded from the backupmeta ordered by
+// their names.
+func sortedDatabases(databases map[string]*utils.Database) []*utils.Database {
+	dbs := make([]*utils.Database, 0, len(databases))
+	for _, db := range databases {
+		dbs = append(dbs, db)
+	}
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].Info.Name.O < dbs[j].Info.Name.O })
+	return dbs
+}
+
+// filterTables returns the tables of the databases which match the filter
+// and the partitions of the config.
+func filterTables(databases []*utils.Database, cfg *Config) ([]*utils.Table, error) {
+	tableFilter, err := filter.New(cfg.CaseSensitive, &cfg.Filter)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]*utils.Table, 0)
+	for _, db := range databases {
 		for _, table := range db.Tables {
 			if !tableFilter.Match(&filter.Table{Schema: db.Info.Name.O, Name: table.Info.Name.O}) {
 				continue
 			}
-
-			if !createdDatabase {
-				if err = client.CreateDatabase(db.Info); err != nil {
-					return nil, nil, err
+			if len(cfg.Partitions) > 0 {
+				if table, err = utils.FilterTablePartitions(table, cfg.Partitions); err != nil {
+					return nil, err
 				}
-				createdDatabase = true
 			}
-
-			files = append(files, table.Files...)
 			tables = append(tables, table)
 		}
 	}
+	return tables, nil
+}
+
+func filterRestoreFiles(
+	client *restore.Client,
+	cfg *RestoreConfig,
+) (files []*backup.File, tables []*utils.Table, err error) {
+	tables, err = filterTables(client.GetDatabases(), &cfg.Config)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return
+	createdDatabases := make(map[string]bool)
+	for _, table := range tables {
+		if !createdDatabases[table.Db.Name.L] {
+			if err = client.CreateDatabase(table.Db); err != nil {
+				return nil, nil, err
+			}
+			createdDatabases[table.Db.Name.L] = true
+		}
+		files = append(files, table.Files()...)
+	}
+	return files, tables, nil
 }
 
 // restorePreWork executes some prepare work before restore
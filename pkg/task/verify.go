@@ -0,0 +1,85 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/pingcap/errors"
+	"github.com/spf13/pflag"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	flagVerifyConcurrency = "verify-concurrency"
+	flagDeep              = "deep"
+
+	defaultVerifyConcurrency = 16
+)
+
+// VerifyConfig is the configuration specific for verifying a backup.
+type VerifyConfig struct {
+	Config
+
+	// VerifyConcurrency is the number of the files verified concurrently.
+	VerifyConcurrency uint `json:"verify-concurrency" toml:"verify-concurrency"`
+	// Deep verifies the content of the files by recomputing their checksums.
+	Deep bool `json:"deep" toml:"deep"`
+	// Format is the output format, "table" or "json".
+	Format string `json:"format" toml:"format"`
+}
+
+// DefineVerifyFlags defines the flags for verifying a backup.
+func DefineVerifyFlags(flags *pflag.FlagSet) {
+	flags.Uint(flagVerifyConcurrency, defaultVerifyConcurrency, "The number of the files verified concurrently")
+	flags.Bool(flagDeep, false, "Decode the files and recompute their checksums, which reads each file into memory")
+	flags.String(flagFormat, FormatTable, `The output format, "table" or "json"`)
+}
+
+// ParseFromFlags parses the verify config from the flag set.
+func (cfg *VerifyConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.VerifyConcurrency, err = flags.GetUint(flagVerifyConcurrency)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.VerifyConcurrency == 0 {
+		return errors.New("verify concurrency must be positive")
+	}
+	cfg.Deep, err = flags.GetBool(flagDeep)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.Format, err = flags.GetString(flagFormat)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Format != FormatTable && cfg.Format != FormatJSON {
+		return errors.Errorf("unknown format %q, it should be %q or %q", cfg.Format, FormatTable, FormatJSON)
+	}
+	return cfg.Config.ParseFromFlags(flags)
+}
+
+// RunVerify verifies the files of the backup against the backup meta, and
+// writes the report to w. The error is returned only if the verification
+// can't run, the missing, extra or corrupted files are in the report.
+func RunVerify(c context.Context, cfg *VerifyConfig, w io.Writer) (*utils.VerifyReport, error) {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	_, s, reader, err := NewBackupMetaReader(ctx, &cfg.Config)
+	if err != nil {
+		return nil, err
+	}
+	report, err := utils.VerifyBackup(ctx, s, reader, cfg.VerifyConcurrency, cfg.Deep)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Format == FormatJSON {
+		err = writeJSON(w, report, cfg.Format, "")
+	} else {
+		_, err = fmt.Fprintln(w, report)
+	}
+	return report, errors.Trace(err)
+}
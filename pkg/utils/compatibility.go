@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CompatibilitySettings are the global variables recorded in the backup and
+// compared on restore, as the restored tables depend on them.
+var CompatibilitySettings = []string{
+	"character_set_server",
+	"collation_server",
+	"lower_case_table_names",
+}
+
+// CompatLevel is how severe an incompatibility between a backup and the
+// restore environment is.
+type CompatLevel int
+
+// The levels of the incompatibilities.
+const (
+	// CompatWarn means the backup can be restored, but the result may differ
+	// from the backed up cluster.
+	CompatWarn CompatLevel = iota
+	// CompatRefuse means the backup can't be restored safely.
+	CompatRefuse
+)
+
+func (l CompatLevel) String() string {
+	switch l {
+	case CompatWarn:
+		return "warn"
+	case CompatRefuse:
+		return "refuse"
+	default:
+		return "unknown"
+	}
+}
+
+// CompatIssue is an incompatibility between a backup and the restore
+// environment.
+type CompatIssue struct {
+	Level   CompatLevel
+	Message string
+}
+
+func (i CompatIssue) String() string {
+	return fmt.Sprintf("[%s] %s", i.Level, i.Message)
+}
+
+// CheckCompatibility checks whether the backup made in the environment info
+// can be restored in the environment env. The environment of the backup is
+// nil if it is made by the old versions. The rules are:
+//   - A backup made by a newer BR is refused, as it may contain the data the
+//     current BR doesn't understand.
+//   - A backup of a newer cluster is refused, as the schemas and the data may
+//     not be supported by the older cluster. A backup of an older major
+//     version is restored with a warning.
+//   - The different settings are warned.
+//
+// The versions which can't be parsed are warned.
+func CheckCompatibility(info, env *BackupInfo) []CompatIssue {
+	if info == nil {
+		return []CompatIssue{{
+			Level:   CompatWarn,
+			Message: "the backup doesn't record its environment, it may be made by an old BR",
+		}}
+	}
+	issues := make([]CompatIssue, 0)
+	warnf := func(format string, args ...interface{}) {
+		issues = append(issues, CompatIssue{Level: CompatWarn, Message: fmt.Sprintf(format, args...)})
+	}
+	refusef := func(format string, args ...interface{}) {
+		issues = append(issues, CompatIssue{Level: CompatRefuse, Message: fmt.Sprintf(format, args...)})
+	}
+
+	backupBR, err1 := ParseVersion(info.BRVersion)
+	currentBR, err2 := ParseVersion(env.BRVersion)
+	switch {
+	case err1 != nil || err2 != nil:
+		warnf("cannot compare the BR version %s of the backup with the current BR version %s",
+			info.BRVersion, env.BRVersion)
+	case backupBR.Major > currentBR.Major ||
+		(backupBR.Major == currentBR.Major && backupBR.Minor > currentBR.Minor):
+		refusef("the backup is made by BR %s, which is newer than the current BR %s, "+
+			"please use BR %d.%d or later", backupBR, currentBR, backupBR.Major, backupBR.Minor)
+	}
+
+	backupCluster, err1 := ParseVersion(info.ClusterVersion)
+	currentCluster, err2 := ParseVersion(env.ClusterVersion)
+	switch {
+	case err1 != nil || err2 != nil:
+		warnf("cannot compare the cluster version %s of the backup with the current cluster version %s",
+			info.ClusterVersion, env.ClusterVersion)
+	case backupCluster.Major > currentCluster.Major ||
+		(backupCluster.Major == currentCluster.Major && backupCluster.Minor > currentCluster.Minor):
+		refusef("the backup is made in cluster %s, which is newer than the current cluster %s",
+			backupCluster, currentCluster)
+	case backupCluster.Major < currentCluster.Major:
+		warnf("the backup is made in cluster %s, which is an older major version than the current cluster %s",
+			backupCluster, currentCluster)
+	}
+
+	names := make([]string, 0, len(info.Settings))
+	for name := range info.Settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, ok := env.Settings[name]
+		if ok && value != info.Settings[name] {
+			warnf("%s is %q in the backup, but %q in the current cluster", name, info.Settings[name], value)
+		}
+	}
+	return issues
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	. "github.com/pingcap/check"
+)
+
+type testCompatibilitySuite struct{}
+
+var _ = Suite(&testCompatibilitySuite{})
+
+func (r *testCompatibilitySuite) TestParseVersion(c *C) {
+	v, err := ParseVersion("v3.1.0-beta.1-12-g3f1d0a2")
+	c.Assert(err, IsNil)
+	c.Assert(v.Major, Equals, int64(3))
+	c.Assert(v.Minor, Equals, int64(1))
+	// The responses of PD are quoted.
+	v, err = ParseVersion("\"4.0.0-rc\"\n")
+	c.Assert(err, IsNil)
+	c.Assert(v.String(), Equals, "4.0.0-rc")
+	_, err = ParseVersion("None")
+	c.Assert(err, ErrorMatches, `invalid version "None".*`)
+
+	c.Assert(CheckClusterVersion("v3.1.0-beta"), IsNil)
+	c.Assert(CheckClusterVersion("4.0.0"), IsNil)
+	c.Assert(CheckClusterVersion("3.0.8"), ErrorMatches,
+		"cluster version 3.0.8 is not supported, BR requires 3.1.0-alpha or later")
+}
+
+func (r *testCompatibilitySuite) TestCheckCompatibility(c *C) {
+	env := &BackupInfo{
+		BRVersion:      "v3.1.0-beta.2",
+		ClusterVersion: "3.1.0",
+		Settings:       map[string]string{"collation_server": "utf8mb4_bin", "lower_case_table_names": "2"},
+	}
+	check := func(brVersion, clusterVersion string, settings map[string]string) []CompatIssue {
+		return CheckCompatibility(&BackupInfo{
+			BRVersion:      brVersion,
+			ClusterVersion: clusterVersion,
+			Settings:       settings,
+		}, env)
+	}
+
+	c.Assert(check("v3.1.0-beta.1", "3.1.0-rc", env.Settings), HasLen, 0)
+	c.Assert(check("v3.0.0", "3.1.2", nil), HasLen, 0)
+	c.Assert(CheckCompatibility(nil, env), DeepEquals, []CompatIssue{{
+		Level:   CompatWarn,
+		Message: "the backup doesn't record its environment, it may be made by an old BR",
+	}})
+
+	// The backups made by the newer BR are refused.
+	c.Assert(check("v3.2.0", "3.1.0", nil), DeepEquals, []CompatIssue{{
+		Level: CompatRefuse,
+		Message: "the backup is made by BR 3.2.0, which is newer than the current BR 3.1.0-beta.2, " +
+			"please use BR 3.2 or later",
+	}})
+	issues := check("None", "3.1.0", nil)
+	c.Assert(issues, HasLen, 1)
+	c.Assert(issues[0].Level, Equals, CompatWarn)
+
+	// The backups of the newer clusters are refused, and the backups of the
+	// older major versions are warned.
+	c.Assert(check("v3.1.0", "4.0.0", nil), DeepEquals, []CompatIssue{{
+		Level:   CompatRefuse,
+		Message: "the backup is made in cluster 4.0.0, which is newer than the current cluster 3.1.0",
+	}})
+	issues = check("v3.1.0", "3.2.0", nil)
+	c.Assert(issues, HasLen, 1)
+	c.Assert(issues[0].Level, Equals, CompatRefuse)
+	c.Assert(check("v3.1.0", "2.1.0", nil), DeepEquals, []CompatIssue{{
+		Level:   CompatWarn,
+		Message: "the backup is made in cluster 2.1.0, which is an older major version than the current cluster 3.1.0",
+	}})
+
+	// The different settings are warned, the settings unknown to either side
+	// are ignored.
+	issues = check("v3.1.0", "3.1.0", map[string]string{
+		"collation_server":     "utf8mb4_general_ci",
+		"character_set_server": "utf8mb4",
+	})
+	c.Assert(issues, DeepEquals, []CompatIssue{{
+		Level:   CompatWarn,
+		Message: `collation_server is "utf8mb4_general_ci" in the backup, but "utf8mb4_bin" in the current cluster`,
+	}})
+	c.Assert(issues[0].String(), Matches, `\[warn\] collation_server is .*`)
+}
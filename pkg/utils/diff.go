@@ -0,0 +1,367 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+)
+
+// BackupDiff is the difference from an old backup to a new backup. The
+// tables are matched by their names, and the unmatched tables of the same
+// ID are renamed. The DDL jobs are only recorded in the incremental backups,
+// so they are complete only if the new backup is an incremental backup
+// starting no later than the old backup ends.
+type BackupDiff struct {
+	OldEndVersion   uint64          `json:"old-end-version"`
+	NewEndVersion   uint64          `json:"new-end-version"`
+	AddedTables     []string        `json:"added-tables"`
+	DroppedTables   []string        `json:"dropped-tables"`
+	RenamedTables   []TableRename   `json:"renamed-tables"`
+	ChangedTables   []*TableDiff    `json:"changed-tables"`
+	UnchangedTables int             `json:"unchanged-tables"`
+	DDLJobs         []*DDLJobReport `json:"ddl-jobs"`
+	DDLJobsComplete bool            `json:"ddl-jobs-complete"`
+}
+
+// TableRename is a table renamed between the backups.
+type TableRename struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// NameDiff is the difference of the named objects of a table, such as the
+// columns. The modified objects are described as "name: old -> new".
+type NameDiff struct {
+	Added    []string `json:"added,omitempty"`
+	Dropped  []string `json:"dropped,omitempty"`
+	Modified []string `json:"modified,omitempty"`
+}
+
+func (d *NameDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Dropped) == 0 && len(d.Modified) == 0
+}
+
+func (d *NameDiff) String() string {
+	changes := make([]string, 0, len(d.Added)+len(d.Dropped)+len(d.Modified))
+	for _, name := range d.Added {
+		changes = append(changes, "+"+name)
+	}
+	for _, name := range d.Dropped {
+		changes = append(changes, "-"+name)
+	}
+	for _, name := range d.Modified {
+		changes = append(changes, "~"+name)
+	}
+	return strings.Join(changes, ", ")
+}
+
+// TableDiff is the difference of a table between the backups. The totals
+// are summed from the files, and the checksums are recorded at the backup
+// time.
+type TableDiff struct {
+	Name          string    `json:"name"`
+	Columns       *NameDiff `json:"columns,omitempty"`
+	Indexes       *NameDiff `json:"indexes,omitempty"`
+	Partitions    *NameDiff `json:"partitions,omitempty"`
+	OldTotalKvs   uint64    `json:"old-total-kvs"`
+	NewTotalKvs   uint64    `json:"new-total-kvs"`
+	OldTotalBytes uint64    `json:"old-total-bytes"`
+	NewTotalBytes uint64    `json:"new-total-bytes"`
+	OldChecksum   Checksum  `json:"old-checksum"`
+	NewChecksum   Checksum  `json:"new-checksum"`
+}
+
+// describeColumn describes the definition of the column.
+func describeColumn(col *model.ColumnInfo) string {
+	desc := col.GetTypeDesc()
+	if mysql.HasNotNullFlag(col.Flag) {
+		desc += " not null"
+	}
+	if value := col.GetDefaultValue(); value != nil {
+		desc += fmt.Sprintf(" default %v", value)
+	}
+	return desc
+}
+
+// describeIndex describes the definition of the index.
+func describeIndex(index *model.IndexInfo) string {
+	columns := make([]string, 0, len(index.Columns))
+	for _, col := range index.Columns {
+		columns = append(columns, col.Name.O)
+	}
+	desc := "(" + strings.Join(columns, ",") + ")"
+	switch {
+	case index.Primary:
+		desc = "primary " + desc
+	case index.Unique:
+		desc = "unique " + desc
+	}
+	return desc
+}
+
+// namedDesc is the name and the description of a named object.
+type namedDesc struct {
+	name string
+	desc string
+}
+
+// diffNames compares the named objects by their lower case names and their
+// descriptions. It returns nil if nothing changes.
+func diffNames(old, new map[string]namedDesc) *NameDiff {
+	diff := &NameDiff{}
+	for key, obj := range new {
+		oldObj, ok := old[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, obj.name)
+		case oldObj.desc != obj.desc:
+			diff.Modified = append(diff.Modified, fmt.Sprintf("%s: %s -> %s", obj.name, oldObj.desc, obj.desc))
+		}
+	}
+	for key, obj := range old {
+		if _, ok := new[key]; !ok {
+			diff.Dropped = append(diff.Dropped, obj.name)
+		}
+	}
+	if diff.empty() {
+		return nil
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Dropped)
+	sort.Strings(diff.Modified)
+	return diff
+}
+
+// diffSchemas compares the columns, the indexes and the partitions of the
+// table.
+func diffSchemas(diff *TableDiff, old, new *model.TableInfo) {
+	columns := func(info *model.TableInfo) map[string]namedDesc {
+		descs := make(map[string]namedDesc)
+		for _, col := range info.Columns {
+			if col.State == model.StatePublic {
+				descs[col.Name.L] = namedDesc{name: col.Name.O, desc: describeColumn(col)}
+			}
+		}
+		return descs
+	}
+	indexes := func(info *model.TableInfo) map[string]namedDesc {
+		descs := make(map[string]namedDesc)
+		for _, index := range info.Indices {
+			if index.State == model.StatePublic {
+				descs[index.Name.L] = namedDesc{name: index.Name.O, desc: describeIndex(index)}
+			}
+		}
+		return descs
+	}
+	partitions := func(info *model.TableInfo) map[string]namedDesc {
+		descs := make(map[string]namedDesc)
+		if info.Partition != nil {
+			for _, def := range info.Partition.Definitions {
+				descs[def.Name.L] = namedDesc{name: def.Name.O, desc: strings.Join(def.LessThan, ",")}
+			}
+		}
+		return descs
+	}
+	diff.Columns = diffNames(columns(old), columns(new))
+	diff.Indexes = diffNames(indexes(old), indexes(new))
+	diff.Partitions = diffNames(partitions(old), partitions(new))
+}
+
+func newTableDiff(name string, old, new *Table) *TableDiff {
+	diff := &TableDiff{
+		Name:        name,
+		OldChecksum: Checksum{Crc64Xor: old.Crc64Xor, TotalKvs: old.TotalKvs, TotalBytes: old.TotalBytes},
+		NewChecksum: Checksum{Crc64Xor: new.Crc64Xor, TotalKvs: new.TotalKvs, TotalBytes: new.TotalBytes},
+	}
+	for _, file := range old.Files() {
+		diff.OldTotalKvs += file.TotalKvs
+		diff.OldTotalBytes += file.TotalBytes
+	}
+	for _, file := range new.Files() {
+		diff.NewTotalKvs += file.TotalKvs
+		diff.NewTotalBytes += file.TotalBytes
+	}
+	diffSchemas(diff, old.Info, new.Info)
+	return diff
+}
+
+func (d *TableDiff) changed() bool {
+	return d.Columns != nil || d.Indexes != nil || d.Partitions != nil ||
+		d.OldTotalKvs != d.NewTotalKvs || d.OldTotalBytes != d.NewTotalBytes ||
+		d.OldChecksum != d.NewChecksum
+}
+
+// loadTablesByName loads the tables of the backup meta read by the reader by
+// their quoted names.
+func loadTablesByName(ctx context.Context, reader *MetaReader) (map[string]*Table, error) {
+	databases, err := ReadBackupTables(ctx, reader)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	tables := make(map[string]*Table)
+	for _, db := range databases {
+		for _, table := range db.Tables {
+			tables[EncloseName(db.Info.Name.O)+"."+EncloseName(table.Info.Name.O)] = table
+		}
+	}
+	return tables, nil
+}
+
+// NewBackupDiff compares the new backup with the old backup. The DDL jobs
+// are the jobs included in the new backup, which is an incremental backup,
+// and finished after the old backup. A full backup includes no DDL jobs.
+func NewBackupDiff(ctx context.Context, oldReader, newReader *MetaReader) (*BackupDiff, error) {
+	old, new := oldReader.Meta(), newReader.Meta()
+	diff := &BackupDiff{
+		OldEndVersion: old.EndVersion,
+		NewEndVersion: new.EndVersion,
+		AddedTables:   make([]string, 0),
+		DroppedTables: make([]string, 0),
+		RenamedTables: make([]TableRename, 0),
+		ChangedTables: make([]*TableDiff, 0),
+		DDLJobs:       make([]*DDLJobReport, 0),
+		DDLJobsComplete: new.StartVersion != 0 && new.StartVersion != new.EndVersion &&
+			new.StartVersion <= old.EndVersion,
+	}
+	oldTables, err := loadTablesByName(ctx, oldReader)
+	if err != nil {
+		return nil, errors.Annotate(err, "load the tables of the old backup failed")
+	}
+	newTables, err := loadTablesByName(ctx, newReader)
+	if err != nil {
+		return nil, errors.Annotate(err, "load the tables of the new backup failed")
+	}
+
+	compare := func(name string, oldTable, newTable *Table) {
+		tableDiff := newTableDiff(name, oldTable, newTable)
+		if tableDiff.changed() {
+			diff.ChangedTables = append(diff.ChangedTables, tableDiff)
+		} else {
+			diff.UnchangedTables++
+		}
+	}
+	droppedIDs := make(map[int64]string)
+	for name, oldTable := range oldTables {
+		if newTable, ok := newTables[name]; ok {
+			compare(name, oldTable, newTable)
+		} else {
+			droppedIDs[oldTable.Info.ID] = name
+		}
+	}
+	for name, newTable := range newTables {
+		if _, ok := oldTables[name]; ok {
+			continue
+		}
+		oldName, ok := droppedIDs[newTable.Info.ID]
+		if !ok {
+			diff.AddedTables = append(diff.AddedTables, name)
+			continue
+		}
+		delete(droppedIDs, newTable.Info.ID)
+		diff.RenamedTables = append(diff.RenamedTables, TableRename{Old: oldName, New: name})
+		compare(name, oldTables[oldName], newTable)
+	}
+	for _, name := range droppedIDs {
+		diff.DroppedTables = append(diff.DroppedTables, name)
+	}
+	sort.Strings(diff.AddedTables)
+	sort.Strings(diff.DroppedTables)
+	sort.Slice(diff.RenamedTables, func(i, j int) bool { return diff.RenamedTables[i].New < diff.RenamedTables[j].New })
+	sort.Slice(diff.ChangedTables, func(i, j int) bool { return diff.ChangedTables[i].Name < diff.ChangedTables[j].Name })
+
+	jobs, err := decodeDDLJobs(new)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if job.BinlogInfo != nil && job.BinlogInfo.FinishedTS != 0 && job.BinlogInfo.FinishedTS <= old.EndVersion {
+			continue
+		}
+		diff.DDLJobs = append(diff.DDLJobs, newDDLJobReport(job))
+	}
+	return diff, nil
+}
+
+func formatChange(old, new uint64, format func(uint64) string) string {
+	if old == new {
+		return format(new)
+	}
+	sign := "+"
+	delta := new - old
+	if new < old {
+		sign, delta = "-", old-new
+	}
+	return fmt.Sprintf("%s -> %s (%s%s)", format(old), format(new), sign, format(delta))
+}
+
+func formatCount(n uint64) string {
+	return fmt.Sprintf("%d", n)
+}
+
+// WriteText writes the difference as the readable text.
+func (d *BackupDiff) WriteText(w io.Writer) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "old end version: %d (%s)\n", d.OldEndVersion, formatTS(d.OldEndVersion))
+	fmt.Fprintf(&buf, "new end version: %d (%s)\n", d.NewEndVersion, formatTS(d.NewEndVersion))
+	if len(d.AddedTables) > 0 {
+		buf.WriteString("\nadded tables:\n")
+		for _, name := range d.AddedTables {
+			fmt.Fprintf(&buf, "  + %s\n", name)
+		}
+	}
+	if len(d.DroppedTables) > 0 {
+		buf.WriteString("\ndropped tables:\n")
+		for _, name := range d.DroppedTables {
+			fmt.Fprintf(&buf, "  - %s\n", name)
+		}
+	}
+	if len(d.RenamedTables) > 0 {
+		buf.WriteString("\nrenamed tables:\n")
+		for _, rename := range d.RenamedTables {
+			fmt.Fprintf(&buf, "  %s -> %s\n", rename.Old, rename.New)
+		}
+	}
+	if len(d.ChangedTables) > 0 {
+		buf.WriteString("\nchanged tables:\n")
+		for _, table := range d.ChangedTables {
+			fmt.Fprintf(&buf, "  %s\n", table.Name)
+			if table.Columns != nil {
+				fmt.Fprintf(&buf, "    columns:    %s\n", table.Columns)
+			}
+			if table.Indexes != nil {
+				fmt.Fprintf(&buf, "    indexes:    %s\n", table.Indexes)
+			}
+			if table.Partitions != nil {
+				fmt.Fprintf(&buf, "    partitions: %s\n", table.Partitions)
+			}
+			fmt.Fprintf(&buf, "    kvs:        %s\n", formatChange(table.OldTotalKvs, table.NewTotalKvs, formatCount))
+			fmt.Fprintf(&buf, "    size:       %s\n", formatChange(table.OldTotalBytes, table.NewTotalBytes, FormatBytes))
+			if table.OldChecksum != table.NewChecksum {
+				fmt.Fprintf(&buf, "    checksum:   %d/%d/%d -> %d/%d/%d\n",
+					table.OldChecksum.Crc64Xor, table.OldChecksum.TotalKvs, table.OldChecksum.TotalBytes,
+					table.NewChecksum.Crc64Xor, table.NewChecksum.TotalKvs, table.NewChecksum.TotalBytes)
+			}
+		}
+	}
+	if !d.DDLJobsComplete {
+		buf.WriteString("\nddl jobs: incomplete, only an incremental backup since the old backup " +
+			"records all the ddl jobs between the backups\n")
+	}
+	if len(d.DDLJobs) > 0 {
+		fmt.Fprintf(&buf, "\nddl jobs: %d\n", len(d.DDLJobs))
+		for _, job := range d.DDLJobs {
+			fmt.Fprintf(&buf, "  %d %s %s: %s\n", job.ID, job.Type, job.Schema, job.Query)
+		}
+	}
+	fmt.Fprintf(&buf, "\n%d added, %d dropped, %d renamed, %d changed, %d unchanged tables\n",
+		len(d.AddedTables), len(d.DroppedTables), len(d.RenamedTables), len(d.ChangedTables), d.UnchangedTables)
+	_, err := w.Write(buf.Bytes())
+	return errors.Trace(err)
+}
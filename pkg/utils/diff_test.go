@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/types"
+)
+
+type testDiffSuite struct{}
+
+var _ = Suite(&testDiffSuite{})
+
+func mockDiffColumn(id int64, name string, tp byte) *model.ColumnInfo {
+	return &model.ColumnInfo{
+		ID:        id,
+		Name:      model.NewCIStr(name),
+		State:     model.StatePublic,
+		FieldType: *types.NewFieldType(tp),
+	}
+}
+
+func (r *testDiffSuite) TestBackupDiff(c *C) {
+	a := mockDiffColumn(1, "a", mysql.TypeLong)
+	b := mockDiffColumn(2, "b", mysql.TypeVarchar)
+	b.Flen = 10
+	idx := &model.IndexInfo{
+		Name:    model.NewCIStr("idx"),
+		Columns: []*model.IndexColumn{{Name: model.NewCIStr("b")}},
+		State:   model.StatePublic,
+	}
+	t1 := &model.TableInfo{ID: 1, Name: model.NewCIStr("t1"), Columns: []*model.ColumnInfo{a, b}, Indices: []*model.IndexInfo{idx}}
+	t2 := &model.TableInfo{ID: 2, Name: model.NewCIStr("t2"), Columns: []*model.ColumnInfo{a}}
+	t3 := &model.TableInfo{ID: 3, Name: model.NewCIStr("t3"), Columns: []*model.ColumnInfo{a}}
+	t4 := &model.TableInfo{ID: 4, Name: model.NewCIStr("t4"), Columns: []*model.ColumnInfo{a}}
+	oldMeta := &backup.BackupMeta{
+		EndVersion: 100,
+		Schemas: []*backup.Schema{
+			mockReportSchema(c, "db", t1, 1),
+			mockReportSchema(c, "db", t2, 2),
+			mockReportSchema(c, "db", t3, 3),
+			mockReportSchema(c, "db", t4, 4),
+		},
+		Files: []*backup.File{mockReportFile(1, 10, 100), mockReportFile(2, 20, 200)},
+	}
+
+	// t1 modifies b, adds c and a unique index, and drops idx, t2 is
+	// unchanged, t3 is dropped, t4 is renamed to t5, and t6 is added.
+	newB := mockDiffColumn(2, "b", mysql.TypeVarchar)
+	newB.Flen = 20
+	newB.Flag = mysql.NotNullFlag
+	cc := mockDiffColumn(3, "c", mysql.TypeLonglong)
+	cc.DefaultValue = "1"
+	uk := &model.IndexInfo{
+		Name:    model.NewCIStr("uk"),
+		Columns: []*model.IndexColumn{{Name: model.NewCIStr("a")}, {Name: model.NewCIStr("c")}},
+		Unique:  true,
+		State:   model.StatePublic,
+	}
+	newT1 := &model.TableInfo{ID: 1, Name: model.NewCIStr("t1"), Columns: []*model.ColumnInfo{a, newB, cc}, Indices: []*model.IndexInfo{uk}}
+	t5 := &model.TableInfo{ID: 4, Name: model.NewCIStr("t5"), Columns: []*model.ColumnInfo{a}}
+	t6 := &model.TableInfo{ID: 6, Name: model.NewCIStr("t6"), Columns: []*model.ColumnInfo{a}}
+	ddls, err := json.Marshal([]*model.Job{
+		{ID: 7, Type: model.ActionAddColumn, SchemaName: "db", Query: "alter table t1 add column c bigint",
+			BinlogInfo: &model.HistoryInfo{FinishedTS: 90, TableInfo: newT1}},
+		{ID: 8, Type: model.ActionCreateTable, SchemaName: "db", Query: "create table t6 (a int)",
+			BinlogInfo: &model.HistoryInfo{FinishedTS: 110, TableInfo: t6}},
+	})
+	c.Assert(err, IsNil)
+	newMeta := &backup.BackupMeta{
+		StartVersion: 100,
+		EndVersion:   200,
+		Schemas: []*backup.Schema{
+			mockReportSchema(c, "db", newT1, 5),
+			mockReportSchema(c, "db", t2, 2),
+			mockReportSchema(c, "db", t5, 4),
+			mockReportSchema(c, "db", t6, 6),
+		},
+		Files: []*backup.File{mockReportFile(1, 15, 150), mockReportFile(2, 20, 200)},
+		Ddls:  ddls,
+	}
+
+	ctx := context.Background()
+	oldReader := mockMetaReader(c, createLocalStorage(c), oldMeta)
+	newReader := mockMetaReader(c, createLocalStorage(c), newMeta)
+	diff, err := NewBackupDiff(ctx, oldReader, newReader)
+	c.Assert(err, IsNil)
+	c.Assert(diff.AddedTables, DeepEquals, []string{"`db`.`t6`"})
+	c.Assert(diff.DroppedTables, DeepEquals, []string{"`db`.`t3`"})
+	c.Assert(diff.RenamedTables, DeepEquals, []TableRename{{Old: "`db`.`t4`", New: "`db`.`t5`"}})
+	c.Assert(diff.UnchangedTables, Equals, 2)
+	c.Assert(diff.ChangedTables, DeepEquals, []*TableDiff{{
+		Name: "`db`.`t1`",
+		Columns: &NameDiff{
+			Added:    []string{"c"},
+			Modified: []string{"b: varchar(10) -> varchar(20) not null"},
+		},
+		Indexes:       &NameDiff{Added: []string{"uk"}, Dropped: []string{"idx"}},
+		OldTotalKvs:   10,
+		NewTotalKvs:   15,
+		OldTotalBytes: 100,
+		NewTotalBytes: 150,
+		OldChecksum:   Checksum{1, 1, 2},
+		NewChecksum:   Checksum{5, 1, 2},
+	}})
+	c.Assert(diff.DDLJobs, DeepEquals, []*DDLJobReport{{
+		ID: 8, Type: "create table", Schema: "db", Table: "t6", Query: "create table t6 (a int)",
+	}})
+	c.Assert(diff.DDLJobsComplete, IsTrue)
+
+	var buf bytes.Buffer
+	c.Assert(diff.WriteText(&buf), IsNil)
+	c.Assert(buf.String(), Matches, "(?s).*added tables:\n  \\+ `db`.`t6`\n.*"+
+		"renamed tables:\n  `db`.`t4` -> `db`.`t5`\n.*"+
+		"    columns: +\\+c, ~b: varchar\\(10\\) -> varchar\\(20\\) not null\n"+
+		"    indexes: +\\+uk, -idx\n"+
+		"    kvs: +10 -> 15 \\(\\+5\\)\n.*"+
+		"ddl jobs: 1\n.*"+
+		"1 added, 1 dropped, 1 renamed, 1 changed, 2 unchanged tables\n")
+
+	c.Assert(buf.String(), Not(Matches), "(?s).*ddl jobs: incomplete.*")
+
+	// The same backup has no difference, and the full backup doesn't record
+	// the ddl jobs.
+	diff, err = NewBackupDiff(ctx, oldReader, oldReader)
+	c.Assert(err, IsNil)
+	c.Assert(diff.ChangedTables, HasLen, 0)
+	c.Assert(diff.UnchangedTables, Equals, 4)
+	c.Assert(diff.DDLJobsComplete, IsFalse)
+	buf.Reset()
+	c.Assert(diff.WriteText(&buf), IsNil)
+	c.Assert(buf.String(), Matches, "(?s).*ddl jobs: incomplete.*")
+}
+
+func (r *testDiffSuite) TestDiffSchemas(c *C) {
+	// The objects of different kinds may have the same name.
+	b := mockDiffColumn(1, "b", mysql.TypeLong)
+	idx := &model.IndexInfo{
+		Name:    model.NewCIStr("B"),
+		Columns: []*model.IndexColumn{{Name: model.NewCIStr("b")}},
+		State:   model.StatePublic,
+	}
+	old := &model.TableInfo{Columns: []*model.ColumnInfo{b}, Indices: []*model.IndexInfo{idx}}
+	new := &model.TableInfo{
+		Columns:   []*model.ColumnInfo{b, mockDiffColumn(2, "P", mysql.TypeLong)},
+		Partition: &model.PartitionInfo{Definitions: []model.PartitionDefinition{{Name: model.NewCIStr("p")}}},
+	}
+	diff := &TableDiff{}
+	diffSchemas(diff, old, new)
+	c.Assert(diff.Columns, DeepEquals, &NameDiff{Added: []string{"P"}})
+	c.Assert(diff.Indexes, DeepEquals, &NameDiff{Dropped: []string{"B"}})
+	c.Assert(diff.Partitions, DeepEquals, &NameDiff{Added: []string{"p"}})
+}
+
+func (r *testDiffSuite) TestDescribeIndex(c *C) {
+	index := &model.IndexInfo{
+		Columns: []*model.IndexColumn{{Name: model.NewCIStr("a")}, {Name: model.NewCIStr("b")}},
+		Primary: true,
+		Unique:  true,
+	}
+	c.Assert(describeIndex(index), Equals, "primary (a,b)")
+	index.Primary = false
+	c.Assert(describeIndex(index), Equals, "unique (a,b)")
+	index.Unique = false
+	c.Assert(describeIndex(index), Equals, "(a,b)")
+}
@@ -0,0 +1,375 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+const (
+	// MetaIndexFile represents the index file name of the sharded backup meta
+	MetaIndexFile = "backupmeta.index"
+	// MetaInfoFile represents the file name of the environment of the backup
+	MetaInfoFile = "backupmeta.info"
+
+	// MetaV1 saves the whole backup meta in MetaFile.
+	MetaV1 = 1
+	// MetaV2 saves the files and the schemas in the numbered shards, which
+	// are referenced by MetaIndexFile.
+	MetaV2 = 2
+
+	// DefaultMetaShardSize is the default number of the files or the schemas
+	// in a shard of the backup meta.
+	DefaultMetaShardSize = 65536
+
+	metaFileShardPrefix   = "backupmeta.files."
+	metaSchemaShardPrefix = "backupmeta.schemas."
+)
+
+// MetaIndex is the index of the sharded backup meta. Each shard is a backup
+// meta which only contains the files or the schemas, and the rest of the
+// backup meta is saved in the index.
+type MetaIndex struct {
+	Version int `json:"version"`
+	// Meta is the encoded backup meta without the files and the schemas.
+	Meta         []byte   `json:"meta"`
+	FileShards   []string `json:"file-shards"`
+	SchemaShards []string `json:"schema-shards"`
+}
+
+// BackupInfo is the environment which a backup is made in, it is saved in
+// MetaInfoFile along with the backup meta of any version.
+type BackupInfo struct {
+	BRVersion      string `json:"br-version"`
+	BRGitHash      string `json:"br-git-hash"`
+	ClusterID      uint64 `json:"cluster-id"`
+	ClusterVersion string `json:"cluster-version"`
+	// Settings are the global variables of the cluster which affect how the
+	// backed up data is interpreted, see CompatibilitySettings.
+	Settings map[string]string `json:"settings"`
+}
+
+// MetaWriter writes the backup meta. The files and the schemas are written
+// to the shards once there are enough of them in MetaV2, so that they are
+// not all kept in memory.
+type MetaWriter struct {
+	storage   storage.ExternalStorage
+	version   int
+	shardSize int
+
+	info    *BackupInfo
+	index   MetaIndex
+	files   []*backup.File
+	schemas []*backup.Schema
+}
+
+// NewMetaWriter returns a writer of the backup meta in the version.
+func NewMetaWriter(s storage.ExternalStorage, version, shardSize int) *MetaWriter {
+	if shardSize <= 0 {
+		shardSize = DefaultMetaShardSize
+	}
+	return &MetaWriter{
+		storage:   s,
+		version:   version,
+		shardSize: shardSize,
+		index:     MetaIndex{Version: version},
+	}
+}
+
+// SetInfo sets the environment of the backup, which is written on finishing.
+func (w *MetaWriter) SetInfo(info *BackupInfo) {
+	w.info = info
+}
+
+// AppendFiles appends the files to the backup meta.
+func (w *MetaWriter) AppendFiles(ctx context.Context, files []*backup.File) error {
+	w.files = append(w.files, files...)
+	if w.version == MetaV1 {
+		return nil
+	}
+	for len(w.files) >= w.shardSize {
+		if err := w.flushFiles(ctx, w.shardSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AppendSchemas appends the schemas to the backup meta.
+func (w *MetaWriter) AppendSchemas(ctx context.Context, schemas []*backup.Schema) error {
+	w.schemas = append(w.schemas, schemas...)
+	if w.version == MetaV1 {
+		return nil
+	}
+	for len(w.schemas) >= w.shardSize {
+		if err := w.flushSchemas(ctx, w.shardSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *MetaWriter) flushFiles(ctx context.Context, n int) error {
+	name := fmt.Sprintf("%s%06d", metaFileShardPrefix, len(w.index.FileShards)+1)
+	if err := w.writeShard(ctx, name, &backup.BackupMeta{Files: w.files[:n]}); err != nil {
+		return err
+	}
+	w.index.FileShards = append(w.index.FileShards, name)
+	// Copy the rest, so that the flushed files are released.
+	w.files = append([]*backup.File{}, w.files[n:]...)
+	return nil
+}
+
+func (w *MetaWriter) flushSchemas(ctx context.Context, n int) error {
+	name := fmt.Sprintf("%s%06d", metaSchemaShardPrefix, len(w.index.SchemaShards)+1)
+	if err := w.writeShard(ctx, name, &backup.BackupMeta{Schemas: w.schemas[:n]}); err != nil {
+		return err
+	}
+	w.index.SchemaShards = append(w.index.SchemaShards, name)
+	w.schemas = append([]*backup.Schema{}, w.schemas[n:]...)
+	return nil
+}
+
+func (w *MetaWriter) writeShard(ctx context.Context, name string, shard *backup.BackupMeta) error {
+	data, err := proto.Marshal(shard)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("save backup meta shard", zap.String("name", name),
+		zap.Int("files", len(shard.Files)), zap.Int("schemas", len(shard.Schemas)))
+	return errors.Annotatef(w.storage.Write(ctx, name, data), "save %s failed", name)
+}
+
+// Finish writes the rest of the files and the schemas along with the meta,
+// whose files and schemas are ignored. The index is written at last in
+// MetaV2, so that a backup meta without the index is never read. The
+// environment is written before the backup meta for the same reason.
+func (w *MetaWriter) Finish(ctx context.Context, meta *backup.BackupMeta) error {
+	if w.info != nil {
+		data, err := json.Marshal(w.info)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = w.storage.Write(ctx, MetaInfoFile, data); err != nil {
+			return errors.Annotatef(err, "save %s failed", MetaInfoFile)
+		}
+	}
+	m := *meta
+	if w.version == MetaV1 {
+		m.Files, m.Schemas = w.files, w.schemas
+		data, err := proto.Marshal(&m)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return w.storage.Write(ctx, MetaFile, data)
+	}
+
+	if len(w.files) > 0 {
+		if err := w.flushFiles(ctx, len(w.files)); err != nil {
+			return err
+		}
+	}
+	if len(w.schemas) > 0 {
+		if err := w.flushSchemas(ctx, len(w.schemas)); err != nil {
+			return err
+		}
+	}
+	m.Files, m.Schemas = nil, nil
+	data, err := proto.Marshal(&m)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	w.index.Meta = data
+	indexData, err := json.Marshal(&w.index)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return w.storage.Write(ctx, MetaIndexFile, indexData)
+}
+
+// MetaExists checks whether a backup meta of any version exists.
+func MetaExists(ctx context.Context, s storage.ExternalStorage) (bool, error) {
+	for _, name := range []string{MetaFile, MetaIndexFile} {
+		exist, err := s.FileExists(ctx, name)
+		if err != nil {
+			return false, errors.Annotatef(err, "error occurred when checking %s file", name)
+		}
+		if exist {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MetaReader reads the backup meta of any version. The files and the
+// schemas are read shard by shard in MetaV2.
+type MetaReader struct {
+	storage storage.ExternalStorage
+	// meta is the whole backup meta in MetaV1, or the backup meta without the
+	// files and the schemas in MetaV2.
+	meta  *backup.BackupMeta
+	index *MetaIndex
+}
+
+// NewMetaReader reads the backup meta in MetaV1, or the index of the backup
+// meta in MetaV2.
+func NewMetaReader(ctx context.Context, s storage.ExternalStorage) (*MetaReader, error) {
+	exist, err := s.FileExists(ctx, MetaFile)
+	if err != nil {
+		return nil, errors.Annotatef(err, "error occurred when checking %s file", MetaFile)
+	}
+	if exist {
+		data, err := s.Read(ctx, MetaFile)
+		if err != nil {
+			return nil, errors.Annotate(err, "load backupmeta failed")
+		}
+		meta := &backup.BackupMeta{}
+		if err = proto.Unmarshal(data, meta); err != nil {
+			return nil, errors.Annotate(err, "parse backupmeta failed")
+		}
+		return &MetaReader{storage: s, meta: meta}, nil
+	}
+
+	data, err := s.Read(ctx, MetaIndexFile)
+	if err != nil {
+		return nil, errors.Annotate(err, "load backupmeta failed")
+	}
+	index := &MetaIndex{}
+	if err = json.Unmarshal(data, index); err != nil {
+		return nil, errors.Annotate(err, "parse backupmeta index failed")
+	}
+	if index.Version != MetaV2 {
+		return nil, errors.Errorf("unsupported backupmeta version %d", index.Version)
+	}
+	meta := &backup.BackupMeta{}
+	if err = proto.Unmarshal(index.Meta, meta); err != nil {
+		return nil, errors.Annotate(err, "parse backupmeta failed")
+	}
+	return &MetaReader{storage: s, meta: meta, index: index}, nil
+}
+
+// Version returns the version of the backup meta.
+func (r *MetaReader) Version() int {
+	if r.index != nil {
+		return r.index.Version
+	}
+	return MetaV1
+}
+
+// Meta returns the backup meta, the files and the schemas are excluded in
+// MetaV2.
+func (r *MetaReader) Meta() *backup.BackupMeta {
+	return r.meta
+}
+
+// Info returns the environment of the backup, it is nil if the backup is
+// made by the old versions which don't save it.
+func (r *MetaReader) Info(ctx context.Context) (*BackupInfo, error) {
+	exist, err := r.storage.FileExists(ctx, MetaInfoFile)
+	if err != nil {
+		return nil, errors.Annotatef(err, "error occurred when checking %s file", MetaInfoFile)
+	}
+	if !exist {
+		return nil, nil
+	}
+	data, err := r.storage.Read(ctx, MetaInfoFile)
+	if err != nil {
+		return nil, errors.Annotatef(err, "load %s failed", MetaInfoFile)
+	}
+	info := &BackupInfo{}
+	if err = json.Unmarshal(data, info); err != nil {
+		return nil, errors.Annotatef(err, "parse %s failed", MetaInfoFile)
+	}
+	return info, nil
+}
+
+// WalkFiles calls fn on each file of the backup meta.
+func (r *MetaReader) WalkFiles(ctx context.Context, fn func(*backup.File) error) error {
+	if r.index == nil {
+		for _, file := range r.meta.Files {
+			if err := fn(file); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return r.walkShards(ctx, r.index.FileShards, func(shard *backup.BackupMeta) error {
+		for _, file := range shard.Files {
+			if err := fn(file); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WalkSchemas calls fn on each schema of the backup meta.
+func (r *MetaReader) WalkSchemas(ctx context.Context, fn func(*backup.Schema) error) error {
+	if r.index == nil {
+		for _, schema := range r.meta.Schemas {
+			if err := fn(schema); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return r.walkShards(ctx, r.index.SchemaShards, func(shard *backup.BackupMeta) error {
+		for _, schema := range shard.Schemas {
+			if err := fn(schema); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *MetaReader) walkShards(ctx context.Context, names []string, fn func(*backup.BackupMeta) error) error {
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return errors.Trace(err)
+		}
+		data, err := r.storage.Read(ctx, name)
+		if err != nil {
+			return errors.Annotatef(err, "load %s failed", name)
+		}
+		shard := &backup.BackupMeta{}
+		if err = proto.Unmarshal(data, shard); err != nil {
+			return errors.Annotatef(err, "parse %s failed", name)
+		}
+		if err = fn(shard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadAll returns the whole backup meta with all the files and the schemas.
+func (r *MetaReader) ReadAll(ctx context.Context) (*backup.BackupMeta, error) {
+	if r.index == nil {
+		return r.meta, nil
+	}
+	meta := *r.meta
+	err := r.WalkFiles(ctx, func(file *backup.File) error {
+		meta.Files = append(meta.Files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = r.WalkSchemas(ctx, func(schema *backup.Schema) error {
+		meta.Schemas = append(meta.Schemas, schema)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
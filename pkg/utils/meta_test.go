@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+type testMetaSuite struct{}
+
+var _ = Suite(&testMetaSuite{})
+
+func createLocalStorage(c *C) storage.ExternalStorage {
+	s, err := storage.Create(context.Background(), &backup.StorageBackend{
+		Backend: &backup.StorageBackend_Local{Local: &backup.Local{Path: c.MkDir()}},
+	}, false)
+	c.Assert(err, IsNil)
+	return s
+}
+
+// mockMetaReader writes the backup meta to the storage in MetaV2, whose
+// shards have 2 files or schemas, and returns the reader of it.
+func mockMetaReader(c *C, s storage.ExternalStorage, meta *backup.BackupMeta) *MetaReader {
+	ctx := context.Background()
+	writer := NewMetaWriter(s, MetaV2, 2)
+	c.Assert(writer.AppendFiles(ctx, meta.Files), IsNil)
+	c.Assert(writer.AppendSchemas(ctx, meta.Schemas), IsNil)
+	c.Assert(writer.Finish(ctx, meta), IsNil)
+	reader, err := NewMetaReader(ctx, s)
+	c.Assert(err, IsNil)
+	return reader
+}
+
+func mockMetaContent(files, schemas int) ([]*backup.File, []*backup.Schema) {
+	mockFiles := make([]*backup.File, 0, files)
+	for i := 0; i < files; i++ {
+		mockFiles = append(mockFiles, &backup.File{Name: fmt.Sprintf("%d.sst", i)})
+	}
+	mockSchemas := make([]*backup.Schema, 0, schemas)
+	for i := 0; i < schemas; i++ {
+		mockSchemas = append(mockSchemas, &backup.Schema{Table: []byte(fmt.Sprintf("t%d", i))})
+	}
+	return mockFiles, mockSchemas
+}
+
+func readAllMeta(c *C, s storage.ExternalStorage, version int) *backup.BackupMeta {
+	reader, err := NewMetaReader(context.Background(), s)
+	c.Assert(err, IsNil)
+	c.Assert(reader.Version(), Equals, version)
+	meta, err := reader.ReadAll(context.Background())
+	c.Assert(err, IsNil)
+	return meta
+}
+
+func (r *testMetaSuite) TestMetaV1(c *C) {
+	ctx := context.Background()
+	files, schemas := mockMetaContent(5, 3)
+
+	// The backup meta saved by the old versions is readable.
+	s := createLocalStorage(c)
+	data, err := proto.Marshal(&backup.BackupMeta{EndVersion: 10, Files: files, Schemas: schemas})
+	c.Assert(err, IsNil)
+	c.Assert(s.Write(ctx, MetaFile, data), IsNil)
+	meta := readAllMeta(c, s, MetaV1)
+	c.Assert(meta.EndVersion, Equals, uint64(10))
+	c.Assert(meta.Files, DeepEquals, files)
+	c.Assert(meta.Schemas, DeepEquals, schemas)
+
+	s = createLocalStorage(c)
+	exist, err := MetaExists(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsFalse)
+	writer := NewMetaWriter(s, MetaV1, 2)
+	c.Assert(writer.AppendFiles(ctx, files), IsNil)
+	c.Assert(writer.AppendSchemas(ctx, schemas), IsNil)
+	c.Assert(writer.Finish(ctx, &backup.BackupMeta{EndVersion: 10}), IsNil)
+	exist, err = MetaExists(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsTrue)
+	exist, err = s.FileExists(ctx, MetaIndexFile)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsFalse)
+	meta = readAllMeta(c, s, MetaV1)
+	c.Assert(meta.EndVersion, Equals, uint64(10))
+	c.Assert(meta.Files, DeepEquals, files)
+	c.Assert(meta.Schemas, DeepEquals, schemas)
+
+	// The environment is absent if it isn't set.
+	reader, err := NewMetaReader(ctx, s)
+	c.Assert(err, IsNil)
+	info, err := reader.Info(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(info, IsNil)
+}
+
+func (r *testMetaSuite) TestMetaV2(c *C) {
+	ctx := context.Background()
+	files, schemas := mockMetaContent(5, 3)
+	s := createLocalStorage(c)
+	writer := NewMetaWriter(s, MetaV2, 2)
+	// The full shards are written on appending.
+	c.Assert(writer.AppendFiles(ctx, files[:3]), IsNil)
+	exist, err := s.FileExists(ctx, "backupmeta.files.000001")
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsTrue)
+	exist, err = MetaExists(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsFalse)
+	c.Assert(writer.AppendFiles(ctx, files[3:]), IsNil)
+	c.Assert(writer.AppendSchemas(ctx, schemas), IsNil)
+	info := &BackupInfo{
+		BRVersion:      "v3.1.0",
+		ClusterID:      1,
+		ClusterVersion: "3.1.0",
+		Settings:       map[string]string{"collation_server": "utf8mb4_bin"},
+	}
+	writer.SetInfo(info)
+	c.Assert(writer.Finish(ctx, &backup.BackupMeta{EndVersion: 10, Files: files}), IsNil)
+
+	data, err := s.Read(ctx, MetaIndexFile)
+	c.Assert(err, IsNil)
+	index := &MetaIndex{}
+	c.Assert(json.Unmarshal(data, index), IsNil)
+	c.Assert(index.FileShards, DeepEquals,
+		[]string{"backupmeta.files.000001", "backupmeta.files.000002", "backupmeta.files.000003"})
+	c.Assert(index.SchemaShards, DeepEquals, []string{"backupmeta.schemas.000001", "backupmeta.schemas.000002"})
+	exist, err = s.FileExists(ctx, MetaFile)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsFalse)
+
+	reader, err := NewMetaReader(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(reader.Version(), Equals, MetaV2)
+	// The index doesn't contain the files.
+	c.Assert(reader.Meta().EndVersion, Equals, uint64(10))
+	c.Assert(reader.Meta().Files, HasLen, 0)
+	names := make([]string, 0)
+	c.Assert(reader.WalkFiles(ctx, func(file *backup.File) error {
+		names = append(names, file.Name)
+		return nil
+	}), IsNil)
+	c.Assert(names, DeepEquals, []string{"0.sst", "1.sst", "2.sst", "3.sst", "4.sst"})
+	savedInfo, err := reader.Info(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(savedInfo, DeepEquals, info)
+	meta := readAllMeta(c, s, MetaV2)
+	c.Assert(meta.Files, DeepEquals, files)
+	c.Assert(meta.Schemas, DeepEquals, schemas)
+
+	// The unknown versions are refused.
+	index.Version = 3
+	data, err = json.Marshal(index)
+	c.Assert(err, IsNil)
+	c.Assert(s.Write(ctx, MetaIndexFile, data), IsNil)
+	_, err = NewMetaReader(ctx, s)
+	c.Assert(err, ErrorMatches, "unsupported backupmeta version 3")
+}
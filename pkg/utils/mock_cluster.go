@@ -36,8 +36,9 @@ type MockCluster struct {
 	kv.Storage
 	*server.TiDBDriver
 	*domain.Domain
-	DSN      string
-	PDClient pd.Client
+	DSN        string
+	StatusAddr string
+	PDClient   pd.Client
 }
 
 // NewMockCluster create a new mock cluster.
@@ -120,6 +121,7 @@ func (mock *MockCluster) Start() error {
 		}
 	}()
 	mock.DSN = waitUntilServerOnline(addrURL.Host, cfg.Status.StatusPort)
+	mock.StatusAddr = fmt.Sprintf("127.0.0.1:%d", cfg.Status.StatusPort)
 	return nil
 }
 
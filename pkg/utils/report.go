@@ -0,0 +1,377 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/store/tikv/oracle"
+)
+
+// BackupReport is the readable summary of a backup.
+type BackupReport struct {
+	StartVersion uint64      `json:"start-version"`
+	StartTime    string      `json:"start-time,omitempty"`
+	EndVersion   uint64      `json:"end-version"`
+	EndTime      string      `json:"end-time"`
+	Incremental  bool        `json:"incremental"`
+	RawKV        bool        `json:"raw-kv"`
+	Info         *BackupInfo `json:"info,omitempty"`
+
+	Files      int    `json:"files"`
+	TotalKvs   uint64 `json:"total-kvs"`
+	TotalBytes uint64 `json:"total-bytes"`
+
+	Databases     []*DatabaseReport `json:"databases"`
+	LargestTables []TableSize       `json:"largest-tables"`
+	DDLJobs       []*DDLJobReport   `json:"ddl-jobs"`
+}
+
+// DatabaseReport is the summary of a backed up database.
+type DatabaseReport struct {
+	Name   string         `json:"name"`
+	Tables []*TableReport `json:"tables"`
+}
+
+// TableReport is the summary of a backed up table. The totals are summed
+// from the files, and the checksum is recorded at the backup time.
+type TableReport struct {
+	Name       string   `json:"name"`
+	Partitions []string `json:"partitions,omitempty"`
+	Files      int      `json:"files"`
+	TotalKvs   uint64   `json:"total-kvs"`
+	TotalBytes uint64   `json:"total-bytes"`
+	Checksum   Checksum `json:"checksum"`
+}
+
+// Checksum is the checksum of a table recorded in the backup.
+type Checksum struct {
+	Crc64Xor   uint64 `json:"crc64xor"`
+	TotalKvs   uint64 `json:"total-kvs"`
+	TotalBytes uint64 `json:"total-bytes"`
+}
+
+// TableSize is the size of a backed up table.
+type TableSize struct {
+	Name       string `json:"name"`
+	TotalBytes uint64 `json:"total-bytes"`
+}
+
+// DDLJobReport is the summary of a DDL job included in an incremental
+// backup.
+type DDLJobReport struct {
+	ID     int64  `json:"id"`
+	Type   string `json:"type"`
+	Schema string `json:"schema"`
+	Table  string `json:"table,omitempty"`
+	Query  string `json:"query"`
+}
+
+func formatTS(ts uint64) string {
+	return oracle.GetTimeFromTS(ts).Format(time.RFC3339)
+}
+
+// NewBackupReport summarizes the backup meta read by the reader and its
+// environment, which is nil for the backups made by the old versions. At most
+// largest tables are listed as the largest.
+func NewBackupReport(ctx context.Context, reader *MetaReader, info *BackupInfo, largest int) (*BackupReport, error) {
+	meta := reader.Meta()
+	report := &BackupReport{
+		StartVersion:  meta.StartVersion,
+		EndVersion:    meta.EndVersion,
+		EndTime:       formatTS(meta.EndVersion),
+		Incremental:   meta.StartVersion != 0 && meta.StartVersion != meta.EndVersion,
+		RawKV:         meta.IsRawKv,
+		Info:          info,
+		Databases:     make([]*DatabaseReport, 0),
+		LargestTables: make([]TableSize, 0),
+		DDLJobs:       make([]*DDLJobReport, 0),
+	}
+	if meta.StartVersion != 0 {
+		report.StartTime = formatTS(meta.StartVersion)
+	}
+	databases, err := readBackupTables(ctx, reader, func(file *backup.File) {
+		report.Files++
+		report.TotalKvs += file.TotalKvs
+		report.TotalBytes += file.TotalBytes
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	names := make([]string, 0, len(databases))
+	for name := range databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		db := databases[name]
+		dbReport := &DatabaseReport{Name: db.Info.Name.O, Tables: make([]*TableReport, 0, len(db.Tables))}
+		for _, table := range db.Tables {
+			tableReport := &TableReport{
+				Name: table.Info.Name.O,
+				Checksum: Checksum{
+					Crc64Xor:   table.Crc64Xor,
+					TotalKvs:   table.TotalKvs,
+					TotalBytes: table.TotalBytes,
+				},
+			}
+			if table.Info.Partition != nil {
+				for _, def := range table.Info.Partition.Definitions {
+					tableReport.Partitions = append(tableReport.Partitions, def.Name.O)
+				}
+			}
+			for _, file := range table.Files() {
+				tableReport.Files++
+				tableReport.TotalKvs += file.TotalKvs
+				tableReport.TotalBytes += file.TotalBytes
+			}
+			dbReport.Tables = append(dbReport.Tables, tableReport)
+			report.LargestTables = append(report.LargestTables, TableSize{
+				Name:       EncloseName(db.Info.Name.O) + "." + EncloseName(table.Info.Name.O),
+				TotalBytes: tableReport.TotalBytes,
+			})
+		}
+		sort.Slice(dbReport.Tables, func(i, j int) bool { return dbReport.Tables[i].Name < dbReport.Tables[j].Name })
+		report.Databases = append(report.Databases, dbReport)
+	}
+	sort.SliceStable(report.LargestTables, func(i, j int) bool {
+		return report.LargestTables[i].TotalBytes > report.LargestTables[j].TotalBytes
+	})
+	if len(report.LargestTables) > largest {
+		report.LargestTables = report.LargestTables[:largest]
+	}
+
+	jobs, err := decodeDDLJobs(meta)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		report.DDLJobs = append(report.DDLJobs, newDDLJobReport(job))
+	}
+	return report, nil
+}
+
+// decodeDDLJobs decodes the DDL jobs included in the backup meta.
+func decodeDDLJobs(meta *backup.BackupMeta) ([]*model.Job, error) {
+	jobs := make([]*model.Job, 0)
+	if len(meta.Ddls) == 0 {
+		return jobs, nil
+	}
+	if err := json.Unmarshal(meta.Ddls, &jobs); err != nil {
+		return nil, errors.Annotate(err, "decode ddl jobs failed")
+	}
+	return jobs, nil
+}
+
+func newDDLJobReport(job *model.Job) *DDLJobReport {
+	report := &DDLJobReport{
+		ID:     job.ID,
+		Type:   job.Type.String(),
+		Schema: job.SchemaName,
+		Query:  job.Query,
+	}
+	if job.BinlogInfo != nil && job.BinlogInfo.TableInfo != nil {
+		report.Table = job.BinlogInfo.TableInfo.Name.O
+	}
+	return report
+}
+
+// WriteText writes the report as the readable text.
+func (r *BackupReport) WriteText(w io.Writer) error {
+	var buf bytes.Buffer
+	kind := "full"
+	if r.Incremental {
+		kind = "incremental"
+	}
+	if r.RawKV {
+		kind += " (raw kv)"
+	}
+	fmt.Fprintf(&buf, "type:          %s\n", kind)
+	if r.StartVersion != 0 {
+		fmt.Fprintf(&buf, "start version: %d (%s)\n", r.StartVersion, r.StartTime)
+	}
+	fmt.Fprintf(&buf, "end version:   %d (%s)\n", r.EndVersion, r.EndTime)
+	if r.Info != nil {
+		fmt.Fprintf(&buf, "br version:    %s\n", r.Info.BRVersion)
+		fmt.Fprintf(&buf, "cluster:       %d (%s)\n", r.Info.ClusterID, r.Info.ClusterVersion)
+	}
+	fmt.Fprintf(&buf, "files:         %d\n", r.Files)
+	fmt.Fprintf(&buf, "total kvs:     %d\n", r.TotalKvs)
+	fmt.Fprintf(&buf, "total size:    %s\n", FormatBytes(r.TotalBytes))
+
+	if len(r.Databases) > 0 {
+		buf.WriteString("\n")
+		tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "DATABASE\tTABLE\tPARTITIONS\tFILES\tKVS\tSIZE\tCHECKSUM KVS\tCHECKSUM SIZE\tCRC64XOR")
+		for _, db := range r.Databases {
+			for _, table := range db.Tables {
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\t%d\t%s\t%d\n",
+					db.Name, table.Name, len(table.Partitions), table.Files, table.TotalKvs,
+					FormatBytes(table.TotalBytes), table.Checksum.TotalKvs,
+					FormatBytes(table.Checksum.TotalBytes), table.Checksum.Crc64Xor)
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if len(r.LargestTables) > 0 {
+		buf.WriteString("\nlargest tables:\n")
+		for i, table := range r.LargestTables {
+			fmt.Fprintf(&buf, "  %d. %s %s\n", i+1, table.Name, FormatBytes(table.TotalBytes))
+		}
+	}
+
+	if len(r.DDLJobs) > 0 {
+		fmt.Fprintf(&buf, "\nddl jobs: %d\n", len(r.DDLJobs))
+		for _, job := range r.DDLJobs {
+			fmt.Fprintf(&buf, "  %d %s %s: %s\n", job.ID, job.Type, job.Schema, job.Query)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return errors.Trace(err)
+}
+
+// SelectJSON selects the values from the JSON document by a jq-style path,
+// e.g. `.databases[0].tables[].name`. A path consists of `.field`, `[index]`
+// and `[]`, which iterates over the elements of an array or the values of
+// an object. The missing fields and indices select null, like jq.
+func SelectJSON(data []byte, path string) ([]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, errors.Trace(err)
+	}
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, ".") && !strings.HasPrefix(path, "[") {
+		return nil, errors.Errorf("invalid path %q, it should start with '.'", path)
+	}
+
+	values := []interface{}{doc}
+	for rest := path; len(rest) > 0; {
+		var selector func(interface{}) ([]interface{}, error)
+		switch {
+		case rest == ".":
+			rest = ""
+			continue
+		case strings.HasPrefix(rest, "["):
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, errors.Errorf("invalid path %q, missing ']'", path)
+			}
+			selector = selectIndex(rest[1:end])
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				if strings.HasPrefix(rest, "[") {
+					continue
+				}
+				return nil, errors.Errorf("invalid path %q, empty field", path)
+			}
+			selector = selectField(rest[:end])
+			rest = rest[end:]
+		default:
+			return nil, errors.Errorf("invalid path %q at %q", path, rest)
+		}
+
+		next := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			selected, err := selector(value)
+			if err != nil {
+				return nil, errors.Annotatef(err, "invalid path %q", path)
+			}
+			next = append(next, selected...)
+		}
+		values = next
+	}
+	return values, nil
+}
+
+func selectField(name string) func(interface{}) ([]interface{}, error) {
+	return func(value interface{}) ([]interface{}, error) {
+		switch v := value.(type) {
+		case nil:
+			return []interface{}{nil}, nil
+		case map[string]interface{}:
+			return []interface{}{v[name]}, nil
+		default:
+			return nil, errors.Errorf("cannot select field %q of %s", name, jsonKind(value))
+		}
+	}
+}
+
+func selectIndex(index string) func(interface{}) ([]interface{}, error) {
+	return func(value interface{}) ([]interface{}, error) {
+		if index == "" {
+			switch v := value.(type) {
+			case []interface{}:
+				return v, nil
+			case map[string]interface{}:
+				keys := make([]string, 0, len(v))
+				for key := range v {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				values := make([]interface{}, 0, len(v))
+				for _, key := range keys {
+					values = append(values, v[key])
+				}
+				return values, nil
+			default:
+				return nil, errors.Errorf("cannot iterate over %s", jsonKind(value))
+			}
+		}
+		i, err := strconv.Atoi(index)
+		if err != nil {
+			return nil, errors.Errorf("invalid index %q", index)
+		}
+		switch v := value.(type) {
+		case nil:
+			return []interface{}{nil}, nil
+		case []interface{}:
+			if i < 0 {
+				i += len(v)
+			}
+			if i < 0 || i >= len(v) {
+				return []interface{}{nil}, nil
+			}
+			return []interface{}{v[i]}, nil
+		default:
+			return nil, errors.Errorf("cannot index %s with %d", jsonKind(value), i)
+		}
+	}
+}
+
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/store/tikv/oracle"
+	"github.com/pingcap/tidb/tablecodec"
+)
+
+type testReportSuite struct{}
+
+var _ = Suite(&testReportSuite{})
+
+func mockReportSchema(c *C, db string, table *model.TableInfo, checksum uint64) *backup.Schema {
+	dbBytes, err := json.Marshal(&model.DBInfo{Name: model.NewCIStr(db)})
+	c.Assert(err, IsNil)
+	tblBytes, err := json.Marshal(table)
+	c.Assert(err, IsNil)
+	return &backup.Schema{Db: dbBytes, Table: tblBytes, Crc64Xor: checksum, TotalKvs: 1, TotalBytes: 2}
+}
+
+func mockReportFile(id int64, kvs, size uint64) *backup.File {
+	return &backup.File{
+		StartKey:   tablecodec.EncodeRowKey(id, []byte("a")),
+		EndKey:     tablecodec.EncodeRowKey(id, []byte("b")),
+		TotalKvs:   kvs,
+		TotalBytes: size,
+	}
+}
+
+func (r *testReportSuite) TestBackupReport(c *C) {
+	t1 := &model.TableInfo{ID: 1, Name: model.NewCIStr("t1")}
+	t2 := &model.TableInfo{ID: 2, Name: model.NewCIStr("t2"), Partition: &model.PartitionInfo{
+		Definitions: []model.PartitionDefinition{{ID: 3, Name: model.NewCIStr("p0")}},
+	}}
+	t3 := &model.TableInfo{ID: 4, Name: model.NewCIStr("t3")}
+	ddls, err := json.Marshal([]*model.Job{{
+		ID: 5, Type: model.ActionCreateTable, SchemaName: "db1", Query: "create table t3 (a int)",
+		BinlogInfo: &model.HistoryInfo{TableInfo: t3},
+	}})
+	c.Assert(err, IsNil)
+	startTS := oracle.ComposeTS(1000, 0)
+	meta := &backup.BackupMeta{
+		StartVersion: startTS,
+		EndVersion:   startTS + 1,
+		Schemas: []*backup.Schema{
+			mockReportSchema(c, "db2", t3, 7),
+			mockReportSchema(c, "db1", t2, 8),
+			mockReportSchema(c, "db1", t1, 9),
+		},
+		Files: []*backup.File{
+			mockReportFile(1, 10, 100),
+			mockReportFile(3, 20, 300),
+			mockReportFile(3, 30, 300),
+			mockReportFile(4, 40, 200),
+		},
+		Ddls: ddls,
+	}
+	info := &BackupInfo{BRVersion: "v3.1.0", ClusterID: 1, ClusterVersion: "3.1.0"}
+
+	ctx := context.Background()
+	report, err := NewBackupReport(ctx, mockMetaReader(c, createLocalStorage(c), meta), info, 2)
+	c.Assert(err, IsNil)
+	c.Assert(report.Incremental, IsTrue)
+	c.Assert(report.StartTime, Not(Equals), "")
+	c.Assert(report.Files, Equals, 4)
+	c.Assert(report.TotalKvs, Equals, uint64(100))
+	c.Assert(report.TotalBytes, Equals, uint64(900))
+	c.Assert(report.Databases, HasLen, 2)
+	c.Assert(report.Databases[0].Name, Equals, "db1")
+	c.Assert(report.Databases[0].Tables, DeepEquals, []*TableReport{
+		{Name: "t1", Files: 1, TotalKvs: 10, TotalBytes: 100, Checksum: Checksum{9, 1, 2}},
+		{Name: "t2", Partitions: []string{"p0"}, Files: 2, TotalKvs: 50, TotalBytes: 600, Checksum: Checksum{8, 1, 2}},
+	})
+	c.Assert(report.LargestTables, DeepEquals, []TableSize{
+		{Name: "`db1`.`t2`", TotalBytes: 600},
+		{Name: "`db2`.`t3`", TotalBytes: 200},
+	})
+	c.Assert(report.DDLJobs, DeepEquals, []*DDLJobReport{{
+		ID: 5, Type: "create table", Schema: "db1", Table: "t3", Query: "create table t3 (a int)",
+	}})
+
+	var buf bytes.Buffer
+	c.Assert(report.WriteText(&buf), IsNil)
+	c.Assert(buf.String(), Matches, `(?s)type: +incremental\n.*db1 +t2 +1 +2 +50 +600B .*`+
+		"largest tables:\n  1. `db1`.`t2` 600B\n.*ddl jobs: 1\n.*")
+
+	// A full backup made by an old BR.
+	reader := mockMetaReader(c, createLocalStorage(c), &backup.BackupMeta{EndVersion: startTS})
+	report, err = NewBackupReport(ctx, reader, nil, 10)
+	c.Assert(err, IsNil)
+	c.Assert(report.Incremental, IsFalse)
+	c.Assert(report.StartTime, Equals, "")
+	c.Assert(report.Databases, HasLen, 0)
+	buf.Reset()
+	c.Assert(report.WriteText(&buf), IsNil)
+	c.Assert(buf.String(), Matches, `(?s)type: +full\nend version: .*`)
+}
+
+func (r *testReportSuite) TestSelectJSON(c *C) {
+	data := []byte(`{"a": 1, "b": [{"c": "x"}, {"c": "y", "d": true}], "e": {"f": null, "g": [1, 2]}}`)
+	selectJSON := func(path string) string {
+		values, err := SelectJSON(data, path)
+		c.Assert(err, IsNil)
+		result, err := json.Marshal(values)
+		c.Assert(err, IsNil)
+		return string(result)
+	}
+	c.Assert(selectJSON("."), Equals, `[{"a":1,"b":[{"c":"x"},{"c":"y","d":true}],"e":{"f":null,"g":[1,2]}}]`)
+	c.Assert(selectJSON(".a"), Equals, `[1]`)
+	c.Assert(selectJSON(".b[].c"), Equals, `["x","y"]`)
+	c.Assert(selectJSON(".b[1].d"), Equals, `[true]`)
+	c.Assert(selectJSON(".b[-1].c"), Equals, `["y"]`)
+	c.Assert(selectJSON(".b[0].d"), Equals, `[null]`)
+	c.Assert(selectJSON(".b[5]"), Equals, `[null]`)
+	c.Assert(selectJSON(".e[]"), Equals, `[null,[1,2]]`)
+	c.Assert(selectJSON(".e.g[]"), Equals, `[1,2]`)
+	c.Assert(selectJSON(".missing.field"), Equals, `[null]`)
+	c.Assert(selectJSON(".[]"), Equals, `[1,[{"c":"x"},{"c":"y","d":true}],{"f":null,"g":[1,2]}]`)
+
+	for _, path := range []string{"a", ".b[", ".b[x]", ".a.b", ".a[]", ".e[0]", ".b..c"} {
+		_, err := SelectJSON(data, path)
+		c.Assert(err, NotNil, Commentf("path %s", path))
+	}
+}
@@ -25,20 +25,121 @@ const (
 type Table struct {
 	Db         *model.DBInfo
 	Info       *model.TableInfo
+	Extra      TableExtra
 	Crc64Xor   uint64
 	TotalKvs   uint64
 	TotalBytes uint64
-	Files      []*backup.File
+
+	// files are the files of the table if fileIndex is nil.
+	files     []*backup.File
+	fileIndex *FileIndex
+}
+
+// Files returns the files of the table and its partitions. The files of the
+// tables loaded from the backup meta are looked up from the file index on
+// access.
+func (tbl *Table) Files() []*backup.File {
+	if tbl.fileIndex != nil {
+		return tbl.fileIndex.TableFiles(tbl.Info)
+	}
+	return tbl.files
+}
+
+// FileIndex buckets the files of a backup by the IDs of the tables or the
+// partitions they belong to.
+type FileIndex struct {
+	files map[int64][]*backup.File
+}
+
+// NewFileIndex builds the index of the files in a single pass.
+func NewFileIndex(files []*backup.File) *FileIndex {
+	index := &FileIndex{files: make(map[int64][]*backup.File)}
+	for _, file := range files {
+		index.add(file)
+	}
+	return index
+}
+
+func (index *FileIndex) add(file *backup.File) {
+	// If the file do not contains any table data, skip it.
+	if !bytes.HasPrefix(file.GetStartKey(), tablecodec.TablePrefix()) &&
+		!bytes.HasPrefix(file.GetEndKey(), tablecodec.TablePrefix()) {
+		return
+	}
+	// The file belongs to the table or partition of its start key.
+	id := tablecodec.DecodeTableID(file.GetStartKey())
+	index.files[id] = append(index.files[id], file)
+}
+
+// TableFiles returns the files of the table and its partitions.
+func (index *FileIndex) TableFiles(info *model.TableInfo) []*backup.File {
+	files := index.files[info.ID]
+	if info.Partition == nil {
+		// Callers appending to the files don't overwrite the index.
+		return files[:len(files):len(files)]
+	}
+	tableFiles := make([]*backup.File, 0, len(files))
+	tableFiles = append(tableFiles, files...)
+	for _, def := range info.Partition.Definitions {
+		tableFiles = append(tableFiles, index.files[def.ID]...)
+	}
+	return tableFiles
+}
+
+// TableExtra is the information of a table saved along with its schema,
+// which is not a part of the table schema itself.
+type TableExtra struct {
+	// PendingIndices are the indices being added at the backup time. They are
+	// excluded from the schema and the data, and are rebuilt after restore.
+	PendingIndices []*model.IndexInfo `json:"br_pending_indices,omitempty"`
+	// ExcludedIndices are the other non-public indices at the backup time,
+	// e.g. the indices being dropped.
+	ExcludedIndices []*model.IndexInfo `json:"br_excluded_indices,omitempty"`
+	// ExcludedColumns are the non-public columns at the backup time.
+	ExcludedColumns []*model.ColumnInfo `json:"br_excluded_columns,omitempty"`
+	// AutoRandomID is the next global ID of the AUTO_RANDOM allocator. The
+	// row ID allocator, which is shared with AUTO_INCREMENT, is saved in
+	// `TableInfo.AutoIncID`.
+	AutoRandomID int64 `json:"br_auto_random_id,omitempty"`
+}
+
+// tableInfoWithExtra is the JSON format of a table schema in the backup meta.
+// The extra fields are ignored by the versions unaware of them.
+type tableInfoWithExtra struct {
+	*model.TableInfo
+	TableExtra
+}
+
+// MarshalTableInfo encodes the schema and the extra information of a table.
+func MarshalTableInfo(info *model.TableInfo, extra TableExtra) ([]byte, error) {
+	data, err := json.Marshal(tableInfoWithExtra{TableInfo: info, TableExtra: extra})
+	return data, errors.Trace(err)
+}
+
+// UnmarshalTableInfo decodes the schema and the extra information of a table.
+func UnmarshalTableInfo(data []byte) (*model.TableInfo, TableExtra, error) {
+	table := tableInfoWithExtra{TableInfo: &model.TableInfo{}}
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, TableExtra{}, errors.Trace(err)
+	}
+	return table.TableInfo, table.TableExtra, nil
 }
 
 // Database wraps the schema and tables of a database.
 type Database struct {
 	Info   *model.DBInfo
 	Tables []*Table
+
+	// tableMap is the tables by name of the database loaded from the backup
+	// meta.
+	tableMap map[string]*Table
 }
 
 // GetTable returns a table of the database by name.
 func (db *Database) GetTable(name string) *Table {
+	if db.tableMap != nil {
+		return db.tableMap[name]
+	}
 	for _, table := range db.Tables {
 		if table.Info.Name.String() == name {
 			return table
@@ -47,63 +148,157 @@ func (db *Database) GetTable(name string) *Table {
 	return nil
 }
 
-// LoadBackupTables loads schemas from BackupMeta.
+// LoadBackupTables loads schemas from BackupMeta. The files are bucketed by
+// the table IDs once, rather than scanned for each table.
 func LoadBackupTables(meta *backup.BackupMeta) (map[string]*Database, error) {
-	databases := make(map[string]*Database)
+	loader := newTableLoader(NewFileIndex(meta.Files))
 	for _, schema := range meta.Schemas {
-		// Parse the database schema.
-		dbInfo := &model.DBInfo{}
-		err := json.Unmarshal(schema.Db, dbInfo)
-		if err != nil {
-			return nil, errors.Trace(err)
+		if err := loader.add(schema); err != nil {
+			return nil, err
 		}
-		// If the database do not ever added into the map, initialize a database object in the map.
-		db, ok := databases[dbInfo.Name.String()]
-		if !ok {
-			db = &Database{
-				Info:   dbInfo,
-				Tables: make([]*Table, 0),
-			}
-			databases[dbInfo.Name.String()] = db
-		}
-		// Parse the table schema.
-		tableInfo := &model.TableInfo{}
-		err = json.Unmarshal(schema.Table, tableInfo)
-		if err != nil {
-			return nil, errors.Trace(err)
-		}
-		partitions := make(map[int64]bool)
-		if tableInfo.Partition != nil {
-			for _, p := range tableInfo.Partition.Definitions {
-				partitions[p.ID] = true
-			}
+	}
+	return loader.databases, nil
+}
+
+// ReadBackupTables loads schemas from the backup meta read by the reader. The
+// shards of the files and the schemas are decoded one by one in MetaV2, so
+// the encoded backup meta is never loaded as a whole.
+func ReadBackupTables(ctx context.Context, reader *MetaReader) (map[string]*Database, error) {
+	return readBackupTables(ctx, reader, nil)
+}
+
+// readBackupTables is ReadBackupTables, and it also calls onFile on each
+// file, so the files are not read twice by the callers walking them.
+func readBackupTables(
+	ctx context.Context,
+	reader *MetaReader,
+	onFile func(*backup.File),
+) (map[string]*Database, error) {
+	fileIndex := NewFileIndex(nil)
+	err := reader.WalkFiles(ctx, func(file *backup.File) error {
+		if onFile != nil {
+			onFile(file)
 		}
-		// Find the files belong to the table
-		tableFiles := make([]*backup.File, 0)
-		for _, file := range meta.Files {
-			// If the file do not contains any table data, skip it.
-			if !bytes.HasPrefix(file.GetStartKey(), tablecodec.TablePrefix()) &&
-				!bytes.HasPrefix(file.GetEndKey(), tablecodec.TablePrefix()) {
-				continue
-			}
-			startTableID := tablecodec.DecodeTableID(file.GetStartKey())
-			// If the file contains a part of the data of the table, append it to the slice.
-			if ok := partitions[startTableID]; ok || startTableID == tableInfo.ID {
-				tableFiles = append(tableFiles, file)
-			}
+		fileIndex.add(file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	loader := newTableLoader(fileIndex)
+	if err = reader.WalkSchemas(ctx, loader.add); err != nil {
+		return nil, err
+	}
+	return loader.databases, nil
+}
+
+// tableLoader decodes the schemas into the databases one by one.
+type tableLoader struct {
+	databases map[string]*Database
+	fileIndex *FileIndex
+}
+
+func newTableLoader(fileIndex *FileIndex) *tableLoader {
+	return &tableLoader{databases: make(map[string]*Database), fileIndex: fileIndex}
+}
+
+func (l *tableLoader) add(schema *backup.Schema) error {
+	// Parse the database schema.
+	dbInfo := &model.DBInfo{}
+	err := json.Unmarshal(schema.Db, dbInfo)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// If the database do not ever added into the map, initialize a database object in the map.
+	db, ok := l.databases[dbInfo.Name.String()]
+	if !ok {
+		db = &Database{
+			Info:     dbInfo,
+			Tables:   make([]*Table, 0),
+			tableMap: make(map[string]*Table),
 		}
-		table := &Table{
-			Db:         dbInfo,
-			Info:       tableInfo,
-			Crc64Xor:   schema.Crc64Xor,
-			TotalKvs:   schema.TotalKvs,
-			TotalBytes: schema.TotalBytes,
-			Files:      tableFiles,
+		l.databases[dbInfo.Name.String()] = db
+	}
+	// Parse the table schema.
+	tableInfo, extra, err := UnmarshalTableInfo(schema.Table)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	table := &Table{
+		Db:         dbInfo,
+		Info:       tableInfo,
+		Extra:      extra,
+		Crc64Xor:   schema.Crc64Xor,
+		TotalKvs:   schema.TotalKvs,
+		TotalBytes: schema.TotalBytes,
+		fileIndex:  l.fileIndex,
+	}
+	db.Tables = append(db.Tables, table)
+	db.tableMap[tableInfo.Name.String()] = table
+	return nil
+}
+
+// FilterPartitions returns a copy of the table schema which only contains the
+// given partitions. Only range partitioned tables are supported, since the rows
+// of the other kinds of partitions depend on the number of partitions.
+func FilterPartitions(info *model.TableInfo, names []string) (*model.TableInfo, error) {
+	if info.Partition == nil {
+		return nil, errors.Errorf("table %s is not partitioned", info.Name)
+	}
+	if info.Partition.Type != model.PartitionTypeRange {
+		return nil, errors.Errorf("partitions of %s partitioned table %s cannot be selected",
+			info.Partition.Type, info.Name)
+	}
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		selected[strings.ToLower(name)] = true
+	}
+	defs := make([]model.PartitionDefinition, 0, len(names))
+	for _, def := range info.Partition.Definitions {
+		if selected[def.Name.L] {
+			defs = append(defs, def)
+			delete(selected, def.Name.L)
 		}
-		db.Tables = append(db.Tables, table)
 	}
+	for name := range selected {
+		return nil, errors.Errorf("partition %s not found in table %s", name, info.Name)
+	}
+	newInfo := info.Clone()
+	// The partition info is shared after cloning the table schema.
+	partition := *info.Partition
+	partition.Definitions = defs
+	partition.Num = uint64(len(defs))
+	newInfo.Partition = &partition
+	return newInfo, nil
+}
 
-	return databases, nil
+// FilterTablePartitions returns a copy of the backed up table which only
+// contains the given partitions and their files. The checksum of the table is
+// recalculated from the files.
+func FilterTablePartitions(table *Table, names []string) (*Table, error) {
+	info, err := FilterPartitions(table.Info, names)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	partIDs := make(map[int64]bool, len(info.Partition.Definitions))
+	for _, def := range info.Partition.Definitions {
+		partIDs[def.ID] = true
+	}
+	newTable := &Table{
+		Db:    table.Db,
+		Info:  info,
+		Extra: table.Extra,
+	}
+	for _, file := range table.Files() {
+		if !partIDs[tablecodec.DecodeTableID(file.GetStartKey())] {
+			continue
+		}
+		newTable.Crc64Xor ^= file.Crc64Xor
+		newTable.TotalKvs += file.TotalKvs
+		newTable.TotalBytes += file.TotalBytes
+		newTable.files = append(newTable.files, file)
+	}
+	return newTable, nil
 }
 
 // ResultSetToStringSlice changes the RecordSet to [][]string. port from tidb
@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"testing"
 
 	. "github.com/pingcap/check"
 	"github.com/pingcap/kvproto/pkg/backup"
@@ -66,6 +69,219 @@ func (r *testSchemaSuite) TestLoadBackupMeta(c *C) {
 	dbs, err := LoadBackupTables(meta)
 	tbl := dbs[dbName.String()].GetTable(tblName.String())
 	c.Assert(err, IsNil)
-	c.Assert(tbl.Files, HasLen, 1)
-	c.Assert(tbl.Files[0].Name, Equals, "1.sst")
+	c.Assert(tbl.Files(), HasLen, 1)
+	c.Assert(tbl.Files()[0].Name, Equals, "1.sst")
+}
+
+func (r *testSchemaSuite) TestLoadBackupMetaPartitions(c *C) {
+	tblInfo := &model.TableInfo{
+		ID:   1,
+		Name: model.NewCIStr("t1"),
+		Partition: &model.PartitionInfo{
+			Type: model.PartitionTypeRange,
+			Definitions: []model.PartitionDefinition{
+				{ID: 2, Name: model.NewCIStr("p0")},
+				{ID: 3, Name: model.NewCIStr("p1")},
+			},
+		},
+	}
+	tblBytes, err := json.Marshal(tblInfo)
+	c.Assert(err, IsNil)
+	dbBytes, err := json.Marshal(&model.DBInfo{ID: 100, Name: model.NewCIStr("test")})
+	c.Assert(err, IsNil)
+	files := []*backup.File{
+		{Name: "3.sst", StartKey: tablecodec.EncodeRowKey(3, []byte("a"))},
+		{Name: "4.sst", StartKey: tablecodec.EncodeRowKey(4, []byte("a"))},
+		{Name: "2.sst", StartKey: tablecodec.EncodeRowKey(2, []byte("a"))},
+		{Name: "meta.sst", StartKey: []byte("m"), EndKey: []byte("n")},
+	}
+	meta := mockBackupMeta([]*backup.Schema{{Db: dbBytes, Table: tblBytes}}, files)
+	dbs, err := LoadBackupTables(meta)
+	c.Assert(err, IsNil)
+	c.Assert(dbs["test"].GetTable("t2"), IsNil)
+	tbl := dbs["test"].GetTable("t1")
+	names := make([]string, 0)
+	for _, file := range tbl.Files() {
+		names = append(names, file.Name)
+	}
+	// The files of the partitions are in the order of the partitions.
+	c.Assert(names, DeepEquals, []string{"2.sst", "3.sst"})
+}
+
+func (r *testSchemaSuite) TestReadBackupTables(c *C) {
+	ctx := context.Background()
+	dbBytes, err := json.Marshal(&model.DBInfo{ID: 100, Name: model.NewCIStr("test")})
+	c.Assert(err, IsNil)
+	schemas := make([]*backup.Schema, 0)
+	files := make([]*backup.File, 0)
+	for id := int64(1); id <= 3; id++ {
+		tblBytes, err := json.Marshal(&model.TableInfo{ID: id, Name: model.NewCIStr(fmt.Sprintf("t%d", id))})
+		c.Assert(err, IsNil)
+		schemas = append(schemas, &backup.Schema{Db: dbBytes, Table: tblBytes, TotalKvs: uint64(id)})
+		files = append(files,
+			&backup.File{Name: fmt.Sprintf("%d_1.sst", id), StartKey: tablecodec.EncodeRowKeyWithHandle(id, 1)},
+			&backup.File{Name: fmt.Sprintf("%d_2.sst", id), StartKey: tablecodec.EncodeRowKeyWithHandle(id, 2)})
+	}
+
+	// The tables are read from the shards of 2 files or schemas.
+	for _, version := range []int{MetaV1, MetaV2} {
+		s := createLocalStorage(c)
+		writer := NewMetaWriter(s, version, 2)
+		c.Assert(writer.AppendFiles(ctx, files), IsNil)
+		c.Assert(writer.AppendSchemas(ctx, schemas), IsNil)
+		c.Assert(writer.Finish(ctx, &backup.BackupMeta{EndVersion: 10}), IsNil)
+		reader, err := NewMetaReader(ctx, s)
+		c.Assert(err, IsNil)
+		dbs, err := ReadBackupTables(ctx, reader)
+		c.Assert(err, IsNil)
+		c.Assert(dbs["test"].Tables, HasLen, 3)
+		for id := int64(1); id <= 3; id++ {
+			tbl := dbs["test"].GetTable(fmt.Sprintf("t%d", id))
+			c.Assert(tbl.TotalKvs, Equals, uint64(id))
+			c.Assert(tbl.Files(), HasLen, 2)
+			c.Assert(tbl.Files()[1].Name, Equals, fmt.Sprintf("%d_2.sst", id))
+		}
+	}
+}
+
+func (r *testSchemaSuite) TestLoadBackupMetaWithExtra(c *C) {
+	tblInfo := &model.TableInfo{
+		ID:   123,
+		Name: model.NewCIStr("t1"),
+	}
+	extra := TableExtra{
+		PendingIndices: []*model.IndexInfo{{
+			ID:    2,
+			Name:  model.NewCIStr("idx"),
+			State: model.StateWriteReorganization,
+		}},
+		ExcludedColumns: []*model.ColumnInfo{{
+			ID:    3,
+			Name:  model.NewCIStr("c"),
+			State: model.StateWriteOnly,
+		}},
+	}
+	tblBytes, err := MarshalTableInfo(tblInfo, extra)
+	c.Assert(err, IsNil)
+	// The extra information does not break the table schema.
+	plainInfo := &model.TableInfo{}
+	c.Assert(json.Unmarshal(tblBytes, plainInfo), IsNil)
+	c.Assert(plainInfo, DeepEquals, tblInfo)
+
+	dbBytes, err := json.Marshal(&model.DBInfo{ID: 1, Name: model.NewCIStr("test")})
+	c.Assert(err, IsNil)
+	meta := mockBackupMeta([]*backup.Schema{{Db: dbBytes, Table: tblBytes}}, nil)
+	dbs, err := LoadBackupTables(meta)
+	c.Assert(err, IsNil)
+	tbl := dbs["test"].GetTable("t1")
+	c.Assert(tbl.Info, DeepEquals, tblInfo)
+	c.Assert(tbl.Extra, DeepEquals, extra)
+}
+
+func (r *testSchemaSuite) TestFilterTablePartitions(c *C) {
+	info := &model.TableInfo{
+		ID:   1,
+		Name: model.NewCIStr("t1"),
+		Partition: &model.PartitionInfo{
+			Type: model.PartitionTypeRange,
+			Num:  3,
+			Definitions: []model.PartitionDefinition{
+				{ID: 2, Name: model.NewCIStr("p0"), LessThan: []string{"10"}},
+				{ID: 3, Name: model.NewCIStr("p1"), LessThan: []string{"20"}},
+				{ID: 4, Name: model.NewCIStr("p2"), LessThan: []string{"MAXVALUE"}},
+			},
+		},
+	}
+	files := make([]*backup.File, 0, 3)
+	for i, def := range info.Partition.Definitions {
+		files = append(files, &backup.File{
+			Name:       def.Name.O,
+			StartKey:   tablecodec.EncodeRowKey(def.ID, []byte("a")),
+			EndKey:     tablecodec.EncodeRowKey(def.ID, []byte("b")),
+			Crc64Xor:   uint64(1 << uint(i)),
+			TotalKvs:   uint64(i + 1),
+			TotalBytes: uint64(10 * (i + 1)),
+		})
+	}
+	table := &Table{Info: info, files: files}
+
+	newTable, err := FilterTablePartitions(table, []string{"P0", "p2"})
+	c.Assert(err, IsNil)
+	defs := newTable.Info.Partition.Definitions
+	c.Assert(defs, HasLen, 2)
+	c.Assert(defs[0].Name.L, Equals, "p0")
+	c.Assert(defs[1].Name.L, Equals, "p2")
+	c.Assert(newTable.Info.Partition.Num, Equals, uint64(2))
+	c.Assert(newTable.Files(), HasLen, 2)
+	c.Assert(newTable.Files()[0].Name, Equals, "p0")
+	c.Assert(newTable.Files()[1].Name, Equals, "p2")
+	c.Assert(newTable.Crc64Xor, Equals, uint64(1|4))
+	c.Assert(newTable.TotalKvs, Equals, uint64(1+3))
+	c.Assert(newTable.TotalBytes, Equals, uint64(10+30))
+	// The original table is not changed.
+	c.Assert(info.Partition.Definitions, HasLen, 3)
+	c.Assert(info.Partition.Num, Equals, uint64(3))
+
+	_, err = FilterTablePartitions(table, []string{"p3"})
+	c.Assert(err, ErrorMatches, "partition p3 not found in table t1")
+	info.Partition.Type = model.PartitionTypeHash
+	_, err = FilterPartitions(info, []string{"p0"})
+	c.Assert(err, ErrorMatches, "partitions of HASH partitioned table t1 cannot be selected")
+	_, err = FilterPartitions(&model.TableInfo{Name: model.NewCIStr("t2")}, []string{"p0"})
+	c.Assert(err, ErrorMatches, "table t2 is not partitioned")
+}
+
+// mockLargeBackupMeta returns a backup meta of the tables, each of which has
+// the files.
+func mockLargeBackupMeta(b *testing.B, tables, filesPerTable int) *backup.BackupMeta {
+	dbBytes, err := json.Marshal(&model.DBInfo{ID: 1, Name: model.NewCIStr("test")})
+	if err != nil {
+		b.Fatal(err)
+	}
+	schemas := make([]*backup.Schema, 0, tables)
+	files := make([]*backup.File, 0, tables*filesPerTable)
+	for i := 0; i < tables; i++ {
+		id := int64(i + 2)
+		tblBytes, err := json.Marshal(&model.TableInfo{ID: id, Name: model.NewCIStr(fmt.Sprintf("t%d", i))})
+		if err != nil {
+			b.Fatal(err)
+		}
+		schemas = append(schemas, &backup.Schema{Db: dbBytes, Table: tblBytes})
+		for j := 0; j < filesPerTable; j++ {
+			files = append(files, &backup.File{
+				Name:     fmt.Sprintf("%d_%d.sst", id, j),
+				StartKey: tablecodec.EncodeRowKeyWithHandle(id, int64(j)),
+				EndKey:   tablecodec.EncodeRowKeyWithHandle(id, int64(j+1)),
+			})
+		}
+	}
+	return mockBackupMeta(schemas, files)
+}
+
+func BenchmarkLoadBackupTables(b *testing.B) {
+	meta := mockLargeBackupMeta(b, 10000, 20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := LoadBackupTables(meta); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadBackupTablesFiles(b *testing.B) {
+	meta := mockLargeBackupMeta(b, 10000, 20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dbs, err := LoadBackupTables(meta)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, db := range dbs {
+			for _, table := range db.Tables {
+				if len(table.Files()) != 20 {
+					b.Fatal("unexpected files")
+				}
+			}
+		}
+	}
 }
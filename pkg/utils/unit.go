@@ -1,5 +1,7 @@
 package utils
 
+import "fmt"
+
 // unit of storage
 const (
 	B = uint64(1) << (iota * 10)
@@ -8,3 +10,18 @@ const (
 	GB
 	TB
 )
+
+// FormatBytes formats the size in the largest unit not greater than it,
+// e.g. "1.50GiB".
+func FormatBytes(size uint64) string {
+	units := []struct {
+		size uint64
+		name string
+	}{{TB, "TiB"}, {GB, "GiB"}, {MB, "MiB"}, {KB, "KiB"}}
+	for _, unit := range units {
+		if size >= unit.size {
+			return fmt.Sprintf("%.2f%s", float64(size)/float64(unit.size), unit.name)
+		}
+	}
+	return fmt.Sprintf("%dB", size)
+}
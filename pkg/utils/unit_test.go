@@ -15,3 +15,11 @@ func (r *testUnitSuite) TestLoadBackupMeta(c *C) {
 	c.Assert(GB, Equals, uint64(1024*1024*1024))
 	c.Assert(TB, Equals, uint64(1024*1024*1024*1024))
 }
+
+func (r *testUnitSuite) TestFormatBytes(c *C) {
+	c.Assert(FormatBytes(0), Equals, "0B")
+	c.Assert(FormatBytes(1023), Equals, "1023B")
+	c.Assert(FormatBytes(1536), Equals, "1.50KiB")
+	c.Assert(FormatBytes(3*GB), Equals, "3.00GiB")
+	c.Assert(FormatBytes(2048*TB), Equals, "2048.00TiB")
+}
@@ -0,0 +1,409 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/sst"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// VerifyReport is the result of verifying the files of a backup.
+type VerifyReport struct {
+	Files     int             `json:"files"`
+	ReadBytes uint64          `json:"read-bytes"`
+	Missing   []string        `json:"missing"`
+	Extra     []string        `json:"extra"`
+	Corrupted []CorruptedFile `json:"corrupted"`
+	Tables    []*TableVerify  `json:"tables"`
+}
+
+// CorruptedFile is a file whose content doesn't match the backup meta.
+type CorruptedFile struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// TableVerify is the totals of the files of a table, compared with the
+// checksum recorded in the schema. Unchecked is true if the backup skipped
+// the checksum of the table.
+type TableVerify struct {
+	Name       string   `json:"name"`
+	Crc64Xor   uint64   `json:"crc64xor"`
+	TotalKvs   uint64   `json:"total-kvs"`
+	TotalBytes uint64   `json:"total-bytes"`
+	Checksum   Checksum `json:"checksum"`
+	Unchecked  bool     `json:"unchecked,omitempty"`
+	Mismatched bool     `json:"mismatched,omitempty"`
+}
+
+// Failed returns whether any file is missing or corrupted, or any table
+// mismatches its checksum. The extra files don't fail the verification.
+func (r *VerifyReport) Failed() bool {
+	if len(r.Missing) > 0 || len(r.Corrupted) > 0 {
+		return true
+	}
+	for _, table := range r.Tables {
+		if table.Mismatched {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *VerifyReport) String() string {
+	var buf strings.Builder
+	for _, name := range r.Missing {
+		fmt.Fprintf(&buf, "[MISSING] %s\n", name)
+	}
+	for _, file := range r.Corrupted {
+		fmt.Fprintf(&buf, "[CORRUPTED] %s: %s\n", file.Name, file.Reason)
+	}
+	for _, name := range r.Extra {
+		fmt.Fprintf(&buf, "[EXTRA] %s\n", name)
+	}
+	mismatched := 0
+	for _, table := range r.Tables {
+		status := "OK"
+		switch {
+		case table.Mismatched:
+			status = "MISMATCHED"
+			mismatched++
+		case table.Unchecked:
+			status = "UNCHECKED"
+		}
+		fmt.Fprintf(&buf, "[%s] %s: crc64xor %d, total kvs %d, total bytes %d, checksum %d/%d/%d\n",
+			status, table.Name, table.Crc64Xor, table.TotalKvs, table.TotalBytes,
+			table.Checksum.Crc64Xor, table.Checksum.TotalKvs, table.Checksum.TotalBytes)
+	}
+	fmt.Fprintf(&buf, "%d files (%s) verified: %d missing, %d corrupted, %d extra, %d of %d tables mismatched",
+		r.Files, FormatBytes(r.ReadBytes), len(r.Missing), len(r.Corrupted), len(r.Extra),
+		mismatched, len(r.Tables))
+	return buf.String()
+}
+
+// isMetaFile returns whether the file is a part of the backup meta rather
+// than the backed up data.
+func isMetaFile(name string) bool {
+	return strings.HasPrefix(name, MetaFile)
+}
+
+// VerifyBackup verifies the files of the backup in the storage against the
+// backup meta read by the reader. The files are hashed by concurrency workers, and are streamed
+// rather than read into memory. If deep is true, the files are also decoded
+// in memory, and their checksums are recomputed from the key-value pairs.
+// Every missing, extra or corrupted file is reported, the error is returned
+// only if the verification can't run.
+func VerifyBackup(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	reader *MetaReader,
+	concurrency uint,
+	deep bool,
+) (*VerifyReport, error) {
+	report := &VerifyReport{
+		Missing:   make([]string, 0),
+		Extra:     make([]string, 0),
+		Corrupted: make([]CorruptedFile, 0),
+		Tables:    make([]*TableVerify, 0),
+	}
+
+	sizes := make(map[string]int64)
+	err := s.WalkDir(ctx, func(name string, size int64) error {
+		sizes[name] = size
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Annotate(err, "list files failed")
+	}
+
+	files := make([]*backup.File, 0)
+	backedUp := make(map[string]bool)
+	databases, err := readBackupTables(ctx, reader, func(file *backup.File) {
+		if backedUp[file.Name] {
+			return
+		}
+		backedUp[file.Name] = true
+		size, ok := sizes[file.Name]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, file.Name)
+		case file.Size_ != 0 && uint64(size) != file.Size_:
+			report.Corrupted = append(report.Corrupted, CorruptedFile{
+				Name:   file.Name,
+				Reason: fmt.Sprintf("size is %d, but %d in the backup meta", size, file.Size_),
+			})
+		default:
+			files = append(files, file)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name := range sizes {
+		if !backedUp[name] && !isMetaFile(name) {
+			report.Extra = append(report.Extra, name)
+		}
+	}
+
+	var mu sync.Mutex
+	wg := new(sync.WaitGroup)
+	pool := NewWorkerPool(concurrency, "verify")
+	groups := make([][]*backup.File, 0, len(files))
+	rawKV := reader.Meta().IsRawKv
+	if deep && !rawKV {
+		groups = GroupFiles(files)
+	} else {
+		for _, file := range files {
+			groups = append(groups, []*backup.File{file})
+		}
+	}
+	for _, group := range groups {
+		if ctx.Err() != nil {
+			break
+		}
+		group := group
+		wg.Add(1)
+		pool.Apply(func() {
+			defer wg.Done()
+			var n uint64
+			var corrupted []CorruptedFile
+			if deep {
+				n, corrupted = verifyContent(ctx, s, group, rawKV)
+			} else {
+				var reason string
+				if n, reason = verifyFile(ctx, s, group[0]); len(reason) > 0 {
+					corrupted = []CorruptedFile{{Name: group[0].Name, Reason: reason}}
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Files += len(group)
+			report.ReadBytes += n
+			for _, file := range corrupted {
+				log.Warn("corrupted backup file", zap.String("file", file.Name), zap.String("reason", file.Reason))
+				report.Corrupted = append(report.Corrupted, file)
+			}
+		})
+	}
+	wg.Wait()
+	if err = ctx.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	report.Tables = verifyTables(databases)
+	sort.Strings(report.Missing)
+	sort.Strings(report.Extra)
+	sort.Slice(report.Corrupted, func(i, j int) bool { return report.Corrupted[i].Name < report.Corrupted[j].Name })
+	return report, nil
+}
+
+// verifyFile hashes the file, and returns the number of the bytes read and
+// why the file is corrupted, which is empty if it isn't.
+func verifyFile(ctx context.Context, s storage.ExternalStorage, file *backup.File) (uint64, string) {
+	reader, err := s.Open(ctx, file.Name)
+	if err != nil {
+		return 0, fmt.Sprintf("open failed: %v", err)
+	}
+	defer reader.Close()
+	hash := sha256.New()
+	n, err := io.Copy(hash, reader)
+	if err != nil {
+		return uint64(n), fmt.Sprintf("read failed: %v", err)
+	}
+	return uint64(n), checkFile(file, uint64(n), hash.Sum(nil))
+}
+
+// checkFile compares the size and the sha256 of the file content with the
+// backup meta, and returns why the file is corrupted.
+func checkFile(file *backup.File, size uint64, sum []byte) string {
+	if file.Size_ != 0 && size != file.Size_ {
+		return fmt.Sprintf("size is %d, but %d in the backup meta", size, file.Size_)
+	}
+	if len(file.Sha256) > 0 && !bytes.Equal(sum, file.Sha256) {
+		return fmt.Sprintf("sha256 is %x, but %x in the backup meta", sum, file.Sha256)
+	}
+	return ""
+}
+
+// GroupFiles groups each file of the write column family with the file of
+// the default column family of the same range, whose values are needed to
+// recompute the checksum. The file of the write column family is the first
+// of the group.
+func GroupFiles(files []*backup.File) [][]*backup.File {
+	type fileRange struct {
+		start, end string
+	}
+	defaults := make(map[fileRange]*backup.File)
+	for _, file := range files {
+		if strings.Contains(file.Name, "default") {
+			defaults[fileRange{string(file.StartKey), string(file.EndKey)}] = file
+		}
+	}
+	groups := make([][]*backup.File, 0, len(files))
+	grouped := make(map[*backup.File]bool)
+	for _, file := range files {
+		if !strings.Contains(file.Name, "write") {
+			continue
+		}
+		group := []*backup.File{file}
+		if def, ok := defaults[fileRange{string(file.StartKey), string(file.EndKey)}]; ok {
+			group = append(group, def)
+			grouped[def] = true
+		}
+		groups = append(groups, group)
+	}
+	for _, file := range files {
+		if !strings.Contains(file.Name, "write") && !grouped[file] {
+			groups = append(groups, []*backup.File{file})
+		}
+	}
+	return groups
+}
+
+// FindFileGroup returns the file of the backup, and the file of the default
+// column family of the same range if it is a file of the write column
+// family. It returns nil if the file isn't in the backup meta.
+func FindFileGroup(files []*backup.File, name string, rawKV bool) []*backup.File {
+	if rawKV {
+		for _, file := range files {
+			if file.Name == name {
+				return []*backup.File{file}
+			}
+		}
+		return nil
+	}
+	for _, group := range GroupFiles(files) {
+		for _, file := range group {
+			if file.Name == name {
+				return group
+			}
+		}
+	}
+	return nil
+}
+
+// RecomputeChecksum decodes the content of the group of the files, and
+// recomputes the checksum of the first file. The checksum of a file of the
+// default column family is always zero, since it is summed with the write
+// column family.
+func RecomputeChecksum(group []*backup.File, data [][]byte, rawKV bool) (sst.Checksum, error) {
+	readers := make([]*sst.Reader, 0, len(data))
+	for i := range data {
+		reader, err := sst.NewReader(data[i])
+		if err != nil {
+			return sst.Checksum{}, errors.Annotatef(err, "decode %s failed", group[i].Name)
+		}
+		readers = append(readers, reader)
+	}
+	switch {
+	case rawKV:
+		return sst.ComputeRawChecksum(readers[0])
+	case strings.Contains(group[0].Name, "write"):
+		var def *sst.Reader
+		if len(readers) > 1 {
+			def = readers[1]
+		}
+		return sst.ComputeChecksum(readers[0], def)
+	default:
+		err := readers[0].Iterate(func(*sst.Entry) error { return nil })
+		return sst.Checksum{}, errors.Trace(err)
+	}
+}
+
+// ChecksumMismatch compares the recomputed checksum with the file, and
+// returns the mismatch, which is empty if they match. Only the number of
+// the key-value pairs is compared if the backup skipped the checksum.
+func ChecksumMismatch(file *backup.File, checksum sst.Checksum) string {
+	matched := checksum.TotalKvs == file.TotalKvs
+	if file.Crc64Xor != 0 || file.TotalBytes != 0 {
+		matched = matched && checksum.Crc64Xor == file.Crc64Xor && checksum.TotalBytes == file.TotalBytes
+	}
+	if matched {
+		return ""
+	}
+	return fmt.Sprintf("checksum is %d/%d/%d, but %d/%d/%d in the backup meta",
+		checksum.Crc64Xor, checksum.TotalKvs, checksum.TotalBytes,
+		file.Crc64Xor, file.TotalKvs, file.TotalBytes)
+}
+
+// verifyContent reads the group of the files into memory, checks their
+// sizes and sha256, and then recomputes the checksum of the first file.
+func verifyContent(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	group []*backup.File,
+	rawKV bool,
+) (uint64, []CorruptedFile) {
+	var n uint64
+	corrupted := make([]CorruptedFile, 0)
+	data := make([][]byte, 0, len(group))
+	for _, file := range group {
+		content, err := s.Read(ctx, file.Name)
+		if err != nil {
+			corrupted = append(corrupted, CorruptedFile{Name: file.Name, Reason: fmt.Sprintf("read failed: %v", err)})
+			continue
+		}
+		n += uint64(len(content))
+		sum := sha256.Sum256(content)
+		if reason := checkFile(file, uint64(len(content)), sum[:]); len(reason) > 0 {
+			corrupted = append(corrupted, CorruptedFile{Name: file.Name, Reason: reason})
+		}
+		data = append(data, content)
+	}
+	if len(corrupted) > 0 {
+		return n, corrupted
+	}
+	checksum, err := RecomputeChecksum(group, data, rawKV)
+	if err != nil {
+		return n, []CorruptedFile{{Name: group[0].Name, Reason: fmt.Sprintf("decode failed: %v", err)}}
+	}
+	if reason := ChecksumMismatch(group[0], checksum); len(reason) > 0 {
+		return n, []CorruptedFile{{Name: group[0].Name, Reason: reason}}
+	}
+	return n, nil
+}
+
+// verifyTables sums the checksums of the files of each table, and compares
+// them with the checksums of the schemas.
+func verifyTables(databases map[string]*Database) []*TableVerify {
+	tables := make([]*TableVerify, 0)
+	for _, db := range databases {
+		for _, table := range db.Tables {
+			result := &TableVerify{
+				Name: EncloseName(db.Info.Name.O) + "." + EncloseName(table.Info.Name.O),
+				Checksum: Checksum{
+					Crc64Xor:   table.Crc64Xor,
+					TotalKvs:   table.TotalKvs,
+					TotalBytes: table.TotalBytes,
+				},
+			}
+			for _, file := range table.Files() {
+				result.Crc64Xor ^= file.Crc64Xor
+				result.TotalKvs += file.TotalKvs
+				result.TotalBytes += file.TotalBytes
+			}
+			// The checksum is all zero if it is skipped by the backup.
+			result.Unchecked = result.Checksum == Checksum{}
+			result.Mismatched = !result.Unchecked && result.Checksum != Checksum{
+				Crc64Xor:   result.Crc64Xor,
+				TotalKvs:   result.TotalKvs,
+				TotalBytes: result.TotalBytes,
+			}
+			tables = append(tables, result)
+		}
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	return tables
+}
@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+
+	"github.com/pingcap/br/pkg/sst"
+)
+
+type testVerifySuite struct{}
+
+var _ = Suite(&testVerifySuite{})
+
+func (r *testVerifySuite) TestVerifyBackup(c *C) {
+	ctx := context.Background()
+	s := createLocalStorage(c)
+	mockFile := func(name string, id int64, data string, write bool) *backup.File {
+		file := mockReportFile(id, uint64(len(data)), uint64(len(data)))
+		sum := sha256.Sum256([]byte(data))
+		file.Name = name
+		file.Sha256 = sum[:]
+		file.Crc64Xor = uint64(id)
+		file.Size_ = uint64(len(data))
+		if write {
+			c.Assert(s.Write(ctx, name, []byte(data)), IsNil)
+		}
+		return file
+	}
+	files := []*backup.File{
+		mockFile("1_write.sst", 1, "t1 data", true),
+		mockFile("1_default.sst", 1, "t1 default data", true),
+		mockFile("2_write.sst", 2, "t2 data", true),
+		mockFile("3_write.sst", 3, "t3 data", false),
+		mockFile("4_write.sst", 4, "t4 data", true),
+		mockFile("5_write.sst", 5, "t5 data", true),
+	}
+	// The corrupted files, and the extra files.
+	c.Assert(s.Write(ctx, "4_write.sst", []byte("t4 date")), IsNil)
+	c.Assert(s.Write(ctx, "5_write.sst", []byte("t5")), IsNil)
+	c.Assert(s.Write(ctx, "6_write.sst", []byte("t6 data")), IsNil)
+
+	schema := func(id int64, checksum Checksum) *backup.Schema {
+		schema := mockReportSchema(c, "db", &model.TableInfo{ID: id, Name: model.NewCIStr(fmt.Sprintf("t%d", id))}, 0)
+		schema.Crc64Xor, schema.TotalKvs, schema.TotalBytes = checksum.Crc64Xor, checksum.TotalKvs, checksum.TotalBytes
+		return schema
+	}
+	meta := &backup.BackupMeta{
+		Files: files,
+		Schemas: []*backup.Schema{
+			schema(1, Checksum{Crc64Xor: 0, TotalKvs: 22, TotalBytes: 22}),
+			schema(2, Checksum{Crc64Xor: 2, TotalKvs: 8, TotalBytes: 7}),
+			schema(3, Checksum{}),
+		},
+	}
+
+	// The shards of the backup meta are not extra files.
+	report, err := VerifyBackup(ctx, s, mockMetaReader(c, s, meta), 2, false)
+	c.Assert(err, IsNil)
+	c.Assert(report.Failed(), IsTrue)
+	c.Assert(report.Files, Equals, 4)
+	c.Assert(report.ReadBytes, Equals, uint64(36))
+	c.Assert(report.Missing, DeepEquals, []string{"3_write.sst"})
+	c.Assert(report.Extra, DeepEquals, []string{"6_write.sst"})
+	c.Assert(report.Corrupted, HasLen, 2)
+	c.Assert(report.Corrupted[0].Name, Equals, "4_write.sst")
+	c.Assert(report.Corrupted[0].Reason, Matches, "sha256 is .*, but .* in the backup meta")
+	c.Assert(report.Corrupted[1], DeepEquals, CorruptedFile{
+		Name:   "5_write.sst",
+		Reason: "size is 2, but 7 in the backup meta",
+	})
+	c.Assert(report.Tables, HasLen, 3)
+	c.Assert(report.Tables[0].Name, Equals, "`db`.`t1`")
+	c.Assert(report.Tables[0].Mismatched, IsFalse)
+	c.Assert(report.Tables[1].Mismatched, IsTrue)
+	c.Assert(report.Tables[2].Unchecked, IsTrue)
+	c.Assert(report.Tables[2].Mismatched, IsFalse)
+	c.Assert(report.String(), Matches, `(?s)\[MISSING\] 3_write.sst\n.*`+
+		`4 files \(36B\) verified: 1 missing, 2 corrupted, 1 extra, 1 of 3 tables mismatched`)
+
+	// Only the extra files don't fail the verification.
+	meta.Files = files[:3]
+	meta.Schemas = meta.Schemas[:1]
+	report, err = VerifyBackup(ctx, s, mockMetaReader(c, s, meta), 1, false)
+	c.Assert(err, IsNil)
+	c.Assert(report.Extra, DeepEquals, []string{"4_write.sst", "5_write.sst", "6_write.sst"})
+	c.Assert(report.Failed(), IsFalse)
+
+	// The deep verification decodes the files, and the write file is
+	// decoded with the default file of the same range.
+	c.Assert(GroupFiles(meta.Files), DeepEquals, [][]*backup.File{{files[0], files[1]}, {files[2]}})
+	report, err = VerifyBackup(ctx, s, mockMetaReader(c, s, meta), 2, true)
+	c.Assert(err, IsNil)
+	c.Assert(report.Files, Equals, 3)
+	c.Assert(report.Corrupted, HasLen, 2)
+	c.Assert(report.Corrupted[0].Name, Equals, "1_write.sst")
+	c.Assert(report.Corrupted[0].Reason, Matches, "decode failed: decode 1_write.sst failed: file size 7 is too small for an SST file")
+	c.Assert(report.Corrupted[1].Name, Equals, "2_write.sst")
+	c.Assert(FindFileGroup(meta.Files, "1_default.sst", false), DeepEquals, []*backup.File{files[0], files[1]})
+	c.Assert(FindFileGroup(meta.Files, "6_write.sst", false), IsNil)
+}
+
+func (r *testVerifySuite) TestChecksumMismatch(c *C) {
+	file := &backup.File{Crc64Xor: 1, TotalKvs: 2, TotalBytes: 3}
+	c.Assert(ChecksumMismatch(file, sst.Checksum{Crc64Xor: 1, TotalKvs: 2, TotalBytes: 3}), Equals, "")
+	c.Assert(ChecksumMismatch(file, sst.Checksum{Crc64Xor: 2, TotalKvs: 2, TotalBytes: 3}), Equals,
+		"checksum is 2/2/3, but 1/2/3 in the backup meta")
+	// Only the number of the key-value pairs is compared if the backup
+	// skipped the checksum.
+	file = &backup.File{TotalKvs: 2}
+	c.Assert(ChecksumMismatch(file, sst.Checksum{Crc64Xor: 2, TotalKvs: 2, TotalBytes: 3}), Equals, "")
+	c.Assert(ChecksumMismatch(file, sst.Checksum{TotalKvs: 1}), Not(Equals), "")
+}
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"runtime"
+	"strings"
 
+	"github.com/coreos/go-semver/semver"
+	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"github.com/pingcap/tidb/util/israce"
 	"github.com/spf13/cobra"
@@ -21,6 +24,9 @@ var (
 	goVersion        = runtime.Version()
 )
 
+// MinClusterVersion is the minimum version of the cluster supported by BR.
+var MinClusterVersion = semver.New("3.1.0-alpha")
+
 // LogBRInfo logs version information about BR.
 func LogBRInfo() {
 	log.Info("Welcome to Backup & Restore (BR)")
@@ -52,3 +58,28 @@ func LogArguments(cmd *cobra.Command) {
 	})
 	log.Info("arguments", fields...)
 }
+
+// ParseVersion parses a version like "v3.1.0-beta.1", the quotes around the
+// version in the responses of PD are trimmed.
+func ParseVersion(version string) (*semver.Version, error) {
+	s := strings.Trim(strings.TrimSpace(version), `"`)
+	v, err := semver.NewVersion(strings.TrimPrefix(s, "v"))
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid version %q", version)
+	}
+	return v, nil
+}
+
+// CheckClusterVersion checks whether the version of the cluster is supported
+// by BR.
+func CheckClusterVersion(version string) error {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return err
+	}
+	if v.LessThan(*MinClusterVersion) {
+		return errors.Errorf("cluster version %s is not supported, BR requires %s or later",
+			v, MinClusterVersion)
+	}
+	return nil
+}
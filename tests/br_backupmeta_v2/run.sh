@@ -0,0 +1,58 @@
+#!/bin/sh
+#
+# Copyright 2020 PingCAP, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eu
+DB="$TEST_NAME"
+TABLE_COUNT=3
+
+run_sql "CREATE DATABASE $DB;"
+for i in $(seq $TABLE_COUNT); do
+    run_sql "CREATE TABLE $DB.usertable$i(id INT PRIMARY KEY, c VARCHAR(64));"
+    run_sql "INSERT INTO $DB.usertable$i VALUES (1, 'a'), (2, 'b'), (3, 'c');"
+done
+
+# backup db with a meta file for each file and schema
+echo "backup start..."
+run_br --pd $PD_ADDR backup db --db $DB -s "local://$TEST_DIR/$DB" --ratelimit 5 --concurrency 4 \
+    --backupmeta-version 2 --backupmeta-shard-size 1
+
+if [ -f "$TEST_DIR/$DB/backupmeta" ] || [ ! -f "$TEST_DIR/$DB/backupmeta.index" ]; then
+    echo "TEST: [$TEST_NAME] backupmeta is not sharded!"
+    exit 1
+fi
+if [ ! -f "$TEST_DIR/$DB/backupmeta.schemas.00000$TABLE_COUNT" ]; then
+    echo "TEST: [$TEST_NAME] schemas are not sharded!"
+    exit 1
+fi
+
+# the sharded backupmeta can be validated
+run_br validate checksum -s "local://$TEST_DIR/$DB"
+run_br validate backupmeta -s "local://$TEST_DIR/$DB"
+
+run_sql "DROP DATABASE $DB;"
+
+# restore db
+echo "restore start..."
+run_br --pd $PD_ADDR restore db --db $DB -s "local://$TEST_DIR/$DB"
+
+for i in $(seq $TABLE_COUNT); do
+    row_count=$(run_sql "SELECT COUNT(*) FROM $DB.usertable$i;" | awk '/COUNT/{print $2}')
+    if [ "$row_count" != "3" ]; then
+        echo "TEST: [$TEST_NAME] failed!, row count of usertable$i is $row_count after restore"
+        exit 1
+    fi
+done
+
+run_sql "DROP DATABASE $DB;"
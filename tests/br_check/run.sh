@@ -0,0 +1,48 @@
+#!/bin/sh
+#
+# Copyright 2020 PingCAP, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eu
+DB="$TEST_NAME"
+
+run_sql "CREATE DATABASE $DB;"
+run_sql "CREATE TABLE $DB.usertable1(id INT PRIMARY KEY, c VARCHAR(64));"
+run_sql "INSERT INTO $DB.usertable1 VALUES (1, 'a'), (2, 'b'), (3, 'c');"
+
+# the backup can run, and nothing is written by the check
+echo "check backup..."
+run_br --pd $PD_ADDR check backup db --db $DB -s "local://$TEST_DIR/$DB" | tee "$TEST_DIR/$DB.check"
+if grep -q "FAIL" "$TEST_DIR/$DB.check" || [ -n "$(ls -A "$TEST_DIR/$DB")" ]; then
+    echo "TEST: [$TEST_NAME] check backup failed!"
+    exit 1
+fi
+
+run_br --pd $PD_ADDR backup db --db $DB -s "local://$TEST_DIR/$DB"
+
+# the backup can't run again into the same storage
+if run_br --pd $PD_ADDR check backup db --db $DB -s "local://$TEST_DIR/$DB"; then
+    echo "TEST: [$TEST_NAME] check backup succeeded with an existing backup!"
+    exit 1
+fi
+
+# the existing tables are warned
+echo "check restore..."
+run_br --pd $PD_ADDR check restore db --db $DB -s "local://$TEST_DIR/$DB" | tee "$TEST_DIR/$DB.check"
+if ! grep -q "\[WARN\] tables: 1 of the 1 restored tables exist already" "$TEST_DIR/$DB.check"; then
+    echo "TEST: [$TEST_NAME] existing tables are not warned!"
+    exit 1
+fi
+
+run_sql "DROP DATABASE $DB;"
+run_br --pd $PD_ADDR check restore db --db $DB -s "local://$TEST_DIR/$DB"
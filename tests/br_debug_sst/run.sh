@@ -0,0 +1,53 @@
+#!/bin/sh
+#
+# Copyright 2020 PingCAP, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eu
+DB="$TEST_NAME"
+
+run_sql "CREATE DATABASE $DB;"
+run_sql "CREATE TABLE $DB.usertable1(id INT PRIMARY KEY, c TEXT);"
+run_sql "INSERT INTO $DB.usertable1 VALUES (1, 'a'), (2, REPEAT('b', 1024)), (3, 'c');"
+
+run_br --pd $PD_ADDR backup db --db $DB -s "local://$TEST_DIR/$DB"
+
+sst=$(ls "$TEST_DIR/$DB" | grep "_write\.sst$" | head -n 1)
+echo "debug sst $sst..."
+run_br debug sst -s "local://$TEST_DIR/$DB" --file "$sst" | tee "$TEST_DIR/$DB.debug"
+entries=$(grep -c "handle [0-9]*, commit ts [0-9]*: put" "$TEST_DIR/$DB.debug" || true)
+if [ "$entries" -ne 3 ]; then
+    echo "TEST: [$TEST_NAME] decoded $entries entries, expected 3!"
+    exit 1
+fi
+if ! grep -q "^OK$" "$TEST_DIR/$DB.debug"; then
+    echo "TEST: [$TEST_NAME] the recomputed checksum mismatches!"
+    exit 1
+fi
+
+# the long value is in the file of the default column family, whose checksum
+# is recomputed with the file of the write column family of the same range
+sst=$(ls "$TEST_DIR/$DB" | grep "_default\.sst$" | head -n 1)
+echo "debug sst $sst..."
+run_br debug sst -s "local://$TEST_DIR/$DB" --file "$sst" | tee "$TEST_DIR/$DB.debug"
+entries=$(grep -c "handle [0-9]*, start ts [0-9]*: value" "$TEST_DIR/$DB.debug" || true)
+if [ "$entries" -ne 1 ]; then
+    echo "TEST: [$TEST_NAME] decoded $entries entries of the default file, expected 1!"
+    exit 1
+fi
+if ! grep -q "^checksum of the write file .*_write\.sst" "$TEST_DIR/$DB.debug" || ! grep -q "^OK$" "$TEST_DIR/$DB.debug"; then
+    echo "TEST: [$TEST_NAME] the recomputed checksum of the default file mismatches!"
+    exit 1
+fi
+
+run_sql "DROP DATABASE $DB;"
@@ -0,0 +1,53 @@
+#!/bin/sh
+#
+# Copyright 2020 PingCAP, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eu
+DB="$TEST_NAME"
+
+run_sql "CREATE DATABASE $DB;"
+run_sql "CREATE TABLE $DB.usertable1(id INT PRIMARY KEY, c VARCHAR(64));"
+run_sql "INSERT INTO $DB.usertable1 VALUES (1, 'a'), (2, 'b'), (3, 'c');"
+
+# the dry run prints the plan without writing anything
+echo "backup dry run..."
+run_br --pd $PD_ADDR backup db --db $DB -s "local://$TEST_DIR/$DB" --dry-run | tee "$TEST_DIR/$DB.plan"
+if ! grep -q "\`$DB\`.\`usertable1\`" "$TEST_DIR/$DB.plan" || [ -e "$TEST_DIR/$DB/backupmeta" ]; then
+    echo "TEST: [$TEST_NAME] backup dry run failed!"
+    exit 1
+fi
+
+run_br --pd $PD_ADDR backup db --db $DB -s "local://$TEST_DIR/$DB"
+run_sql "DROP DATABASE $DB;"
+
+# the restore dry run doesn't create the tables
+echo "restore dry run..."
+run_br --pd $PD_ADDR restore db --db $DB -s "local://$TEST_DIR/$DB" --dry-run | tee "$TEST_DIR/$DB.plan"
+if ! grep -q "tables to create: 1" "$TEST_DIR/$DB.plan"; then
+    echo "TEST: [$TEST_NAME] restore dry run failed!"
+    exit 1
+fi
+if run_sql "SHOW DATABASES;" | grep -q "Database: $DB$"; then
+    echo "TEST: [$TEST_NAME] restore dry run created the database!"
+    exit 1
+fi
+
+run_br --pd $PD_ADDR restore db --db $DB -s "local://$TEST_DIR/$DB"
+row_count=$(run_sql "SELECT COUNT(*) FROM $DB.usertable1;" | awk '/COUNT/{print $2}')
+if [ "$row_count" -ne 3 ]; then
+    echo "TEST: [$TEST_NAME] restore failed!"
+    exit 1
+fi
+
+run_sql "DROP DATABASE $DB;"
@@ -0,0 +1,63 @@
+#!/bin/sh
+#
+# Copyright 2020 PingCAP, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eu
+DB="$TEST_NAME"
+
+run_sql "CREATE DATABASE $DB;"
+run_sql "CREATE TABLE $DB.usertable1(id INT PRIMARY KEY, c VARCHAR(64), d TEXT);"
+run_sql "INSERT INTO $DB.usertable1 VALUES (1, 'a', NULL), (2, 'b,\"c', REPEAT('x', 1024)), (3, 'c', 'd');"
+run_sql "DELETE FROM $DB.usertable1 WHERE id = 3;"
+run_sql "CREATE TABLE $DB.usertable2(id INT, c VARCHAR(64)) PARTITION BY HASH(id) PARTITIONS 2;"
+run_sql "INSERT INTO $DB.usertable2 VALUES (1, 'a'), (2, 'b'), (3, 'c');"
+run_sql "CREATE TABLE $DB.other(id INT);"
+run_sql "INSERT INTO $DB.other VALUES (1);"
+
+run_br --pd $PD_ADDR backup db --db $DB -s "local://$TEST_DIR/$DB"
+
+echo "export csv..."
+run_br export -s "local://$TEST_DIR/$DB" --output "local://$TEST_DIR/$DB.csv" --filter "$DB.usertable?" --log-file "$TEST_DIR/$DB.log"
+if ls "$TEST_DIR/$DB.csv" | grep -q "other"; then
+    echo "TEST: [$TEST_NAME] the table not matching the filter is exported!"
+    exit 1
+fi
+rows=$(cat "$TEST_DIR/$DB.csv/$DB.usertable1."*.csv | grep -cv "^id,")
+if [ "$rows" -ne 2 ]; then
+    echo "TEST: [$TEST_NAME] exported $rows rows of usertable1, expected 2!"
+    exit 1
+fi
+rows=$(cat "$TEST_DIR/$DB.csv/$DB.usertable2."*.csv | grep -cv "^id,")
+if [ "$rows" -ne 3 ]; then
+    echo "TEST: [$TEST_NAME] exported $rows rows of usertable2, expected 3!"
+    exit 1
+fi
+if ! grep -q '^2,"b,""c",' "$TEST_DIR/$DB.csv/$DB.usertable1."*.csv; then
+    echo "TEST: [$TEST_NAME] the exported csv is malformed!"
+    exit 1
+fi
+
+echo "export sql..."
+run_br export -s "local://$TEST_DIR/$DB" --output "local://$TEST_DIR/$DB.sql" --format sql --filter "$DB.usertable1" --log-file "$TEST_DIR/$DB.log"
+run_sql "DELETE FROM $DB.usertable1;"
+for file in "$TEST_DIR/$DB.sql/"*.sql; do
+    run_sql "$(cat "$file")"
+done
+row_count=$(run_sql "SELECT COUNT(*) FROM $DB.usertable1 WHERE d IS NULL OR LENGTH(d) = 1024;" | awk '/COUNT/{print $2}')
+if [ "$row_count" -ne 2 ]; then
+    echo "TEST: [$TEST_NAME] imported $row_count rows from the exported sql, expected 2!"
+    exit 1
+fi
+
+run_sql "DROP DATABASE $DB;"
@@ -31,6 +31,10 @@ bin/locker -tidb $TIDB_STATUS_ADDR -pd $PD_ADDR -db $DB -table $TABLE -lock-ttl
 echo "backup start..."
 run_br --pd $PD_ADDR backup table -s "local://$TEST_DIR/$DB" --db $DB -t $TABLE --ratelimit 5 --concurrency 4
 
+# backup table again, resolving the locks before backup
+echo "backup with resolving locks start..."
+run_br --pd $PD_ADDR backup table -s "local://$TEST_DIR/$DB-resolve-locks" --db $DB -t $TABLE --ratelimit 5 --concurrency 4 --resolve-locks
+
 run_sql "DROP TABLE $DB.$TABLE;"
 
 # restore table
@@ -0,0 +1,54 @@
+#!/bin/sh
+#
+# Copyright 2020 PingCAP, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eu
+DB="$TEST_NAME"
+
+run_sql "CREATE DATABASE $DB;"
+run_sql "CREATE TABLE $DB.usertable1(id INT PRIMARY KEY, c VARCHAR(64));"
+run_sql "CREATE TABLE $DB.usertable2(id INT PRIMARY KEY);"
+run_sql "CREATE TABLE $DB.usertable3(id INT PRIMARY KEY);"
+run_sql "INSERT INTO $DB.usertable1 VALUES (1, 'a'), (2, 'b');"
+
+run_br --pd $PD_ADDR backup db --db $DB -s "local://$TEST_DIR/$DB/old"
+
+run_sql "ALTER TABLE $DB.usertable1 ADD COLUMN d INT;"
+run_sql "ALTER TABLE $DB.usertable1 ADD INDEX idx_c(c);"
+run_sql "INSERT INTO $DB.usertable1 VALUES (3, 'c', 3);"
+run_sql "DROP TABLE $DB.usertable2;"
+run_sql "CREATE TABLE $DB.usertable4(id INT PRIMARY KEY);"
+
+run_br --pd $PD_ADDR backup db --db $DB -s "local://$TEST_DIR/$DB/new"
+
+echo "diff..."
+run_br meta diff -s "local://$TEST_DIR/$DB/new" --old "local://$TEST_DIR/$DB/old" \
+    | tee "$TEST_DIR/$DB.diff"
+if ! grep -q "1 added, 1 dropped, 0 renamed, 1 changed, 1 unchanged tables" "$TEST_DIR/$DB.diff"; then
+    echo "TEST: [$TEST_NAME] diff failed!"
+    exit 1
+fi
+if ! grep -q "columns: *+d" "$TEST_DIR/$DB.diff" || ! grep -q "indexes: *+idx_c" "$TEST_DIR/$DB.diff"; then
+    echo "TEST: [$TEST_NAME] diff didn't report the schema changes!"
+    exit 1
+fi
+
+added=$(run_br meta diff -s "local://$TEST_DIR/$DB/new" --old "local://$TEST_DIR/$DB/old" \
+    --field ".added-tables[]")
+if [ "$added" != "\`$DB\`.\`usertable4\`" ]; then
+    echo "TEST: [$TEST_NAME] diff reported added tables $added!"
+    exit 1
+fi
+
+run_sql "DROP DATABASE $DB;"
@@ -0,0 +1,46 @@
+#!/bin/sh
+#
+# Copyright 2020 PingCAP, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eu
+DB="$TEST_NAME"
+
+run_sql "CREATE DATABASE $DB;"
+run_sql "CREATE TABLE $DB.usertable1(id INT PRIMARY KEY, c VARCHAR(64));"
+run_sql "INSERT INTO $DB.usertable1 VALUES (1, 'a'), (2, 'b'), (3, 'c');"
+run_sql "CREATE TABLE $DB.usertable2(id INT PRIMARY KEY) PARTITION BY RANGE (id) (PARTITION p0 VALUES LESS THAN (10), PARTITION p1 VALUES LESS THAN MAXVALUE);"
+run_sql "INSERT INTO $DB.usertable2 VALUES (1), (20);"
+
+run_br --pd $PD_ADDR backup db --db $DB -s "local://$TEST_DIR/$DB"
+
+echo "show backup..."
+run_br meta show -s "local://$TEST_DIR/$DB" | tee "$TEST_DIR/$DB.show"
+if ! grep -q "type: *full" "$TEST_DIR/$DB.show" || ! grep -q "$DB *usertable2 *2" "$TEST_DIR/$DB.show"; then
+    echo "TEST: [$TEST_NAME] meta show failed!"
+    exit 1
+fi
+
+# the logs are written to the file, so that the selected fields are the only output
+tables=$(run_br meta show -s "local://$TEST_DIR/$DB" --field ".databases[].tables[].name" | tr '\n' ' ')
+if [ "$tables" != "usertable1 usertable2 " ]; then
+    echo "TEST: [$TEST_NAME] meta show selected wrong tables: $tables"
+    exit 1
+fi
+partitions=$(run_br meta show -s "local://$TEST_DIR/$DB" --format json --field ".databases[0].tables[1].partitions" | tr -d ' \n')
+if [ "$partitions" != '["p0","p1"]' ]; then
+    echo "TEST: [$TEST_NAME] meta show selected wrong partitions: $partitions"
+    exit 1
+fi
+
+run_sql "DROP DATABASE $DB;"
@@ -0,0 +1,53 @@
+#!/bin/sh
+#
+# Copyright 2020 PingCAP, Inc.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eu
+DB="$TEST_NAME"
+
+run_sql "CREATE DATABASE $DB;"
+run_sql "CREATE TABLE $DB.usertable1(id INT PRIMARY KEY, c VARCHAR(64));"
+run_sql "INSERT INTO $DB.usertable1 VALUES (1, 'a'), (2, 'b'), (3, 'c');"
+
+run_br --pd $PD_ADDR backup db --db $DB -s "local://$TEST_DIR/$DB"
+
+echo "verify..."
+run_br verify -s "local://$TEST_DIR/$DB" | tee "$TEST_DIR/$DB.verify"
+if ! grep -q "0 missing, 0 corrupted, 0 extra, 0 of 1 tables mismatched" "$TEST_DIR/$DB.verify"; then
+    echo "TEST: [$TEST_NAME] verify failed!"
+    exit 1
+fi
+
+echo "deep verify..."
+run_br verify --deep -s "local://$TEST_DIR/$DB" | tee "$TEST_DIR/$DB.verify"
+if ! grep -q "0 missing, 0 corrupted, 0 extra, 0 of 1 tables mismatched" "$TEST_DIR/$DB.verify"; then
+    echo "TEST: [$TEST_NAME] deep verify failed!"
+    exit 1
+fi
+
+# every corrupted or missing file is reported
+sst=$(ls "$TEST_DIR/$DB" | grep "\.sst$" | head -n 1)
+echo "corrupted" >> "$TEST_DIR/$DB/$sst"
+echo "extra" > "$TEST_DIR/$DB/extra.sst"
+if run_br verify -s "local://$TEST_DIR/$DB" > "$TEST_DIR/$DB.verify"; then
+    echo "TEST: [$TEST_NAME] verify succeeded with a corrupted file!"
+    exit 1
+fi
+cat "$TEST_DIR/$DB.verify"
+if ! grep -q "\[CORRUPTED\] $sst" "$TEST_DIR/$DB.verify" || ! grep -q "\[EXTRA\] extra.sst" "$TEST_DIR/$DB.verify"; then
+    echo "TEST: [$TEST_NAME] verify didn't report the corrupted and extra files!"
+    exit 1
+fi
+
+run_sql "DROP DATABASE $DB;"